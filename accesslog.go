@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLog writes requests in Apache's combined format for tools like GoAccess and awstats.
+// It rotates itself at maxSize, keeping path.1 … path.N, and can be reopened after an
+// external logrotate moves the file.
+type AccessLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64 // Bytes; 0 never rotates
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+var accessLog *AccessLog
+
+var accessLogConfig = struct {
+	Path      string
+	MaxSizeMB int
+	Keep      int
+}{MaxSizeMB: 100, Keep: 5}
+
+func openAccessLog(path string, maxSize int64, keep int) (*AccessLog, error) {
+	a := &AccessLog{path: path, maxSize: maxSize, keep: keep}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AccessLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, info.Size()
+	return nil
+}
+
+// Reopen starts writing to a fresh file at the same path
+func (a *AccessLog) Reopen() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file.Close()
+	return a.open()
+}
+
+// rotate shifts path.N-1 to path.N and so on, then moves the current file to path.1
+func (a *AccessLog) rotate() error {
+	a.file.Close()
+	if a.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", a.path, a.keep))
+		for i := a.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(a.path); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+func (a *AccessLog) Write(line string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.WriteString(line)
+	a.size += int64(n)
+	return err
+}
+
+// combinedLogLine formats a finished request as
+// host ident user [time] "request" status bytes "referer" "user-agent"
+func combinedLogLine(r *http.Request, ip, user string, status int, bytes int64, start time.Time) string {
+	if user == "" {
+		user = "-"
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	request := r.Method + " " + redactedURI(r.URL) + " " + r.Proto
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s\n",
+		ip, strings.ReplaceAll(user, " ", "_"), start.Format("02/Jan/2006:15:04:05 -0700"),
+		quoteLogField(request), status, size,
+		quoteLogField(r.Referer()), quoteLogField(r.UserAgent()))
+}
+
+// secretParams are query parameters that carry credentials: ?token= links, and the password,
+// salted token and API key Subsonic clients send
+var secretParams = []string{"token", "p", "t", "s", "apiKey"}
+
+// redactedURI keeps secrets in the query string out of the log
+func redactedURI(u *url.URL) string {
+	q := u.Query()
+	redacted := false
+	for _, name := range secretParams {
+		if q.Has(name) {
+			q.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.RequestURI()
+	}
+	return u.EscapedPath() + "?" + q.Encode()
+}
+
+// quoteLogField quotes a value the way Apache does, escaping quotes, backslashes and control characters
+func quoteLogField(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
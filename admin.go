@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The /api/admin routes cover routine upkeep without a shell on the server: rescanning,
+// clearing caches, accounts, streams in progress and the settings in effect
+
+// secretFlags hold credentials, which config inspection doesn't show
+var secretFlags = map[string]bool{"auth": true, "auth-token": true, "lastfm-api-key": true, "lastfm-secret": true, "oidc-client-secret": true, "sync-key": true, "mount": true}
+
+// RescanStatus is how the last rescan went
+type RescanStatus struct {
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Files    int       `json:"files"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var rescan = struct {
+	sync.Mutex
+	status RescanStatus
+}{}
+
+func registerAdminRoutes() {
+	handleFunc("GET /api/admin/rescan", requireRole(roleAdmin, getRescan))
+	handleFunc("POST /api/admin/rescan", requireRole(roleAdmin, startRescan))
+	handleFunc("DELETE /api/admin/cache", requireRole(roleAdmin, clearCaches))
+	handleFunc("GET /api/admin/config", requireRole(roleAdmin, getConfig))
+	handleFunc("GET /api/admin/streams", requireRole(roleAdmin, listStreams))
+	handleFunc("DELETE /api/admin/streams/{id}", requireRole(roleAdmin, stopStream))
+	handleFunc("GET /api/admin/users", requireRole(roleAdmin, listUsers))
+	handleFunc("POST /api/admin/users", requireRole(roleAdmin, createUser))
+	handleFunc("PATCH /api/admin/users/{id}", requireRole(roleAdmin, updateUser))
+	handleFunc("DELETE /api/admin/users/{id}", requireRole(roleAdmin, deleteUser))
+	handleFunc("PUT /api/admin/users/{id}/password", requireRole(roleAdmin, setUserPassword))
+}
+
+func getRescan(w http.ResponseWriter, r *http.Request) {
+	rescan.Lock()
+	defer rescan.Unlock()
+	writeJSON(w, http.StatusOK, rescan.status)
+}
+
+func init() {
+	registerJobKind("rescan", &jobKind{concurrency: 1, run: runRescan})
+}
+
+// startRescan queues a "rescan" job to read the whole library again, tags included, for
+// changes the storage didn't report. GET /api/admin/rescan follows its progress.
+func startRescan(w http.ResponseWriter, r *http.Request) {
+	rescan.Lock()
+	defer rescan.Unlock()
+	if !rescan.status.Running {
+		if _, err := jobs.Add("rescan", uploadOwner(r), jobPriority(r, 10), nil); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		rescan.status = RescanStatus{Running: true, Started: time.Now()}
+	}
+	writeJSON(w, http.StatusAccepted, rescan.status)
+}
+
+func runRescan(ctx context.Context, run *jobRun) error {
+	rescan.Lock()
+	rescan.status = RescanStatus{Running: true, Started: time.Now()}
+	rescan.Unlock()
+
+	run.Progress(0, "Listing files")
+	files, err := scanLibrary()
+	if err == nil {
+		forgetMeta()
+		for i, f := range files {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			if i%100 == 0 {
+				run.Progress(float64(i)/float64(len(files)), "Reading tags")
+			}
+			trackMeta(f)
+		}
+	}
+	if err == nil {
+		libraryIndex.Reset()
+		setLibraryListing(files)
+		if err := saveMetaCache(); err != nil {
+			slog.Error("Error saving metadata cache", "err", err)
+		}
+	}
+
+	rescan.Lock()
+	defer rescan.Unlock()
+	rescan.status.Running, rescan.status.Finished, rescan.status.Files = false, time.Now(), len(files)
+	if err != nil {
+		rescan.status.Error = err.Error()
+		return err
+	}
+	slog.Info("Rescanned library", "files", len(files), "duration", rescan.status.Finished.Sub(rescan.status.Started))
+	events.Publish(Event{Type: "scan.completed", Data: map[string]any{
+		"files":      len(files),
+		"durationMs": rescan.status.Finished.Sub(rescan.status.Started).Milliseconds(),
+	}})
+	return nil
+}
+
+// clearCaches empties the remote storage block cache, the tag cache and the search index,
+// which all fill again as they're used
+func clearCaches(w http.ResponseWriter, r *http.Request) {
+	var freed int64
+	if diskCache != nil {
+		freed = diskCache.Clear()
+	}
+	tags := forgetMeta()
+	if err := saveMetaCache(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"blockBytes":  freed,
+		"tags":        tags,
+		"searchIndex": libraryIndex.Reset(),
+	})
+}
+
+// ConfigOption is a setting as the server is running with it
+type ConfigOption struct {
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	Default    string `json:"default"`
+	Source     string `json:"source"` // flag, env, config or default
+	Reloadable bool   `json:"reloadable"`
+	Usage      string `json:"usage"`
+}
+
+// getConfig lists every setting with where its value came from, leaving out credentials
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	fileValues := map[string]string{}
+	if configPath != "" {
+		if values, err := readConfigFile(configPath); err == nil {
+			fileValues = values
+		}
+	}
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	options := []ConfigOption{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if unconfigurableFlags[f.Name] || flagShorthands[f.Name] != "" {
+			return
+		}
+		o := ConfigOption{Name: f.Name, Value: f.Value.String(), Default: f.DefValue, Source: "default",
+			Reloadable: isReloadable(f.Name), Usage: f.Usage}
+		_, fromEnv := os.LookupEnv(envName(f.Name))
+		_, fromFile := fileValues[f.Name]
+		switch {
+		case commandLineFlags[f.Name]:
+			o.Source = "flag"
+		case fromEnv:
+			o.Source = "env"
+		case fromFile:
+			o.Source = "config"
+		}
+		switch {
+		case secretFlags[f.Name] && o.Value != "":
+			o.Value = "xxxxx"
+		case strings.Contains(o.Value, "://"):
+			o.Value = redactURL(o.Value)
+		}
+		options = append(options, o)
+	})
+	sort.Slice(options, func(i, j int) bool { return options[i].Name < options[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"configFile": configPath, "options": options})
+}
+
+// listStreams shows the audio being sent and the event connections open, either of which
+// DELETE /api/admin/streams/{id} ends
+func listStreams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"streams": streams.List(), "sessions": streams.Sessions()})
+}
+
+func stopStream(w http.ResponseWriter, r *http.Request) {
+	if !streams.Stop(r.PathValue("id")) {
+		http.Error(w, "No such stream or session", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// apiV1 is the versioned prefix for the API. Every /api route is also served under it.
+const apiV1 = "/api/v1"
+
+// routes records every pattern registered through handleFunc, for the OpenAPI document
+var routes []string
+
+// handleFunc registers a handler on the default mux like http.HandleFunc, and remembers it
+func handleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	routes = append(routes, pattern)
+	http.HandleFunc(pattern, handler)
+}
+
+// APIError is the body of every API error. The request ID matches the X-Request-ID header
+// and the request's log line.
+type APIError struct {
+	Error struct {
+		Code      string `json:"code"`
+		Status    int    `json:"status"`
+		Message   string `json:"message"`
+		RequestID string `json:"requestId,omitempty"`
+	} `json:"error"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// versionedAPI routes /api/v1/... to the /api/... handlers, so auth, CSRF, rate limits and
+// the rest see the same paths either way
+func versionedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, apiV1)
+		if !ok || rest != "" && !strings.HasPrefix(rest, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/api" + rest
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags each request with an ID, taking a proxy's X-Request-ID when it looks sane
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// jsonErrors makes every API error an APIError, whichever handler or middleware it came from
+func jsonErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api" && !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		jw := &jsonErrorWriter{ResponseWriter: w, requestID: requestID(r)}
+		next.ServeHTTP(jw, r)
+		jw.finish()
+	})
+}
+
+// jsonErrorWriter turns the plain text errors handlers write with http.Error into APIError
+// objects. Anything else passes straight through.
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	requestID string
+	status    int // Of the error being held back, 0 when there isn't one
+	body      bytes.Buffer
+}
+
+func (w *jsonErrorWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *jsonErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *jsonErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *jsonErrorWriter) finish() {
+	if w.status == 0 {
+		return
+	}
+	var body APIError
+	body.Error.Code = errorCode(w.status)
+	body.Error.Status = w.status
+	body.Error.Message = strings.TrimSpace(w.body.String())
+	body.Error.RequestID = w.requestID
+	w.Header().Del("Content-Length")
+	writeJSON(w.ResponseWriter, w.status, body)
+}
+
+func registerAPIRoutes() {
+	handleFunc("GET /api/openapi.json", getOpenAPI)
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)(\.\.\.)?\}`)
+
+// openAPIDocument describes every /api route as an OpenAPI 3 document, built from the
+// registered patterns so it can't drift from what's served
+func openAPIDocument() map[string]any {
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content": map[string]any{"application/json": map[string]any{
+			"schema": map[string]any{"$ref": "#/components/schemas/Error"},
+		}},
+	}
+	paths := map[string]map[string]any{}
+	patterns := append([]string(nil), routes...)
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		method, p, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, p = "GET", pattern
+		}
+		rest, ok := strings.CutPrefix(p, "/api/")
+		if !ok || p == "/api/openapi.json" {
+			continue
+		}
+		var params []map[string]any
+		for _, m := range pathParam.FindAllStringSubmatch(rest, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]string{"type": "string"},
+			})
+		}
+		rest = pathParam.ReplaceAllString(rest, "{$1}")
+		op := map[string]any{
+			"operationId": operationID(method, rest),
+			"tags":        []string{strings.Split(rest, "/")[0]},
+			"responses": map[string]any{
+				"2XX":     map[string]any{"description": "Success"},
+				"default": errorResponse,
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		switch method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			op["requestBody"] = map[string]any{"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]string{"type": "object"}},
+			}}
+		}
+		if paths["/"+rest] == nil {
+			paths["/"+rest] = map[string]any{}
+		}
+		paths["/"+rest][strings.ToLower(method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "Beatgraze",
+			"version": versionInfo().Version,
+		},
+		"servers": []map[string]string{{"url": prefixed(apiV1)}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"error": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"code":      map[string]string{"type": "string"},
+								"status":    map[string]string{"type": "integer"},
+								"message":   map[string]string{"type": "string"},
+								"requestId": map[string]string{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"basic":   map[string]string{"type": "http", "scheme": "basic"},
+				"bearer":  map[string]string{"type": "http", "scheme": "bearer"},
+				"session": map[string]string{"type": "apiKey", "in": "cookie", "name": sessionCookie},
+			},
+		},
+		"security": []map[string][]string{{"basic": {}}, {"bearer": {}}, {"session": {}}},
+	}
+}
+
+// operationID names an operation from its route, like "getPlaylistsIdTracks"
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	upper := true
+	for _, r := range path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(openAPIDocument())
+}
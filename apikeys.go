@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const apiKeyPrefix = "bgz_"
+
+type APIScope string
+
+const (
+	scopeRead      APIScope = "read"
+	scopeReadWrite APIScope = "read-write"
+)
+
+// APIKey lets scripts and clients act as a user without their password. Only a hash of
+// the key is kept, so a leaked users.json can't be replayed.
+type APIKey struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	UserID   string    `json:"userId,omitempty"` // Empty for keys made before any account existed
+	Scope    APIScope  `json:"scope"`
+	Hint     string    `json:"hint"` // Last few characters, to tell keys apart
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed,omitzero"`
+}
+
+var errAPIKeyNotFound = errors.New("API key not found")
+
+func (s *UserStore) CreateAPIKey(name, userID string, scope APIScope) (string, APIKey, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", APIKey{}, err
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if userID != "" {
+		if _, ok := s.Users[userID]; !ok {
+			return "", APIKey{}, errUserNotFound
+		}
+	}
+	key := &APIKey{
+		ID:      newID(),
+		Name:    name,
+		UserID:  userID,
+		Scope:   scope,
+		Hint:    secret[len(secret)-4:],
+		Created: time.Now().UTC(),
+	}
+	hash := hashSecret(secret)
+	s.APIKeys[hash] = key
+	if err := saveJSON(s.path, s); err != nil {
+		delete(s.APIKeys, hash)
+		return "", APIKey{}, err
+	}
+	return secret, *key, nil
+}
+
+func (s *UserStore) ListAPIKeys() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]APIKey, 0, len(s.APIKeys))
+	for _, k := range s.APIKeys {
+		list = append(list, *k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+func (s *UserStore) RevokeAPIKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, k := range s.APIKeys {
+		if k.ID == id {
+			delete(s.APIKeys, hash)
+			if err := saveJSON(s.path, s); err != nil {
+				s.APIKeys[hash] = k
+				return err
+			}
+			return nil
+		}
+	}
+	return errAPIKeyNotFound
+}
+
+// LookupAPIKey finds the key and, for keys tied to an account, its user. Use times are
+// kept in memory and written out with the next change rather than on every request.
+func (s *UserStore) LookupAPIKey(secret string) (APIKey, *User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.APIKeys[hashSecret(secret)]
+	if !ok {
+		return APIKey{}, nil, false
+	}
+	var user *User
+	if k.UserID != "" {
+		u, ok := s.Users[k.UserID]
+		if !ok {
+			return APIKey{}, nil, false
+		}
+		c := *u
+		user = &c
+	}
+	k.LastUsed = time.Now().UTC()
+	return *k, user, true
+}
+
+// Flush writes out the key use times LookupAPIKey has been keeping in memory
+func (s *UserStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return saveJSON(s.path, s)
+}
+
+// allowedByScope reports whether a key's scope lets it make this request
+func allowedByScope(scope APIScope, r *http.Request) bool {
+	if scope == scopeReadWrite {
+		return true
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || queryRoutes[r.Method+" "+r.URL.Path]
+}
+
+func registerAPIKeyRoutes() {
+	handleFunc("GET /api/keys", requireRole(roleAdmin, listAPIKeys))
+	handleFunc("POST /api/keys", requireRole(roleAdmin, createAPIKey))
+	handleFunc("DELETE /api/keys/{id}", requireRole(roleAdmin, revokeAPIKey))
+}
+
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, users.ListAPIKeys())
+}
+
+// createAPIKey returns the secret once; afterwards only its hint is shown
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		UserID string   `json:"userId"`
+		Scope  APIScope `json:"scope"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Key name is required", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = scopeRead
+	}
+	if req.Scope != scopeRead && req.Scope != scopeReadWrite {
+		http.Error(w, "scope must be read or read-write", http.StatusBadRequest)
+		return
+	}
+	// Keys default to the admin creating them
+	if u := currentUser(r); req.UserID == "" && u != nil {
+		req.UserID = u.ID
+	}
+
+	secret, key, err := users.CreateAPIKey(req.Name, req.UserID, req.Scope)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		APIKey
+		Key string `json:"key"`
+	}{key, secret})
+}
+
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := users.RevokeAPIKey(r.PathValue("id"))
+	if errors.Is(err, errAPIKeyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one line of audit.jsonl
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"` // Account name, "operator" for -auth/-auth-token, or "anonymous"
+	IP     string    `json:"ip"`
+	Action string    `json:"action"` // Method and route pattern, e.g. "DELETE /api/playlists/{id}"
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// unauditedActions are routine listening bookkeeping that would drown out the interesting entries
+var unauditedActions = map[string]bool{
+	"POST /api/plays":    true,
+	"PUT /api/positions": true,
+	// Each tus chunk; creating the upload is what gets recorded
+	"PATCH /api/uploads/tus/{id}": true,
+	// WebDAV listings, and the locks clients take around saving
+	"PROPFIND /dav/": true,
+	"LOCK /dav/":     true,
+	"UNLOCK /dav/":   true,
+}
+
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+var auditLog *AuditLog
+
+func (a *AuditLog) Append(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Query returns matching entries, newest first
+func (a *AuditLog) Query(match func(AuditEntry) bool, limit int) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	result := make([]AuditEntry, 0, min(limit, len(entries)))
+	for i := len(entries) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, entries[i])
+	}
+	return result, nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func requestUserName(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.Name
+	}
+	if authCredentials != "" || authToken != "" {
+		return "operator"
+	}
+	return "anonymous"
+}
+
+// auditWrites records every request that can change something, whether or not it succeeded
+func auditWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		action := r.Method + " " + r.URL.Path
+		if _, pattern := http.DefaultServeMux.Handler(r); pattern != "" {
+			action = pattern
+		}
+		if !strings.HasPrefix(action, r.Method) {
+			action = r.Method + " " + action
+		}
+		if unauditedActions[action] || queryRoutes[action] {
+			return
+		}
+		err := auditLog.Append(AuditEntry{
+			Time:   time.Now().UTC(),
+			User:   requestUserName(r),
+			IP:     clientIP(r).String(),
+			Action: action,
+			Path:   r.URL.Path,
+			Status: rec.status,
+		})
+		if err != nil {
+			slog.Error("Error writing audit log", "err", err)
+		}
+	})
+}
+
+func registerAuditRoutes() {
+	handleFunc("GET /api/audit", requireRole(roleAdmin, getAuditLog))
+}
+
+// getAuditLog filters by ?user=, ?action= (substring), ?since= (RFC 3339 or an age like 7d) and ?limit=
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 100
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 10000 {
+		limit = l
+	}
+	var since time.Time
+	if s := q.Get("since"); s != "" {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			since = t
+		} else if age, err := parseAge(s); err == nil {
+			since = time.Now().Add(-age)
+		} else {
+			http.Error(w, "since must be an RFC 3339 time or an age like 24h or 7d", http.StatusBadRequest)
+			return
+		}
+	}
+	user, action := q.Get("user"), strings.ToLower(q.Get("action"))
+
+	entries, err := auditLog.Query(func(e AuditEntry) bool {
+		return (user == "" || strings.EqualFold(e.User, user)) &&
+			(action == "" || strings.Contains(strings.ToLower(e.Action), action)) &&
+			!e.Time.Before(since)
+	}, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const authTokenCookie = "beatgraze_token"
+
+var (
+	authCredentials string // "user:pass" for HTTP Basic auth
+	authToken       string
+)
+
+// secureCompare hashes both sides first so the comparison time doesn't leak the secret's length either
+func secureCompare(given, want string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// requestToken finds a token in the Authorization header, ?token= query parameter or cookie
+func requestToken(r *http.Request) (token string, fromQuery bool) {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer), false
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, true
+	}
+	if cookie, err := r.Cookie(authTokenCookie); err == nil {
+		return cookie.Value, false
+	}
+	return "", false
+}
+
+// requestAPIKey finds an API key sent as a bearer token, or for reading, as ?token= for feed
+// readers and podcast apps, which can't send headers
+func requestAPIKey(r *http.Request) (string, bool) {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(bearer, apiKeyPrefix) {
+		return strings.TrimSpace(bearer), true
+	}
+	if token := r.URL.Query().Get("token"); strings.HasPrefix(token, apiKeyPrefix) && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return token, true
+	}
+	return "", false
+}
+
+// requireAuth guards every endpoint when -auth or -auth-token is set, or once any account
+// exists. Operator credentials, a session cookie, an API key or an account's name and password all work.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if u, ok := users.SessionUser(cookie.Value); ok {
+				next.ServeHTTP(w, withUser(r, u))
+				return
+			}
+		}
+
+		hasUsers := users.Count() > 0
+		if authCredentials == "" && authToken == "" && !hasUsers && !oidcEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Signing in, share links, their players and oEmbed details, and cast and Home Assistant media, which carry their own tokens, Subsonic clients,
+		// which send their credentials as parameters, and DLNA players on the local network
+		if r.Method == http.MethodPost && r.URL.Path == "/api/login" || strings.HasPrefix(r.URL.Path, "/auth/oidc/") || strings.HasPrefix(r.URL.Path, "/s/") || strings.HasPrefix(r.URL.Path, "/embed/") || r.URL.Path == "/oembed" || strings.HasPrefix(r.URL.Path, "/cast/") || strings.HasPrefix(r.URL.Path, "/ha/media/") || subsonicCredentials(r) || dlnaRequest(r) {
+			next.ServeHTTP(w, withoutSignIn(r))
+			return
+		}
+
+		if secret, ok := requestAPIKey(r); ok {
+			if loginThrottled(w, r, "") {
+				return
+			}
+			key, u, ok := users.LookupAPIKey(secret)
+			if !ok {
+				authFailed(r, "api-key", "")
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			if !allowedByScope(key.Scope, r) {
+				http.Error(w, "This API key is read-only", http.StatusForbidden)
+				return
+			}
+			if u != nil {
+				r = withUser(r, *u)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if authToken != "" {
+			token, fromQuery := requestToken(r)
+			if token != "" && loginThrottled(w, r, "") {
+				return
+			}
+			if token != "" && secureCompare(token, authToken) {
+				// Remember a token from a link so the page's own fetches and <audio> requests carry it
+				if fromQuery {
+					http.SetCookie(w, &http.Cookie{
+						Name:     authTokenCookie,
+						Value:    token,
+						Path:     prefixed("/"),
+						HttpOnly: true,
+						SameSite: http.SameSiteStrictMode,
+						Secure:   r.TLS != nil,
+					})
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if token != "" {
+				authFailed(r, "token", "")
+			}
+		}
+		if user, pass, ok := r.BasicAuth(); ok {
+			if loginThrottled(w, r, user) {
+				return
+			}
+			if authCredentials != "" && secureCompare(user+":"+pass, authCredentials) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// bcrypt is deliberately slow, so swap Basic credentials for a session after the first request
+			if u, ok := users.Authenticate(user, pass); ok {
+				loginGuard.Succeed(user)
+				if err := startSession(w, r, u); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				next.ServeHTTP(w, withUser(r, u))
+				return
+			}
+			authFailed(r, "basic", user)
+		}
+		// Browsers opening the app go straight to the identity provider
+		if oidcEnabled() && r.Method == http.MethodGet && r.URL.Path == "/" {
+			http.Redirect(w, r, prefixed("/auth/oidc/login"), http.StatusFound)
+			return
+		}
+		if authCredentials != "" || hasUsers {
+			w.Header().Set("WWW-Authenticate", `Basic realm="beatgraze", charset="UTF-8"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// basePath is where a reverse proxy mounts beatgraze, like "/beatgraze", or "" for the root
+var basePath string
+
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// prefixed turns a route into the URL path clients see
+func prefixed(path string) string {
+	return basePath + path
+}
+
+// withBasePath strips basePath before routing, so every route works under the prefix unchanged
+func withBasePath(next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		path, ok := strings.CutPrefix(r.URL.Path, basePath+"/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + path
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// injectBasePath points the page's relative URLs at basePath, wherever in the app it's opened
+func injectBasePath(page string) string {
+	return strings.Replace(page, "<head>", `<head>
+    <base href="`+html.EscapeString(basePath)+`/">`, 1)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cacheBlockSize is the unit remote files are fetched and cached in, big enough that
+// sequential playback makes few requests and small enough that a seek doesn't waste much
+const cacheBlockSize = 1 << 20
+
+// CacheConfig holds the flags for the disk cache in front of remote libraries
+type CacheConfig struct {
+	Dir    string
+	SizeMB int
+}
+
+var cacheConfig = CacheConfig{SizeMB: 2048}
+
+// diskCache keeps recently read blocks of remote files, so repeat plays and seeks don't go
+// back to S3, WebDAV or SFTP; nil when the library is local or the cache is off
+var diskCache *blockCache
+
+// blockCache is a size-capped store of file blocks on disk, evicting the least recently used.
+// Blocks are keyed by the file's identity including its size and modification time, so
+// changed files simply stop hitting their old blocks, which then age out.
+type blockCache struct {
+	dir      string
+	maxBytes int64
+
+	mu     sync.Mutex
+	size   int64
+	lru    *list.List // Of *cachedBlock, most recently used first
+	blocks map[string]*list.Element
+}
+
+type cachedBlock struct {
+	key  string
+	size int64
+}
+
+// openBlockCache picks up blocks left by earlier runs, oldest first in the LRU order
+func openBlockCache(dir string, maxBytes int64) (*blockCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &blockCache{dir: dir, maxBytes: maxBytes, lru: list.New(), blocks: map[string]*list.Element{}}
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var existing []found
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if filepath.Ext(path) == ".tmp" {
+			os.Remove(path)
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			existing = append(existing, found{key: d.Name(), size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.After(existing[j].modTime) })
+	for _, f := range existing {
+		c.blocks[f.key] = c.lru.PushBack(&cachedBlock{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// cacheKey names one block of a file in a storage
+func cacheKey(storageID, path string, size int64, modTime time.Time, block int64) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d", storageID, path, size, modTime.UnixNano())))
+	return hex.EncodeToString(h[:16]) + "-" + strconv.FormatInt(block, 10)
+}
+
+func (c *blockCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Get returns a cached block, or nil if it isn't cached
+func (c *blockCache) Get(key string) []byte {
+	c.mu.Lock()
+	e, ok := c.blocks[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		metrics.ObserveCache(false)
+		return nil
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		c.remove(key)
+		metrics.ObserveCache(false)
+		return nil
+	}
+	// Recency survives a restart through the modification time
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	metrics.ObserveCache(true)
+	return data
+}
+
+func (c *blockCache) Put(key string, data []byte) {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	tmp := path + "." + strconv.FormatInt(time.Now().UnixNano(), 36) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.blocks[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, size: int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+}
+
+func (c *blockCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.blocks[key]; ok {
+		c.size -= e.Value.(*cachedBlock).size
+		c.lru.Remove(e)
+		delete(c.blocks, key)
+	}
+}
+
+// evict drops the least recently used blocks until the cache fits; c.mu must be held
+func (c *blockCache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		b := e.Value.(*cachedBlock)
+		os.Remove(c.path(b.key))
+		c.size -= b.size
+		c.lru.Remove(e)
+		delete(c.blocks, b.key)
+	}
+}
+
+// Size is the bytes currently cached
+func (c *blockCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Clear drops every cached block, returning the bytes freed
+func (c *blockCache) Clear() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	freed := c.size
+	for key := range c.blocks {
+		os.Remove(c.path(key))
+	}
+	c.size = 0
+	c.lru.Init()
+	c.blocks = map[string]*list.Element{}
+	return freed
+}
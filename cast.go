@@ -0,0 +1,939 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// castURL is where cast devices fetch tracks from, for when the address they'd reach us
+// on can't be worked out, e.g. behind a proxy or on a Unix socket
+var castURL string
+
+const (
+	castMediaReceiver = "CC1AD845" // Google's Default Media Receiver app
+	castSender        = "sender-beatgraze"
+	castReceiver      = "receiver-0"
+
+	castConnectionNS = "urn:x-cast:com.google.cast.tp.connection"
+	castHeartbeatNS  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	castReceiverNS   = "urn:x-cast:com.google.cast.receiver"
+	castMediaNS      = "urn:x-cast:com.google.cast.media"
+
+	castDeviceAge = time.Minute // How long a discovery is reused before asking the LAN again
+)
+
+// castFormats are the library formats cast devices play as they are; anything else is
+// transcoded
+var castFormats = map[string]bool{"mp3": true, "flac": true, "wav": true, "m4a": true, "aac": true, "ogg": true}
+
+// castTranscodes are the formats tracks can be transcoded to for casting, with the ffmpeg
+// muxer and encoder options, which have to stream rather than seek back
+var castTranscodes = map[string][]string{
+	"mp3":  {"-f", "mp3", "-c:a", "libmp3lame", "-q:a", "2"},
+	"ogg":  {"-f", "ogg", "-c:a", "libvorbis", "-q:a", "6"},
+	"flac": {"-f", "flac", "-c:a", "flac"},
+	"wav":  {"-f", "wav", "-c:a", "pcm_s16le"},
+	"aac":  {"-f", "adts", "-c:a", "aac", "-b:a", "256k"},
+}
+
+var (
+	errCastUnknownDevice = errors.New("no cast device with that ID was found on the network")
+	errCastNotPlaying    = errors.New("nothing is being cast to that device")
+	errCastClosed        = errors.New("the cast device closed the connection")
+)
+
+// CastDevice is a Chromecast, or anything else speaking the cast protocol, found over mDNS
+type CastDevice struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Model string `json:"model,omitempty"`
+	Addr  string `json:"addr"`
+}
+
+// CastStatus is what a device is playing, as it last reported
+type CastStatus struct {
+	Device   CastDevice `json:"device"`
+	User     string     `json:"user,omitempty"` // Who started the track
+	Path     string     `json:"path,omitempty"`
+	Format   string     `json:"format,omitempty"` // What the track is transcoded to, empty when sent as is
+	State    string     `json:"state"`            // IDLE, BUFFERING, PLAYING or PAUSED
+	Position float64    `json:"position"`
+	Duration float64    `json:"duration,omitempty"`
+	Updated  time.Time  `json:"updated"`
+}
+
+// castMedia is a track handed to a device, served on /cast/{token} while it's playing
+type castMedia struct {
+	Path   string
+	Format string
+	Start  float64 // Where a transcoded stream starts, since those can't seek
+}
+
+// CastManager keeps a connection to each device something is being cast to
+type CastManager struct {
+	mu         sync.Mutex
+	devices    map[string]CastDevice
+	discovered time.Time
+	sessions   map[string]*castSession
+	media      map[string]castMedia
+}
+
+var casts = &CastManager{devices: map[string]CastDevice{}, sessions: map[string]*castSession{}, media: map[string]castMedia{}}
+
+// Devices lists the cast devices on the network, asking again when refresh is set or the
+// last answer is stale
+func (m *CastManager) Devices(refresh bool) ([]CastDevice, error) {
+	m.mu.Lock()
+	fresh := time.Since(m.discovered) < castDeviceAge
+	m.mu.Unlock()
+	if refresh || !fresh {
+		found, err := discoverCastDevices(2 * time.Second)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.devices = map[string]CastDevice{}
+		for _, d := range found {
+			m.devices[d.ID] = d
+		}
+		m.discovered = time.Now()
+		m.mu.Unlock()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	devices := make([]CastDevice, 0, len(m.devices))
+	for _, d := range m.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+func (m *CastManager) device(id string) (CastDevice, error) {
+	m.mu.Lock()
+	d, ok := m.devices[id]
+	m.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+	// It may have turned up since the last look
+	if _, err := m.Devices(true); err != nil {
+		return CastDevice{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d, ok := m.devices[id]; ok {
+		return d, nil
+	}
+	return CastDevice{}, errCastUnknownDevice
+}
+
+// session returns the open connection to a device, when there is one
+func (m *CastManager) session(id string) (*castSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, errCastNotPlaying
+	}
+	return s, nil
+}
+
+// connect returns the connection to a device, opening one and launching the media
+// receiver on it if needed
+func (m *CastManager) connect(id string) (*castSession, error) {
+	if s, err := m.session(id); err == nil {
+		return s, nil
+	}
+	d, err := m.device(id)
+	if err != nil {
+		return nil, err
+	}
+	s, err := dialCast(d)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	if old, ok := m.sessions[id]; ok {
+		// Someone else connected first
+		m.mu.Unlock()
+		s.close()
+		return old, nil
+	}
+	m.sessions[id] = s
+	m.mu.Unlock()
+	go func() {
+		<-s.closed
+		s.mu.Lock()
+		token := s.token
+		s.mu.Unlock()
+		m.mu.Lock()
+		if m.sessions[id] == s {
+			delete(m.sessions, id)
+		}
+		delete(m.media, token)
+		m.mu.Unlock()
+	}()
+	return s, nil
+}
+
+// Statuses reports every device being cast to
+func (m *CastManager) Statuses() []CastStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]CastStatus, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		statuses = append(statuses, s.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Device.Name < statuses[j].Device.Name })
+	return statuses
+}
+
+// serve swaps the track a session's token points at, so the device can only fetch what it
+// was last told to play
+func (m *CastManager) serve(s *castSession, media castMedia) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.media, s.token)
+	token := newID() + newID()
+	m.media[token] = media
+	return token
+}
+
+func (m *CastManager) lookup(token string) (castMedia, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	media, ok := m.media[token]
+	return media, ok
+}
+
+// discoverCastDevices asks the LAN for _googlecast._tcp over mDNS and collects answers
+// until timeout. Asking from an ephemeral port gets the answers sent straight back.
+func discoverCastDevices(timeout time.Duration) ([]CastDevice, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	service := dnsmessage.MustNewName("_googlecast._tcp.local.")
+	query := dnsmessage.Message{Questions: []dnsmessage.Question{{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}}}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(packet, mdnsGroup); err != nil {
+		return nil, err
+	}
+
+	type instance struct {
+		host string
+		port uint16
+		txt  map[string]string
+	}
+	instances := map[string]*instance{}
+	hosts := map[string]net.IP{}
+	get := func(name string) *instance {
+		if instances[name] == nil {
+			instances[name] = &instance{txt: map[string]string{}}
+		}
+		return instances[name]
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || !msg.Response {
+			continue
+		}
+		for _, rr := range append(msg.Answers, msg.Additionals...) {
+			name := strings.ToLower(rr.Header.Name.String())
+			switch body := rr.Body.(type) {
+			case *dnsmessage.PTRResource:
+				if name == service.String() {
+					get(strings.ToLower(body.PTR.String()))
+				}
+			case *dnsmessage.SRVResource:
+				in := get(name)
+				in.host, in.port = strings.ToLower(body.Target.String()), body.Port
+			case *dnsmessage.TXTResource:
+				in := get(name)
+				for _, kv := range body.TXT {
+					if k, v, ok := strings.Cut(kv, "="); ok {
+						in.txt[k] = v
+					}
+				}
+			case *dnsmessage.AResource:
+				hosts[name] = net.IP(body.A[:])
+			}
+		}
+	}
+
+	var devices []CastDevice
+	for name, in := range instances {
+		ip, ok := hosts[in.host]
+		if !strings.HasSuffix(name, "."+service.String()) || !ok || in.port == 0 {
+			continue
+		}
+		d := CastDevice{
+			ID:    firstNonEmpty(in.txt["id"], strings.TrimSuffix(name, "."+service.String())),
+			Name:  firstNonEmpty(in.txt["fn"], strings.TrimSuffix(name, "."+service.String())),
+			Model: in.txt["md"],
+			Addr:  net.JoinHostPort(ip.String(), strconv.Itoa(int(in.port))),
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// castMessage is the protocol's CastMessage protobuf, always with a JSON payload
+type castMessage struct {
+	Source, Destination, Namespace, Payload string
+}
+
+func (m castMessage) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType) // protocol_version: CASTV2_1_0
+	b = protowire.AppendVarint(b, 0)
+	for i, s := range []string{m.Source, m.Destination, m.Namespace} {
+		b = protowire.AppendTag(b, protowire.Number(i+2), protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	b = protowire.AppendTag(b, 5, protowire.VarintType) // payload_type: STRING
+	b = protowire.AppendVarint(b, 0)
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	return protowire.AppendString(b, m.Payload)
+}
+
+func unmarshalCastMessage(b []byte) (castMessage, error) {
+	var m castMessage
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			switch num {
+			case 2:
+				m.Source = v
+			case 3:
+				m.Destination = v
+			case 4:
+				m.Namespace = v
+			case 6:
+				m.Payload = v
+			}
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return m, nil
+}
+
+// castReply is the part of a device's JSON messages we look at
+type castReply struct {
+	Type      string          `json:"type"`
+	RequestID int64           `json:"requestId"`
+	Status    json.RawMessage `json:"status"`
+	Reason    string          `json:"reason"`
+}
+
+type castMediaStatus struct {
+	MediaSessionID int     `json:"mediaSessionId"`
+	PlayerState    string  `json:"playerState"`
+	CurrentTime    float64 `json:"currentTime"`
+	Media          *struct {
+		Duration float64 `json:"duration"`
+	} `json:"media"`
+}
+
+// castSession is a connection to one device and the media receiver running on it
+type castSession struct {
+	conn    *tls.Conn
+	writeMu sync.Mutex
+	nextID  atomic.Int64
+	closed  chan struct{}
+	once    sync.Once
+
+	mu           sync.Mutex
+	waiting      map[int64]chan castReply
+	transport    string // The receiver app's ID, which media messages go to
+	appSession   string
+	mediaSession int
+	token        string
+	media        castMedia
+	status       CastStatus
+}
+
+func dialCast(d CastDevice) (*castSession, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	// Cast devices present certificates signed by Google's device CA, not one a client can
+	// check a LAN address against
+	conn, err := tls.DialWithDialer(dialer, "tcp", d.Addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to %s: %w", d.Name, err)
+	}
+	s := &castSession{
+		conn:    conn,
+		closed:  make(chan struct{}),
+		waiting: map[int64]chan castReply{},
+		status:  CastStatus{Device: d, State: "IDLE", Updated: time.Now()},
+	}
+	go s.read()
+	go s.heartbeat()
+
+	if err := s.send(castConnectionNS, castReceiver, map[string]any{"type": "CONNECT"}); err != nil {
+		s.close()
+		return nil, err
+	}
+	reply, err := s.request(castReceiverNS, castReceiver, map[string]any{"type": "LAUNCH", "appId": castMediaReceiver})
+	if err == nil && reply.Type != "RECEIVER_STATUS" {
+		err = fmt.Errorf("%s wouldn't start the media receiver: %s", d.Name, firstNonEmpty(reply.Reason, reply.Type))
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	var status struct {
+		Applications []struct {
+			AppID       string `json:"appId"`
+			SessionID   string `json:"sessionId"`
+			TransportID string `json:"transportId"`
+		} `json:"applications"`
+	}
+	json.Unmarshal(reply.Status, &status)
+	for _, app := range status.Applications {
+		if app.AppID == castMediaReceiver {
+			s.transport, s.appSession = app.TransportID, app.SessionID
+		}
+	}
+	if s.transport == "" {
+		s.close()
+		return nil, fmt.Errorf("%s didn't start the media receiver", d.Name)
+	}
+	if err := s.send(castConnectionNS, s.transport, map[string]any{"type": "CONNECT"}); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *castSession) close() {
+	s.once.Do(func() {
+		s.conn.Close()
+		close(s.closed)
+	})
+}
+
+func (s *castSession) send(namespace, destination string, payload map[string]any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	frame := castMessage{Source: castSender, Destination: destination, Namespace: namespace, Payload: string(body)}.marshal()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := binary.Write(s.conn, binary.BigEndian, uint32(len(frame))); err != nil {
+		return err
+	}
+	_, err = s.conn.Write(frame)
+	return err
+}
+
+// request sends a message and waits for the device's answer to it
+func (s *castSession) request(namespace, destination string, payload map[string]any) (castReply, error) {
+	id := s.nextID.Add(1)
+	payload["requestId"] = id
+	ch := make(chan castReply, 1)
+	s.mu.Lock()
+	s.waiting[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiting, id)
+		s.mu.Unlock()
+	}()
+	if err := s.send(namespace, destination, payload); err != nil {
+		return castReply{}, err
+	}
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-s.closed:
+		return castReply{}, errCastClosed
+	case <-time.After(20 * time.Second):
+		return castReply{}, fmt.Errorf("%s didn't answer", s.status.Device.Name)
+	}
+}
+
+func (s *castSession) read() {
+	defer s.close()
+	for {
+		var size uint32
+		if err := binary.Read(s.conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size > 1<<20 {
+			slog.Warn("Cast device sent an oversized message", "device", s.status.Device.Name, "size", size)
+			return
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(s.conn, frame); err != nil {
+			return
+		}
+		msg, err := unmarshalCastMessage(frame)
+		if err != nil {
+			continue
+		}
+		var reply castReply
+		if json.Unmarshal([]byte(msg.Payload), &reply) != nil {
+			continue
+		}
+		switch {
+		case reply.Type == "PING":
+			go s.send(castHeartbeatNS, msg.Source, map[string]any{"type": "PONG"})
+		case reply.Type == "CLOSE" && msg.Source == s.transport:
+			// The receiver app was stopped, by us or from another sender
+			return
+		case reply.Type == "MEDIA_STATUS":
+			s.updateStatus(reply.Status)
+		}
+		s.mu.Lock()
+		if ch, ok := s.waiting[reply.RequestID]; ok && reply.RequestID != 0 {
+			ch <- reply
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *castSession) heartbeat() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.send(castHeartbeatNS, castReceiver, map[string]any{"type": "PING"}); err != nil {
+				s.close()
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *castSession) updateStatus(raw json.RawMessage) {
+	var statuses []castMediaStatus
+	if json.Unmarshal(raw, &statuses) != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(statuses) == 0 {
+		s.status.State = "IDLE"
+		s.status.Updated = time.Now()
+		return
+	}
+	st := statuses[0]
+	s.mediaSession = st.MediaSessionID
+	s.status.State = st.PlayerState
+	// Transcoded streams start at 0 wherever in the track they begin
+	s.status.Position = s.media.Start + st.CurrentTime
+	if st.Media != nil && st.Media.Duration > 0 {
+		s.status.Duration = s.media.Start + st.Media.Duration
+	}
+	s.status.Updated = time.Now()
+}
+
+func (s *castSession) Status() CastStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	// Extrapolate from the last report rather than asking the device
+	if status.State == "PLAYING" {
+		status.Position += time.Since(status.Updated).Seconds()
+		if status.Duration > 0 {
+			status.Position = min(status.Position, status.Duration)
+		}
+	}
+	return status
+}
+
+// load tells the device to play a track, fetched from base
+func (s *castSession) load(base string, media castMedia, user string) error {
+	f, err := libraryFileAt(media.Path)
+	if err != nil {
+		return err
+	}
+	meta := trackMeta(f)
+	token := casts.serve(s, media)
+	contentType := dlnaMime(media.Path)
+	if media.Format != "" {
+		contentType = dlnaMime("." + media.Format)
+	}
+	metadata := map[string]any{
+		"metadataType": 3, // MusicTrackMediaMetadata
+		"title":        firstNonEmpty(meta.Title, strings.TrimSuffix(f.Name, path.Ext(f.Name))),
+		"artist":       meta.Artist,
+		"albumName":    meta.Album,
+	}
+	if _, ok := folderCover(f.Dir); ok {
+		metadata["images"] = []map[string]string{{"url": base + "/" + token + "/cover"}}
+	}
+	load := map[string]any{
+		"type":     "LOAD",
+		"autoplay": true,
+		"media": map[string]any{
+			"contentId":   base + "/" + token,
+			"contentType": contentType,
+			"streamType":  "BUFFERED",
+			"metadata":    metadata,
+		},
+	}
+	if media.Format == "" {
+		load["currentTime"] = media.Start
+	}
+	if media.Format != "" && meta.Duration > 0 {
+		// A transcoded stream is only as long as what's left of the track
+		load["media"].(map[string]any)["duration"] = meta.Duration - media.Start
+	}
+
+	s.mu.Lock()
+	s.token = token
+	s.media = media
+	if media.Format == "" {
+		// Untranscoded files seek on the device, so positions come back counted from 0
+		s.media.Start = 0
+	}
+	s.status.User, s.status.Path, s.status.Format = user, media.Path, media.Format
+	s.status.State, s.status.Position, s.status.Duration, s.status.Updated = "BUFFERING", media.Start, meta.Duration, time.Now()
+	s.mu.Unlock()
+
+	reply, err := s.request(castMediaNS, s.transport, load)
+	if err != nil {
+		return err
+	}
+	if reply.Type != "MEDIA_STATUS" {
+		return fmt.Errorf("%s couldn't play %s: %s", s.status.Device.Name, media.Path, firstNonEmpty(reply.Reason, reply.Type))
+	}
+	return nil
+}
+
+// control sends PLAY, PAUSE or another media command about the current track
+func (s *castSession) control(command string, extra map[string]any) error {
+	s.mu.Lock()
+	mediaSession := s.mediaSession
+	s.mu.Unlock()
+	if mediaSession == 0 {
+		return errCastNotPlaying
+	}
+	payload := map[string]any{"type": command, "mediaSessionId": mediaSession}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	reply, err := s.request(castMediaNS, s.transport, payload)
+	if err != nil {
+		return err
+	}
+	if reply.Type != "MEDIA_STATUS" {
+		return fmt.Errorf("%s refused %s: %s", s.status.Device.Name, strings.ToLower(command), firstNonEmpty(reply.Reason, reply.Type))
+	}
+	return nil
+}
+
+// seek moves within the current track; transcoded streams can't seek, so they're reloaded
+// from the new position instead
+func (s *castSession) seek(base string, position float64) error {
+	s.mu.Lock()
+	media, user := s.media, s.status.User
+	s.mu.Unlock()
+	if media.Path == "" {
+		return errCastNotPlaying
+	}
+	if media.Format != "" {
+		media.Start = position
+		return s.load(base, media, user)
+	}
+	return s.control("SEEK", map[string]any{"currentTime": position})
+}
+
+// stop quits the receiver app, which also ends the session
+func (s *castSession) stop() error {
+	_, err := s.request(castReceiverNS, castReceiver, map[string]any{"type": "STOP", "sessionId": s.appSession})
+	s.close()
+	return err
+}
+
+// castBase is the URL devices fetch /cast/ media from: -cast-url, or our address on the
+// network the device is on
+func castBase(s *castSession) (string, error) {
+	if castURL != "" {
+		return strings.TrimSuffix(castURL, "/") + "/cast", nil
+	}
+	if tlsEnabled() {
+		return "", errors.New("cast devices can't fetch from this server over HTTPS by IP address; set -cast-url")
+	}
+	_, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", errors.New("can't work out an address cast devices can reach; set -cast-url")
+	}
+	host, _, _ := net.SplitHostPort(s.conn.LocalAddr().String())
+	return "http://" + net.JoinHostPort(host, port) + prefixed("/cast"), nil
+}
+
+func registerCastRoutes() {
+	handleFunc("GET /api/cast/devices", requireRole(roleListener, listCastDevices))
+	handleFunc("GET /api/cast", requireRole(roleListener, listCasts))
+	handleFunc("GET /api/cast/{device}", requireRole(roleListener, getCast))
+	handleFunc("POST /api/cast/{device}/play", requireRole(roleListener, playCast))
+	handleFunc("POST /api/cast/{device}/seek", requireRole(roleListener, seekCast))
+	handleFunc("POST /api/cast/{device}/pause", requireRole(roleListener, controlCast("PAUSE")))
+	handleFunc("POST /api/cast/{device}/resume", requireRole(roleListener, controlCast("PLAY")))
+	handleFunc("POST /api/cast/{device}/stop", requireRole(roleListener, stopCast))
+	handleFunc("GET /cast/{token}", streamCast)
+	handleFunc("GET /cast/{token}/cover", castCover)
+}
+
+func castError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCastUnknownDevice), errors.Is(err, errCastNotPlaying):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func listCastDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := casts.Devices(r.URL.Query().Has("refresh"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func listCasts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, casts.Statuses())
+}
+
+func getCast(w http.ResponseWriter, r *http.Request) {
+	s, err := casts.session(r.PathValue("device"))
+	if err != nil {
+		castError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Status())
+}
+
+func playCast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path     string  `json:"path"`
+		Position float64 `json:"position"`
+		Format   string  `json:"format"` // Transcode to this even if the device could play the file
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	track, err := validateTrack(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Position < 0 {
+		http.Error(w, "position cannot be negative", http.StatusBadRequest)
+		return
+	}
+	media := castMedia{Path: filepath.ToSlash(track), Format: strings.TrimPrefix(strings.ToLower(req.Format), "."), Start: req.Position}
+	if media.Format == "" && !castFormats[strings.TrimPrefix(strings.ToLower(path.Ext(media.Path)), ".")] {
+		media.Format = "mp3"
+	}
+	if media.Format != "" {
+		if _, ok := castTranscodes[media.Format]; !ok {
+			http.Error(w, "format must be one of mp3, ogg, flac, wav or aac", http.StatusBadRequest)
+			return
+		}
+		if _, err := exec.LookPath(ffmpegPath); err != nil {
+			http.Error(w, "Transcoding needs ffmpeg, which isn't installed", http.StatusNotImplemented)
+			return
+		}
+	}
+
+	s, err := casts.connect(r.PathValue("device"))
+	if err != nil {
+		castError(w, err)
+		return
+	}
+	base, err := castBase(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err := s.load(base, media, requestUserName(r)); err != nil {
+		castError(w, err)
+		return
+	}
+	if !readOnly {
+		stats := stateFor(r).stats
+		if err := stats.RecordPlay(media.Path); err != nil {
+			slog.Warn("Error recording cast play", "path", media.Path, "err", err)
+		} else {
+			nowPlaying.Publish(NowPlaying{User: requestUserName(r), Path: media.Path, Time: stats.Get(media.Path).LastPlayed})
+		}
+		if userID, _, ok := scrobbleClient(r); ok {
+			scrobbleStarted(userID, "cast:"+r.PathValue("device"), media.Path)
+		}
+	}
+	writeJSON(w, http.StatusOK, s.Status())
+}
+
+func seekCast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Position float64 `json:"position"`
+	}
+	if err := readJSON(r, &req); err != nil || req.Position < 0 {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	s, err := casts.session(r.PathValue("device"))
+	if err != nil {
+		castError(w, err)
+		return
+	}
+	base, err := castBase(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err := s.seek(base, req.Position); err != nil {
+		castError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Status())
+}
+
+func controlCast(command string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := casts.session(r.PathValue("device"))
+		if err != nil {
+			castError(w, err)
+			return
+		}
+		if err := s.control(command, nil); err != nil {
+			castError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Status())
+	}
+}
+
+func stopCast(w http.ResponseWriter, r *http.Request) {
+	s, err := casts.session(r.PathValue("device"))
+	if err != nil {
+		castError(w, err)
+		return
+	}
+	if err := s.stop(); err != nil && !errors.Is(err, errCastClosed) {
+		castError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// streamCast serves the track a device was told to play. Devices can't sign in, so the
+// unguessable token is what lets them in.
+func streamCast(w http.ResponseWriter, r *http.Request) {
+	media, ok := casts.lookup(r.PathValue("token"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if media.Format == "" {
+		w.Header().Set("Content-Type", dlnaMime(media.Path))
+		serveLibraryFile(w, r, media.Path)
+		return
+	}
+	w.Header().Set("Content-Type", dlnaMime("."+media.Format))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := transcodeForCast(r.Context(), w, media); err != nil && r.Context().Err() == nil {
+		slog.Warn("Error transcoding for casting", "path", media.Path, "err", err)
+	}
+}
+
+func castCover(w http.ResponseWriter, r *http.Request) {
+	media, ok := casts.lookup(r.PathValue("token"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cover, ok := folderCover(libraryDir(media.Path))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	serveLibraryFile(w, r, cover)
+}
+
+// transcodeForCast streams media through ffmpeg, reading local files directly and
+// anything else (archives, remote storage) through a pipe
+func transcodeForCast(ctx context.Context, w io.Writer, media castMedia) error {
+	start := strconv.FormatFloat(media.Start, 'f', 3, 64)
+	args := []string{"-hide_banner", "-nostats", "-loglevel", "error"}
+	var stdin io.Reader
+	if p, ok := localLibraryPath(media.Path); ok {
+		// Seeking before -i is fast, but only works on files
+		args = append(args, "-nostdin", "-ss", start, "-i", p)
+	} else {
+		src, err := library.Open(media.Path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		stdin = src
+		args = append(args, "-i", "pipe:0", "-ss", start)
+	}
+	args = append(append(append(args, "-map", "0:a", "-vn"), castTranscodes[media.Format]...), "pipe:1")
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	var stderr strings.Builder
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, w, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
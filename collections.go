@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Collections are built-in virtual folders assembled on the server, so clients can show
+// "what's new" and "what I've been listening to" without stitching several requests together.
+
+type Collection struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Total int    `json:"total"`
+}
+
+type CollectionFile struct {
+	AudioFile
+	Time time.Time `json:"time"` // When the file was added or last played, depending on the collection
+}
+
+type CollectionResponse struct {
+	Collection
+	Files      []CollectionFile `json:"files"`
+	Page       int              `json:"page"`
+	PerPage    int              `json:"perPage"`
+	TotalPages int              `json:"totalPages"`
+}
+
+var builtinCollections = []struct {
+	id    string
+	name  string
+	build func(st *userState, files []libraryFile, since time.Time) []CollectionFile
+}{
+	{"recently-added", "Recently Added", recentlyAdded},
+	{"recently-played", "Recently Played", recentlyPlayed},
+}
+
+// recentlyAdded uses the file modification time, which is when the file landed in the library for most workflows
+func recentlyAdded(_ *userState, files []libraryFile, since time.Time) []CollectionFile {
+	var items []CollectionFile
+	for _, f := range files {
+		if f.ModTime.After(since) {
+			items = append(items, CollectionFile{AudioFile: f.AudioFile, Time: f.ModTime})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+	return items
+}
+
+// recentlyPlayed lists each track once, at its most recent play, skipping files that no longer exist
+func recentlyPlayed(st *userState, files []libraryFile, since time.Time) []CollectionFile {
+	exists := make(map[string]AudioFile, len(files))
+	for _, f := range files {
+		exists[f.Path] = f.AudioFile
+	}
+	seen := map[string]bool{}
+	var items []CollectionFile
+	for _, play := range st.stats.RecentPlays(maxHistory) {
+		if play.Time.Before(since) {
+			break
+		}
+		file, ok := exists[play.Path]
+		if !ok || seen[play.Path] {
+			continue
+		}
+		seen[play.Path] = true
+		items = append(items, CollectionFile{AudioFile: file, Time: play.Time})
+	}
+	return items
+}
+
+// collectionSince reads the window from ?days=, defaulting to the last 30 days
+func collectionSince(r *http.Request) time.Time {
+	days := 30
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = d
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
+func registerCollectionRoutes() {
+	handleFunc("GET /api/collections", listCollections)
+	handleFunc("GET /api/collections/{id}", getCollection)
+}
+
+func listCollections(w http.ResponseWriter, r *http.Request) {
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := make([]Collection, 0, len(builtinCollections))
+	for _, c := range builtinCollections {
+		list = append(list, Collection{ID: c.id, Name: c.name, Total: len(c.build(stateFor(r), files, collectionSince(r)))})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func getCollection(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	for _, c := range builtinCollections {
+		if c.id != id {
+			continue
+		}
+		files, err := scanLibrary()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items := c.build(stateFor(r), files, collectionSince(r))
+		page, perPage := pageParams(r)
+		start, end, totalPages := paginate(len(items), page, perPage)
+		writeJSON(w, http.StatusOK, CollectionResponse{
+			Collection: Collection{ID: c.id, Name: c.name, Total: len(items)},
+			Files:      items[start:end],
+			Page:       page,
+			PerPage:    perPage,
+			TotalPages: totalPages,
+		})
+		return
+	}
+	http.Error(w, "Collection not found", http.StatusNotFound)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// command is one of "beatgraze <command>". Everything but serve works on the library and
+// the data directory directly, without a server running.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands starts with serve, which runs when no command is given
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "Serve the library (the default)", runServe},
+		{"scan", "List the library's audio files, with -json their tags too", runScan},
+		{"verify", "Read every file through, reporting any that are unreadable or damaged", runVerify},
+		{"organize", "Move tracks into folders named after their tags", runOrganize},
+		{"export", "Write out playlists as M3U8 files, or the library as CSV or JSON", runExport},
+		{"export-site", "Write a static site of the library, with a player page, for any web host", runExportSite},
+		{"users", "List, add and remove accounts and set passwords", runUsers},
+		{"ctl", "Control a running server's jukebox: play, pause, next, queue and status", runCtl},
+		{"tui", "Browse a server's library and queue tracks on its jukebox in the terminal", runTUI},
+		{"backup", "Back up users, playlists and other state", runBackupCommand},
+		{"update", "Replace this binary with the latest release", runUpdate},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Commands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+}
+
+// commandUsage sets a command's -h text
+func commandUsage(fs *flag.FlagSet, args, description string) {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n\n%s\n\nOptions:\n", os.Args[0], fs.Name(), args, description)
+		fs.PrintDefaults()
+	}
+}
+
+// libraryFlags are the options for commands that read the library
+func libraryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&audioDir, "dir", "", "Library to read, as for serve (default: current directory)")
+	fs.StringVar(&pluginsDir, "plugins", "", "Folder of plugins to start, for a library on plugin storage")
+}
+
+// stateFlags are the options for commands that use the server's state
+func stateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&dataDir, "data", "", "The server's data directory (default: user config dir)")
+	fs.StringVar(&storeKind, "store", storeKind, "Where the server keeps its state: bolt, json or a postgres:// URL")
+}
+
+// openCommandLibrary opens -dir, or the directory given as the first argument
+func openCommandLibrary(fs *flag.FlagSet) error {
+	if fs.NArg() > 0 {
+		audioDir = fs.Arg(0)
+	}
+	if pluginsDir != "" {
+		startPlugins(pluginsDir)
+	}
+	storage, dir, err := openLibrary(audioDir)
+	if err != nil {
+		return err
+	}
+	library.Store(storage)
+	audioDir = dir
+	return nil
+}
+
+// openCommandState opens the data store and loads the accounts and their playlists and
+// stats. The caller closes stateStore.
+func openCommandState() error {
+	var err error
+	if dataDir == "" {
+		if dataDir, err = defaultDataDir(); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(dataDir); err != nil {
+		return fmt.Errorf("no data directory: %w", err)
+	}
+	if stateStore, err = openDataStore(storeKind, dataDir); err != nil {
+		return err
+	}
+	if defaultState, err = loadUserState(dataDir); err == nil {
+		users, err = loadUserStore(filepath.Join(dataDir, "users.json"))
+	}
+	if err != nil {
+		stateStore.Close()
+	}
+	return err
+}
+
+// ScannedFile is a file as scan -json prints it
+type ScannedFile struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	TrackMeta
+}
+
+// runScan implements "beatgraze scan", listing each audio file with its size, or with
+// -json or -ndjson its tags and duration too, for scripts to index a library without a server
+func runScan(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	libraryFlags(fs)
+	asJSON := fs.Bool("json", false, "Print a JSON array of the files with their tags and durations")
+	asNDJSON := fs.Bool("ndjson", false, "Like -json, but one object per line as each file is read")
+	commandUsage(fs, "[options] [directory]", "Lists the library's audio files, one per line with its size in bytes, or with -json\nor -ndjson as JSON with their tags and durations.")
+	fs.Parse(args)
+	if *asJSON && *asNDJSON {
+		return errors.New("-json and -ndjson can't both be given")
+	}
+	if err := openCommandLibrary(fs); err != nil {
+		return err
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if *asJSON || *asNDJSON {
+		return writeScan(out, files, *asNDJSON)
+	}
+	var total int64
+	for _, f := range files {
+		fmt.Fprintf(out, "%s\t%d\n", f.Path, f.Size)
+		total += f.Size
+	}
+	fmt.Fprintf(os.Stderr, "%d files, %.1f MB\n", len(files), float64(total)/(1<<20))
+	return nil
+}
+
+// writeScan reads each file's tags and writes it out as it goes, so a big library starts
+// printing straight away
+func writeScan(out *bufio.Writer, files []libraryFile, ndjson bool) error {
+	if !ndjson {
+		out.WriteString("[")
+	}
+	for i, f := range files {
+		line, err := json.Marshal(ScannedFile{Path: f.Path, Size: f.Size, Modified: f.ModTime.UTC(), TrackMeta: trackMeta(f)})
+		if err != nil {
+			return err
+		}
+		switch {
+		case ndjson:
+		case i == 0:
+			out.WriteString("\n")
+		default:
+			out.WriteString(",\n")
+		}
+		out.Write(line)
+		if ndjson {
+			out.WriteString("\n")
+			// Each line goes out whole, for pipelines reading as it runs
+			if err := out.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if !ndjson {
+		out.WriteString("\n]\n")
+	}
+	return out.Flush()
+}
+
+// runVerify implements "beatgraze verify", reading each file to the end and parsing its tags
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	libraryFlags(fs)
+	commandUsage(fs, "[options] [directory]", "Reads every audio file through and parses its tags, listing any that fail.")
+	fs.Parse(args)
+	if err := openCommandLibrary(fs); err != nil {
+		return err
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, f := range files {
+		if err := verifyFile(f); err != nil {
+			fmt.Printf("%s: %v\n", f.Path, err)
+			failed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d files checked\n", len(files))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files have problems", failed, len(files))
+	}
+	return nil
+}
+
+var errTruncated = errors.New("shorter than its listed size")
+
+func verifyFile(f libraryFile) error {
+	if _, err := readTags(f.Path); err != nil && !errors.Is(err, errNoTags) {
+		return err
+	}
+	file, err := library.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	n, err := io.Copy(io.Discard, file)
+	if err != nil {
+		return err
+	}
+	if n < f.Size {
+		return errTruncated
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var configPath string
+
+// Shorthand flags share a variable with their long form, which is the only name configs use
+var flagShorthands = map[string]string{"p": "port", "d": "dir", "h": "help"}
+
+// Flags that are one-off actions rather than settings
+var unconfigurableFlags = map[string]bool{"config": true, "help": true, "version": true, "add-user": true, "role": true}
+
+func envName(flagName string) string {
+	return "BEATGRAZE_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Options given on the command line, which a config file or reload never overrides
+var commandLineFlags = map[string]bool{}
+
+// applyConfig fills in every option not given on the command line, first from the -config
+// file and then from BEATGRAZE_* variables, so the precedence is command line, then
+// environment, then config file, then the built-in defaults.
+func applyConfig() error {
+	flag.Visit(func(f *flag.Flag) {
+		name := f.Name
+		if long, ok := flagShorthands[name]; ok {
+			name = long
+		}
+		commandLineFlags[name] = true
+	})
+	// A positional directory counts as -dir
+	if flag.NArg() > 0 {
+		commandLineFlags["dir"] = true
+	}
+
+	if configPath == "" {
+		configPath = os.Getenv(envName("config"))
+	}
+	values, err := configValues()
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// configValues reads the config file and environment, leaving out options set on the command line
+func configValues() (map[string]string, error) {
+	values := map[string]string{}
+	if configPath != "" {
+		fileValues, err := readConfigFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fileValues {
+			values[name] = value
+		}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if unconfigurableFlags[f.Name] || flagShorthands[f.Name] != "" {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			values[f.Name] = value
+		}
+	})
+	for name := range commandLineFlags {
+		delete(values, name)
+	}
+	return values, nil
+}
+
+// readConfigFile reads the TOML subset beatgraze needs: "option = value" lines named after
+// the flags, where a [section] header prefixes the names below it ([tls] cert = ... is
+// tls-cert), and values are strings, numbers, booleans or one-line arrays of strings.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(stripConfigComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", path, lineNo, fmt.Sprintf(format, args...))
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fail("unterminated section header")
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fail("expected option = value")
+		}
+		name := strings.ReplaceAll(strings.Trim(strings.TrimSpace(key), `"`), "_", "-")
+		if section != "" {
+			name = strings.ReplaceAll(section, "_", "-") + "-" + name
+		}
+		if flag.Lookup(name) == nil || unconfigurableFlags[name] || flagShorthands[name] != "" {
+			return nil, fail("unknown option %q", name)
+		}
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fail("%s: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
+
+// stripConfigComment drops a # comment that isn't inside a quoted string
+func stripConfigComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseConfigValue turns a TOML value into the text its flag would take; arrays become comma lists
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := parseConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, v)
+		}
+		return strings.Join(items, ","), nil
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return raw[1 : len(raw)-1], nil
+	}
+	return raw, nil
+}
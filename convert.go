@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffmpegPath is the ffmpeg conversions run, found on $PATH by default
+var ffmpegPath = "ffmpeg"
+
+// convertFormats are the formats files can be converted to, with ffmpeg's encoder options
+var convertFormats = map[string][]string{
+	"flac": {"-c:a", "flac"},
+	"mp3":  {"-c:a", "libmp3lame", "-q:a", "2"},
+	"ogg":  {"-c:a", "libvorbis", "-q:a", "6"},
+	"m4a":  {"-c:a", "aac", "-b:a", "256k"},
+	"wav":  {"-c:a", "pcm_s16le"},
+}
+
+var errSomeNotConverted = errors.New("some files couldn't be converted")
+
+// ConvertJob converts a batch of library files to one format, one file at a time. It runs
+// as a "convert" job, with everything but the job's own fields kept in its data.
+type ConvertJob struct {
+	ID              string        `json:"id"`
+	OwnerID         string        `json:"ownerId,omitempty"`
+	Format          string        `json:"format"`
+	Folder          string        `json:"folder,omitempty"` // Where converted files go; empty for next to the originals
+	DeleteOriginals bool          `json:"deleteOriginals"`
+	Files           []ConvertFile `json:"files"`
+	Status          jobStatus     `json:"status"`   // failed when some files couldn't be converted
+	Progress        float64       `json:"progress"` // 0 to 1 across all files
+	Created         time.Time     `json:"created"`
+	Finished        time.Time     `json:"finished,omitzero"`
+}
+
+type ConvertFile struct {
+	Path   string    `json:"path"`
+	Output string    `json:"output,omitempty"`
+	Status jobStatus `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// convertData is a convert job's data
+type convertData struct {
+	Format          string        `json:"format"`
+	Folder          string        `json:"folder,omitempty"`
+	DeleteOriginals bool          `json:"deleteOriginals"`
+	Files           []ConvertFile `json:"files"`
+}
+
+func init() {
+	registerJobKind("convert", &jobKind{concurrency: 1, writes: true, run: runConvertJob, onChange: func(job Job) {
+		// Clients from before the job queue follow conversions with these
+		events.Publish(Event{Type: "convert.updated", Data: convertJobView(job), visible: ownerOnly(job.OwnerID)})
+	}})
+}
+
+// convertJobView shows a convert job the way /api/convert always has
+func convertJobView(job Job) ConvertJob {
+	var data convertData
+	json.Unmarshal(job.Data, &data)
+	return ConvertJob{
+		ID:              job.ID,
+		OwnerID:         job.OwnerID,
+		Format:          data.Format,
+		Folder:          data.Folder,
+		DeleteOriginals: data.DeleteOriginals,
+		Files:           data.Files,
+		Status:          job.Status,
+		Progress:        job.Progress,
+		Created:         job.Created,
+		Finished:        job.Finished,
+	}
+}
+
+// runConvertJob converts the files still waiting, so after a restart it carries on with
+// the ones it hadn't got to
+func runConvertJob(ctx context.Context, run *jobRun) error {
+	var data convertData
+	if err := run.Decode(&data); err != nil {
+		return err
+	}
+	job := convertJobView(run.Job())
+	failed := false
+	for i, file := range data.Files {
+		if ctx.Err() != nil {
+			break
+		}
+		switch file.Status {
+		case jobDone:
+			continue
+		case jobRunning:
+			// Cut off by a restart
+		case jobQueued:
+		default:
+			failed = true // Refused when the job was made
+			continue
+		}
+		data.Files[i].Status = jobRunning
+		run.Save(data)
+		output, err := convertFile(ctx, job, file.Path, func(fraction float64) {
+			run.Progress((float64(i)+fraction)/float64(len(data.Files)), file.Path)
+		})
+		data.Files[i].Output = output
+		switch {
+		case err == nil:
+			data.Files[i].Status = jobDone
+		case ctx.Err() != nil:
+			data.Files[i].Status = jobCancelled
+		default:
+			data.Files[i].Status, data.Files[i].Error = jobFailed, err.Error()
+			failed = true
+			slog.Warn("Conversion failed", "path", file.Path, "format", data.Format, "err", err)
+		}
+		if ctx.Err() == nil {
+			run.Progress(float64(i+1)/float64(len(data.Files)), "")
+		}
+		run.Save(data)
+	}
+	if ctx.Err() != nil {
+		for i := range data.Files {
+			if data.Files[i].Status == jobQueued {
+				data.Files[i].Status = jobCancelled
+			}
+		}
+		run.Save(data)
+		return ctx.Err()
+	}
+	if failed {
+		return errSomeNotConverted
+	}
+	return nil
+}
+
+// convertFile converts one library file and returns the new file's path, reporting how far
+// through it ffmpeg is as it goes
+func convertFile(ctx context.Context, job ConvertJob, relPath string, progress func(fraction float64)) (string, error) {
+	storage, ok := writableLibrary()
+	dir, isDir := storage.(*dirStorage)
+	if !ok || !isDir {
+		return "", errLibraryNotWritable
+	}
+	src, err := dir.resolve(relPath)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	folder := job.Folder
+	if folder == "" {
+		folder = path.Dir(relPath)
+	}
+	output := path.Join(folder, strings.TrimSuffix(path.Base(relPath), path.Ext(relPath))+"."+job.Format)
+	if _, err := library.Stat(output); err == nil {
+		return "", fmt.Errorf("%s already exists", output)
+	}
+	duration := trackMeta(libraryFile{AudioFile: audioFileFromPath(relPath), Size: info.Size(), ModTime: info.ModTime()}).Duration
+
+	tmp, err := os.CreateTemp("", "beatgraze-convert-*."+job.Format)
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := []string{"-hide_banner", "-nostdin", "-nostats", "-loglevel", "error", "-progress", "pipe:1", "-y",
+		"-i", src, "-map", "0:a", "-map_metadata", "0"}
+	args = append(append(args, convertFormats[job.Format]...), tmp.Name())
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	// ffmpeg reports key=value lines, including how much audio it's written so far
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "out_time_us="); ok && duration > 0 {
+			if us, err := strconv.ParseFloat(value, 64); err == nil {
+				progress(min(us/1e6/duration, 1))
+			}
+		}
+	}
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("ffmpeg: %s", msg)
+		}
+		return "", fmt.Errorf("ffmpeg: %v", err)
+	}
+
+	converted, err := os.Open(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+	err = storage.Create(output, converted)
+	converted.Close()
+	if err != nil {
+		return "", err
+	}
+	if info, err := library.Stat(output); err == nil {
+		trackMeta(libraryFile{AudioFile: audioFileFromPath(output), Size: info.Size(), ModTime: info.ModTime()})
+	}
+
+	if job.DeleteOriginals {
+		// Into the trash, so a bad conversion can still be undone
+		user, _ := users.Get(job.OwnerID)
+		if _, err := trash.Add(storage, relPath, user.Name); err != nil {
+			return output, fmt.Errorf("converted, but the original couldn't be deleted: %v", err)
+		}
+		updateMovedPaths(relPath, output)
+	}
+	return output, nil
+}
+
+func registerConvertRoutes() {
+	handleFunc("POST /api/convert", requireRole(roleListener, startConvert))
+	handleFunc("GET /api/convert", requireRole(roleListener, listConverts))
+	handleFunc("GET /api/convert/{id}", requireRole(roleListener, getConvert))
+	handleFunc("DELETE /api/convert/{id}", requireRole(roleListener, cancelConvert))
+}
+
+// startConvert queues {"paths": [...], "format": "flac", "folder": ..., "deleteOriginals": true},
+// and for admins optionally "priority"
+func startConvert(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Paths           []string `json:"paths"`
+		Format          string   `json:"format"`
+		Folder          string   `json:"folder"`
+		DeleteOriginals bool     `json:"deleteOriginals"`
+		Priority        int      `json:"priority"`
+	}
+	if err := readJSON(r, &req); err != nil || len(req.Paths) == 0 {
+		http.Error(w, "paths are required", http.StatusBadRequest)
+		return
+	}
+	req.Format = strings.TrimPrefix(strings.ToLower(req.Format), ".")
+	if _, ok := convertFormats[req.Format]; !ok {
+		http.Error(w, "format must be one of flac, mp3, ogg, m4a or wav", http.StatusBadRequest)
+		return
+	}
+	// Trashing the originals is a library-wide change, like deleting them by hand
+	if req.DeleteOriginals && !hasRole(r, roleAdmin) {
+		http.Error(w, fmt.Sprintf("Deleting the originals needs %s access", roleAdmin), http.StatusForbidden)
+		return
+	}
+	if _, ok := writableLibrary(); !ok {
+		http.Error(w, errLibraryNotWritable.Error(), http.StatusNotImplemented)
+		return
+	}
+	if _, err := exec.LookPath(ffmpegPath); err != nil {
+		http.Error(w, "Converting needs ffmpeg, which isn't installed", http.StatusNotImplemented)
+		return
+	}
+	if req.Folder != "" {
+		clean, err := cleanLibraryPath(req.Folder)
+		if err != nil || insideArchive(filepath.ToSlash(clean)+"/x") {
+			http.Error(w, "Invalid folder", http.StatusBadRequest)
+			return
+		}
+		req.Folder = filepath.ToSlash(clean)
+	}
+
+	data := convertData{Format: req.Format, Folder: req.Folder, DeleteOriginals: req.DeleteOriginals}
+	for _, p := range req.Paths {
+		clean, err := cleanLibraryPath(p)
+		if err != nil {
+			http.Error(w, "Invalid path "+p, http.StatusBadRequest)
+			return
+		}
+		p = filepath.ToSlash(clean)
+		if !audioExts[strings.ToLower(path.Ext(p))] {
+			http.Error(w, p+" isn't an audio file", http.StatusBadRequest)
+			return
+		}
+		file := ConvertFile{Path: p, Status: jobQueued}
+		if strings.EqualFold(path.Ext(p), "."+req.Format) {
+			file.Status, file.Error = jobFailed, "already "+req.Format
+		}
+		data.Files = append(data.Files, file)
+	}
+	job, err := jobs.Add("convert", uploadOwner(r), jobPriority(r, req.Priority), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, convertJobView(job))
+}
+
+func listConverts(w http.ResponseWriter, r *http.Request) {
+	list := []ConvertJob{}
+	for _, job := range jobs.List(jobViewer(r), "convert") {
+		list = append(list, convertJobView(job))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// getConvertJob finds a convert job the caller may see
+func getConvertJob(r *http.Request) (Job, error) {
+	job, err := jobs.Get(r.PathValue("id"), jobViewer(r))
+	if err == nil && job.Kind != "convert" {
+		err = errJobNotFound
+	}
+	return job, err
+}
+
+func getConvert(w http.ResponseWriter, r *http.Request) {
+	job, err := getConvertJob(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, convertJobView(job))
+}
+
+func cancelConvert(w http.ResponseWriter, r *http.Request) {
+	job, err := getConvertJob(r)
+	if err == nil {
+		err = jobs.Cancel(job.ID, jobViewer(r))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
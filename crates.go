@@ -0,0 +1,557 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Crates are DJ-style collections for set preparation. Unlike playlists they hold each
+// track at most once, can be left unordered, and come with a summary of runtime, tempo
+// and key spread so you can see at a glance whether a set hangs together.
+type Crate struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Ordered bool      `json:"ordered"`
+	Tracks  []string  `json:"tracks"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+type CrateTrack struct {
+	Path     string  `json:"path"`
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration,omitempty"`
+	BPM      float64 `json:"bpm,omitempty"`
+	Key      string  `json:"key,omitempty"`
+	Camelot  string  `json:"camelot,omitempty"`
+}
+
+type CrateSummary struct {
+	TrackCount       int            `json:"trackCount"`
+	TotalDuration    float64        `json:"totalDuration"`
+	EstimatedSet     float64        `json:"estimatedSetLength"` // Total minus the mix overlap between consecutive tracks
+	UnknownDurations int            `json:"unknownDurations"`
+	BPMMin           float64        `json:"bpmMin,omitempty"`
+	BPMMax           float64        `json:"bpmMax,omitempty"`
+	BPMAverage       float64        `json:"bpmAverage,omitempty"`
+	UnknownBPM       int            `json:"unknownBpm"`
+	Keys             map[string]int `json:"keys"`
+}
+
+type CrateView struct {
+	Crate
+	Summary CrateSummary `json:"summary"`
+	Details []CrateTrack `json:"details,omitempty"`
+}
+
+type CrateStore struct {
+	mu     sync.Mutex
+	path   string
+	crates map[string]*Crate
+}
+
+var crates *CrateStore
+
+var errCrateNotFound = errors.New("crate not found")
+
+func loadCrateStore(path string) (*CrateStore, error) {
+	s := &CrateStore{path: path, crates: map[string]*Crate{}}
+	var list []*Crate
+	if err := loadJSON(path, &list); err != nil {
+		return nil, err
+	}
+	for _, c := range list {
+		s.crates[c.ID] = c
+	}
+	return s, nil
+}
+
+// save must be called with mu held
+func (s *CrateStore) save() error {
+	list := make([]*Crate, 0, len(s.crates))
+	for _, c := range s.crates {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return saveJSON(s.path, list)
+}
+
+func (s *CrateStore) List() []Crate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Crate, 0, len(s.crates))
+	for _, c := range s.crates {
+		list = append(list, c.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+func (s *CrateStore) Get(id string) (Crate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.crates[id]
+	if !ok {
+		return Crate{}, errCrateNotFound
+	}
+	return c.clone(), nil
+}
+
+func (s *CrateStore) Create(name string, ordered bool, tracks []string) (Crate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	c := &Crate{
+		ID:      newID(),
+		Name:    name,
+		Ordered: ordered,
+		Tracks:  dedupeTracks(tracks),
+		Created: now,
+		Updated: now,
+	}
+	s.crates[c.ID] = c
+	if err := s.save(); err != nil {
+		delete(s.crates, c.ID)
+		return Crate{}, err
+	}
+	return c.clone(), nil
+}
+
+func (s *CrateStore) Update(id string, fn func(c *Crate) error) (Crate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.crates[id]
+	if !ok {
+		return Crate{}, errCrateNotFound
+	}
+	c := old.clone()
+	if err := fn(&c); err != nil {
+		return Crate{}, err
+	}
+	c.Tracks = dedupeTracks(c.Tracks)
+	c.Updated = time.Now().UTC()
+	s.crates[id] = &c
+	if err := s.save(); err != nil {
+		s.crates[id] = old
+		return Crate{}, err
+	}
+	return c.clone(), nil
+}
+
+// MovePaths points tracks at files that have moved to their new paths; move maps an old path to its new one
+func (s *CrateStore) MovePaths(move func(path string) (string, bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, c := range s.crates {
+		for i, track := range c.Tracks {
+			if moved, ok := move(track); ok {
+				c.Tracks[i], changed = moved, true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *CrateStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.crates[id]
+	if !ok {
+		return errCrateNotFound
+	}
+	delete(s.crates, id)
+	if err := s.save(); err != nil {
+		s.crates[id] = old
+		return err
+	}
+	return nil
+}
+
+func (c *Crate) clone() Crate {
+	cp := *c
+	cp.Tracks = append([]string{}, c.Tracks...)
+	return cp
+}
+
+// dedupeTracks keeps the first occurrence of each track, since a crate is a set
+func dedupeTracks(tracks []string) []string {
+	seen := make(map[string]bool, len(tracks))
+	out := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// crateView reads tags for every track and summarizes the crate.
+// Unordered crates are presented sorted by BPM, which is how you'd browse them while planning.
+func crateView(c Crate, mixOverlap float64, withDetails bool) (CrateView, error) {
+	files, err := scanLibrary()
+	if err != nil {
+		return CrateView{}, err
+	}
+	byPath := make(map[string]libraryFile, len(files))
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+
+	view := CrateView{Crate: c, Summary: CrateSummary{Keys: map[string]int{}}}
+	details := make([]CrateTrack, 0, len(c.Tracks))
+	var bpmTotal float64
+	var bpmCount int
+	for _, path := range c.Tracks {
+		track := CrateTrack{Path: path, Name: audioFileFromPath(path).Name}
+		if f, ok := byPath[path]; ok {
+			meta := trackMeta(f)
+			track.Duration = meta.Duration
+			track.BPM = meta.BPM
+			track.Key = meta.Key
+			track.Camelot = camelotKey(meta.Key)
+		}
+		details = append(details, track)
+
+		s := &view.Summary
+		s.TrackCount++
+		if track.Duration > 0 {
+			s.TotalDuration += track.Duration
+		} else {
+			s.UnknownDurations++
+		}
+		if track.BPM > 0 {
+			if bpmCount == 0 || track.BPM < s.BPMMin {
+				s.BPMMin = track.BPM
+			}
+			s.BPMMax = max(s.BPMMax, track.BPM)
+			bpmTotal += track.BPM
+			bpmCount++
+		} else {
+			s.UnknownBPM++
+		}
+		key := track.Camelot
+		if key == "" {
+			key = track.Key
+		}
+		if key == "" {
+			key = "unknown"
+		}
+		s.Keys[key]++
+	}
+	if bpmCount > 0 {
+		view.Summary.BPMAverage = math.Round(bpmTotal/float64(bpmCount)*10) / 10
+	}
+	view.Summary.EstimatedSet = view.Summary.TotalDuration
+	if n := view.Summary.TrackCount; n > 1 {
+		view.Summary.EstimatedSet = max(0, view.Summary.TotalDuration-float64(n-1)*mixOverlap)
+	}
+
+	if !c.Ordered {
+		sort.SliceStable(details, func(i, j int) bool { return details[i].BPM < details[j].BPM })
+		view.Tracks = make([]string, len(details))
+		for i, d := range details {
+			view.Tracks[i] = d.Path
+		}
+	}
+	if withDetails {
+		view.Details = details
+	}
+	return view, nil
+}
+
+var (
+	camelotPattern = regexp.MustCompile(`^(1[0-2]|[1-9])([AaBb])$`)
+	openKeyPattern = regexp.MustCompile(`^(1[0-2]|[1-9])([MmDd])$`)
+	musicalPattern = regexp.MustCompile(`^([A-Ga-g])([#♯b♭]?)\s*(m|min|minor|maj|major)?$`)
+)
+
+// camelotKey normalizes Camelot (8A), Open Key (1m) and musical (Am, F# minor) notations to Camelot
+func camelotKey(key string) string {
+	key = strings.TrimSpace(key)
+	if m := camelotPattern.FindStringSubmatch(key); m != nil {
+		return m[1] + strings.ToUpper(m[2])
+	}
+	if m := openKeyPattern.FindStringSubmatch(key); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		letter := "A"
+		if strings.EqualFold(m[2], "d") {
+			letter = "B"
+		}
+		return fmt.Sprintf("%d%s", (n+6)%12+1, letter)
+	}
+	m := musicalPattern.FindStringSubmatch(key)
+	if m == nil {
+		return ""
+	}
+	pc := map[string]int{"C": 0, "D": 2, "E": 4, "F": 5, "G": 7, "A": 9, "B": 11}[strings.ToUpper(m[1])]
+	switch m[2] {
+	case "#", "♯":
+		pc++
+	case "b", "♭":
+		pc += 11
+	}
+	pc %= 12
+	minor := strings.HasPrefix(m[3], "m") && !strings.HasPrefix(m[3], "maj")
+	// Each step around the circle of fifths is one Camelot number
+	if minor {
+		return fmt.Sprintf("%dA", ((pc*7)%12+4)%12+1)
+	}
+	return fmt.Sprintf("%dB", ((pc*7)%12+7)%12+1)
+}
+
+func registerCrateRoutes() {
+	handleFunc("GET /api/crates", listCrates)
+	handleFunc("POST /api/crates", requireRole(roleListener, createCrate))
+	handleFunc("GET /api/crates/{id}", getCrate)
+	handleFunc("PATCH /api/crates/{id}", requireRole(roleListener, updateCrate))
+	handleFunc("DELETE /api/crates/{id}", requireRole(roleListener, deleteCrate))
+	handleFunc("POST /api/crates/{id}/tracks", requireRole(roleListener, addCrateTracks))
+	handleFunc("DELETE /api/crates/{id}/tracks", requireRole(roleListener, removeCrateTracks))
+	handleFunc("POST /api/crates/{id}/reorder", requireRole(roleListener, reorderCrate))
+}
+
+func writeCrateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCrateNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func mixOverlapParam(r *http.Request) float64 {
+	overlap, err := strconv.ParseFloat(r.URL.Query().Get("overlap"), 64)
+	if err != nil || overlap < 0 {
+		return 0
+	}
+	return overlap
+}
+
+func writeCrate(w http.ResponseWriter, r *http.Request, status int, c Crate) {
+	view, err := crateView(c, mixOverlapParam(r), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status, view)
+}
+
+func listCrates(w http.ResponseWriter, r *http.Request) {
+	list := crates.List()
+	views := make([]CrateView, 0, len(list))
+	for _, c := range list {
+		view, err := crateView(c, mixOverlapParam(r), false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func createCrate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    string   `json:"name"`
+		Ordered bool     `json:"ordered"`
+		Tracks  []string `json:"tracks"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Crate name is required", http.StatusBadRequest)
+		return
+	}
+	tracks, err := validateTracks(req.Tracks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := crates.Create(req.Name, req.Ordered, tracks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCrate(w, r, http.StatusCreated, c)
+}
+
+func getCrate(w http.ResponseWriter, r *http.Request) {
+	c, err := crates.Get(r.PathValue("id"))
+	if err != nil {
+		writeCrateError(w, err)
+		return
+	}
+	writeCrate(w, r, http.StatusOK, c)
+}
+
+func updateCrate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    *string   `json:"name"`
+		Ordered *bool     `json:"ordered"`
+		Tracks  *[]string `json:"tracks"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var tracks []string
+	if req.Tracks != nil {
+		var err error
+		if tracks, err = validateTracks(*req.Tracks); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	c, err := crates.Update(r.PathValue("id"), func(c *Crate) error {
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				return errors.New("crate name cannot be empty")
+			}
+			c.Name = name
+		}
+		if req.Ordered != nil {
+			c.Ordered = *req.Ordered
+		}
+		if req.Tracks != nil {
+			c.Tracks = tracks
+		}
+		return nil
+	})
+	if err != nil {
+		writeCrateError(w, err)
+		return
+	}
+	writeCrate(w, r, http.StatusOK, c)
+}
+
+func deleteCrate(w http.ResponseWriter, r *http.Request) {
+	if err := crates.Delete(r.PathValue("id")); err != nil {
+		writeCrateError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func addCrateTracks(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Paths []string `json:"paths"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	tracks, err := validateTracks(req.Paths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := crates.Update(r.PathValue("id"), func(c *Crate) error {
+		c.Tracks = append(c.Tracks, tracks...)
+		return nil
+	})
+	if err != nil {
+		writeCrateError(w, err)
+		return
+	}
+	writeCrate(w, r, http.StatusOK, c)
+}
+
+// removeCrateTracks takes paths rather than indexes since unordered crates have no stable positions
+func removeCrateTracks(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Paths []string `json:"paths"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	remove := map[string]bool{}
+	for _, p := range req.Paths {
+		remove[p] = true
+	}
+	c, err := crates.Update(r.PathValue("id"), func(c *Crate) error {
+		kept := c.Tracks[:0]
+		for _, t := range c.Tracks {
+			if !remove[t] {
+				kept = append(kept, t)
+			}
+		}
+		c.Tracks = kept
+		return nil
+	})
+	if err != nil {
+		writeCrateError(w, err)
+		return
+	}
+	writeCrate(w, r, http.StatusOK, c)
+}
+
+// reorderCrate takes either a single move or the complete new order from a drag-and-drop UI
+func reorderCrate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From  *int     `json:"from"`
+		To    *int     `json:"to"`
+		Order []string `json:"order"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	c, err := crates.Update(r.PathValue("id"), func(c *Crate) error {
+		if !c.Ordered {
+			return errors.New("crate is unordered; set ordered=true first")
+		}
+		if req.Order != nil {
+			if len(req.Order) != len(c.Tracks) {
+				return errors.New("order must list every track in the crate exactly once")
+			}
+			current := map[string]bool{}
+			for _, t := range c.Tracks {
+				current[t] = true
+			}
+			for _, t := range req.Order {
+				if !current[t] {
+					return fmt.Errorf("track not in crate: %s", t)
+				}
+				delete(current, t)
+			}
+			c.Tracks = req.Order
+			return nil
+		}
+		if req.From == nil || req.To == nil {
+			return errors.New("provide from and to, or order")
+		}
+		n := len(c.Tracks)
+		from, to := *req.From, *req.To
+		if from < 0 || from >= n || to < 0 || to >= n {
+			return errors.New("track index out of range")
+		}
+		track := c.Tracks[from]
+		c.Tracks = append(c.Tracks[:from], c.Tracks[from+1:]...)
+		c.Tracks = append(c.Tracks[:to], append([]string{track}, c.Tracks[to:]...)...)
+		return nil
+	})
+	if err != nil {
+		writeCrateError(w, err)
+		return
+	}
+	writeCrate(w, r, http.StatusOK, c)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dailyMixSize     = 30
+	dailyMixLookback = 30 * 24 * time.Hour
+)
+
+var dailyMixCount = 3
+
+// dailyMixState remembers which day the mixes were last built for so restarts don't reshuffle them
+var dailyMixState = struct {
+	sync.Mutex
+	Day string `json:"day"`
+}{}
+
+type mixSeed struct {
+	file  libraryFile
+	meta  TrackMeta
+	plays int
+}
+
+type mixCluster struct {
+	label  string
+	seeds  []mixSeed
+	genre  string
+	bpm    float64
+	keys   map[string]bool
+	folder map[string]bool
+}
+
+// SetGenerated creates or replaces the server-generated playlist identified by auto
+func (s *PlaylistStore) SetGenerated(auto, name string, tracks []string) error {
+	s.mu.Lock()
+	existing := ""
+	for _, p := range s.playlists {
+		if p.Auto == auto {
+			existing = p.ID
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if existing != "" {
+		_, err := s.Update(existing, func(p *Playlist) error {
+			p.Name = name
+			p.Tracks = tracks
+			return nil
+		})
+		return err
+	}
+	_, err := s.Add(Playlist{Name: name, Tracks: tracks, Auto: auto})
+	return err
+}
+
+// refreshDailyMixes clusters recent listening by genre and tempo, then fills each
+// "Daily Graze" with a blend of those favorites and similar tracks not played lately.
+func refreshDailyMixes(st *userState, day string) error {
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]libraryFile, len(files))
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+
+	playCounts := map[string]int{}
+	cutoff := time.Now().Add(-dailyMixLookback)
+	for _, play := range st.stats.RecentPlays(maxHistory) {
+		if play.Time.Before(cutoff) {
+			break
+		}
+		if _, ok := byPath[play.Path]; ok {
+			playCounts[play.Path]++
+		}
+	}
+	if len(playCounts) == 0 {
+		return nil // Nothing to base a mix on yet
+	}
+
+	var seeds []mixSeed
+	for path, plays := range playCounts {
+		f := byPath[path]
+		seeds = append(seeds, mixSeed{file: f, meta: trackMeta(f), plays: plays})
+	}
+	sort.Slice(seeds, func(i, j int) bool {
+		if seeds[i].plays != seeds[j].plays {
+			return seeds[i].plays > seeds[j].plays
+		}
+		return seeds[i].file.Path < seeds[j].file.Path
+	})
+
+	clusters := clusterSeeds(seeds, dailyMixCount)
+	rng := rand.New(rand.NewSource(int64(dayNumber(day))))
+	allStats := st.stats.Snapshot()
+	for i, cluster := range clusters {
+		tracks := buildMix(cluster, files, allStats, rng)
+		name := fmt.Sprintf("Daily Graze %d", i+1)
+		if cluster.label != "" {
+			name += " · " + cluster.label
+		}
+		if err := st.playlists.SetGenerated("daily-"+strconv.Itoa(i+1), name, tracks); err != nil {
+			return err
+		}
+	}
+
+	// Drop leftovers from days with more clusters, or from a higher -daily-mixes setting
+	for _, p := range st.playlists.List() {
+		n, err := strconv.Atoi(strings.TrimPrefix(p.Auto, "daily-"))
+		if strings.HasPrefix(p.Auto, "daily-") && err == nil && n > len(clusters) {
+			if err := st.playlists.Delete(p.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clusterSeeds groups seeds by genre, and splits by tempo when there aren't enough genres to go round
+func clusterSeeds(seeds []mixSeed, n int) []mixCluster {
+	groups := map[string][]mixSeed{}
+	weight := map[string]int{}
+	for _, s := range seeds {
+		genre := strings.ToLower(s.meta.Genre)
+		groups[genre] = append(groups[genre], s)
+		weight[genre] += s.plays
+	}
+	genres := make([]string, 0, len(groups))
+	for g := range groups {
+		genres = append(genres, g)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if weight[genres[i]] != weight[genres[j]] {
+			return weight[genres[i]] > weight[genres[j]]
+		}
+		return genres[i] < genres[j]
+	})
+
+	var clusters []mixCluster
+	for _, g := range genres {
+		if len(clusters) == n {
+			break
+		}
+		clusters = append(clusters, newMixCluster(g, groups[g]))
+	}
+
+	// Split the biggest clusters at their median tempo until we have n mixes or can't split further
+	for len(clusters) < n {
+		sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i].seeds) > len(clusters[j].seeds) })
+		big := clusters[0]
+		var slow, fast []mixSeed
+		for _, s := range big.seeds {
+			if s.meta.BPM > 0 && s.meta.BPM < big.bpm {
+				slow = append(slow, s)
+			} else {
+				fast = append(fast, s)
+			}
+		}
+		if len(slow) == 0 || len(fast) == 0 {
+			break
+		}
+		clusters[0] = newMixCluster(big.genre, slow)
+		clusters = append(clusters, newMixCluster(big.genre, fast))
+	}
+	return clusters
+}
+
+func newMixCluster(genre string, seeds []mixSeed) mixCluster {
+	c := mixCluster{genre: genre, seeds: seeds, keys: map[string]bool{}, folder: map[string]bool{}}
+	var bpms []float64
+	for _, s := range seeds {
+		if s.meta.BPM > 0 {
+			bpms = append(bpms, s.meta.BPM)
+		}
+		if key := camelotKey(s.meta.Key); key != "" {
+			c.keys[key] = true
+		}
+		c.folder[s.file.Folder] = true
+	}
+	if len(bpms) > 0 {
+		sort.Float64s(bpms)
+		c.bpm = bpms[len(bpms)/2]
+	}
+	var label []string
+	if len(seeds) > 0 && seeds[0].meta.Genre != "" {
+		label = append(label, seeds[0].meta.Genre)
+	}
+	if c.bpm > 0 {
+		label = append(label, fmt.Sprintf("~%.0f BPM", c.bpm))
+	}
+	c.label = strings.Join(label, " ")
+	return c
+}
+
+// camelotCompatible reports whether two Camelot keys mix harmonically (same, adjacent or relative)
+func camelotCompatible(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	na, _ := strconv.Atoi(a[:len(a)-1])
+	nb, _ := strconv.Atoi(b[:len(b)-1])
+	if a[len(a)-1] != b[len(b)-1] {
+		return na == nb
+	}
+	d := (na - nb + 12) % 12
+	return d <= 1 || d == 11
+}
+
+func buildMix(c mixCluster, files []libraryFile, allStats map[string]TrackStats, rng *rand.Rand) []string {
+	inMix := map[string]bool{}
+	var tracks []string
+
+	// Roughly a third familiar favorites...
+	for _, s := range c.seeds {
+		if len(tracks) >= dailyMixSize/3 {
+			break
+		}
+		tracks = append(tracks, s.file.Path)
+		inMix[s.file.Path] = true
+	}
+
+	// ...and the rest similar tracks, nudged towards ones that haven't had a spin recently
+	type scored struct {
+		path  string
+		score float64
+	}
+	var candidates []scored
+	recent := time.Now().Add(-24 * time.Hour)
+	for _, f := range files {
+		if inMix[f.Path] || allStats[f.Path].LastPlayed.After(recent) {
+			continue
+		}
+		meta := trackMeta(f)
+		score := 0.0
+		if c.genre != "" && strings.EqualFold(meta.Genre, c.genre) {
+			score += 3
+		}
+		if c.bpm > 0 && meta.BPM > 0 {
+			score += 2 * math.Max(0, 1-math.Abs(meta.BPM-c.bpm)/8)
+		}
+		if key := camelotKey(meta.Key); key != "" {
+			for seedKey := range c.keys {
+				if camelotCompatible(key, seedKey) {
+					score++
+					break
+				}
+			}
+		}
+		if c.folder[f.Folder] {
+			score++
+		}
+		if score < 2 {
+			continue
+		}
+		if r := allStats[f.Path].Rating; r > 0 {
+			score += float64(r-3) / 2
+		}
+		candidates = append(candidates, scored{f.Path, score + rng.Float64()*1.5})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	for _, cand := range candidates {
+		if len(tracks) >= dailyMixSize {
+			break
+		}
+		tracks = append(tracks, cand.path)
+	}
+
+	rng.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+	return tracks
+}
+
+func dayNumber(day string) int {
+	t, _ := time.Parse("2006-01-02", day)
+	return int(t.Unix() / 86400)
+}
+
+// runDailyMixes rebuilds the mixes whenever the local date changes
+func runDailyMixes(statePath string) {
+	if err := loadJSON(statePath, &dailyMixState); err != nil {
+		slog.Error("Error loading daily mix state", "err", err)
+	}
+	for {
+		today := time.Now().Format("2006-01-02")
+		dailyMixState.Lock()
+		stale := dailyMixState.Day != today
+		dailyMixState.Unlock()
+		if stale {
+			settingsMu.RLock()
+			err := refreshDailyMixesFor(today, statePath)
+			settingsMu.RUnlock()
+			if err != nil {
+				slog.Error("Error refreshing daily mixes", "err", err)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func refreshDailyMixesFor(day, statePath string) error {
+	for _, st := range users.States() {
+		if err := refreshDailyMixes(st, day); err != nil {
+			return err
+		}
+	}
+	dailyMixState.Lock()
+	defer dailyMixState.Unlock()
+	dailyMixState.Day = day
+	return saveJSON(statePath, &dailyMixState)
+}
+
+func refreshDailyMixesHandler(statePath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := refreshDailyMixesFor(time.Now().Format("2006-01-02"), statePath); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var mixes []Playlist
+		for _, p := range stateFor(r).playlists.List() {
+			if strings.HasPrefix(p.Auto, "daily-") {
+				mixes = append(mixes, p)
+			}
+		}
+		writeJSON(w, http.StatusOK, mixes)
+	}
+}
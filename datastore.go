@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	bolt "go.etcd.io/bbolt"
+)
+
+// storeKind is -store: where state like users, playlists and play history is kept. "bolt"
+// keeps it in one database file in the data directory, "json" in a JSON file per store as
+// older versions did, and a postgres:// URL in a table that several servers can share.
+var storeKind = "bolt"
+
+// dataStore holds the server's state as JSON documents, keyed by their path in the data
+// directory, like "playlists.json" or "users/<id>/stats.json". Files that aren't documents,
+// like uploads in progress, the trash and the audit log, stay in the data directory.
+type dataStore interface {
+	// Load returns a document, or nil if there isn't one
+	Load(key string) ([]byte, error)
+	// Save replaces a document in one step, so a crash never leaves half of it
+	Save(key string, data []byte) error
+	// Keys lists the documents, in order
+	Keys() ([]string, error)
+	Close() error
+}
+
+// stateStore is where loadJSON and saveJSON keep documents under dataDir, once it's open
+var stateStore dataStore
+
+var errStoreLocked = errors.New("the data store is in use by another beatgraze process; stop it first, or use -store json")
+
+// openDataStore opens the -store kind for the data directory
+func openDataStore(kind, dir string) (dataStore, error) {
+	switch {
+	case kind == "json":
+		return fileStore{dir: dir}, nil
+	case kind == "bolt":
+		store, err := openBoltStore(filepath.Join(dir, "beatgraze.db"))
+		if err != nil {
+			return nil, err
+		}
+		return &importingStore{dataStore: store, dir: dir}, nil
+	case strings.HasPrefix(kind, "postgres://"), strings.HasPrefix(kind, "postgresql://"):
+		store, err := openPostgresStore(kind)
+		if err != nil {
+			return nil, err
+		}
+		return &importingStore{dataStore: store, dir: dir}, nil
+	}
+	return nil, fmt.Errorf("-store must be bolt, json or a postgres:// URL, not %q", redactedLibrary(kind))
+}
+
+// stateKey is the key a state file is kept under, if it's in the data directory
+func stateKey(path string) (string, bool) {
+	if stateStore == nil || dataDir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(dataDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// fileStore keeps each document in its own file under dir
+type fileStore struct {
+	dir string
+}
+
+func (s fileStore) Load(key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save writes to a temp file and renames it into place
+func (s fileStore) Save(key string, data []byte) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// notDocuments are the data directory's folders of other files
+var notDocuments = map[string]bool{"uploads": true, "trash": true, "cache": true, "acme": true, "backups": true}
+
+func (s fileStore) Keys() ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.dir, path)
+		if d.IsDir() && notDocuments[filepath.ToSlash(rel)] {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".json" {
+			keys = append(keys, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (s fileStore) Close() error {
+	return nil
+}
+
+// importingStore brings over the JSON files a server kept before it used a database: a
+// document the database doesn't have yet is read from its file and saved in. The file is
+// left behind, untouched from then on.
+type importingStore struct {
+	dataStore
+	dir string
+}
+
+func (s *importingStore) Load(key string) ([]byte, error) {
+	data, err := s.dataStore.Load(key)
+	if data != nil || err != nil {
+		return data, err
+	}
+	if data, err = (fileStore{dir: s.dir}).Load(key); data == nil || err != nil {
+		return data, err
+	}
+	return data, s.dataStore.Save(key, data)
+}
+
+var boltBucket = []byte("state")
+
+// boltStore keeps documents in a bbolt database file
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, errStoreLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Load(key string) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltBucket).Get([]byte(key)); v != nil {
+			// The value is only valid during the transaction
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return data, err
+}
+
+func (s *boltStore) Save(key string, data []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *boltStore) Keys() ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// postgresStore keeps documents in a table, so servers behind a load balancer can share
+// accounts and playlists. Each server still reads the documents once at startup, so
+// changes made through one show up on the others after they restart.
+type postgresStore struct {
+	db *sql.DB
+}
+
+func openPostgresStore(url string) (*postgresStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS beatgraze_state (
+		key text PRIMARY KEY,
+		value bytea NOT NULL,
+		updated timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("setting up %s: %w", redactedLibrary(url), err)
+	}
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) Load(key string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT value FROM beatgraze_state WHERE key = $1`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *postgresStore) Save(key string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO beatgraze_state (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated = now()`, key, data)
+	return err
+}
+
+func (s *postgresStore) Keys() ([]string, error) {
+	rows, err := s.db.Query(`SELECT key FROM beatgraze_state ORDER BY key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// The library is served over WebDAV at /dav/, so file managers and DJ software can mount it
+// like a network drive. It's read-only unless -dav-write is given, and then only admins
+// can change it, with the same checks as uploads and moves through the API.
+
+// davWrite is -dav-write
+var davWrite bool
+
+// davLocks are the advisory locks clients like Finder take before saving a file
+var davLocks = webdav.NewMemLS()
+
+// davReadMethods is all a read-only mount allows
+const davReadMethods = "OPTIONS, GET, HEAD, PROPFIND"
+
+func registerDAVRoutes() {
+	handleFunc("/dav/", serveDAV)
+}
+
+// davWritable reports whether the request's user can change the library over WebDAV
+func davWritable(r *http.Request) bool {
+	if !davWrite || readOnly || !hasRole(r, roleAdmin) {
+		return false
+	}
+	_, ok := writableLibrary()
+	return ok
+}
+
+func serveDAV(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, "PROPFIND":
+	case http.MethodOptions:
+		// Without class 2, which is locking, clients mount it read-only
+		if !davWritable(r) {
+			w.Header().Set("DAV", "1")
+			w.Header().Set("Allow", davReadMethods)
+			return
+		}
+	default:
+		if !davWrite {
+			w.Header().Set("Allow", davReadMethods)
+			http.Error(w, "The library is read-only over WebDAV", http.StatusMethodNotAllowed)
+			return
+		}
+		if !hasRole(r, roleAdmin) {
+			http.Error(w, fmt.Sprintf("This needs %s access", roleAdmin), http.StatusForbidden)
+			return
+		}
+		if _, ok := writableLibrary(); !ok {
+			writeUploadError(w, errLibraryNotWritable)
+			return
+		}
+	}
+	files, err := listLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Routing strips -base-path, but the links in listings and Destination headers have it
+	r = r.Clone(r.Context())
+	r.URL.Path = prefixed(r.URL.Path)
+	h := &webdav.Handler{
+		Prefix:     prefixed("/dav"),
+		FileSystem: newDAVFileSystem(r, files),
+		LockSystem: davLocks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				slog.Debug("WebDAV request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+	h.ServeHTTP(w, r)
+}
+
+// davFileSystem is the library as one WebDAV request sees it: the audio files from the last
+// scan and the folders they're in. Folders only exist with tracks in them, as everywhere else.
+type davFileSystem struct {
+	r       *http.Request
+	files   map[string]*davInfo
+	folders map[string]*davInfo
+	entries map[string][]fs.FileInfo // Each folder's contents, by its path; the root is ""
+}
+
+// davInfo saves WebDAV opening every file in a listing to sniff its type
+type davInfo struct {
+	remoteFileInfo
+}
+
+func (i *davInfo) ContentType(ctx context.Context) (string, error) {
+	return dlnaMime(i.name), nil
+}
+
+func newDAVFileSystem(r *http.Request, files []libraryFile) *davFileSystem {
+	d := &davFileSystem{
+		r:       r,
+		files:   make(map[string]*davInfo, len(files)),
+		folders: map[string]*davInfo{"": {remoteFileInfo{name: libraryName(), dir: true}}},
+		entries: map[string][]fs.FileInfo{},
+	}
+	for _, f := range files {
+		info := &davInfo{remoteFileInfo{name: path.Base(f.Path), size: f.Size, modTime: f.ModTime}}
+		d.files[f.Path] = info
+		d.add(f.Path, info)
+	}
+	return d
+}
+
+// add lists info in its folder, adding the folders above it as needed. Folders take the
+// newest time of anything in them, so a file manager sorting by date sees where things changed.
+func (d *davFileSystem) add(p string, info *davInfo) {
+	dir := libraryDir(p)
+	folder, ok := d.folders[dir]
+	if !ok {
+		folder = &davInfo{remoteFileInfo{name: path.Base(dir), dir: true}}
+		d.folders[dir] = folder
+		d.add(dir, folder)
+	}
+	d.entries[dir] = append(d.entries[dir], info)
+	for ; info.modTime.After(folder.modTime); folder = d.folders[dir] {
+		folder.modTime = info.modTime
+		if dir == "" {
+			break
+		}
+		dir = libraryDir(dir)
+	}
+}
+
+// davPath turns WebDAV's rooted names into library paths
+func davPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+func (d *davFileSystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	p := davPath(name)
+	if info, ok := d.files[p]; ok {
+		return info, nil
+	}
+	if info, ok := d.folders[p]; ok {
+		return info, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (d *davFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := davPath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if !davWritable(d.r) {
+			return nil, fs.ErrPermission
+		}
+		if _, ok := d.folders[p]; ok {
+			return nil, fs.ErrExist
+		}
+		target, err := uploadTarget(libraryDir(p), path.Base(p), true)
+		if err != nil {
+			return nil, err
+		}
+		tmp, err := os.CreateTemp(uploads.dir, "dav-*")
+		if err != nil {
+			return nil, err
+		}
+		return &davUpload{r: d.r, target: target, tmp: tmp}, nil
+	}
+	if info, ok := d.files[p]; ok {
+		return &davFile{path: p, info: info}, nil
+	}
+	if info, ok := d.folders[p]; ok {
+		return &davFolder{info: info, entries: d.entries[p]}, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+// Mkdir has nothing to do, since a folder shows up once a file is put in it
+func (d *davFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if !davWritable(d.r) {
+		return fs.ErrPermission
+	}
+	if _, err := d.Stat(ctx, name); err == nil {
+		return fs.ErrExist
+	}
+	return nil
+}
+
+// RemoveAll moves the file or folder to the trash, where it can be restored from
+func (d *davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	p := davPath(name)
+	storage, ok := writableLibrary()
+	if !ok || !davWritable(d.r) || p == "" {
+		return fs.ErrPermission
+	}
+	if _, err := trash.Add(storage, p, requestUserName(d.r)); err != nil {
+		return err
+	}
+	davChanged()
+	return nil
+}
+
+func (d *davFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	from, to := davPath(oldName), davPath(newName)
+	storage, ok := writableLibrary()
+	if !ok || !davWritable(d.r) || from == "" || to == "" {
+		return fs.ErrPermission
+	}
+	if _, ok := d.files[from]; ok && !audioExts[strings.ToLower(path.Ext(to))] {
+		return fmt.Errorf("%w: keep an audio file's extension when renaming it", errInvalidPath)
+	}
+	if err := storage.Rename(from, to); err != nil {
+		return err
+	}
+	updateMovedPaths(from, to)
+	davChanged()
+	return nil
+}
+
+// davChanged rescans after a change, so the next request lists it. Reads go by the last
+// listing, which the library watcher keeps up with otherwise.
+func davChanged() {
+	if _, err := rescanLibrary(); err != nil {
+		slog.Warn("Error scanning library", "err", err)
+	}
+}
+
+var errDAVFolder = errors.New("is a folder")
+
+// davFile opens the library file on its first read, since listings open every file only to
+// look at its details
+type davFile struct {
+	path string
+	info fs.FileInfo
+	file File
+}
+
+func (f *davFile) open() error {
+	if f.file != nil {
+		return nil
+	}
+	file, err := library.Open(f.path)
+	f.file = file
+	return err
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Read(p)
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Seek(offset, whence)
+}
+
+func (f *davFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) { return nil, fs.ErrInvalid }
+
+func (f *davFile) Write(p []byte) (int, error) { return 0, fs.ErrPermission }
+
+type davFolder struct {
+	info    fs.FileInfo
+	entries []fs.FileInfo
+}
+
+func (f *davFolder) Read(p []byte) (int, error) { return 0, errDAVFolder }
+
+func (f *davFolder) Seek(offset int64, whence int) (int64, error) { return 0, errDAVFolder }
+
+func (f *davFolder) Close() error { return nil }
+
+func (f *davFolder) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *davFolder) Readdir(count int) ([]fs.FileInfo, error) {
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(f.entries))
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *davFolder) Write(p []byte) (int, error) { return 0, errDAVFolder }
+
+// davUpload spools a file being saved to disk, since storage only takes whole files, and adds
+// it to the library on Close, counted against the user's quota like any other upload
+type davUpload struct {
+	r      *http.Request
+	target string
+	tmp    *os.File
+	size   int64
+}
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	if maxSize := int64(uploadConfig.MaxMB) << 20; u.size+int64(len(p)) > maxSize {
+		return 0, fmt.Errorf("%s is larger than %d MB", path.Base(u.target), uploadConfig.MaxMB)
+	}
+	n, err := u.tmp.Write(p)
+	u.size += int64(n)
+	return n, err
+}
+
+func (u *davUpload) Close() error {
+	defer os.Remove(u.tmp.Name())
+	defer u.tmp.Close()
+	if _, err := u.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	storage, ok := writableLibrary()
+	if !ok {
+		return errLibraryNotWritable
+	}
+	up, err := uploads.Create(Upload{OwnerID: uploadOwner(u.r), Path: u.target, Size: u.size, Offset: u.size, Complete: true}, uploadQuota(u.r))
+	if err != nil {
+		return err
+	}
+	// Clients save over files, which storage never does, so the old copy goes to the trash
+	if _, err := library.Stat(u.target); err == nil {
+		if _, err := trash.Add(storage, u.target, requestUserName(u.r)); err != nil {
+			uploads.forget(up.ID)
+			return err
+		}
+	}
+	if err := storage.Create(u.target, u.tmp); err != nil {
+		uploads.forget(up.ID)
+		return err
+	}
+	indexUpload(up)
+	davChanged()
+	return nil
+}
+
+func (u *davUpload) Stat() (fs.FileInfo, error) {
+	return &davInfo{remoteFileInfo{name: path.Base(u.target), size: u.size, modTime: time.Now()}}, nil
+}
+
+func (u *davUpload) Read(p []byte) (int, error) { return 0, fs.ErrPermission }
+
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) { return 0, fs.ErrPermission }
+
+func (u *davUpload) Readdir(count int) ([]fs.FileInfo, error) { return nil, fs.ErrInvalid }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var (
+	debugAddr string
+	startTime = time.Now()
+)
+
+// adminOnlyDebug keeps the /debug/pprof/ handlers, which net/http/pprof registers on the
+// default mux by itself, away from everyone but admins
+func adminOnlyDebug(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/") && !hasRole(r, roleAdmin) {
+			http.Error(w, "This needs admin access", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func registerDebugRoutes() {
+	handleFunc("GET /api/debug/runtime", requireRole(roleAdmin, getRuntimeStats))
+}
+
+func getRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	var lastGC *time.Time
+	if mem.LastGC > 0 {
+		t := time.Unix(0, int64(mem.LastGC)).UTC()
+		lastGC = &t
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"goVersion":     runtime.Version(),
+		"uptime":        time.Since(startTime).Round(time.Second).String(),
+		"goroutines":    runtime.NumGoroutine(),
+		"heapAlloc":     mem.HeapAlloc,
+		"heapInuse":     mem.HeapInuse,
+		"heapObjects":   mem.HeapObjects,
+		"heapReleased":  mem.HeapReleased,
+		"sys":           mem.Sys,
+		"totalAlloc":    mem.TotalAlloc,
+		"numGC":         mem.NumGC,
+		"lastGC":        lastGC,
+		"gcPauseTotal":  time.Duration(mem.PauseTotalNs).String(),
+		"gcCPUFraction": mem.GCCPUFraction,
+	})
+}
+
+// serveDebug answers pprof and runtime stats on their own listener, without auth,
+// for reaching over localhost or an SSH tunnel
+func serveDebug(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /api/debug/runtime", getRuntimeStats)
+	slog.Info("Serving debug endpoints", "addr", addr)
+	if err := newServer(addr, mux).ListenAndServe(); err != nil {
+		slog.Error("Debug listener stopped", "err", err)
+	}
+}
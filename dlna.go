@@ -0,0 +1,773 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	dlnaEnabled bool
+	dlnaName    string
+)
+
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+const (
+	ssdpMaxAge            = 1800
+	upnpMediaServer       = "urn:schemas-upnp-org:device:MediaServer:1"
+	upnpContentDirectory  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	upnpConnectionManager = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	// Streaming, byte seeks allowed, no transcoding
+	dlnaFeatures = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+)
+
+// dlnaUpdateID is ContentDirectory's SystemUpdateID, bumped whenever the library changes
+// so renderers know their cached listings are stale
+var dlnaUpdateID atomic.Uint32
+
+// dlnaUDN names the device; it's derived from the host and data directory so it stays
+// the same across restarts and renderers keep treating us as the same server
+func dlnaUDN() string {
+	hostname, _ := os.Hostname()
+	sum := sha256.Sum256([]byte("beatgraze\x00" + hostname + "\x00" + dataDir))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func dlnaFriendlyName() string {
+	if dlnaName != "" {
+		return dlnaName
+	}
+	return "Beatgraze (" + libraryName() + ")"
+}
+
+// dlnaClient reports whether a peer may browse over UPnP, which has no sign-in:
+// only the local network gets in. Loopback doesn't count, since that's where a reverse
+// proxy connects from; one listed in -trusted-proxies gets its client checked instead.
+func dlnaClient(ip netip.Addr) bool {
+	return ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// dlnaRequest is a UPnP request from the local network, which skips sign-in since TVs
+// and speakers have no way to authenticate
+func dlnaRequest(r *http.Request) bool {
+	return dlnaEnabled && strings.HasPrefix(r.URL.Path, "/upnp/") && dlnaClient(clientIP(r))
+}
+
+// ssdpAdvertiser answers SSDP searches and announces the MediaServer on the LAN
+type ssdpAdvertiser struct {
+	conn   *net.UDPConn
+	udn    string
+	port   int
+	server string
+}
+
+// advertiseDLNA runs until the process exits; addr is the listener, whose port the
+// device description is fetched from
+func advertiseDLNA(addr net.Addr) {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		slog.Warn("DLNA needs a TCP listener, not advertising")
+		return
+	}
+	if tlsEnabled() {
+		// Renderers fetch descriptions and streams over plain HTTP only
+		slog.Warn("DLNA doesn't work over HTTPS, not advertising")
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, ssdpGroup)
+	if err != nil {
+		slog.Warn("Not advertising over DLNA", "err", err)
+		return
+	}
+	a := &ssdpAdvertiser{
+		conn:   conn,
+		udn:    dlnaUDN(),
+		port:   tcp.Port,
+		server: fmt.Sprintf("%s/1.0 UPnP/1.0 beatgraze/%s", runtime.GOOS, versionInfo().Version),
+	}
+	slog.Info("Advertising over DLNA", "name", dlnaFriendlyName(), "udn", a.udn, "port", a.port)
+
+	go func() {
+		changes, stop := events.Watch()
+		defer stop()
+		for e := range changes {
+			if strings.HasPrefix(e.Type, "files.") {
+				dlnaUpdateID.Add(1)
+			}
+		}
+	}()
+	go func() {
+		// Announce a few times at start, then again well before the advertisement expires
+		for i := 0; i < 3; i++ {
+			a.notify("ssdp:alive")
+			time.Sleep(time.Second)
+		}
+		ticker := time.NewTicker(ssdpMaxAge / 3 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.notify("ssdp:alive")
+			case <-shuttingDown.Done():
+				a.notify("ssdp:byebye")
+				return
+			}
+		}
+	}()
+	a.serve()
+}
+
+// targets are the notification types we advertise, each with its USN
+func (a *ssdpAdvertiser) targets() [][2]string {
+	return [][2]string{
+		{"upnp:rootdevice", a.udn + "::upnp:rootdevice"},
+		{a.udn, a.udn},
+		{upnpMediaServer, a.udn + "::" + upnpMediaServer},
+		{upnpContentDirectory, a.udn + "::" + upnpContentDirectory},
+		{upnpConnectionManager, a.udn + "::" + upnpConnectionManager},
+	}
+}
+
+// location is the description URL as seen from the network that leads to peer
+func (a *ssdpAdvertiser) location(peer *net.UDPAddr) string {
+	host := "127.0.0.1"
+	// Connecting a UDP socket sends nothing, but picks the interface the peer is reached through
+	if conn, err := net.DialUDP("udp4", nil, peer); err == nil {
+		host = conn.LocalAddr().(*net.UDPAddr).IP.String()
+		conn.Close()
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(a.port)) + prefixed("/upnp/description.xml")
+}
+
+func (a *ssdpAdvertiser) notify(nts string) {
+	location := a.location(ssdpGroup)
+	for _, t := range a.targets() {
+		msg := "NOTIFY * HTTP/1.1\r\n" +
+			"HOST: 239.255.255.250:1900\r\n" +
+			"NT: " + t[0] + "\r\n" +
+			"NTS: " + nts + "\r\n" +
+			"USN: " + t[1] + "\r\n"
+		if nts == "ssdp:alive" {
+			msg += fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nSERVER: %s\r\n", ssdpMaxAge, location, a.server)
+		}
+		if _, err := a.conn.WriteToUDP([]byte(msg+"\r\n"), ssdpGroup); err != nil {
+			slog.Debug("Couldn't send SSDP notification", "err", err)
+			return
+		}
+	}
+}
+
+func (a *ssdpAdvertiser) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			slog.Warn("DLNA advertising stopped", "err", err)
+			return
+		}
+		ip, _ := netip.AddrFromSlice(from.IP)
+		ip = ip.Unmap()
+		if !dlnaClient(ip) || !currentAccess().allows(ip) {
+			continue
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		st := req.Header.Get("ST")
+		var replies [][2]string
+		for _, t := range a.targets() {
+			if st == "ssdp:all" || st == t[0] {
+				replies = append(replies, t)
+			}
+		}
+		if len(replies) == 0 {
+			continue
+		}
+		// Spread replies over the MX window the searcher allowed, as the spec asks
+		mx, _ := strconv.Atoi(req.Header.Get("MX"))
+		go a.reply(from, replies, time.Duration(rand.IntN(min(max(mx, 1), 5)*1000))*time.Millisecond)
+	}
+}
+
+func (a *ssdpAdvertiser) reply(to *net.UDPAddr, targets [][2]string, delay time.Duration) {
+	time.Sleep(delay)
+	location := a.location(to)
+	for _, t := range targets {
+		msg := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nDATE: %s\r\nEXT:\r\nLOCATION: %s\r\nSERVER: %s\r\nST: %s\r\nUSN: %s\r\n\r\n",
+			ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), location, a.server, t[0], t[1])
+		if _, err := a.conn.WriteToUDP([]byte(msg), to); err != nil {
+			slog.Debug("Couldn't answer SSDP search", "err", err)
+			return
+		}
+	}
+}
+
+func registerDLNARoutes() {
+	handleFunc("GET /upnp/description.xml", dlnaOnly(getDLNADescription))
+	handleFunc("GET /upnp/cd.xml", dlnaOnly(serveSCPD(contentDirectorySCPD)))
+	handleFunc("GET /upnp/cm.xml", dlnaOnly(serveSCPD(connectionManagerSCPD)))
+	handleFunc("POST /upnp/control/cd", dlnaOnly(postContentDirectory))
+	handleFunc("POST /upnp/control/cm", dlnaOnly(postConnectionManager))
+	handleFunc("/upnp/event/", dlnaOnly(dlnaSubscribe))
+	handleFunc("GET /upnp/media/{path...}", dlnaOnly(getDLNAMedia))
+	handleFunc("GET /upnp/art/{dir...}", dlnaOnly(getDLNAArt))
+	// Control requests only ever read, and subscriptions are never kept
+	for _, route := range []string{"POST /upnp/control/cd", "POST /upnp/control/cm", "SUBSCRIBE /upnp/event/cd", "SUBSCRIBE /upnp/event/cm", "UNSUBSCRIBE /upnp/event/cd", "UNSUBSCRIBE /upnp/event/cm"} {
+		queryRoutes[route] = true
+	}
+}
+
+// dlnaOnly hides the UPnP routes unless -dlna is on, and keeps signed-out visitors from
+// outside the local network away from them
+func dlnaOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !dlnaEnabled {
+			http.NotFound(w, r)
+			return
+		}
+		if currentUser(r) == nil && !dlnaClient(clientIP(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Server", fmt.Sprintf("%s/1.0 UPnP/1.0 beatgraze/%s", runtime.GOOS, versionInfo().Version))
+		h(w, r)
+	}
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+type upnpDescription struct {
+	XMLName     xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion struct {
+		Major int `xml:"major"`
+		Minor int `xml:"minor"`
+	} `xml:"specVersion"`
+	Device struct {
+		DeviceType      string        `xml:"deviceType"`
+		FriendlyName    string        `xml:"friendlyName"`
+		Manufacturer    string        `xml:"manufacturer"`
+		ManufacturerURL string        `xml:"manufacturerURL"`
+		ModelName       string        `xml:"modelName"`
+		ModelNumber     string        `xml:"modelNumber"`
+		UDN             string        `xml:"UDN"`
+		DLNADoc         string        `xml:"urn:schemas-dlna-org:device-1-0 X_DLNADOC"`
+		PresentationURL string        `xml:"presentationURL"`
+		ServiceList     []upnpService `xml:"serviceList>service"`
+	} `xml:"device"`
+}
+
+func getDLNADescription(w http.ResponseWriter, r *http.Request) {
+	var d upnpDescription
+	d.SpecVersion.Major, d.SpecVersion.Minor = 1, 0
+	d.Device.DeviceType = upnpMediaServer
+	d.Device.FriendlyName = dlnaFriendlyName()
+	d.Device.Manufacturer = "beatgraze"
+	d.Device.ManufacturerURL = "https://github.com/jackharrhy/beatgraze"
+	d.Device.ModelName = "beatgraze"
+	d.Device.ModelNumber = versionInfo().Version
+	d.Device.UDN = dlnaUDN()
+	d.Device.DLNADoc = "DMS-1.50"
+	d.Device.PresentationURL = prefixed("/")
+	d.Device.ServiceList = []upnpService{
+		{upnpContentDirectory, "urn:upnp-org:serviceId:ContentDirectory", prefixed("/upnp/cd.xml"), prefixed("/upnp/control/cd"), prefixed("/upnp/event/cd")},
+		{upnpConnectionManager, "urn:upnp-org:serviceId:ConnectionManager", prefixed("/upnp/cm.xml"), prefixed("/upnp/control/cm"), prefixed("/upnp/event/cm")},
+	}
+	writeUPnPXML(w, http.StatusOK, d)
+}
+
+func writeUPnPXML(w http.ResponseWriter, status int, v any) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+func serveSCPD(doc string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.Write([]byte(xml.Header + doc))
+	}
+}
+
+// dlnaSubscribe accepts GENA subscriptions so renderers that insist on them carry on,
+// but never sends events; they poll SystemUpdateID instead
+func dlnaSubscribe(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		sid := r.Header.Get("SID")
+		if sid == "" {
+			sid = "uuid:" + newID()
+		}
+		w.Header().Set("SID", sid)
+		w.Header().Set("TIMEOUT", fmt.Sprintf("Second-%d", ssdpMaxAge))
+	case "UNSUBSCRIBE":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// soapArgs are a SOAP action's arguments, in order
+type soapArgs [][2]string
+
+// soapAction reads a control request; the action named by the SOAPACTION header has to
+// match the body
+func soapAction(r *http.Request, service string) (string, map[string]string, error) {
+	var env struct {
+		Body struct {
+			Action struct {
+				XMLName xml.Name
+				Args    []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<16)).Decode(&env); err != nil {
+		return "", nil, err
+	}
+	header := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	action := env.Body.Action.XMLName
+	if header != service+"#"+action.Local || action.Space != service {
+		return "", nil, fmt.Errorf("action %q doesn't match the body", header)
+	}
+	args := map[string]string{}
+	for _, arg := range env.Body.Action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+	return action.Local, args, nil
+}
+
+func writeSOAP(w http.ResponseWriter, service, action string, args soapArgs) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, action, service)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>", arg[0])
+		xml.EscapeText(&b, []byte(arg[1]))
+		fmt.Fprintf(&b, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&b, `</u:%sResponse></s:Body></s:Envelope>`, action)
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	w.Write(b.Bytes())
+}
+
+// UPnP error codes sent back in SOAP faults
+const (
+	upnpInvalidAction = 401
+	upnpInvalidArgs   = 402
+	upnpNoSuchObject  = 701
+)
+
+func writeSOAPFault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault>`)
+	b.WriteString(`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0">`)
+	fmt.Fprintf(&b, "<errorCode>%d</errorCode><errorDescription>", code)
+	xml.EscapeText(&b, []byte(description))
+	b.WriteString(`</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+	w.Write(b.Bytes())
+}
+
+func postContentDirectory(w http.ResponseWriter, r *http.Request) {
+	action, args, err := soapAction(r, upnpContentDirectory)
+	if err != nil {
+		writeSOAPFault(w, upnpInvalidAction, err.Error())
+		return
+	}
+	switch action {
+	case "GetSearchCapabilities":
+		writeSOAP(w, upnpContentDirectory, action, soapArgs{{"SearchCaps", ""}})
+	case "GetSortCapabilities":
+		writeSOAP(w, upnpContentDirectory, action, soapArgs{{"SortCaps", ""}})
+	case "GetSystemUpdateID":
+		writeSOAP(w, upnpContentDirectory, action, soapArgs{{"Id", strconv.Itoa(int(dlnaUpdateID.Load()))}})
+	case "Browse":
+		dlnaBrowse(w, r, args)
+	default:
+		writeSOAPFault(w, upnpInvalidAction, "Invalid Action")
+	}
+}
+
+func postConnectionManager(w http.ResponseWriter, r *http.Request) {
+	action, args, err := soapAction(r, upnpConnectionManager)
+	if err != nil {
+		writeSOAPFault(w, upnpInvalidAction, err.Error())
+		return
+	}
+	switch action {
+	case "GetProtocolInfo":
+		var source []string
+		for _, mime := range subsonicContentTypes {
+			source = append(source, "http-get:*:"+mime+":*")
+		}
+		sort.Strings(source)
+		writeSOAP(w, upnpConnectionManager, action, soapArgs{{"Source", strings.Join(source, ",")}, {"Sink", ""}})
+	case "GetCurrentConnectionIDs":
+		writeSOAP(w, upnpConnectionManager, action, soapArgs{{"ConnectionIDs", "0"}})
+	case "GetCurrentConnectionInfo":
+		if args["ConnectionID"] != "0" {
+			writeSOAPFault(w, 706, "Invalid connection reference")
+			return
+		}
+		writeSOAP(w, upnpConnectionManager, action, soapArgs{
+			{"RcsID", "-1"}, {"AVTransportID", "-1"}, {"ProtocolInfo", ""}, {"PeerConnectionManager", ""},
+			{"PeerConnectionID", "-1"}, {"Direction", "Output"}, {"Status", "OK"},
+		})
+	default:
+		writeSOAPFault(w, upnpInvalidAction, "Invalid Action")
+	}
+}
+
+// DIDL-Lite object IDs: "0" is the library root, folders are "f/<path>" and tracks "t/<path>"
+func dlnaFolderID(dir string) string {
+	if dir == "" {
+		return "0"
+	}
+	return "f/" + dir
+}
+
+func dlnaTrackID(relPath string) string {
+	return "t/" + relPath
+}
+
+type didlLite struct {
+	XMLName    xml.Name        `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ DIDL-Lite"`
+	DC         string          `xml:"xmlns:dc,attr"`
+	UPnP       string          `xml:"xmlns:upnp,attr"`
+	DLNA       string          `xml:"xmlns:dlna,attr"`
+	Containers []didlContainer `xml:"container"`
+	Items      []didlItem      `xml:"item"`
+}
+
+type didlContainer struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	Searchable int    `xml:"searchable,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+	AlbumArt   string `xml:"upnp:albumArtURI,omitempty"`
+}
+
+type didlItem struct {
+	ID          string  `xml:"id,attr"`
+	ParentID    string  `xml:"parentID,attr"`
+	Restricted  int     `xml:"restricted,attr"`
+	Title       string  `xml:"dc:title"`
+	Class       string  `xml:"upnp:class"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	Artist      string  `xml:"upnp:artist,omitempty"`
+	Album       string  `xml:"upnp:album,omitempty"`
+	Genre       string  `xml:"upnp:genre,omitempty"`
+	TrackNumber int     `xml:"upnp:originalTrackNumber,omitempty"`
+	Date        string  `xml:"dc:date,omitempty"`
+	AlbumArt    string  `xml:"upnp:albumArtURI,omitempty"`
+	Res         didlRes `xml:"res"`
+}
+
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	Duration     string `xml:"duration,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+// dlnaURL is an absolute URL for a renderer, built from the address it reached us on
+func dlnaURL(r *http.Request, route, relPath string) string {
+	parts := strings.Split(relPath, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return "http://" + r.Host + prefixed(route+strings.Join(parts, "/"))
+}
+
+// dlnaDuration formats seconds as DIDL-Lite's H:MM:SS.mmm
+func dlnaDuration(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func dlnaMime(relPath string) string {
+	if mime, ok := subsonicContentTypes[strings.TrimPrefix(strings.ToLower(path.Ext(relPath)), ".")]; ok {
+		return mime
+	}
+	return "application/octet-stream"
+}
+
+func dlnaFolder(r *http.Request, n *FolderNode, parent string) didlContainer {
+	c := didlContainer{
+		ID:         dlnaFolderID(n.Path),
+		ParentID:   parent,
+		Restricted: 1,
+		ChildCount: len(n.Folders) + n.Files,
+		Title:      n.Name,
+		Class:      "object.container.storageFolder",
+	}
+	if n.Path == "" {
+		c.ParentID = "-1"
+		c.Title = dlnaFriendlyName()
+	}
+	if _, ok := folderCover(n.Path); ok {
+		c.AlbumArt = dlnaURL(r, "/upnp/art/", n.Path)
+	}
+	return c
+}
+
+func dlnaTrack(r *http.Request, f libraryFile) didlItem {
+	meta := trackMeta(f)
+	item := didlItem{
+		ID:          dlnaTrackID(f.Path),
+		ParentID:    dlnaFolderID(f.Dir),
+		Restricted:  1,
+		Title:       firstNonEmpty(meta.Title, strings.TrimSuffix(f.Name, path.Ext(f.Name))),
+		Class:       "object.item.audioItem.musicTrack",
+		Creator:     meta.Artist,
+		Artist:      meta.Artist,
+		Album:       meta.Album,
+		Genre:       meta.Genre,
+		TrackNumber: meta.Track,
+		Res: didlRes{
+			ProtocolInfo: "http-get:*:" + dlnaMime(f.Path) + ":" + dlnaFeatures,
+			Size:         f.Size,
+			Duration:     dlnaDuration(meta.Duration),
+			URL:          dlnaURL(r, "/upnp/media/", f.Path),
+		},
+	}
+	if meta.Year > 0 {
+		item.Date = fmt.Sprintf("%04d-01-01", meta.Year)
+	}
+	if _, ok := folderCover(f.Dir); ok {
+		item.AlbumArt = dlnaURL(r, "/upnp/art/", f.Dir)
+	}
+	return item
+}
+
+func dlnaBrowse(w http.ResponseWriter, r *http.Request, args map[string]string) {
+	start, err1 := strconv.Atoi(firstNonEmpty(args["StartingIndex"], "0"))
+	count, err2 := strconv.Atoi(firstNonEmpty(args["RequestedCount"], "0"))
+	if err1 != nil || err2 != nil || start < 0 || count < 0 {
+		writeSOAPFault(w, upnpInvalidArgs, "Invalid Args")
+		return
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tree := buildFolderTree(files)
+	id := args["ObjectID"]
+	didl := didlLite{DC: "http://purl.org/dc/elements/1.1/", UPnP: "urn:schemas-upnp-org:metadata-1-0/upnp/", DLNA: "urn:schemas-dlna-org:metadata-1-0/"}
+	total := 0
+
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		if relPath, ok := strings.CutPrefix(id, "t/"); ok {
+			f, err := libraryFileAt(relPath)
+			if err != nil {
+				writeSOAPFault(w, upnpNoSuchObject, "No such object")
+				return
+			}
+			didl.Items = append(didl.Items, dlnaTrack(r, f))
+		} else {
+			node := dlnaNode(tree, id)
+			if node == nil {
+				writeSOAPFault(w, upnpNoSuchObject, "No such object")
+				return
+			}
+			didl.Containers = append(didl.Containers, dlnaFolder(r, node, dlnaFolderID(libraryDir(node.Path))))
+		}
+		total = 1
+	case "BrowseDirectChildren":
+		node := dlnaNode(tree, id)
+		if node == nil {
+			writeSOAPFault(w, upnpNoSuchObject, "No such object")
+			return
+		}
+		// Folders come first, then the tracks directly inside, paged together
+		var tracks []libraryFile
+		for _, f := range files {
+			if f.Dir == node.Path {
+				tracks = append(tracks, f)
+			}
+		}
+		total = len(node.Folders) + len(tracks)
+		end := total
+		if count > 0 {
+			end = min(start+count, total)
+		}
+		for i := start; i < end; i++ {
+			if i < len(node.Folders) {
+				didl.Containers = append(didl.Containers, dlnaFolder(r, node.Folders[i], dlnaFolderID(node.Path)))
+			} else {
+				didl.Items = append(didl.Items, dlnaTrack(r, tracks[i-len(node.Folders)]))
+			}
+		}
+	default:
+		writeSOAPFault(w, upnpInvalidArgs, "Invalid Args")
+		return
+	}
+
+	result, err := xml.Marshal(didl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeSOAP(w, upnpContentDirectory, "Browse", soapArgs{
+		{"Result", string(result)},
+		{"NumberReturned", strconv.Itoa(len(didl.Containers) + len(didl.Items))},
+		{"TotalMatches", strconv.Itoa(total)},
+		{"UpdateID", strconv.Itoa(int(dlnaUpdateID.Load()))},
+	})
+}
+
+// dlnaNode finds the folder an object ID names
+func dlnaNode(tree *FolderNode, id string) *FolderNode {
+	if id == "0" {
+		return tree
+	}
+	dir, ok := strings.CutPrefix(id, "f/")
+	if !ok || dir == "" {
+		return nil
+	}
+	return tree.find(dir)
+}
+
+func getDLNAMedia(w http.ResponseWriter, r *http.Request) {
+	relPath := r.PathValue("path")
+	w.Header().Set("Content-Type", dlnaMime(relPath))
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", dlnaFeatures)
+	serveLibraryFile(w, r, relPath)
+}
+
+func getDLNAArt(w http.ResponseWriter, r *http.Request) {
+	cover, ok := folderCover(r.PathValue("dir"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("transferMode.dlna.org", "Interactive")
+	serveLibraryFile(w, r, cover)
+}
+
+func scpdArg(name, direction, variable string) string {
+	return "<argument><name>" + name + "</name><direction>" + direction + "</direction><relatedStateVariable>" + variable + "</relatedStateVariable></argument>"
+}
+
+func scpdAction(name string, args ...string) string {
+	return "<action><name>" + name + "</name><argumentList>" + strings.Join(args, "") + "</argumentList></action>"
+}
+
+func scpdVariable(name, dataType string, events bool, allowed ...string) string {
+	sendEvents := "no"
+	if events {
+		sendEvents = "yes"
+	}
+	v := `<stateVariable sendEvents="` + sendEvents + `"><name>` + name + "</name><dataType>" + dataType + "</dataType>"
+	if len(allowed) > 0 {
+		v += "<allowedValueList><allowedValue>" + strings.Join(allowed, "</allowedValue><allowedValue>") + "</allowedValue></allowedValueList>"
+	}
+	return v + "</stateVariable>"
+}
+
+func scpd(actions, variables []string) string {
+	return `<scpd xmlns="urn:schemas-upnp-org:service-1-0"><specVersion><major>1</major><minor>0</minor></specVersion>` +
+		"<actionList>" + strings.Join(actions, "") + "</actionList>" +
+		"<serviceStateTable>" + strings.Join(variables, "") + "</serviceStateTable></scpd>"
+}
+
+// The service descriptions list only the actions we answer
+var contentDirectorySCPD = scpd([]string{
+	scpdAction("GetSearchCapabilities", scpdArg("SearchCaps", "out", "SearchCapabilities")),
+	scpdAction("GetSortCapabilities", scpdArg("SortCaps", "out", "SortCapabilities")),
+	scpdAction("GetSystemUpdateID", scpdArg("Id", "out", "SystemUpdateID")),
+	scpdAction("Browse",
+		scpdArg("ObjectID", "in", "A_ARG_TYPE_ObjectID"),
+		scpdArg("BrowseFlag", "in", "A_ARG_TYPE_BrowseFlag"),
+		scpdArg("Filter", "in", "A_ARG_TYPE_Filter"),
+		scpdArg("StartingIndex", "in", "A_ARG_TYPE_Index"),
+		scpdArg("RequestedCount", "in", "A_ARG_TYPE_Count"),
+		scpdArg("SortCriteria", "in", "A_ARG_TYPE_SortCriteria"),
+		scpdArg("Result", "out", "A_ARG_TYPE_Result"),
+		scpdArg("NumberReturned", "out", "A_ARG_TYPE_Count"),
+		scpdArg("TotalMatches", "out", "A_ARG_TYPE_Count"),
+		scpdArg("UpdateID", "out", "A_ARG_TYPE_UpdateID")),
+}, []string{
+	scpdVariable("SearchCapabilities", "string", false),
+	scpdVariable("SortCapabilities", "string", false),
+	scpdVariable("SystemUpdateID", "ui4", true),
+	scpdVariable("A_ARG_TYPE_ObjectID", "string", false),
+	scpdVariable("A_ARG_TYPE_BrowseFlag", "string", false, "BrowseMetadata", "BrowseDirectChildren"),
+	scpdVariable("A_ARG_TYPE_Filter", "string", false),
+	scpdVariable("A_ARG_TYPE_Index", "ui4", false),
+	scpdVariable("A_ARG_TYPE_Count", "ui4", false),
+	scpdVariable("A_ARG_TYPE_SortCriteria", "string", false),
+	scpdVariable("A_ARG_TYPE_Result", "string", false),
+	scpdVariable("A_ARG_TYPE_UpdateID", "ui4", false),
+})
+
+var connectionManagerSCPD = scpd([]string{
+	scpdAction("GetProtocolInfo", scpdArg("Source", "out", "SourceProtocolInfo"), scpdArg("Sink", "out", "SinkProtocolInfo")),
+	scpdAction("GetCurrentConnectionIDs", scpdArg("ConnectionIDs", "out", "CurrentConnectionIDs")),
+	scpdAction("GetCurrentConnectionInfo",
+		scpdArg("ConnectionID", "in", "A_ARG_TYPE_ConnectionID"),
+		scpdArg("RcsID", "out", "A_ARG_TYPE_RcsID"),
+		scpdArg("AVTransportID", "out", "A_ARG_TYPE_AVTransportID"),
+		scpdArg("ProtocolInfo", "out", "A_ARG_TYPE_ProtocolInfo"),
+		scpdArg("PeerConnectionManager", "out", "A_ARG_TYPE_ConnectionManager"),
+		scpdArg("PeerConnectionID", "out", "A_ARG_TYPE_ConnectionID"),
+		scpdArg("Direction", "out", "A_ARG_TYPE_Direction"),
+		scpdArg("Status", "out", "A_ARG_TYPE_ConnectionStatus")),
+}, []string{
+	scpdVariable("SourceProtocolInfo", "string", true),
+	scpdVariable("SinkProtocolInfo", "string", true),
+	scpdVariable("CurrentConnectionIDs", "string", true),
+	scpdVariable("A_ARG_TYPE_ConnectionStatus", "string", false, "OK", "ContentFormatMismatch", "InsufficientBandwidth", "UnreliableChannel", "Unknown"),
+	scpdVariable("A_ARG_TYPE_ConnectionManager", "string", false),
+	scpdVariable("A_ARG_TYPE_Direction", "string", false, "Input", "Output"),
+	scpdVariable("A_ARG_TYPE_ProtocolInfo", "string", false),
+	scpdVariable("A_ARG_TYPE_ConnectionID", "i4", false),
+	scpdVariable("A_ARG_TYPE_AVTransportID", "i4", false),
+	scpdVariable("A_ARG_TYPE_RcsID", "i4", false),
+})
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// readDuration works out a track's length in seconds from container headers, without decoding audio.
+// Unknown formats or damaged headers report 0.
+func readDuration(file io.ReadSeeker, path string) float64 {
+	file.Seek(0, io.SeekStart)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		return mp3Duration(file)
+	case ".flac":
+		return flacDuration(file)
+	case ".ogg":
+		return oggDuration(file)
+	case ".m4a":
+		return mp4Duration(file)
+	case ".wav":
+		return wavDuration(file)
+	}
+	return 0
+}
+
+func flacDuration(r io.ReadSeeker) float64 {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header[:4]); err != nil {
+		return 0
+	}
+	if string(header[:3]) == "ID3" {
+		r.Seek(0, io.SeekStart)
+		io.ReadFull(r, header)
+		r.Seek(int64(syncsafe(header[6:10])), io.SeekCurrent)
+		io.ReadFull(r, header[:4])
+	}
+	if string(header[:4]) != "fLaC" {
+		return 0
+	}
+	// STREAMINFO is always the first metadata block
+	block := make([]byte, 4+34)
+	if _, err := io.ReadFull(r, block); err != nil || block[0]&0x7f != 0 {
+		return 0
+	}
+	info := block[4:]
+	sampleRate := int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	totalSamples := int64(info[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 {
+		return 0
+	}
+	return float64(totalSamples) / float64(sampleRate)
+}
+
+func wavDuration(r io.ReadSeeker) float64 {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != "RIFF" {
+		return 0
+	}
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch string(chunk[:4]) {
+		case "fmt ":
+			body := make([]byte, 16)
+			if size < 16 {
+				return 0
+			}
+			if _, err := io.ReadFull(r, body); err != nil {
+				return 0
+			}
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+			size -= 16
+		case "data":
+			if byteRate == 0 {
+				return 0
+			}
+			return float64(size) / float64(byteRate)
+		}
+		if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+			return 0
+		}
+	}
+}
+
+func mp4Duration(r io.ReadSeeker) float64 {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0
+	}
+	mvhd, err := findMP4Atom(r, 0, end, []string{"moov", "mvhd"})
+	if err != nil {
+		return 0
+	}
+	b := make([]byte, 32)
+	r.Seek(mvhd[0], io.SeekStart)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0
+	}
+	var timescale uint32
+	var duration uint64
+	if b[0] == 1 {
+		timescale = binary.BigEndian.Uint32(b[20:24])
+		duration = binary.BigEndian.Uint64(b[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(b[12:16])
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
+
+func oggDuration(r io.ReadSeeker) float64 {
+	packets, _ := readOggPackets(r, 1)
+	if len(packets) < 1 {
+		return 0
+	}
+	var sampleRate, preSkip float64
+	head := packets[0]
+	switch {
+	case bytes.HasPrefix(head, []byte("\x01vorbis")) && len(head) >= 16:
+		sampleRate = float64(binary.LittleEndian.Uint32(head[12:16]))
+	case bytes.HasPrefix(head, []byte("OpusHead")) && len(head) >= 12:
+		sampleRate = 48000 // Opus granules always count 48kHz samples
+		preSkip = float64(binary.LittleEndian.Uint16(head[10:12]))
+	default:
+		return 0
+	}
+
+	// The granule position of the final page is the total sample count
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil || sampleRate == 0 {
+		return 0
+	}
+	tailSize := min(end, 65536)
+	tail := make([]byte, tailSize)
+	r.Seek(end-tailSize, io.SeekStart)
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return 0
+	}
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) {
+		return 0
+	}
+	granule := float64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+	return max(granule-preSkip, 0) / sampleRate
+}
+
+var mp3Bitrates = map[[2]int][]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mp3SampleRates = map[int][]int{
+	1: {44100, 48000, 32000},
+	2: {22050, 24000, 16000},
+	3: {11025, 12000, 8000}, // MPEG 2.5
+}
+
+// mp3Duration prefers the frame count from a Xing/Info/VBRI header and otherwise assumes constant bitrate
+func mp3Duration(r io.ReadSeeker) float64 {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0
+	}
+	var start int64
+	header := make([]byte, 10)
+	r.Seek(0, io.SeekStart)
+	if _, err := io.ReadFull(r, header); err == nil && string(header[:3]) == "ID3" {
+		start = 10 + int64(syncsafe(header[6:10]))
+		if header[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	}
+
+	buf := make([]byte, 65536)
+	r.Seek(start, io.SeekStart)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := map[byte]int{3: 1, 2: 2, 0: 3}[(buf[i+1]>>3)&3]
+		layer := map[byte]int{3: 1, 2: 2, 1: 3}[(buf[i+1]>>1)&3]
+		bitrateIndex := int(buf[i+2] >> 4)
+		rateIndex := int(buf[i+2]>>2) & 3
+		if version == 0 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+		bitrateVersion := min(version, 2)
+		bitrate := mp3Bitrates[[2]int{bitrateVersion, layer}][bitrateIndex] * 1000
+		sampleRate := mp3SampleRates[version][rateIndex]
+		samplesPerFrame := 1152
+		if layer == 1 {
+			samplesPerFrame = 384
+		} else if layer == 3 && version != 1 {
+			samplesPerFrame = 576
+		}
+
+		mono := buf[i+3]>>6 == 3
+		xingOffset := 4 + 32
+		switch {
+		case version == 1 && mono:
+			xingOffset = 4 + 17
+		case version != 1 && !mono:
+			xingOffset = 4 + 17
+		case version != 1 && mono:
+			xingOffset = 4 + 9
+		}
+		frame := buf[i:]
+		if len(frame) >= xingOffset+12 {
+			tag := string(frame[xingOffset : xingOffset+4])
+			flags := binary.BigEndian.Uint32(frame[xingOffset+4:])
+			if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+				frames := binary.BigEndian.Uint32(frame[xingOffset+8:])
+				return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+			}
+		}
+		if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(frame[36+14:])
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+		}
+
+		audioBytes := size - start - int64(i)
+		tail := make([]byte, 3)
+		r.Seek(-128, io.SeekEnd)
+		if _, err := io.ReadFull(r, tail); err == nil && string(tail) == "TAG" {
+			audioBytes -= 128 // ID3v1
+		}
+		return float64(audioBytes) * 8 / float64(bitrate)
+	}
+	return 0
+}
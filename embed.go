@@ -0,0 +1,161 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+)
+
+var embedAccent = regexp.MustCompile(`^[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · beatgraze</title>
+<style>
+:root { --bg: #111; --fg: #eee; --dim: #888; --line: #333; --accent: {{.Accent}}; }
+.light { --bg: #fff; --fg: #111; --dim: #666; --line: #ddd; }
+* { box-sizing: border-box; }
+body { margin: 0; font: 14px system-ui, sans-serif; background: var(--bg); color: var(--fg); overflow: hidden; }
+.player { display: flex; gap: 12px; align-items: center; padding: 12px; }
+.art { width: 96px; height: 96px; object-fit: cover; border-radius: 4px; flex: none; }
+.small .art { width: 40px; height: 40px; }
+.info { flex: 1; min-width: 0; }
+.title, .artist { white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+.title { font-weight: 600; }
+.artist { color: var(--dim); }
+button { flex: none; width: 44px; height: 44px; border-radius: 50%; border: 0; background: var(--accent); color: #fff; font-size: 18px; cursor: pointer; }
+.bar { height: 4px; margin-top: 8px; background: var(--line); cursor: pointer; }
+.bar div { height: 100%; width: 0; background: var(--accent); }
+.time { color: var(--dim); font-size: 12px; margin-top: 4px; }
+ol { margin: 0; padding: 0 12px 12px 36px; max-height: calc(100vh - 120px); overflow-y: auto; }
+li { padding: 4px 0; border-top: 1px solid var(--line); cursor: pointer; }
+li.playing { color: var(--accent); }
+.small ol, .small .time { display: none; }
+a { color: var(--dim); font-size: 11px; position: absolute; right: 8px; bottom: 4px; }
+</style>
+</head>
+<body class="{{.Theme}} {{.Size}}">
+<div class="player">
+{{if .Cover}}<img class="art" src="{{.Cover}}" alt="">{{end}}
+<button id="play" title="Play">▶</button>
+<div class="info">
+<div class="title" id="title"></div>
+<div class="artist" id="artist"></div>
+<div class="bar" id="bar"><div id="progress"></div></div>
+<div class="time" id="time"></div>
+</div>
+</div>
+{{if gt (len .Tracks) 1}}<ol>
+{{range $i, $t := .Tracks}}<li data-i="{{$i}}">{{$t.Title}}</li>
+{{end}}</ol>{{end}}
+<a href="{{.Link}}" target="_blank" rel="noopener">beatgraze</a>
+<audio id="audio" preload="none"></audio>
+<script>
+const tracks = {{.Tracks}};
+const audio = document.getElementById("audio"), play = document.getElementById("play");
+const items = document.querySelectorAll("li");
+let current = 0;
+function fmt(s) { s = Math.floor(s || 0); return Math.floor(s / 60) + ":" + String(s % 60).padStart(2, "0"); }
+function load(i) {
+  current = i;
+  audio.src = tracks[i].url;
+  document.getElementById("title").textContent = tracks[i].title;
+  document.getElementById("artist").textContent = tracks[i].artist || "";
+  items.forEach((li, j) => li.classList.toggle("playing", j === i));
+}
+play.onclick = () => audio.paused ? audio.play() : audio.pause();
+audio.onplay = () => { play.textContent = "❚❚"; play.title = "Pause"; };
+audio.onpause = () => { play.textContent = "▶"; play.title = "Play"; };
+audio.ontimeupdate = () => {
+  document.getElementById("progress").style.width = (audio.currentTime / audio.duration * 100 || 0) + "%";
+  document.getElementById("time").textContent = fmt(audio.currentTime) + " / " + fmt(audio.duration);
+};
+audio.onended = () => { if (current + 1 < tracks.length) { load(current + 1); audio.play(); } };
+document.getElementById("bar").onclick = e => {
+  if (audio.duration) audio.currentTime = e.offsetX / e.currentTarget.clientWidth * audio.duration;
+};
+items.forEach(li => li.onclick = () => { load(+li.dataset.i); audio.play(); });
+load(0);
+{{if .Autoplay}}audio.play().catch(() => {});{{end}}
+</script>
+</body>
+</html>
+`))
+
+type embedTrack struct {
+	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"`
+	URL    string `json:"url"`
+}
+
+// embedShare serves a small player for a share link that other sites can put in an iframe.
+// ?theme=light switches from the dark theme, ?accent= takes a hex colour without the #,
+// ?size=small drops the artwork and track list for a one-line player, and ?autoplay=1
+// starts playing where browsers allow it.
+func embedShare(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	share, err := shares.Resolve(token, !linkPreviewBot(r))
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	tracks, err := shareTracks(share)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	if len(tracks) == 0 {
+		http.Error(w, "Nothing in this share to play", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	view := struct {
+		Title    string
+		Theme    string
+		Size     string
+		Accent   template.CSS
+		Autoplay bool
+		Cover    string
+		Link     string
+		Tracks   []embedTrack
+	}{
+		Title:    share.Name,
+		Theme:    "dark",
+		Size:     "large",
+		Accent:   "#3a8ee6",
+		Autoplay: q.Get("autoplay") == "1",
+		Link:     prefixed("/s/" + token),
+	}
+	if q.Get("theme") == "light" {
+		view.Theme = "light"
+	}
+	if q.Get("size") == "small" {
+		view.Size = "small"
+	}
+	// Only hex digits get into the stylesheet
+	if a := q.Get("accent"); embedAccent.MatchString(a) {
+		view.Accent = template.CSS("#" + a)
+	}
+	if _, ok := shareCoverPath(share, tracks); ok {
+		view.Cover = prefixed("/s/" + token + "/cover")
+	}
+	for _, track := range tracks {
+		t := embedTrack{Title: trackTitle(track), URL: (&url.URL{Path: prefixed("/s/") + token + "/" + filepath.ToSlash(track)}).String()}
+		if f, err := libraryFileAt(track); err == nil {
+			meta := trackMeta(f)
+			t.Title = firstNonEmpty(meta.Title, t.Title)
+			t.Artist = meta.Artist
+		}
+		view.Tracks = append(view.Tracks, t)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	allowFraming(w)
+	embedPage.Execute(w, view)
+}
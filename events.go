@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// feed hands each published value to everyone watching. Slow watchers miss values rather
+// than holding up whoever is publishing.
+type feed[T any] struct {
+	mu       sync.Mutex
+	watchers map[chan T]struct{}
+}
+
+func newFeed[T any]() *feed[T] {
+	return &feed[T]{watchers: map[chan T]struct{}{}}
+}
+
+// Watch returns a channel of values and a function to stop watching
+func (f *feed[T]) Watch() (<-chan T, func()) {
+	ch := make(chan T, 16)
+	f.mu.Lock()
+	f.watchers[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.watchers, ch)
+		f.mu.Unlock()
+	}
+}
+
+func (f *feed[T]) Publish(v T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.watchers {
+		select {
+		case ch <- v:
+		default:
+		}
+	}
+}
+
+// Event is something that changed, sent to clients on /api/events
+type Event struct {
+	Type string
+	Data any
+
+	// Who may see it: everyone when nil
+	visible func(r *http.Request) bool
+}
+
+var events = newFeed[Event]()
+
+// ownerOnly shows an event to the user with ownerID and admins; with no accounts everyone is both
+func ownerOnly(ownerID string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		u := currentUser(r)
+		return u == nil || u.ID == ownerID || hasRole(r, roleAdmin)
+	}
+}
+
+func adminOnly(r *http.Request) bool {
+	return hasRole(r, roleAdmin)
+}
+
+// watchLibrary rescans the library whenever its storage reports changes, announcing which
+// tracks came and went
+func watchLibrary(ctx context.Context) {
+	paths := func(files []libraryFile) map[string]AudioFile {
+		m := make(map[string]AudioFile, len(files))
+		for _, f := range files {
+			m[f.Path] = f.AudioFile
+		}
+		return m
+	}
+	files, err := rescanLibrary()
+	if err != nil {
+		slog.Warn("Error scanning library", "err", err)
+	}
+	last := paths(files)
+	err = library.Watch(ctx, func() {
+		start := time.Now()
+		files, err := rescanLibrary()
+		if err != nil {
+			slog.Warn("Error scanning library", "err", err)
+			return
+		}
+		current := paths(files)
+		added, removed := []AudioFile{}, []string{}
+		for p, f := range current {
+			if _, ok := last[p]; !ok {
+				added = append(added, f)
+			}
+		}
+		for p := range last {
+			if _, ok := current[p]; !ok {
+				removed = append(removed, p)
+			}
+		}
+		last = current
+		if len(added) > 0 {
+			events.Publish(Event{Type: "files.added", Data: map[string]any{"files": added}})
+		}
+		if len(removed) > 0 {
+			events.Publish(Event{Type: "files.removed", Data: map[string]any{"paths": removed}})
+		}
+		events.Publish(Event{Type: "scan.completed", Data: map[string]any{
+			"files":      len(current),
+			"added":      len(added),
+			"removed":    len(removed),
+			"durationMs": time.Since(start).Milliseconds(),
+		}})
+	})
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("Stopped watching the library", "err", err)
+	}
+}
+
+func registerEventRoutes() {
+	handleFunc("GET /api/events", streamEvents)
+}
+
+// eventKeepAlive keeps proxies from closing quiet streams
+const eventKeepAlive = 30 * time.Second
+
+// streamEvents sends events as server-sent events until the client goes away. ?types= takes a
+// comma-separated list of the event types to send, where "files" matches every files.* type.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	wanted := func(eventType string) bool {
+		if len(types) == 0 {
+			return true
+		}
+		for _, t := range types {
+			if eventType == t || strings.HasPrefix(eventType, t+".") {
+				return true
+			}
+		}
+		return false
+	}
+	everyone := hasRole(r, roleAdmin)
+	me := requestUserName(r)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx holding events back
+	// The stream stays open indefinitely, so don't hold up reloads
+	releaseSettings(r)
+	w.WriteHeader(http.StatusOK)
+	// Tell browsers how soon to reconnect, which also gets the headers out straight away
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ctx, closeSession := streams.openSession(r, types)
+	defer closeSession()
+	all, stopEvents := events.Watch()
+	defer stopEvents()
+	plays, stopPlays := nowPlaying.Watch()
+	defer stopPlays()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	send := func(eventType string, data any) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, b); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-shuttingDown.Done():
+			return
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		case e := <-all:
+			if wanted(e.Type) && (e.visible == nil || e.visible(r)) {
+				err = send(e.Type, e.Data)
+			}
+		case p := <-plays:
+			if wanted("nowplaying") && (everyone || p.User == me) {
+				err = send("nowplaying", struct {
+					NowPlaying
+					File AudioFile `json:"file"`
+				}{p, audioFileFromPath(p.Path)})
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
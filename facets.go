@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// losslessExts are the formats that keep all of the recorded audio
+var losslessExts = map[string]bool{".wav": true, ".flac": true}
+
+var (
+	errInvalidFormat  = errors.New("format must be lossless or lossy")
+	errInvalidBitrate = errors.New("bitrate must be kbps like 320 for at least that, or a range like 128-192")
+)
+
+// fileFormat is "lossless" or "lossy"
+func fileFormat(name string) string {
+	if losslessExts[strings.ToLower(filepath.Ext(name))] {
+		return "lossless"
+	}
+	return "lossy"
+}
+
+func fileExt(name string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+}
+
+// averageBitrate works out a track's bitrate in kbps from its size and duration
+func averageBitrate(f libraryFile) (int, bool) {
+	duration := trackMeta(f).Duration
+	if duration <= 0 {
+		return 0, false
+	}
+	return int(float64(f.Size) * 8 / duration / 1000), true
+}
+
+// fileFilter narrows /api/files to some formats: ?ext=flac,wav by extension, ?format=lossless
+// or lossy, and ?bitrate=320 (at least) or ?bitrate=128-192 in kbps
+type fileFilter struct {
+	exts       map[string]bool
+	format     string
+	minBitrate int
+	maxBitrate int
+}
+
+func parseFileFilter(q url.Values) (fileFilter, error) {
+	var ff fileFilter
+	for _, ext := range strings.Split(q.Get("ext"), ",") {
+		if ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), "."); ext != "" {
+			if ff.exts == nil {
+				ff.exts = map[string]bool{}
+			}
+			ff.exts[ext] = true
+		}
+	}
+	switch ff.format = q.Get("format"); ff.format {
+	case "", "lossless", "lossy":
+	default:
+		return ff, errInvalidFormat
+	}
+	if b := q.Get("bitrate"); b != "" {
+		lo, hi, isRange := strings.Cut(b, "-")
+		var err error
+		if ff.minBitrate, err = strconv.Atoi(lo); err != nil || ff.minBitrate < 0 {
+			return ff, errInvalidBitrate
+		}
+		if isRange {
+			if ff.maxBitrate, err = strconv.Atoi(hi); err != nil || ff.maxBitrate < ff.minBitrate {
+				return ff, errInvalidBitrate
+			}
+		}
+	}
+	return ff, nil
+}
+
+// match reports whether f passes the filter, leaving out the extension or format part when
+// counting facets for them
+func (ff fileFilter) match(f libraryFile, skipExt, skipFormat bool) bool {
+	if !skipExt && ff.exts != nil && !ff.exts[fileExt(f.Name)] {
+		return false
+	}
+	if !skipFormat && ff.format != "" && fileFormat(f.Name) != ff.format {
+		return false
+	}
+	if ff.minBitrate > 0 || ff.maxBitrate > 0 {
+		kbps, ok := averageBitrate(f)
+		if !ok || kbps < ff.minBitrate || (ff.maxBitrate > 0 && kbps > ff.maxBitrate) {
+			return false
+		}
+	}
+	return true
+}
+
+// FileFacets counts files by extension and format. Each count applies the other filters but
+// not its own, so it says how many files picking that value would show.
+type FileFacets struct {
+	Ext    map[string]int `json:"ext"`
+	Format map[string]int `json:"format"`
+}
+
+// apply narrows files down and counts the facets along the way
+func (ff fileFilter) apply(files []libraryFile) ([]libraryFile, FileFacets) {
+	facets := FileFacets{Ext: map[string]int{}, Format: map[string]int{}}
+	var kept []libraryFile
+	for _, f := range files {
+		if ff.match(f, true, false) {
+			facets.Ext[fileExt(f.Name)]++
+		}
+		if ff.match(f, false, true) {
+			facets.Format[fileFormat(f.Name)]++
+		}
+		if ff.match(f, false, false) {
+			kept = append(kept, f)
+		}
+	}
+	return kept, facets
+}
+
+const (
+	defaultGroupItems = 5
+	maxGroupItems     = 100
+)
+
+var errInvalidGroupBy = errors.New("groupBy must be folder, artist, album or ext")
+
+// fileGroupers are what ?groupBy= can group files by
+var fileGroupers = map[string]func(f libraryFile) string{
+	"folder": func(f libraryFile) string { return f.Dir },
+	"artist": func(f libraryFile) string { return trackMeta(f).Artist },
+	"album":  func(f libraryFile) string { return trackMeta(f).Album },
+	"ext":    func(f libraryFile) string { return fileExt(f.Name) },
+}
+
+// FileGroup is the files sharing a folder, artist, album or extension, with the first few of them
+type FileGroup struct {
+	Key   string      `json:"key"` // "" for files without one
+	Count int         `json:"count"`
+	Files []AudioFile `json:"files"`
+}
+
+// groupFiles buckets sorted files by key, keeping up to n files of each in order. The groups
+// come in the order of their first file.
+func groupFiles(sorted []AudioFile, byPath map[string]libraryFile, key func(libraryFile) string, n int) []*FileGroup {
+	var groups []*FileGroup
+	index := map[string]*FileGroup{}
+	for _, f := range sorted {
+		k := key(byPath[f.Path])
+		g, ok := index[k]
+		if !ok {
+			g = &FileGroup{Key: k, Files: []AudioFile{}}
+			index[k] = g
+			groups = append(groups, g)
+		}
+		g.Count++
+		if len(g.Files) < n {
+			g.Files = append(g.Files, f)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFeedItems = 50
+	maxFeedItems     = 500
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Summary string      `xml:"summary,omitempty"`
+}
+
+func registerFeedRoutes() {
+	handleFunc("GET /feed/recent.xml", requireRole(roleListener, getRecentFeed("rss")))
+	handleFunc("GET /feed/recent.atom", requireRole(roleListener, getRecentFeed("atom")))
+}
+
+// getRecentFeed lists the tracks added to the library lately, newest first, as RSS or Atom.
+// ?dir= keeps to one folder and those below it, ?days= is how far back to go (30 by default)
+// and ?limit= caps the entries. Feed readers can pass an API key as ?token=, which the
+// entries' links carry on too.
+func getRecentFeed(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		dir := strings.Trim(q.Get("dir"), "/")
+		limit := defaultFeedItems
+		if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+			limit = min(n, maxFeedItems)
+		}
+		files, err := scanLibrary()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if dir != "" && buildFolderTree(files).find(dir) == nil {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
+		byPath := make(map[string]libraryFile, len(files))
+		for _, f := range files {
+			byPath[f.Path] = f
+		}
+		var added []libraryFile
+		for _, item := range recentlyAdded(nil, files, collectionSince(r)) {
+			if inFolder(item.Path, dir, true) {
+				added = append(added, byPath[item.Path])
+			}
+		}
+		if len(added) > limit {
+			added = added[:limit]
+		}
+
+		withToken := func(u string) string {
+			if token := q.Get("token"); token != "" {
+				u += "?" + url.Values{"token": {token}}.Encode()
+			}
+			return u
+		}
+		title := "Recently added to " + libraryName()
+		if dir != "" {
+			title += "/" + dir
+		}
+		// When nothing is new, the feed hasn't changed since the window opened
+		updated := collectionSince(r)
+		if len(added) > 0 {
+			updated = added[0].ModTime
+			w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+		}
+
+		if format == "atom" {
+			id := externalURL(r, "/feed/recent.atom")
+			if dir != "" {
+				id += "?" + url.Values{"dir": {dir}}.Encode()
+			}
+			feed := atomFeed{
+				Title:   title,
+				ID:      id,
+				Updated: updated.UTC().Format(time.RFC3339),
+				Links:   []atomLink{{Rel: "alternate", Href: externalURL(r, "/")}},
+				Author:  atomAuthor{Name: "beatgraze"},
+			}
+			for _, f := range added {
+				meta := trackMeta(f)
+				entry := atomEntry{
+					Title:   feedItemTitle(f, meta),
+					ID:      "urn:beatgraze:track:" + url.PathEscape(f.Path) + ":" + strconv.FormatInt(f.ModTime.Unix(), 10),
+					Updated: f.ModTime.UTC().Format(time.RFC3339),
+					Links: []atomLink{
+						{Rel: "alternate", Href: withToken(streamURL(r, f.Path))},
+						{Rel: "enclosure", Href: withToken(streamURL(r, f.Path)), Type: dlnaMime(f.Path), Length: f.Size},
+					},
+					Summary: f.Path,
+				}
+				if meta.Artist != "" {
+					entry.Author = &atomAuthor{Name: meta.Artist}
+				}
+				feed.Entries = append(feed.Entries, entry)
+			}
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+			w.Write([]byte(xml.Header))
+			enc := xml.NewEncoder(w)
+			enc.Indent("", "  ")
+			enc.Encode(feed)
+			return
+		}
+
+		ch := rssChannel{
+			Title:       title,
+			Link:        externalURL(r, "/"),
+			Description: "Tracks added to the library lately",
+			PubDate:     updated.UTC().Format(time.RFC1123Z),
+			Generator:   "beatgraze " + versionInfo().Version,
+		}
+		for _, f := range added {
+			meta := trackMeta(f)
+			ch.Items = append(ch.Items, rssItem{
+				Title:       feedItemTitle(f, meta),
+				Link:        withToken(streamURL(r, f.Path)),
+				Description: f.Path,
+				GUID:        rssGUID{Value: f.Path + "@" + strconv.FormatInt(f.ModTime.Unix(), 10)},
+				PubDate:     f.ModTime.UTC().Format(time.RFC1123Z),
+				Enclosure:   rssEnclosure{URL: withToken(streamURL(r, f.Path)), Length: f.Size, Type: dlnaMime(f.Path)},
+				Author:      meta.Artist,
+			})
+		}
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		enc.Encode(rssFeed{Version: "2.0", ITunes: itunesNS, Channel: ch})
+	}
+}
+
+// feedItemTitle names a track as "Artist – Title" when it's tagged, or by its file name
+func feedItemTitle(f libraryFile, meta TrackMeta) string {
+	title := firstNonEmpty(meta.Title, trackTitle(f.Path))
+	if meta.Artist != "" {
+		return meta.Artist + " – " + title
+	}
+	return title
+}
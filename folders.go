@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FolderNode is a folder in the library tree, with how many tracks are in it directly and
+// in it and everything below
+type FolderNode struct {
+	Name    string        `json:"name"`
+	Path    string        `json:"path"`
+	Files   int           `json:"files"`
+	Total   int           `json:"total"`
+	Folders []*FolderNode `json:"folders,omitempty"`
+}
+
+// Breadcrumb is one step on the way from the library root to a folder
+type Breadcrumb struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// buildFolderTree arranges the folders that hold tracks into a tree under the library root
+func buildFolderTree(files []libraryFile) *FolderNode {
+	root := &FolderNode{Name: libraryName()}
+	nodes := map[string]*FolderNode{"": root}
+	var node func(dir string) *FolderNode
+	node = func(dir string) *FolderNode {
+		if n, ok := nodes[dir]; ok {
+			return n
+		}
+		parent := ""
+		if i := strings.LastIndex(dir, "/"); i >= 0 {
+			parent = dir[:i]
+		}
+		n := &FolderNode{Name: path.Base(dir), Path: dir}
+		nodes[dir] = n
+		p := node(parent)
+		p.Folders = append(p.Folders, n)
+		return n
+	}
+	for _, f := range files {
+		dir := libraryDir(f.Path)
+		node(dir).Files++
+		for {
+			nodes[dir].Total++
+			if dir == "" {
+				break
+			}
+			dir = libraryDir(dir)
+		}
+	}
+	for _, n := range nodes {
+		sort.Slice(n.Folders, func(i, j int) bool {
+			return strings.ToLower(n.Folders[i].Name) < strings.ToLower(n.Folders[j].Name)
+		})
+	}
+	return root
+}
+
+// libraryDir is the folder a library path is in, "" for the root
+func libraryDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// breadcrumbs lists dir and every folder above it, starting at the library root
+func breadcrumbs(dir string) []Breadcrumb {
+	return append([]Breadcrumb{{Name: libraryName(), Path: ""}}, folderCrumbs(dir)...)
+}
+
+// folderCrumbs lists dir and the folders above it, leaving out the library root
+func folderCrumbs(dir string) []Breadcrumb {
+	crumbs := []Breadcrumb{}
+	if dir == "" {
+		return crumbs
+	}
+	parts := strings.Split(dir, "/")
+	for i, part := range parts {
+		crumbs = append(crumbs, Breadcrumb{Name: part, Path: strings.Join(parts[:i+1], "/")})
+	}
+	return crumbs
+}
+
+// subfolders lists a folder's children without their own subfolders
+func (n *FolderNode) subfolders() []*FolderNode {
+	list := make([]*FolderNode, len(n.Folders))
+	for i, sub := range n.Folders {
+		c := *sub
+		c.Folders = nil
+		list[i] = &c
+	}
+	return list
+}
+
+// find looks up a folder below n by its path, returning nil if no tracks are in or below it
+func (n *FolderNode) find(dir string) *FolderNode {
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		var next *FolderNode
+		for _, sub := range n.Folders {
+			if sub.Name == part {
+				next = sub
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// coverNames are the images a folder's cover art is taken from, best first
+var coverNames = []string{"cover", "folder", "front", "Cover", "Folder", "Front"}
+
+// folderCover finds the cover image kept in a folder
+func folderCover(dir string) (string, bool) {
+	for _, name := range coverNames {
+		for _, ext := range []string{".jpg", ".jpeg", ".png"} {
+			p := path.Join(dir, name+ext)
+			if info, err := library.Stat(p); err == nil && !info.IsDir() {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// inFolder reports whether a track is in dir, or below it when recursive
+func inFolder(p, dir string, recursive bool) bool {
+	if !recursive {
+		return libraryDir(p) == dir
+	}
+	return dir == "" || strings.HasPrefix(p, dir+"/")
+}
+
+func getFolderTree(w http.ResponseWriter, r *http.Request) {
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, buildFolderTree(files))
+}
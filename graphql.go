@@ -0,0 +1,1440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// graphqlSchema documents what /api/graphql serves. Queries are read-only; changes still go
+// through the REST routes. filter takes the smart playlist rule language and sort its sort
+// fields, so files(filter: "genre=techno AND bpm 120-130", sort: "-plays") works.
+const graphqlSchema = `type Query {
+  files(filter: String, search: String, fuzzy: Boolean = false, dir: String, recursive: Boolean = true, sort: String, first: Int = 200, offset: Int = 0): FileList!
+  file(path: String!): File
+  folder(path: String = ""): Folder
+  tags(field: String!, first: Int): [Tag!]!
+  playlists: [Playlist!]!
+  playlist(id: ID!): Playlist
+  stats: Stats!
+}
+
+type FileList {
+  total: Int!
+  items: [File!]!
+}
+
+type File {
+  name: String!
+  path: String!
+  folder: String!
+  dir: String!
+  breadcrumbs: [Breadcrumb!]!
+  size: Int!
+  modified: String!
+  title: String
+  artist: String
+  album: String
+  genre: String
+  key: String
+  bpm: Float
+  track: Int
+  year: Int
+  duration: Float
+  rating: Int!
+  playCount: Int!
+  lastPlayed: String
+  favorite: Boolean!
+  position: Float!
+}
+
+type Folder {
+  name: String!
+  path: String!
+  files: Int!
+  total: Int!
+  breadcrumbs: [Breadcrumb!]!
+  folders: [Folder!]!
+  tracks(filter: String, search: String, fuzzy: Boolean = false, recursive: Boolean = false, sort: String, first: Int = 200, offset: Int = 0): FileList!
+}
+
+type Breadcrumb {
+  name: String!
+  path: String!
+}
+
+type Tag {
+  value: String!
+  count: Int!
+}
+
+type Playlist {
+  id: ID!
+  name: String!
+  source: String
+  rules: String
+  auto: String
+  sort: String
+  limit: Int
+  smart: Boolean!
+  created: String!
+  updated: String!
+  trackCount: Int!
+  tracks(first: Int, offset: Int = 0): [File!]!
+}
+
+type Stats {
+  recentPlays(first: Int = 20): [Play!]!
+  mostPlayed(first: Int = 20): [File!]!
+  favorites: [File!]!
+}
+
+type Play {
+  time: String!
+  file: File!
+}
+`
+
+func registerGraphQLRoutes() {
+	handleFunc("GET /api/graphql", graphqlHandler)
+	handleFunc("POST /api/graphql", graphqlHandler)
+	handleFunc("GET /api/graphql/schema", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, graphqlSchema)
+	})
+}
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   any            `json:"data"`
+	Errors []graphqlError `json:"errors,omitempty"`
+}
+
+// graphqlHandler takes queries as JSON posts or, for GET, ?query=&variables=&operationName=
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if r.Method == http.MethodPost {
+		if err := readJSON(r, &req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+		return
+	}
+	ex := &graphqlExecutor{r: r, doc: doc}
+	data, err := ex.run(req.OperationName, req.Variables)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+		return
+	}
+	writeJSON(w, http.StatusOK, graphqlResponse{Data: data, Errors: ex.errors})
+}
+
+// Parsing
+
+type graphqlToken struct {
+	kind  string // name, string, int, float, punct
+	value string
+}
+
+func tokenizeGraphQL(src string) ([]graphqlToken, error) {
+	var tokens []graphqlToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c) || c == ',' || c == '\ufeff':
+			i++
+		case c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case strings.ContainsRune("{}()[]:!$=@|&", c):
+			tokens = append(tokens, graphqlToken{kind: "punct", value: string(c)})
+			i++
+		case c == '.':
+			if i+2 >= len(runes) || runes[i+1] != '.' || runes[i+2] != '.' {
+				return nil, errors.New("unexpected '.'")
+			}
+			tokens = append(tokens, graphqlToken{kind: "punct", value: "..."})
+			i += 3
+		case c == '"':
+			s, end, err := graphqlString(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, graphqlToken{kind: "string", value: s})
+			i = end
+		case c == '-' || unicode.IsDigit(c):
+			end := i + 1
+			kind := "int"
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || strings.ContainsRune(".eE+-", runes[end])) {
+				if !unicode.IsDigit(runes[end]) {
+					kind = "float"
+				}
+				end++
+			}
+			tokens = append(tokens, graphqlToken{kind: kind, value: string(runes[i:end])})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(runes) && (runes[end] == '_' || unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+				end++
+			}
+			tokens = append(tokens, graphqlToken{kind: "name", value: string(runes[i:end])})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// graphqlString reads a quoted string starting at runes[start], returning where it ends
+func graphqlString(runes []rune, start int) (string, int, error) {
+	if start+2 < len(runes) && runes[start+1] == '"' && runes[start+2] == '"' {
+		// Block string: no escapes besides \"""
+		var b strings.Builder
+		for i := start + 3; i+2 < len(runes); i++ {
+			if runes[i] == '"' && runes[i+1] == '"' && runes[i+2] == '"' {
+				return b.String(), i + 3, nil
+			}
+			if runes[i] == '\\' && i+3 < len(runes) && string(runes[i+1:i+4]) == `"""` {
+				b.WriteString(`"""`)
+				i += 3
+				continue
+			}
+			b.WriteRune(runes[i])
+		}
+		return "", 0, errors.New("unterminated string")
+	}
+	for end := start + 1; end < len(runes); end++ {
+		switch runes[end] {
+		case '\\':
+			end++
+		case '\n':
+			return "", 0, errors.New("unterminated string")
+		case '"':
+			// GraphQL escapes are a subset of JSON's
+			var s string
+			if err := json.Unmarshal([]byte(string(runes[start:end+1])), &s); err != nil {
+				return "", 0, fmt.Errorf("invalid string %s", string(runes[start:end+1]))
+			}
+			return s, end + 1, nil
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+type graphqlDocument struct {
+	operations []graphqlOperation
+	fragments  map[string]graphqlFragment
+}
+
+type graphqlOperation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	variables  []graphqlVariableDef
+	selections []graphqlSelection
+}
+
+type graphqlVariableDef struct {
+	name     string
+	required bool // Declared non-null without a default
+	def      any
+}
+
+type graphqlFragment struct {
+	on         string
+	selections []graphqlSelection
+}
+
+// graphqlSelection is a field, a ...Fragment spread (spread set) or an inline fragment (inline set)
+type graphqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]any
+	directives []graphqlDirective
+	selections []graphqlSelection
+
+	spread string
+	inline bool
+	on     string
+}
+
+type graphqlDirective struct {
+	name string
+	args map[string]any
+}
+
+// graphqlVariable is a $name reference in an argument, looked up when the query runs
+type graphqlVariable string
+
+// graphqlEnum is a bare enum value in an argument
+type graphqlEnum string
+
+type graphqlParser struct {
+	tokens []graphqlToken
+	pos    int
+}
+
+func parseGraphQL(src string) (*graphqlDocument, error) {
+	tokens, err := tokenizeGraphQL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &graphqlParser{tokens: tokens}
+	doc := &graphqlDocument{fragments: map[string]graphqlFragment{}}
+	for p.pos < len(p.tokens) {
+		if p.punct("{") {
+			p.pos--
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, graphqlOperation{kind: "query", selections: sels})
+			continue
+		}
+		t := p.next()
+		switch t.value {
+		case "query", "mutation", "subscription":
+			op, err := p.operation(t.value)
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case "fragment":
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if !p.keyword("on") {
+				return nil, fmt.Errorf("expected \"on\" after fragment %s", name)
+			}
+			on, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.directives(); err != nil {
+				return nil, err
+			}
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[name]; ok {
+				return nil, fmt.Errorf("fragment %s is defined twice", name)
+			}
+			doc.fragments[name] = graphqlFragment{on: on, selections: sels}
+		default:
+			return nil, fmt.Errorf("unexpected %q", t.value)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, errors.New("no operation in query")
+	}
+	return doc, nil
+}
+
+func (p *graphqlParser) peek() graphqlToken {
+	if p.pos >= len(p.tokens) {
+		return graphqlToken{kind: "eof"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *graphqlParser) next() graphqlToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *graphqlParser) punct(value string) bool {
+	if t := p.peek(); t.kind == "punct" && t.value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *graphqlParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == "name" && t.value == word {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *graphqlParser) expect(value string) error {
+	if !p.punct(value) {
+		return p.unexpected(fmt.Sprintf("%q", value))
+	}
+	return nil
+}
+
+func (p *graphqlParser) unexpected(want string) error {
+	t := p.peek()
+	if t.kind == "eof" {
+		return fmt.Errorf("expected %s, got the end of the query", want)
+	}
+	return fmt.Errorf("expected %s, got %q", want, t.value)
+}
+
+func (p *graphqlParser) name() (string, error) {
+	t := p.peek()
+	if t.kind != "name" {
+		return "", p.unexpected("a name")
+	}
+	p.pos++
+	return t.value, nil
+}
+
+func (p *graphqlParser) operation(kind string) (graphqlOperation, error) {
+	op := graphqlOperation{kind: kind}
+	if p.peek().kind == "name" {
+		op.name, _ = p.name()
+	}
+	if p.punct("(") {
+		for !p.punct(")") {
+			if err := p.expect("$"); err != nil {
+				return op, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return op, err
+			}
+			if err := p.expect(":"); err != nil {
+				return op, err
+			}
+			nonNull, err := p.typeRef()
+			if err != nil {
+				return op, err
+			}
+			v := graphqlVariableDef{name: name, required: nonNull}
+			if p.punct("=") {
+				if v.def, err = p.value(true); err != nil {
+					return op, err
+				}
+				v.required = false
+			}
+			op.variables = append(op.variables, v)
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return op, err
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+// typeRef skips over a variable's type, reporting whether it's non-null
+func (p *graphqlParser) typeRef() (bool, error) {
+	if p.punct("[") {
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	return p.punct("!"), nil
+}
+
+func (p *graphqlParser) selectionSet() ([]graphqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []graphqlSelection
+	for !p.punct("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, errors.New("empty selection set")
+	}
+	return sels, nil
+}
+
+func (p *graphqlParser) selection() (graphqlSelection, error) {
+	var sel graphqlSelection
+	var err error
+	if p.punct("...") {
+		if t := p.peek(); t.kind == "name" && t.value != "on" {
+			sel.spread, _ = p.name()
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+		sel.inline = true
+		if p.keyword("on") {
+			if sel.on, err = p.name(); err != nil {
+				return sel, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return sel, err
+		}
+		sel.selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.punct(":") {
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if sel.args, err = p.arguments(); err != nil {
+		return sel, err
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return sel, err
+	}
+	if t := p.peek(); t.kind == "punct" && t.value == "{" {
+		sel.selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *graphqlParser) arguments() (map[string]any, error) {
+	if !p.punct("(") {
+		return nil, nil
+	}
+	args := map[string]any{}
+	for !p.punct(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func (p *graphqlParser) directives() ([]graphqlDirective, error) {
+	var list []graphqlDirective
+	for p.punct("@") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, graphqlDirective{name: name, args: args})
+	}
+	return list, nil
+}
+
+// value parses an argument value; defaults for variables can't refer to other variables
+func (p *graphqlParser) value(constant bool) (any, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return t.value, nil
+	case "int":
+		n, err := strconv.Atoi(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.value)
+		}
+		return n, nil
+	case "float":
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.value)
+		}
+		return f, nil
+	case "name":
+		switch t.value {
+		case "true", "false":
+			return t.value == "true", nil
+		case "null":
+			return nil, nil
+		}
+		return graphqlEnum(t.value), nil
+	case "punct":
+		switch t.value {
+		case "$":
+			if constant {
+				return nil, errors.New("variables can't be used here")
+			}
+			name, err := p.name()
+			return graphqlVariable(name), err
+		case "[":
+			list := []any{}
+			for !p.punct("]") {
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		case "{":
+			obj := map[string]any{}
+			for !p.punct("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			return obj, nil
+		}
+	}
+	p.pos--
+	return nil, p.unexpected("a value")
+}
+
+// Execution
+
+// graphqlObject is anything a query can select fields from
+type graphqlObject interface {
+	typeName() string
+	field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error)
+}
+
+type graphqlExecutor struct {
+	r         *http.Request
+	doc       *graphqlDocument
+	variables map[string]any
+	errors    []graphqlError
+
+	// Loaded once per query, however many fields need them
+	files  []libraryFile
+	byPath map[string]libraryFile
+	stats  map[string]TrackStats
+	tree   *FolderNode
+}
+
+func (ex *graphqlExecutor) run(operationName string, variables map[string]any) (any, error) {
+	var op *graphqlOperation
+	for i := range ex.doc.operations {
+		o := &ex.doc.operations[i]
+		if operationName == "" && len(ex.doc.operations) > 1 {
+			return nil, errors.New("operationName is needed when the query has several operations")
+		}
+		if operationName == "" || o.name == operationName {
+			op = o
+			break
+		}
+	}
+	if op == nil {
+		return nil, fmt.Errorf("unknown operation %q", operationName)
+	}
+	if op.kind != "query" {
+		return nil, fmt.Errorf("%ss aren't supported; use the REST API to make changes", op.kind)
+	}
+
+	ex.variables = map[string]any{}
+	for _, v := range op.variables {
+		value, ok := variables[v.name]
+		switch {
+		case ok:
+			ex.variables[v.name] = value
+		case v.required:
+			return nil, fmt.Errorf("variable $%s is required", v.name)
+		default:
+			ex.variables[v.name] = v.def
+		}
+	}
+	return ex.selectObject(graphqlQuery{}, op.selections, nil), nil
+}
+
+func (ex *graphqlExecutor) fail(path []any, err error) {
+	ex.errors = append(ex.errors, graphqlError{Message: err.Error(), Path: append([]any(nil), path...)})
+}
+
+// selectObject resolves a selection set against obj, keeping the order fields were asked for
+func (ex *graphqlExecutor) selectObject(obj graphqlObject, sels []graphqlSelection, path []any) *graphqlResult {
+	result := &graphqlResult{values: map[string]any{}}
+	grouped := map[string]*graphqlSelection{}
+	if err := ex.collectFields(obj, sels, result, grouped, map[string]bool{}); err != nil {
+		ex.fail(path, err)
+		return nil
+	}
+	for _, key := range result.keys {
+		sel := grouped[key]
+		fieldPath := append(path[:len(path):len(path)], key)
+		if sel.name == "__typename" {
+			result.values[key] = obj.typeName()
+			continue
+		}
+		args, err := ex.resolveArgs(sel.args)
+		if err != nil {
+			ex.fail(fieldPath, err)
+			result.values[key] = nil
+			continue
+		}
+		value, err := obj.field(ex, sel.name, args)
+		if err != nil {
+			ex.fail(fieldPath, err)
+			result.values[key] = nil
+			continue
+		}
+		result.values[key] = ex.complete(value, sel, fieldPath)
+	}
+	return result
+}
+
+// collectFields flattens fragments and merges fields asked for more than once under the same key
+func (ex *graphqlExecutor) collectFields(obj graphqlObject, sels []graphqlSelection, result *graphqlResult, grouped map[string]*graphqlSelection, visited map[string]bool) error {
+	for _, sel := range sels {
+		include, err := ex.included(sel.directives)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			frag, ok := ex.doc.fragments[sel.spread]
+			if !ok {
+				return fmt.Errorf("unknown fragment %s", sel.spread)
+			}
+			if visited[sel.spread] || frag.on != obj.typeName() {
+				continue
+			}
+			visited[sel.spread] = true
+			if err := ex.collectFields(obj, frag.selections, result, grouped, visited); err != nil {
+				return err
+			}
+		case sel.inline:
+			if sel.on != "" && sel.on != obj.typeName() {
+				continue
+			}
+			if err := ex.collectFields(obj, sel.selections, result, grouped, visited); err != nil {
+				return err
+			}
+		default:
+			key := sel.alias
+			if key == "" {
+				key = sel.name
+			}
+			if prev, ok := grouped[key]; ok {
+				if prev.name != sel.name {
+					return fmt.Errorf("%s is asked for as both %s and %s", key, prev.name, sel.name)
+				}
+				prev.selections = append(prev.selections, sel.selections...)
+				continue
+			}
+			sel := sel
+			sel.selections = append([]graphqlSelection(nil), sel.selections...)
+			grouped[key] = &sel
+			result.keys = append(result.keys, key)
+		}
+	}
+	return nil
+}
+
+// included applies @include(if:) and @skip(if:)
+func (ex *graphqlExecutor) included(directives []graphqlDirective) (bool, error) {
+	for _, d := range directives {
+		if d.name != "include" && d.name != "skip" {
+			return false, fmt.Errorf("unknown directive @%s", d.name)
+		}
+		args, err := ex.resolveArgs(d.args)
+		if err != nil {
+			return false, err
+		}
+		cond, ok := args["if"].(bool)
+		if !ok {
+			return false, fmt.Errorf("@%s needs a Boolean if argument", d.name)
+		}
+		if cond == (d.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// complete turns a resolved value into its JSON form, descending into objects and lists
+func (ex *graphqlExecutor) complete(value any, sel *graphqlSelection, path []any) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case graphqlObject:
+		if len(sel.selections) == 0 {
+			ex.fail(path, fmt.Errorf("%s is a %s, so it needs a selection of fields", sel.name, v.typeName()))
+			return nil
+		}
+		return ex.selectObject(v, sel.selections, path)
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		value = v.UTC().Format(time.RFC3339)
+	case string, bool, int, int64, float64:
+	default:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice {
+			ex.fail(path, fmt.Errorf("can't return a %T", value))
+			return nil
+		}
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = ex.complete(rv.Index(i).Interface(), sel, append(path[:len(path):len(path)], i))
+		}
+		return list
+	}
+	if len(sel.selections) > 0 {
+		ex.fail(path, fmt.Errorf("%s has no fields to select", sel.name))
+		return nil
+	}
+	return value
+}
+
+func (ex *graphqlExecutor) resolveArgs(raw map[string]any) (graphqlArgs, error) {
+	args := graphqlArgs{}
+	for name, v := range raw {
+		value, err := ex.resolveValue(v)
+		if err != nil {
+			return nil, err
+		}
+		args[name] = value
+	}
+	return args, nil
+}
+
+func (ex *graphqlExecutor) resolveValue(v any) (any, error) {
+	switch v := v.(type) {
+	case graphqlVariable:
+		value, ok := ex.variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s isn't declared", v)
+		}
+		return value, nil
+	case []any:
+		list := make([]any, len(v))
+		for i := range v {
+			var err error
+			if list[i], err = ex.resolveValue(v[i]); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]any:
+		obj := map[string]any{}
+		for k := range v {
+			var err error
+			if obj[k], err = ex.resolveValue(v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	}
+	return v, nil
+}
+
+// graphqlResult is an object in the response, which keeps its fields in the order they were asked for
+type graphqlResult struct {
+	keys   []string
+	values map[string]any
+}
+
+func (r *graphqlResult) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// graphqlArgs are a field's arguments with variables filled in
+type graphqlArgs map[string]any
+
+func (a graphqlArgs) String(name, def string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case string:
+		return v, nil
+	case graphqlEnum:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("%s must be a String", name)
+}
+
+func (a graphqlArgs) Int(name string, def int) (int, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64: // From JSON variables
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("%s must be an Int", name)
+}
+
+func (a graphqlArgs) Bool(name string, def bool) (bool, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case bool:
+		return v, nil
+	}
+	return false, fmt.Errorf("%s must be a Boolean", name)
+}
+
+func unknownField(obj graphqlObject, name string) error {
+	return fmt.Errorf("%s has no field %q", obj.typeName(), name)
+}
+
+func (ex *graphqlExecutor) library() ([]libraryFile, error) {
+	if ex.files == nil {
+		files, err := scanLibrary()
+		if err != nil {
+			return nil, err
+		}
+		ex.files, ex.byPath = files, make(map[string]libraryFile, len(files))
+		for _, f := range files {
+			ex.byPath[f.Path] = f
+		}
+	}
+	return ex.files, nil
+}
+
+func (ex *graphqlExecutor) allStats() map[string]TrackStats {
+	if ex.stats == nil {
+		ex.stats = stateFor(ex.r).stats.Snapshot()
+	}
+	return ex.stats
+}
+
+// track looks a path up in the library; tracks that have gone missing keep just their path
+func (ex *graphqlExecutor) track(p string) (*graphqlFile, error) {
+	if _, err := ex.library(); err != nil {
+		return nil, err
+	}
+	f, ok := ex.byPath[p]
+	if !ok {
+		f = libraryFile{AudioFile: AudioFile{Name: path.Base(p), Path: p, Folder: path.Base(libraryDir(p)), Dir: libraryDir(p)}}
+	}
+	return &graphqlFile{ruleTrack{file: f, stats: ex.allStats()[p]}}, nil
+}
+
+func (ex *graphqlExecutor) tracks(paths []string) ([]*graphqlFile, error) {
+	list := make([]*graphqlFile, len(paths))
+	for i, p := range paths {
+		var err error
+		if list[i], err = ex.track(p); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// queryFiles filters, sorts and pages the library for files and Folder.tracks
+func (ex *graphqlExecutor) queryFiles(args graphqlArgs, dir *string, recursive bool) (any, error) {
+	filter, err := args.String("filter", "")
+	if err != nil {
+		return nil, err
+	}
+	search, err := args.String("search", "")
+	if err != nil {
+		return nil, err
+	}
+	fuzzy, err := args.Bool("fuzzy", false)
+	if err != nil {
+		return nil, err
+	}
+	sortBy, err := args.String("sort", "")
+	if err != nil {
+		return nil, err
+	}
+	first, err := args.Int("first", 200)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := args.Int("offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	if first < 0 || first > 1000 || offset < 0 {
+		return nil, errors.New("first must be 0-1000 and offset can't be negative")
+	}
+	if recursive, err = args.Bool("recursive", recursive); err != nil {
+		return nil, err
+	}
+	if dir == nil {
+		if d, err := args.String("dir", ""); err != nil {
+			return nil, err
+		} else if args["dir"] != nil {
+			dir = &d
+		}
+	}
+
+	files, err := ex.library()
+	if err != nil {
+		return nil, err
+	}
+	q := trackQuery{Search: search, Fuzzy: fuzzy, Filter: filter, Sort: sortBy, Dir: dir, Recursive: recursive}
+	found, err := q.run(files, ex.allStats())
+	if err != nil {
+		return nil, err
+	}
+
+	list := &graphqlFileList{total: len(found), items: []*graphqlFile{}}
+	start := min(offset, len(found))
+	end := min(start+first, len(found))
+	for _, t := range found[start:end] {
+		list.items = append(list.items, &graphqlFile{*t})
+	}
+	return list, nil
+}
+
+type graphqlQuery struct{}
+
+func (graphqlQuery) typeName() string { return "Query" }
+
+func (q graphqlQuery) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "files":
+		return ex.queryFiles(args, nil, true)
+	case "file":
+		p, err := args.String("path", "")
+		if err != nil || p == "" {
+			return nil, errors.New("path is required")
+		}
+		if _, err := ex.library(); err != nil {
+			return nil, err
+		}
+		if _, ok := ex.byPath[p]; !ok {
+			return nil, nil
+		}
+		return ex.track(p)
+	case "folder":
+		p, err := args.String("path", "")
+		if err != nil {
+			return nil, err
+		}
+		if ex.tree == nil {
+			files, err := ex.library()
+			if err != nil {
+				return nil, err
+			}
+			ex.tree = buildFolderTree(files)
+		}
+		node := ex.tree.find(p)
+		if node == nil {
+			return nil, nil
+		}
+		return (*graphqlFolder)(node), nil
+	case "tags":
+		return ex.tags(args)
+	case "playlists":
+		list := stateFor(ex.r).playlists.List()
+		if err := materializePlaylists(list, stateFor(ex.r).stats); err != nil {
+			return nil, err
+		}
+		objs := make([]*graphqlPlaylist, len(list))
+		for i := range list {
+			objs[i] = (*graphqlPlaylist)(&list[i])
+		}
+		return objs, nil
+	case "playlist":
+		id, err := args.String("id", "")
+		if err != nil {
+			return nil, err
+		}
+		p, err := stateFor(ex.r).playlists.Get(id)
+		if errors.Is(err, errPlaylistNotFound) {
+			return nil, nil
+		}
+		if err == nil {
+			err = materializePlaylist(&p, stateFor(ex.r).stats)
+		}
+		return (*graphqlPlaylist)(&p), err
+	case "stats":
+		return graphqlStats{}, nil
+	}
+	return nil, unknownField(q, name)
+}
+
+type graphqlTag struct {
+	value string
+	count int
+}
+
+func (graphqlTag) typeName() string { return "Tag" }
+
+func (t graphqlTag) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "value":
+		return t.value, nil
+	case "count":
+		return t.count, nil
+	}
+	return nil, unknownField(t, name)
+}
+
+// tags counts the distinct values of a field across the library, most common first
+func (ex *graphqlExecutor) tags(args graphqlArgs) (any, error) {
+	field, err := args.String("field", "")
+	if err != nil {
+		return nil, err
+	}
+	first, err := args.Int("first", 0)
+	if err != nil {
+		return nil, err
+	}
+	field = strings.ToLower(field)
+	if alias, ok := fieldAliases[field]; ok {
+		field = alias
+	}
+	var get func(t *ruleTrack) string
+	if s, ok := stringFields[field]; ok {
+		get = s
+	} else if n, ok := numberFields[field]; ok {
+		get = func(t *ruleTrack) string {
+			if v, known := n(t); known {
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+			return ""
+		}
+	} else {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+
+	files, err := ex.library()
+	if err != nil {
+		return nil, err
+	}
+	stats := ex.allStats()
+	counts := map[string]int{}
+	for _, f := range files {
+		if v := get(&ruleTrack{file: f, stats: stats[f.Path]}); v != "" {
+			counts[v]++
+		}
+	}
+	tags := make([]graphqlTag, 0, len(counts))
+	for v, n := range counts {
+		tags = append(tags, graphqlTag{v, n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].count != tags[j].count {
+			return tags[i].count > tags[j].count
+		}
+		return strings.ToLower(tags[i].value) < strings.ToLower(tags[j].value)
+	})
+	if first > 0 && len(tags) > first {
+		tags = tags[:first]
+	}
+	return tags, nil
+}
+
+type graphqlFileList struct {
+	total int
+	items []*graphqlFile
+}
+
+func (*graphqlFileList) typeName() string { return "FileList" }
+
+func (l *graphqlFileList) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "total":
+		return l.total, nil
+	case "items":
+		return l.items, nil
+	}
+	return nil, unknownField(l, name)
+}
+
+// graphqlFile reads tags only when a query asks for one
+type graphqlFile struct {
+	ruleTrack
+}
+
+func (*graphqlFile) typeName() string { return "File" }
+
+// optional leaves unknown tags out rather than reporting zeros
+func optional[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+func (f *graphqlFile) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "name":
+		return f.file.Name, nil
+	case "path":
+		return f.file.Path, nil
+	case "folder":
+		return f.file.Folder, nil
+	case "dir":
+		return f.file.Dir, nil
+	case "breadcrumbs":
+		crumbs := folderCrumbs(f.file.Dir)
+		list := make([]graphqlBreadcrumb, len(crumbs))
+		for i, c := range crumbs {
+			list[i] = graphqlBreadcrumb(c)
+		}
+		return list, nil
+	case "size":
+		return f.file.Size, nil
+	case "modified":
+		return f.file.ModTime, nil
+	case "title":
+		return optional(f.tags().Title), nil
+	case "artist":
+		return optional(f.tags().Artist), nil
+	case "album":
+		return optional(f.tags().Album), nil
+	case "genre":
+		return optional(f.tags().Genre), nil
+	case "key":
+		return optional(f.tags().Key), nil
+	case "bpm":
+		return optional(f.tags().BPM), nil
+	case "track":
+		return optional(f.tags().Track), nil
+	case "year":
+		return optional(f.tags().Year), nil
+	case "duration":
+		return optional(f.tags().Duration), nil
+	case "rating":
+		return f.stats.Rating, nil
+	case "playCount":
+		return f.stats.PlayCount, nil
+	case "lastPlayed":
+		return f.stats.LastPlayed, nil
+	case "favorite":
+		return f.stats.Favorite, nil
+	case "position":
+		return f.stats.Position, nil
+	}
+	return nil, unknownField(f, name)
+}
+
+type graphqlBreadcrumb Breadcrumb
+
+func (graphqlBreadcrumb) typeName() string { return "Breadcrumb" }
+
+func (b graphqlBreadcrumb) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "name":
+		return b.Name, nil
+	case "path":
+		return b.Path, nil
+	}
+	return nil, unknownField(b, name)
+}
+
+type graphqlFolder FolderNode
+
+func (*graphqlFolder) typeName() string { return "Folder" }
+
+func (f *graphqlFolder) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "name":
+		return f.Name, nil
+	case "path":
+		return f.Path, nil
+	case "files":
+		return f.Files, nil
+	case "total":
+		return f.Total, nil
+	case "breadcrumbs":
+		crumbs := breadcrumbs(f.Path)
+		list := make([]graphqlBreadcrumb, len(crumbs))
+		for i, c := range crumbs {
+			list[i] = graphqlBreadcrumb(c)
+		}
+		return list, nil
+	case "folders":
+		list := make([]*graphqlFolder, len(f.Folders))
+		for i, sub := range f.Folders {
+			list[i] = (*graphqlFolder)(sub)
+		}
+		return list, nil
+	case "tracks":
+		return ex.queryFiles(args, &f.Path, false)
+	}
+	return nil, unknownField(f, name)
+}
+
+type graphqlPlaylist Playlist
+
+func (*graphqlPlaylist) typeName() string { return "Playlist" }
+
+func (p *graphqlPlaylist) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "id":
+		return p.ID, nil
+	case "name":
+		return p.Name, nil
+	case "source":
+		return optional(p.Source), nil
+	case "rules":
+		return optional(p.Rules), nil
+	case "auto":
+		return optional(p.Auto), nil
+	case "sort":
+		return optional(p.Sort), nil
+	case "limit":
+		return optional(p.Limit), nil
+	case "smart":
+		return p.Rules != "", nil
+	case "created":
+		return p.Created, nil
+	case "updated":
+		return p.Updated, nil
+	case "trackCount":
+		return len(p.Tracks), nil
+	case "tracks":
+		first, err := args.Int("first", len(p.Tracks))
+		if err != nil {
+			return nil, err
+		}
+		offset, err := args.Int("offset", 0)
+		if err != nil {
+			return nil, err
+		}
+		if first < 0 || offset < 0 {
+			return nil, errors.New("first and offset can't be negative")
+		}
+		start := min(offset, len(p.Tracks))
+		end := min(start+first, len(p.Tracks))
+		return ex.tracks(p.Tracks[start:end])
+	}
+	return nil, unknownField(p, name)
+}
+
+type graphqlStats struct{}
+
+func (graphqlStats) typeName() string { return "Stats" }
+
+func (s graphqlStats) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "recentPlays":
+		first, err := args.Int("first", 20)
+		if err != nil {
+			return nil, err
+		}
+		var plays []graphqlPlay
+		for _, e := range stateFor(ex.r).stats.RecentPlays(first) {
+			f, err := ex.track(e.Path)
+			if err != nil {
+				return nil, err
+			}
+			plays = append(plays, graphqlPlay{time: e.Time, file: f})
+		}
+		return plays, nil
+	case "mostPlayed":
+		first, err := args.Int("first", 20)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for p, t := range ex.allStats() {
+			if t.PlayCount > 0 {
+				paths = append(paths, p)
+			}
+		}
+		stats := ex.allStats()
+		sort.Slice(paths, func(i, j int) bool {
+			if stats[paths[i]].PlayCount != stats[paths[j]].PlayCount {
+				return stats[paths[i]].PlayCount > stats[paths[j]].PlayCount
+			}
+			return paths[i] < paths[j]
+		})
+		if first >= 0 && len(paths) > first {
+			paths = paths[:first]
+		}
+		return ex.tracks(paths)
+	case "favorites":
+		return ex.tracks(stateFor(ex.r).stats.Favorites())
+	}
+	return nil, unknownField(s, name)
+}
+
+type graphqlPlay struct {
+	time time.Time
+	file *graphqlFile
+}
+
+func (graphqlPlay) typeName() string { return "Play" }
+
+func (p graphqlPlay) field(ex *graphqlExecutor, name string, args graphqlArgs) (any, error) {
+	switch name {
+	case "time":
+		return p.time, nil
+	case "file":
+		return p.file, nil
+	}
+	return nil, unknownField(p, name)
+}
@@ -0,0 +1,399 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=module=beatgraze --go-grpc_out=. --go-grpc_opt=module=beatgraze proto/beatgraze.proto
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "beatgraze/proto/beatgrazepb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the gRPC API through the main handler, so calls go through the same
+// auth, access lists, rate limits and logging as the HTTP API
+var grpcServer = grpc.NewServer()
+
+// grpcReads are the RPCs that only fetch things; everything else needs listener access
+var grpcReads = map[string]bool{
+	"ListTracks":      true,
+	"GetTrack":        true,
+	"GetFolderTree":   true,
+	"Scan":            true,
+	"ListPlaylists":   true,
+	"GetPlaylist":     true,
+	"WatchNowPlaying": true,
+}
+
+// registerGRPCRoutes gives every RPC its own route, so audit entries and role checks work per call
+func registerGRPCRoutes() {
+	pb.RegisterLibraryServer(grpcServer, libraryService{})
+	pb.RegisterPlaylistsServer(grpcServer, playlistService{})
+	pb.RegisterPlaybackServer(grpcServer, playbackService{})
+	for service, info := range grpcServer.GetServiceInfo() {
+		for _, m := range info.Methods {
+			route := "POST /" + service + "/" + m.Name
+			if grpcReads[m.Name] {
+				queryRoutes[route] = true
+				handleFunc(route, serveGRPC)
+			} else {
+				handleFunc(route, requireRole(roleListener, serveGRPC))
+			}
+		}
+	}
+}
+
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "This is a gRPC endpoint", http.StatusUnsupportedMediaType)
+		return
+	}
+	// Auth and access checks are done, and streaming calls can stay open indefinitely
+	releaseSettings(r)
+	ctx := context.WithValue(r.Context(), grpcRequestContextKey, r)
+	grpcServer.ServeHTTP(flushWriter{w}, r.WithContext(ctx))
+}
+
+// flushWriter finds the connection's Flusher beneath the middleware wrappers, which gRPC needs
+// to send each streamed message as it's ready
+type flushWriter struct {
+	http.ResponseWriter
+}
+
+func (w flushWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// grpcRequest is the HTTP request an RPC arrived on, which carries the signed-in user
+func grpcRequest(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(grpcRequestContextKey).(*http.Request)
+	return r
+}
+
+// grpcError maps the errors stores return onto gRPC status codes
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errPlaylistNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errSmartPlaylist), errors.Is(err, errGeneratedPlaylist):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func trackProto(t *ruleTrack, withTags bool) *pb.Track {
+	track := &pb.Track{
+		Name:     t.file.Name,
+		Path:     t.file.Path,
+		Folder:   t.file.Folder,
+		Dir:      t.file.Dir,
+		Size:     t.file.Size,
+		Modified: timestamp(t.file.ModTime),
+		Stats:    statsProto(t.stats),
+	}
+	if withTags {
+		meta := t.tags()
+		track.Tags = &pb.TrackTags{
+			Title:    meta.Title,
+			Artist:   meta.Artist,
+			Album:    meta.Album,
+			Genre:    meta.Genre,
+			Key:      meta.Key,
+			Bpm:      meta.BPM,
+			Track:    int32(meta.Track),
+			Year:     int32(meta.Year),
+			Duration: meta.Duration,
+		}
+	}
+	return track
+}
+
+func statsProto(s TrackStats) *pb.TrackStats {
+	return &pb.TrackStats{
+		Rating:     int32(s.Rating),
+		PlayCount:  int32(s.PlayCount),
+		LastPlayed: timestamp(s.LastPlayed),
+		Favorite:   s.Favorite,
+		Position:   s.Position,
+	}
+}
+
+func playlistProto(p Playlist) *pb.Playlist {
+	return &pb.Playlist{
+		Id:      p.ID,
+		Name:    p.Name,
+		Tracks:  p.Tracks,
+		Source:  p.Source,
+		Rules:   p.Rules,
+		Auto:    p.Auto,
+		Sort:    p.Sort,
+		Limit:   int32(p.Limit),
+		Created: timestamp(p.Created),
+		Updated: timestamp(p.Updated),
+	}
+}
+
+func folderProto(n *FolderNode) *pb.Folder {
+	f := &pb.Folder{Name: n.Name, Path: n.Path, Files: int32(n.Files), Total: int32(n.Total)}
+	for _, sub := range n.Folders {
+		f.Folders = append(f.Folders, folderProto(sub))
+	}
+	return f
+}
+
+// libraryTrack looks up a single track, with the caller's stats
+func libraryTrack(r *http.Request, relPath string) (*ruleTrack, error) {
+	f, err := libraryFileAt(relPath)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &ruleTrack{file: f, stats: stateFor(r).stats.Get(f.Path)}, nil
+}
+
+type libraryService struct {
+	pb.UnimplementedLibraryServer
+}
+
+func (libraryService) ListTracks(ctx context.Context, req *pb.ListTracksRequest) (*pb.ListTracksResponse, error) {
+	r := grpcRequest(ctx)
+	pageSize := int(req.PageSize)
+	if pageSize == 0 {
+		pageSize = 200
+	}
+	if pageSize < 0 || pageSize > 1000 || req.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must be 1-1000 and offset can't be negative")
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	q := trackQuery{Search: req.Search, Filter: req.Filter, Sort: req.Sort, Recursive: !req.Shallow}
+	if req.Dir != "" || req.Shallow {
+		q.Dir = &req.Dir
+	}
+	found, err := q.run(files, stateFor(r).stats.Snapshot())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	start := min(int(req.Offset), len(found))
+	end := min(start+pageSize, len(found))
+	resp := &pb.ListTracksResponse{Total: int32(len(found)), Tracks: make([]*pb.Track, 0, end-start)}
+	for _, t := range found[start:end] {
+		resp.Tracks = append(resp.Tracks, trackProto(t, req.IncludeTags))
+	}
+	return resp, nil
+}
+
+func (libraryService) GetTrack(ctx context.Context, req *pb.GetTrackRequest) (*pb.Track, error) {
+	t, err := libraryTrack(grpcRequest(ctx), req.Path)
+	if err != nil {
+		return nil, err
+	}
+	return trackProto(t, true), nil
+}
+
+func (libraryService) GetFolderTree(ctx context.Context, req *pb.GetFolderTreeRequest) (*pb.Folder, error) {
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	node := buildFolderTree(files).find(req.Path)
+	if node == nil {
+		return nil, status.Error(codes.NotFound, "folder not found")
+	}
+	return folderProto(node), nil
+}
+
+// scanProgressInterval spaces out progress messages on big libraries
+const scanProgressInterval = 250 * time.Millisecond
+
+func (libraryService) Scan(req *pb.ScanRequest, stream grpc.ServerStreamingServer[pb.ScanProgress]) error {
+	files, err := scanLibrary()
+	if err != nil {
+		return grpcError(err)
+	}
+	total := int32(len(files))
+	var sent time.Time
+	for i, f := range files {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		trackMeta(f)
+		if time.Since(sent) >= scanProgressInterval {
+			if err := stream.Send(&pb.ScanProgress{Scanned: int32(i + 1), Total: total, Path: f.Path}); err != nil {
+				return err
+			}
+			sent = time.Now()
+		}
+	}
+	return stream.Send(&pb.ScanProgress{Scanned: total, Total: total, Done: true})
+}
+
+type playlistService struct {
+	pb.UnimplementedPlaylistsServer
+}
+
+func (playlistService) ListPlaylists(ctx context.Context, req *pb.ListPlaylistsRequest) (*pb.ListPlaylistsResponse, error) {
+	r := grpcRequest(ctx)
+	list := stateFor(r).playlists.List()
+	if err := materializePlaylists(list, stateFor(r).stats); err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.ListPlaylistsResponse{}
+	for _, p := range list {
+		resp.Playlists = append(resp.Playlists, playlistProto(p))
+	}
+	return resp, nil
+}
+
+func (playlistService) GetPlaylist(ctx context.Context, req *pb.GetPlaylistRequest) (*pb.Playlist, error) {
+	r := grpcRequest(ctx)
+	p, err := stateFor(r).playlists.Get(req.Id)
+	if err == nil {
+		err = materializePlaylist(&p, stateFor(r).stats)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return playlistProto(p), nil
+}
+
+func (playlistService) CreatePlaylist(ctx context.Context, req *pb.CreatePlaylistRequest) (*pb.Playlist, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Playlist name is required")
+	}
+	tracks, err := validateTracks(req.Tracks)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	p, err := stateFor(grpcRequest(ctx)).playlists.Create(name, tracks)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return playlistProto(p), nil
+}
+
+func (playlistService) AddTracks(ctx context.Context, req *pb.AddTracksRequest) (*pb.Playlist, error) {
+	tracks, err := validateTracks(req.Tracks)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	p, err := stateFor(grpcRequest(ctx)).playlists.Update(req.Id, func(p *Playlist) error {
+		if err := p.trackEditError(); err != nil {
+			return err
+		}
+		p.Tracks = append(p.Tracks, tracks...)
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return playlistProto(p), nil
+}
+
+func (playlistService) DeletePlaylist(ctx context.Context, req *pb.DeletePlaylistRequest) (*pb.DeletePlaylistResponse, error) {
+	if err := stateFor(grpcRequest(ctx)).playlists.Delete(req.Id); err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.DeletePlaylistResponse{}, nil
+}
+
+type playbackService struct {
+	pb.UnimplementedPlaybackServer
+}
+
+// updateStats validates path and applies update to the caller's stats for it
+func updateStats(ctx context.Context, path string, update func(s *StatsStore, track string) error) (*pb.TrackStats, error) {
+	track, err := validateTrack(path)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	stats := stateFor(grpcRequest(ctx)).stats
+	if err := update(stats, track); err != nil {
+		return nil, grpcError(err)
+	}
+	return statsProto(stats.Get(track)), nil
+}
+
+func (playbackService) RecordPlay(ctx context.Context, req *pb.RecordPlayRequest) (*pb.TrackStats, error) {
+	r := grpcRequest(ctx)
+	return updateStats(ctx, req.Path, func(s *StatsStore, track string) error {
+		if err := s.RecordPlay(track); err != nil {
+			return err
+		}
+		nowPlaying.Publish(NowPlaying{User: requestUserName(r), Path: track, Time: s.Get(track).LastPlayed})
+		if userID, client, ok := scrobbleClient(r); ok {
+			scrobbleStarted(userID, client, track)
+		}
+		return nil
+	})
+}
+
+func (playbackService) SetPosition(ctx context.Context, req *pb.SetPositionRequest) (*pb.TrackStats, error) {
+	if req.Position < 0 || math.IsNaN(req.Position) || math.IsInf(req.Position, 0) {
+		return nil, status.Error(codes.InvalidArgument, "Position must be a number of seconds")
+	}
+	return updateStats(ctx, req.Path, func(s *StatsStore, track string) error {
+		return s.SetPosition(track, req.Position)
+	})
+}
+
+func (playbackService) SetRating(ctx context.Context, req *pb.SetRatingRequest) (*pb.TrackStats, error) {
+	if req.Rating < 0 || req.Rating > 5 {
+		return nil, status.Error(codes.InvalidArgument, "Rating must be between 0 and 5")
+	}
+	return updateStats(ctx, req.Path, func(s *StatsStore, track string) error {
+		return s.SetRating(track, int(req.Rating))
+	})
+}
+
+func (playbackService) SetFavorite(ctx context.Context, req *pb.SetFavoriteRequest) (*pb.TrackStats, error) {
+	return updateStats(ctx, req.Path, func(s *StatsStore, track string) error {
+		return s.SetFavorite(track, req.Favorite)
+	})
+}
+
+func (playbackService) WatchNowPlaying(req *pb.WatchNowPlayingRequest, stream grpc.ServerStreamingServer[pb.NowPlaying]) error {
+	r := grpcRequest(stream.Context())
+	everyone := hasRole(r, roleAdmin)
+	me := requestUserName(r)
+	plays, stop := nowPlaying.Watch()
+	defer stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-shuttingDown.Done():
+			return status.Error(codes.Unavailable, "the server is shutting down")
+		case p := <-plays:
+			if !everyone && p.User != me {
+				continue
+			}
+			msg := &pb.NowPlaying{User: p.User, Time: timestamp(p.Time)}
+			if t, err := libraryTrack(r, p.Path); err == nil {
+				msg.Track = trackProto(t, true)
+			} else {
+				msg.Track = &pb.Track{Name: audioFileFromPath(p.Path).Name, Path: p.Path}
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The bundled page keeps its script and styles inline, so those need 'unsafe-inline';
+// everything else, including audio, must come from this server.
+const contentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob:; media-src 'self' blob:; connect-src 'self'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'"
+
+var corsOrigins string
+
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		// Share links carry their token in the URL, so never leak it to other sites
+		h.Set("Referrer-Policy", "same-origin")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowFraming lets other sites put a page in an iframe, for pages meant to be embedded
+func allowFraming(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("Content-Security-Policy", strings.Replace(contentSecurityPolicy, "frame-ancestors 'none'", "frame-ancestors *", 1))
+	h.Del("X-Frame-Options")
+}
+
+// allowedOrigin returns the value for Access-Control-Allow-Origin, if origin may call the API
+func allowedOrigin(origin string) (string, bool) {
+	if origin == "" || corsOrigins == "" {
+		return "", false
+	}
+	for _, allowed := range strings.Split(corsOrigins, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// withCORS lets the -cors-origin frontends call the API. Named origins may send cookies;
+// a "*" origin may not, so those clients authenticate with API keys instead.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		allow, ok := allowedOrigin(r.Header.Get("Origin"))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", allow)
+		if allow != "*" {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		h.Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition, Retry-After, "+
+			"Location, Upload-Offset, Upload-Length, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, X-Request-ID")
+
+		// Preflights carry no credentials, so answer them before auth gets a look
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Range, "+csrfHeader+
+				", Upload-Length, Upload-Offset, Upload-Metadata, Tus-Resumable, X-Request-ID")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
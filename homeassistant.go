@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The /api/ha endpoints answer in the shape Home Assistant's media source integrations
+// build their BrowseMedia and PlayMedia from, so a small custom integration can pass them
+// straight through. IDs are stable for as long as files stay where they are:
+//
+//	""                  the top level
+//	folder:<dir>        a library folder, folder: for the library root
+//	track:<path>        a track
+//	playlists           the user's playlists
+//	playlist:<id>       one playlist
+//	recent              recently added tracks
+
+// haMediaLifetime is how long a resolved URL lasts at least; media players fetch straight
+// away, but may come back to seek
+const haMediaLifetime = 6 * time.Hour
+
+var errHAMediaNotFound = errors.New("no such media")
+
+// HAMedia is one node of Home Assistant's media browser, with its field names
+type HAMedia struct {
+	Title              string     `json:"title"`
+	MediaClass         string     `json:"media_class"`
+	MediaContentType   string     `json:"media_content_type"`
+	MediaContentID     string     `json:"media_content_id"`
+	CanPlay            bool       `json:"can_play"`
+	CanExpand          bool       `json:"can_expand"`
+	Thumbnail          string     `json:"thumbnail,omitempty"`
+	ChildrenMediaClass string     `json:"children_media_class,omitempty"`
+	Children           []*HAMedia `json:"children,omitempty"`
+}
+
+func registerHomeAssistantRoutes() {
+	handleFunc("GET /api/ha/browse", requireRole(roleListener, haBrowse))
+	handleFunc("GET /api/ha/resolve", requireRole(roleListener, haResolve))
+	handleFunc("GET /ha/media/{token}/{path...}", haServeMedia)
+}
+
+// haBrowse returns ?id= with its children
+func haBrowse(w http.ResponseWriter, r *http.Request) {
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	var media *HAMedia
+	switch {
+	case id == "":
+		media = &HAMedia{Title: libraryName(), MediaClass: "directory", MediaContentType: "library", CanExpand: true,
+			ChildrenMediaClass: "directory", Children: []*HAMedia{
+				{Title: "Folders", MediaClass: "directory", MediaContentType: "library", MediaContentID: "folder:", CanExpand: true},
+				{Title: "Playlists", MediaClass: "directory", MediaContentType: "playlists", MediaContentID: "playlists", CanExpand: true},
+				{Title: "Recently Added", MediaClass: "directory", MediaContentType: "library", MediaContentID: "recent", CanExpand: true},
+			}}
+	case strings.HasPrefix(id, "folder:"):
+		dir := strings.TrimPrefix(id, "folder:")
+		node := buildFolderTree(files).find(dir)
+		if node == nil {
+			http.Error(w, errHAMediaNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		media = haFolder(r, node)
+		for _, sub := range node.Folders {
+			media.Children = append(media.Children, haFolder(r, sub))
+		}
+		for _, f := range files {
+			if f.Dir == node.Path {
+				media.Children = append(media.Children, haTrack(r, f))
+			}
+		}
+		media.ChildrenMediaClass = haChildrenClass(media.Children)
+	case strings.HasPrefix(id, "track:"):
+		f, err := libraryFileAt(strings.TrimPrefix(id, "track:"))
+		if err != nil {
+			http.Error(w, errHAMediaNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		media = haTrack(r, f)
+	case id == "playlists":
+		media = &HAMedia{Title: "Playlists", MediaClass: "directory", MediaContentType: "playlists", MediaContentID: id,
+			CanExpand: true, ChildrenMediaClass: "playlist"}
+		for _, p := range stateFor(r).playlists.List() {
+			media.Children = append(media.Children, &HAMedia{Title: p.Name, MediaClass: "playlist", MediaContentType: "playlist",
+				MediaContentID: "playlist:" + p.ID, CanExpand: true})
+		}
+	case strings.HasPrefix(id, "playlist:"):
+		p, err := stateFor(r).playlists.Get(strings.TrimPrefix(id, "playlist:"))
+		if err == nil {
+			err = materializePlaylist(&p, stateFor(r).stats)
+		}
+		if errors.Is(err, errPlaylistNotFound) {
+			http.Error(w, errHAMediaNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		media = &HAMedia{Title: p.Name, MediaClass: "playlist", MediaContentType: "playlist", MediaContentID: id,
+			CanExpand: true, ChildrenMediaClass: "track"}
+		for _, track := range p.Tracks {
+			if f, err := libraryFileAt(track); err == nil {
+				media.Children = append(media.Children, haTrack(r, f))
+			}
+		}
+	case id == "recent":
+		media = &HAMedia{Title: "Recently Added", MediaClass: "directory", MediaContentType: "library", MediaContentID: id,
+			CanExpand: true, ChildrenMediaClass: "track"}
+		byPath := make(map[string]libraryFile, len(files))
+		for _, f := range files {
+			byPath[f.Path] = f
+		}
+		for i, item := range recentlyAdded(nil, files, collectionSince(r)) {
+			if i == defaultFeedItems {
+				break
+			}
+			media.Children = append(media.Children, haTrack(r, byPath[item.Path]))
+		}
+	default:
+		http.Error(w, errHAMediaNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, media)
+}
+
+func haFolder(r *http.Request, node *FolderNode) *HAMedia {
+	m := &HAMedia{Title: node.Name, MediaClass: "directory", MediaContentType: "library",
+		MediaContentID: "folder:" + node.Path, CanExpand: true}
+	if node.Path == "" {
+		m.Title = libraryName()
+	}
+	if cover, ok := folderCover(node.Path); ok {
+		m.Thumbnail = haMediaURL(r, cover)
+	}
+	return m
+}
+
+func haTrack(r *http.Request, f libraryFile) *HAMedia {
+	meta := trackMeta(f)
+	title := firstNonEmpty(meta.Title, trackTitle(f.Path))
+	if meta.Artist != "" {
+		title = meta.Artist + " - " + title
+	}
+	m := &HAMedia{Title: title, MediaClass: "track", MediaContentType: dlnaMime(f.Path),
+		MediaContentID: "track:" + f.Path, CanPlay: true}
+	if cover, ok := folderCover(f.Dir); ok {
+		m.Thumbnail = haMediaURL(r, cover)
+	}
+	return m
+}
+
+// haChildrenClass is the class all children share, which Home Assistant lays them out by
+func haChildrenClass(children []*HAMedia) string {
+	class := ""
+	for _, c := range children {
+		if class != "" && c.MediaClass != class {
+			return "directory"
+		}
+		class = c.MediaClass
+	}
+	return class
+}
+
+// haResolve turns a track's ID into a URL any media player can fetch without signing in
+func haResolve(w http.ResponseWriter, r *http.Request) {
+	relPath, ok := strings.CutPrefix(r.URL.Query().Get("id"), "track:")
+	if !ok {
+		http.Error(w, "Only tracks can be played", http.StatusBadRequest)
+		return
+	}
+	f, err := libraryFileAt(relPath)
+	if err != nil {
+		http.Error(w, errHAMediaNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"url": haMediaURL(r, f.Path), "mime_type": dlnaMime(f.Path)})
+}
+
+// haMediaURL signs a library file's URL so it works without credentials until it expires.
+// Expiry is rounded up, so a thumbnail keeps the same URL for a while and can be cached.
+func haMediaURL(r *http.Request, relPath string) string {
+	lifetime := int64(haMediaLifetime / time.Second)
+	expires := strconv.FormatInt((time.Now().Unix()/lifetime+2)*lifetime, 36)
+	return externalURL(r, "/ha/media/"+expires+"."+shares.sign("ha:"+expires+":"+relPath)+"/"+relPath)
+}
+
+func haServeMedia(w http.ResponseWriter, r *http.Request) {
+	relPath := path.Clean(r.PathValue("path"))
+	expires, sig, ok := strings.Cut(r.PathValue("token"), ".")
+	unix, err := strconv.ParseInt(expires, 36, 64)
+	if !ok || err != nil || !secureCompare(sig, shares.sign("ha:"+expires+":"+relPath)) {
+		http.NotFound(w, r)
+		return
+	}
+	if time.Now().Unix() > unix {
+		http.Error(w, "This link has expired", http.StatusGone)
+		return
+	}
+	serveLibraryFile(w, r, relPath)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HookConfig holds the commands run when things happen, set with -hook-<event> or in the
+// config file's [hook] section. Each gets the event as JSON on stdin, the same payload a
+// webhook is sent, and its simple fields as BEATGRAZE_* environment variables.
+type HookConfig struct {
+	FilesAdded     string
+	FilesRemoved   string
+	ScanCompleted  string
+	TrackPlayed    string
+	UploadReceived string
+	Timeout        time.Duration
+}
+
+var hookConfig = HookConfig{Timeout: time.Minute}
+
+// maxHookOutput is how much of a failed hook's output is logged
+const maxHookOutput = 4 << 10
+
+// hookSlots limits how many hooks run at once, so a burst of events can't fork the server
+// to death; the rest wait their turn
+var hookSlots = make(chan struct{}, 4)
+
+// command is the hook for an event type, if there is one; settingsMu must be held
+func (c *HookConfig) command(eventType string) string {
+	switch eventType {
+	case "files.added":
+		return c.FilesAdded
+	case "files.removed":
+		return c.FilesRemoved
+	case "scan.completed":
+		return c.ScanCompleted
+	case "track.played":
+		return c.TrackPlayed
+	case "upload.received":
+		return c.UploadReceived
+	}
+	return ""
+}
+
+// dispatchHooks runs the hooks for library events and plays until shutdown
+func dispatchHooks() {
+	all, stopEvents := events.Watch()
+	defer stopEvents()
+	plays, stopPlays := nowPlaying.Watch()
+	defer stopPlays()
+	for {
+		select {
+		case <-shuttingDown.Done():
+			return
+		case e := <-all:
+			startHook(e.Type, e.Data)
+		case p := <-plays:
+			startHook("track.played", struct {
+				NowPlaying
+				File AudioFile `json:"file"`
+			}{p, audioFileFromPath(p.Path)})
+		}
+	}
+}
+
+func startHook(eventType string, data any) {
+	settingsMu.RLock()
+	command, timeout := strings.TrimSpace(hookConfig.command(eventType)), hookConfig.Timeout
+	settingsMu.RUnlock()
+	if command == "" {
+		return
+	}
+	body, err := webhookPayload(eventType, data)
+	if err != nil {
+		slog.Warn("Error encoding hook payload", "event", eventType, "err", err)
+		return
+	}
+	go func() {
+		hookSlots <- struct{}{}
+		defer func() { <-hookSlots }()
+		if err := runHook(command, eventType, body, timeout); err != nil {
+			slog.Warn("Hook failed", "event", eventType, "command", command, "err", err)
+		}
+	}()
+}
+
+// runHook runs command through the shell, so it can have arguments and pipes
+func runHook(command, eventType string, body []byte, timeout time.Duration) error {
+	ctx := shuttingDown
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), hookEnv(eventType, body)...)
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	start := time.Now()
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("still running after %v, stopped", timeout)
+	}
+	if err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			if len(out) > maxHookOutput {
+				out = out[:maxHookOutput] + "…"
+			}
+			err = fmt.Errorf("%v: %s", err, out)
+		}
+		return err
+	}
+	slog.Debug("Ran hook", "event", eventType, "duration", time.Since(start))
+	return nil
+}
+
+// hookEnv turns the payload's fields into variables: BEATGRAZE_EVENT and BEATGRAZE_TIME,
+// then each of the event's own text, number and true/false fields as BEATGRAZE_<FIELD>.
+// Lists of text, or of files, become one line per item, so BEATGRAZE_FILES is the paths
+// added and BEATGRAZE_PATHS those removed.
+func hookEnv(eventType string, body []byte) []string {
+	var payload struct {
+		Time string         `json:"time"`
+		Data map[string]any `json:"data"`
+	}
+	json.Unmarshal(body, &payload)
+	env := []string{"BEATGRAZE_EVENT=" + eventType, "BEATGRAZE_TIME=" + payload.Time}
+	keys := make([]string, 0, len(payload.Data))
+	for k := range payload.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value, ok := hookEnvValue(payload.Data[k])
+		if ok {
+			env = append(env, "BEATGRAZE_"+hookEnvName(k)+"="+value)
+		}
+	}
+	return env
+}
+
+func hookEnvValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case []any:
+		lines := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				lines = append(lines, item)
+			case map[string]any:
+				if path, ok := item["path"].(string); ok {
+					lines = append(lines, path)
+					continue
+				}
+				return "", false
+			default:
+				return "", false
+			}
+		}
+		return strings.Join(lines, "\n"), true
+	}
+	return "", false
+}
+
+// hookEnvName turns a JSON field like durationMs into DURATION_MS
+func hookEnvName(field string) string {
+	var b strings.Builder
+	for i, r := range field {
+		if r >= 'A' && r <= 'Z' && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ICY metadata is how SHOUTcast and Icecast tell players what's on: a client asks with
+// "Icy-MetaData: 1", and the server then puts a block naming the track after every
+// icy-metaint bytes of audio. Car stereos, VLC and internet radio apps show it.
+
+// icyMetaInt is how many bytes of audio go between metadata blocks, the usual SHOUTcast value
+const icyMetaInt = 16000
+
+// wantsICY reports whether the client asked for metadata in the stream
+func wantsICY(r *http.Request) bool {
+	return r.Header.Get("Icy-MetaData") == "1"
+}
+
+// icyTitle is a track as StreamTitle gives it, "Artist - Title" when it's tagged
+func icyTitle(f libraryFile) string {
+	meta := trackMeta(f)
+	title := meta.Title
+	if title == "" {
+		title = trackTitle(f.Path)
+	}
+	if meta.Artist != "" {
+		title = meta.Artist + " - " + title
+	}
+	return title
+}
+
+// icyWriter puts metadata blocks into an audio stream. A block with the title goes out when
+// it's changed, and an empty one otherwise.
+type icyWriter struct {
+	w     io.Writer
+	left  int // Bytes of audio until the next block
+	title string
+	sent  string
+	first bool
+}
+
+func newICYWriter(w io.Writer, title string) *icyWriter {
+	return &icyWriter{w: w, left: icyMetaInt, title: title, first: true}
+}
+
+func (iw *icyWriter) SetTitle(title string) {
+	iw.title = title
+}
+
+func (iw *icyWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if iw.left == 0 {
+			if _, err := iw.w.Write(iw.block()); err != nil {
+				return written, err
+			}
+			iw.left = icyMetaInt
+		}
+		n, err := iw.w.Write(b[:min(len(b), iw.left)])
+		written += n
+		iw.left -= n
+		b = b[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// block is a length byte counting 16-byte units, then StreamTitle='...'; padded with zeros
+func (iw *icyWriter) block() []byte {
+	if iw.title == iw.sent && !iw.first {
+		return []byte{0}
+	}
+	iw.sent, iw.first = iw.title, false
+	meta := "StreamTitle='" + icyEscape(iw.title) + "';"
+	units := (len(meta) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], meta)
+	return block
+}
+
+// icyEscape makes a title safe to quote: players read up to the first "';" with no way to
+// escape one, so quotes and semicolons become lookalikes and control characters go. It's cut
+// short on a character boundary to fit the 255 units the length byte can count.
+func icyEscape(title string) string {
+	title = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\'':
+			return '’'
+		case r == ';':
+			return ','
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(title, ""))
+	limit := 255*16 - len("StreamTitle='';")
+	if len(title) > limit {
+		for limit > 0 && !utf8.RuneStart(title[limit]) {
+			limit--
+		}
+		title = title[:limit]
+	}
+	return title
+}
+
+// setICYHeaders announces the metadata interval, and the stream's name when it has one
+func setICYHeaders(w http.ResponseWriter, name string) {
+	w.Header().Set("icy-metaint", strconv.Itoa(icyMetaInt))
+	if name != "" {
+		w.Header().Set("icy-name", name)
+	}
+}
+
+// serveICYFile sends an MP3 with metadata blocks in it. There's no seeking, since the blocks
+// would shift every offset, so it's only for requests without a Range.
+func serveICYFile(w http.ResponseWriter, r *http.Request, relPath string) {
+	f, err := library.Open(relPath)
+	if err != nil {
+		serveLibraryFile(w, r, relPath)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	file := libraryFile{AudioFile: audioFileFromPath(relPath), Size: info.Size(), ModTime: info.ModTime()}
+	noteStreamFile(r, file)
+	title := icyTitle(file)
+	w.Header().Set("Content-Type", "audio/mpeg")
+	setICYHeaders(w, title)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(newICYWriter(w, title), f)
+}
+
+// icyEligible is whether a direct stream can carry metadata: an MP3, read from the start
+func icyEligible(r *http.Request, relPath string) bool {
+	return wantsICY(r) && r.Header.Get("Range") == "" && strings.EqualFold(path.Ext(relPath), ".mp3")
+}
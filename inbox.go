@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// InboxConfig holds the flags for the folder new music is dropped into
+type InboxConfig struct {
+	Dir         string
+	MusicBrainz bool // Look up tracks whose tags don't say what they are
+}
+
+var inboxConfig InboxConfig
+
+// inboxInterval is how often the inbox is checked. A file is only imported once it's looked
+// the same on two checks, so one still being copied in is left alone.
+const inboxInterval = 10 * time.Second
+
+// inboxRejectedDir holds files dismissed from the review queue, inside the inbox
+const inboxRejectedDir = ".rejected"
+
+// InboxItem is a file in the inbox that couldn't be filed away without someone deciding where
+type InboxItem struct {
+	ID         string      `json:"id"`
+	Path       string      `json:"path"` // Inside the inbox
+	Reason     string      `json:"reason"`
+	Meta       TrackMeta   `json:"meta"` // Best guess so far
+	Candidates []TrackMeta `json:"candidates,omitempty"`
+	Added      time.Time   `json:"added"`
+}
+
+// InboxStore is the review queue
+type InboxStore struct {
+	mu    sync.Mutex
+	path  string
+	Items map[string]*InboxItem `json:"items"`
+}
+
+var inbox *InboxStore
+
+var errInboxItemNotFound = errors.New("not in the review queue")
+
+func loadInboxStore(path string) (*InboxStore, error) {
+	s := &InboxStore{path: path, Items: map[string]*InboxItem{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Items == nil {
+		s.Items = map[string]*InboxItem{}
+	}
+	return s, nil
+}
+
+func (s *InboxStore) List() []InboxItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]InboxItem, 0, len(s.Items))
+	for _, item := range s.Items {
+		list = append(list, *item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Added.Before(list[j].Added) })
+	return list
+}
+
+// queued reports whether a file is already waiting for review
+func (s *InboxStore) queued(p string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.Items {
+		if item.Path == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *InboxStore) Add(item InboxItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item.ID = newID()
+	item.Added = time.Now().UTC()
+	s.Items[item.ID] = &item
+	s.changed()
+	return saveJSON(s.path, s)
+}
+
+func (s *InboxStore) Get(id string) (InboxItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.Items[id]
+	if !ok {
+		return InboxItem{}, errInboxItemNotFound
+	}
+	return *item, nil
+}
+
+func (s *InboxStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Items, id)
+	s.changed()
+	return saveJSON(s.path, s)
+}
+
+// Prune drops items whose files have gone from the inbox
+func (s *InboxStore) Prune(present map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for id, item := range s.Items {
+		if !present[item.Path] {
+			delete(s.Items, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	s.changed()
+	return saveJSON(s.path, s)
+}
+
+// changed tells admins on /api/events how much is waiting for review; mu must be held
+func (s *InboxStore) changed() {
+	events.Publish(Event{Type: "inbox.changed", Data: map[string]int{"waiting": len(s.Items)}, visible: adminOnly})
+}
+
+// watchInbox files away new inbox arrivals until ctx is done
+func watchInbox(ctx context.Context) {
+	dir := newDirStorage(inboxConfig.Dir)
+	type seenFile struct {
+		size    int64
+		modTime time.Time
+	}
+	last := map[string]seenFile{}
+	ticker := time.NewTicker(inboxInterval)
+	defer ticker.Stop()
+	for {
+		current := map[string]seenFile{}
+		present := map[string]bool{}
+		dir.Walk(func(p string, info fs.FileInfo) error {
+			if strings.HasPrefix(p, inboxRejectedDir+"/") || !audioExts[strings.ToLower(path.Ext(p))] {
+				return nil
+			}
+			present[p] = true
+			current[p] = seenFile{info.Size(), info.ModTime()}
+			if prev, ok := last[p]; !ok || prev != current[p] || inbox.queued(p) {
+				return nil
+			}
+			if err := importInboxFile(ctx, p); err != nil {
+				slog.Error("Error importing from the inbox", "path", p, "err", err)
+			}
+			return nil
+		})
+		last = current
+		if err := inbox.Prune(present); err != nil {
+			slog.Error("Error saving the inbox review queue", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// importInboxFile works out what a file is and moves it into the library, or queues it for
+// review when that's not clear
+func importInboxFile(ctx context.Context, p string) error {
+	src := filepath.Join(inboxConfig.Dir, filepath.FromSlash(p))
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	meta, _ := parseTags(f, p)
+	f.Close()
+
+	// Fill gaps from a "Artist - Title" file name
+	if meta.Artist == "" || meta.Title == "" {
+		stem := strings.TrimSuffix(path.Base(p), path.Ext(p))
+		if artist, title, ok := strings.Cut(stem, " - "); ok {
+			meta.Artist = firstNonEmpty(meta.Artist, strings.TrimSpace(artist))
+			meta.Title = firstNonEmpty(meta.Title, strings.TrimSpace(title))
+		}
+	}
+
+	if meta.Artist == "" || meta.Title == "" || meta.Album == "" {
+		if !inboxConfig.MusicBrainz {
+			if meta.Artist == "" || meta.Title == "" {
+				return inbox.Add(InboxItem{Path: p, Reason: "no artist or title in its tags or name", Meta: meta})
+			}
+		} else {
+			candidates, err := lookupMusicBrainz(ctx, meta, path.Base(p))
+			if err != nil {
+				return err
+			}
+			match, ok := confidentMatch(candidates)
+			if !ok {
+				reason := "no confident MusicBrainz match"
+				if len(candidates) == 0 {
+					reason = "not found on MusicBrainz"
+				}
+				return inbox.Add(InboxItem{Path: p, Reason: reason, Meta: meta, Candidates: trackMetas(candidates)})
+			}
+			meta = mergeMeta(meta, match.meta)
+		}
+	}
+
+	err = fileInboxItem(p, meta)
+	if errors.Is(err, fs.ErrExist) {
+		return inbox.Add(InboxItem{Path: p, Reason: "a track with the same name is already in the library", Meta: meta})
+	}
+	return err
+}
+
+// mergeMeta keeps tags the file already has, taking the rest from a lookup
+func mergeMeta(have, found TrackMeta) TrackMeta {
+	have.Artist = firstNonEmpty(have.Artist, found.Artist)
+	have.Title = firstNonEmpty(have.Title, found.Title)
+	have.Album = firstNonEmpty(have.Album, found.Album)
+	if have.Track == 0 {
+		have.Track = found.Track
+	}
+	if have.Year == 0 {
+		have.Year = found.Year
+	}
+	return have
+}
+
+// fileInboxItem moves an inbox file to where the organize layout puts a track with meta
+func fileInboxItem(p string, meta TrackMeta) error {
+	storage, ok := writableLibrary()
+	if !ok {
+		return errLibraryNotWritable
+	}
+	to := organizedPath(organizeLayout, libraryFile{AudioFile: AudioFile{Path: p}}, meta)
+	if err := storage.MoveIn(filepath.Join(inboxConfig.Dir, filepath.FromSlash(p)), to); err != nil {
+		return err
+	}
+	if info, err := library.Stat(to); err == nil {
+		trackMeta(libraryFile{AudioFile: audioFileFromPath(to), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	slog.Info("Imported from the inbox", "from", p, "to", to)
+	return nil
+}
+
+// MusicBrainz
+
+// musicBrainzLimiter keeps to MusicBrainz's limit of one request a second
+var musicBrainzLimiter = rate.NewLimiter(1, 1)
+
+type musicBrainzCandidate struct {
+	score int
+	meta  TrackMeta
+}
+
+// lookupMusicBrainz searches for recordings matching what's known of a track, best first
+func lookupMusicBrainz(ctx context.Context, meta TrackMeta, name string) ([]musicBrainzCandidate, error) {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`"`, "", `\`, "").Replace(s) + `"`
+	}
+	var query string
+	switch {
+	case meta.Title != "" && meta.Artist != "":
+		query = "recording:" + quote(meta.Title) + " AND artist:" + quote(meta.Artist)
+	case meta.Title != "":
+		query = "recording:" + quote(meta.Title)
+	default:
+		query = quote(strings.TrimSuffix(name, path.Ext(name)))
+	}
+	if err := musicBrainzLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"https://musicbrainz.org/ws/2/recording?fmt=json&limit=5&query="+url.QueryEscape(query), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "beatgraze/"+versionInfo().Version+" ( https://github.com/jackharrhy/beatgraze )")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MusicBrainz: %s", resp.Status)
+	}
+
+	var result struct {
+		Recordings []struct {
+			Score   int    `json:"score"`
+			Title   string `json:"title"`
+			Credits []struct {
+				Name       string `json:"name"`
+				JoinPhrase string `json:"joinphrase"`
+			} `json:"artist-credit"`
+			Releases []struct {
+				Title string `json:"title"`
+				Date  string `json:"date"`
+				Media []struct {
+					Track []struct {
+						Number string `json:"number"`
+					} `json:"track"`
+				} `json:"media"`
+			} `json:"releases"`
+		} `json:"recordings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var candidates []musicBrainzCandidate
+	for _, rec := range result.Recordings {
+		c := musicBrainzCandidate{score: rec.Score, meta: TrackMeta{Title: rec.Title}}
+		for _, credit := range rec.Credits {
+			c.meta.Artist += credit.Name + credit.JoinPhrase
+		}
+		if len(rec.Releases) > 0 {
+			release := rec.Releases[0]
+			c.meta.Album = release.Title
+			if len(release.Date) >= 4 {
+				c.meta.Year, _ = strconv.Atoi(release.Date[:4])
+			}
+			if len(release.Media) > 0 && len(release.Media[0].Track) > 0 {
+				c.meta.Track, _ = strconv.Atoi(release.Media[0].Track[0].Number)
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	return candidates, nil
+}
+
+// confidentMatch picks the top candidate if it scored well and clearly beat the rest
+func confidentMatch(candidates []musicBrainzCandidate) (musicBrainzCandidate, bool) {
+	if len(candidates) == 0 || candidates[0].score < 90 {
+		return musicBrainzCandidate{}, false
+	}
+	if len(candidates) > 1 && candidates[1].score > candidates[0].score-10 && candidates[1].meta != candidates[0].meta {
+		return musicBrainzCandidate{}, false
+	}
+	return candidates[0], true
+}
+
+func trackMetas(candidates []musicBrainzCandidate) []TrackMeta {
+	metas := make([]TrackMeta, len(candidates))
+	for i, c := range candidates {
+		metas[i] = c.meta
+	}
+	return metas
+}
+
+// HTTP handlers
+
+func registerInboxRoutes() {
+	handleFunc("GET /api/inbox", requireRole(roleAdmin, listInbox))
+	handleFunc("POST /api/inbox/{id}/accept", requireRole(roleAdmin, acceptInboxItem))
+	handleFunc("DELETE /api/inbox/{id}", requireRole(roleAdmin, rejectInboxItem))
+}
+
+func listInbox(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"enabled": inboxConfig.Dir != "",
+		"items":   inbox.List(),
+	})
+}
+
+// acceptInboxItem files an item away using {"candidate": n} from its MusicBrainz matches,
+// or tags given as {"meta": {...}}, or failing both its best guess
+func acceptInboxItem(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Candidate *int      `json:"candidate"`
+		Meta      TrackMeta `json:"meta"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	item, err := inbox.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	meta := item.Meta
+	switch {
+	case req.Candidate != nil:
+		if *req.Candidate < 0 || *req.Candidate >= len(item.Candidates) {
+			http.Error(w, "No such candidate", http.StatusBadRequest)
+			return
+		}
+		meta = mergeMeta(meta, item.Candidates[*req.Candidate])
+	case req.Meta != (TrackMeta{}):
+		meta = req.Meta
+	}
+	if meta.Title == "" {
+		http.Error(w, "A title is needed to name the file", http.StatusBadRequest)
+		return
+	}
+	if err := fileInboxItem(item.Path, meta); err != nil {
+		if errors.Is(err, errLibraryNotWritable) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		writeFileError(w, err)
+		return
+	}
+	if err := inbox.Remove(item.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectInboxItem moves a file aside into the inbox's .rejected folder so it isn't imported
+func rejectInboxItem(w http.ResponseWriter, r *http.Request) {
+	item, err := inbox.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	src := filepath.Join(inboxConfig.Dir, filepath.FromSlash(item.Path))
+	dest := filepath.Join(inboxConfig.Dir, inboxRejectedDir, filepath.FromSlash(item.Path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(src, dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := inbox.Remove(item.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// finishedJobAge is how long finished jobs stay listed
+	finishedJobAge = 24 * time.Hour
+	// maxQueuedJobs caps the jobs waiting to run
+	maxQueuedJobs = 1000
+	// jobSaveInterval is how often progress is written out while a job runs; status changes
+	// are saved straight away
+	jobSaveInterval = 5 * time.Second
+)
+
+// jobWorkers is how many jobs of any kind run at once
+var jobWorkers = 2
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobDone      jobStatus = "done"
+	jobFailed    jobStatus = "failed"
+	jobCancelled jobStatus = "cancelled"
+)
+
+// Job is background work like a conversion or a rescan. Jobs are saved, so ones that were
+// waiting or running when the server stopped start again when it's back.
+type Job struct {
+	ID       string          `json:"id"`
+	Kind     string          `json:"kind"`
+	OwnerID  string          `json:"ownerId,omitempty"`
+	Priority int             `json:"priority"` // Higher runs first; ties go in the order asked for
+	Status   jobStatus       `json:"status"`
+	Progress float64         `json:"progress"`          // 0 to 1
+	Message  string          `json:"message,omitempty"` // What it's doing now
+	Error    string          `json:"error,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"` // The kind's own parameters and results
+	Restarts int             `json:"restarts,omitempty"`
+	Created  time.Time       `json:"created"`
+	Started  time.Time       `json:"started,omitzero"`
+	Finished time.Time       `json:"finished,omitzero"`
+}
+
+// jobKind runs one sort of job. run returns when the job is finished, failing it with an
+// error, and should stop soon after ctx is cancelled. A job interrupted by a restart is run
+// again from the start with the Data it had saved.
+type jobKind struct {
+	concurrency int  // How many can run at once
+	writes      bool // Changes the library or saved state, so waits while the server is read-only
+	run         func(ctx context.Context, run *jobRun) error
+	onChange    func(job Job) // Optional, for publishing the kind's own events
+}
+
+var jobKinds = map[string]*jobKind{}
+
+func registerJobKind(kind string, k *jobKind) {
+	jobKinds[kind] = k
+}
+
+type JobQueue struct {
+	mu      sync.Mutex
+	path    string
+	Jobs    map[string]*Job `json:"jobs"`
+	cancels map[string]context.CancelFunc
+	running map[string]int // By kind
+	saved   time.Time
+	wake    chan struct{}
+}
+
+var jobs *JobQueue
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobKind     = errors.New("unknown kind of job")
+	errQueueFull   = errors.New("too many jobs waiting, try again later")
+)
+
+func loadJobQueue(path string) (*JobQueue, error) {
+	q := &JobQueue{path: path, Jobs: map[string]*Job{}, cancels: map[string]context.CancelFunc{}, running: map[string]int{}, wake: make(chan struct{}, 1)}
+	if err := loadJSON(path, q); err != nil {
+		return nil, err
+	}
+	if q.Jobs == nil {
+		q.Jobs = map[string]*Job{}
+	}
+	for _, job := range q.Jobs {
+		if job.Status == jobRunning {
+			job.Status, job.Restarts = jobQueued, job.Restarts+1
+		}
+	}
+	q.countQueued()
+	return q, nil
+}
+
+// save must be called with mu held
+func (q *JobQueue) save() {
+	q.saved = time.Now()
+	if err := saveJSON(q.path, q); err != nil {
+		slog.Error("Error saving jobs", "err", err)
+	}
+}
+
+// changed saves a job and tells its owner about it on /api/events; mu must be held
+func (q *JobQueue) changed(job *Job, save bool) {
+	if save || time.Since(q.saved) > jobSaveInterval {
+		q.save()
+	}
+	events.Publish(Event{Type: "job.updated", Data: *job, visible: ownerOnly(job.OwnerID)})
+	if k := jobKinds[job.Kind]; k != nil && k.onChange != nil {
+		k.onChange(*job)
+	}
+}
+
+// countQueued updates the queue depth /metrics reports; mu must be held
+func (q *JobQueue) countQueued() {
+	counts := map[string]int{}
+	for _, job := range q.Jobs {
+		if job.Status == jobQueued {
+			counts[job.Kind]++
+		}
+	}
+	metrics.SetJobsQueued(counts)
+}
+
+func (q *JobQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Add queues a job of a registered kind with data as its parameters
+func (q *JobQueue) Add(kind, ownerID string, priority int, data any) (Job, error) {
+	if jobKinds[kind] == nil {
+		return Job{}, errJobKind
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Job{}, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiting := 0
+	for id, old := range q.Jobs {
+		if !old.Finished.IsZero() && time.Since(old.Finished) > finishedJobAge {
+			delete(q.Jobs, id)
+		}
+		if old.Status == jobQueued {
+			waiting++
+		}
+	}
+	if waiting >= maxQueuedJobs {
+		return Job{}, errQueueFull
+	}
+	job := &Job{ID: newID(), Kind: kind, OwnerID: ownerID, Priority: priority, Status: jobQueued, Data: raw, Created: time.Now().UTC()}
+	q.Jobs[job.ID] = job
+	q.changed(job, true)
+	q.countQueued()
+	q.signal()
+	return *job, nil
+}
+
+// Get returns a job if ownerID may see it; admins pass "" to see everyone's
+func (q *JobQueue) Get(id, ownerID string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.Jobs[id]
+	if !ok || ownerID != "" && job.OwnerID != ownerID {
+		return Job{}, errJobNotFound
+	}
+	return *job, nil
+}
+
+// List returns the jobs ownerID may see, newest first, optionally of one kind
+func (q *JobQueue) List(ownerID, kind string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := []Job{}
+	for _, job := range q.Jobs {
+		if (ownerID == "" || job.OwnerID == ownerID) && (kind == "" || job.Kind == kind) {
+			list = append(list, *job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list
+}
+
+// Cancel stops a job, leaving whatever it already did in place
+func (q *JobQueue) Cancel(id, ownerID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.Jobs[id]
+	if !ok || ownerID != "" && job.OwnerID != ownerID {
+		return errJobNotFound
+	}
+	switch job.Status {
+	case jobQueued:
+		job.Status = jobCancelled
+		job.Finished = time.Now().UTC()
+		q.changed(job, true)
+		q.countQueued()
+	case jobRunning:
+		q.cancels[id]()
+	}
+	return nil
+}
+
+// SetPriority moves a waiting job up or down the queue
+func (q *JobQueue) SetPriority(id string, priority int) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.Jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	job.Priority = priority
+	q.changed(job, true)
+	return *job, nil
+}
+
+// update changes a job under the lock
+func (q *JobQueue) update(id string, save bool, fn func(job *Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.Jobs[id]; ok {
+		fn(job)
+		q.changed(job, save)
+	}
+}
+
+// run starts queued jobs as workers free up, until ctx is done
+func (q *JobQueue) run(ctx context.Context) {
+	for {
+		q.startWaiting(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+	}
+}
+
+// startWaiting starts the most important queued jobs there's room for
+func (q *JobQueue) startWaiting(ctx context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.countQueued()
+	var waiting []*Job
+	for _, job := range q.Jobs {
+		if k := jobKinds[job.Kind]; job.Status == jobQueued && k != nil && !(k.writes && readOnly) {
+			waiting = append(waiting, job)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		if waiting[i].Priority != waiting[j].Priority {
+			return waiting[i].Priority > waiting[j].Priority
+		}
+		return waiting[i].Created.Before(waiting[j].Created)
+	})
+	for _, job := range waiting {
+		if len(q.cancels) >= jobWorkers {
+			return
+		}
+		if q.running[job.Kind] >= max(jobKinds[job.Kind].concurrency, 1) {
+			continue
+		}
+		jobCtx, cancel := context.WithCancel(ctx)
+		q.cancels[job.ID] = cancel
+		q.running[job.Kind]++
+		job.Status, job.Started, job.Error = jobRunning, time.Now().UTC(), ""
+		q.changed(job, true)
+		go q.execute(jobCtx, *job)
+	}
+}
+
+func (q *JobQueue) execute(ctx context.Context, job Job) {
+	err := jobKinds[job.Kind].run(ctx, &jobRun{queue: q, job: job})
+	cancelled := ctx.Err() != nil
+	if err != nil && !cancelled {
+		slog.Warn("Job failed", "kind", job.Kind, "id", job.ID, "err", err)
+	}
+
+	q.mu.Lock()
+	q.cancels[job.ID]()
+	delete(q.cancels, job.ID)
+	q.running[job.Kind]--
+	q.mu.Unlock()
+	q.update(job.ID, true, func(j *Job) {
+		switch {
+		case cancelled:
+			j.Status = jobCancelled
+		case err != nil:
+			j.Status, j.Error = jobFailed, err.Error()
+		default:
+			j.Status, j.Progress = jobDone, 1
+		}
+		j.Message = ""
+		j.Finished = time.Now().UTC()
+	})
+	q.signal()
+}
+
+// jobRun is what a running job reports its progress through
+type jobRun struct {
+	queue *JobQueue
+	job   Job
+}
+
+// Job is the job as it was when it started
+func (r *jobRun) Job() Job {
+	return r.job
+}
+
+// Decode reads the job's data into v
+func (r *jobRun) Decode(v any) error {
+	if len(r.job.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.job.Data, v)
+}
+
+// Progress reports how far through the job is, and what it's doing
+func (r *jobRun) Progress(fraction float64, message string) {
+	r.queue.update(r.job.ID, false, func(j *Job) {
+		j.Progress, j.Message = min(max(fraction, 0), 1), message
+	})
+}
+
+// Save stores v as the job's data, so a restart picks up from there
+func (r *jobRun) Save(v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.queue.update(r.job.ID, true, func(j *Job) { j.Data = raw })
+	return nil
+}
+
+func registerJobRoutes() {
+	handleFunc("GET /api/jobs", requireRole(roleListener, listJobs))
+	handleFunc("GET /api/jobs/{id}", requireRole(roleListener, getJob))
+	handleFunc("PATCH /api/jobs/{id}", requireRole(roleAdmin, updateJob))
+	handleFunc("DELETE /api/jobs/{id}", requireRole(roleListener, cancelJob))
+}
+
+// jobViewer limits listeners to their own jobs; admins see everyone's
+func jobViewer(r *http.Request) string {
+	if hasRole(r, roleAdmin) {
+		return ""
+	}
+	return uploadOwner(r)
+}
+
+// listJobs takes ?kind= and ?status= to narrow the list
+func listJobs(w http.ResponseWriter, r *http.Request) {
+	list := jobs.List(jobViewer(r), r.URL.Query().Get("kind"))
+	if status := jobStatus(r.URL.Query().Get("status")); status != "" {
+		kept := []Job{}
+		for _, job := range list {
+			if job.Status == status {
+				kept = append(kept, job)
+			}
+		}
+		list = kept
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := jobs.Get(r.PathValue("id"), jobViewer(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// updateJob takes {"priority": n}
+func updateJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Priority *int `json:"priority"`
+	}
+	if err := readJSON(r, &req); err != nil || req.Priority == nil {
+		http.Error(w, "priority is required", http.StatusBadRequest)
+		return
+	}
+	job, err := jobs.SetPriority(r.PathValue("id"), *req.Priority)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	jobs.signal()
+	writeJSON(w, http.StatusOK, job)
+}
+
+func cancelJob(w http.ResponseWriter, r *http.Request) {
+	if err := jobs.Cancel(r.PathValue("id"), jobViewer(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jobPriority reads an optional priority for a new job; only admins can set one
+func jobPriority(r *http.Request, requested int) int {
+	if !hasRole(r, roleAdmin) {
+		return 0
+	}
+	return requested
+}
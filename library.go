@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// libraryFile carries the filesystem details the API doesn't expose but features like smart playlists need
+type libraryFile struct {
+	AudioFile
+	Size    int64
+	ModTime time.Time
+}
+
+func scanLibrary() ([]libraryFile, error) {
+	return scanStorage(library)
+}
+
+// libraryListing is the library as the last rescan found it. The watcher keeps it and the
+// search index up to date, so what's asked often doesn't walk the whole library each time.
+var libraryListing struct {
+	sync.Mutex
+	files   []libraryFile
+	scanned bool
+}
+
+// listLibrary returns the last rescan's files, scanning first if there hasn't been one
+func listLibrary() ([]libraryFile, error) {
+	libraryListing.Lock()
+	files, scanned := libraryListing.files, libraryListing.scanned
+	libraryListing.Unlock()
+	if scanned {
+		return files, nil
+	}
+	return rescanLibrary()
+}
+
+// rescanLibrary scans the library and brings the listing and search index in step with it
+func rescanLibrary() ([]libraryFile, error) {
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, err
+	}
+	setLibraryListing(files)
+	return files, nil
+}
+
+// setLibraryListing keeps files, the whole library as just scanned, as the listing
+func setLibraryListing(files []libraryFile) {
+	libraryIndex.Update(files)
+	libraryListing.Lock()
+	libraryListing.files, libraryListing.scanned = files, true
+	libraryListing.Unlock()
+}
+
+// scanStorage lists the audio files in storage, which is the library or part of it
+func scanStorage(storage Storage) ([]libraryFile, error) {
+	start := time.Now()
+	var files []libraryFile
+	err := storage.Walk(func(relPath string, info fs.FileInfo) error {
+		ext := strings.ToLower(filepath.Ext(relPath))
+		if audioExts[ext] {
+			files = append(files, libraryFile{
+				AudioFile: audioFileFromPath(relPath),
+				Size:      info.Size(),
+				ModTime:   info.ModTime(),
+			})
+		}
+		return nil
+	})
+	metrics.ObserveScan(len(files), time.Since(start))
+	return files, err
+}
+
+// libraryFileAt looks up one track by its library path
+func libraryFileAt(relPath string) (libraryFile, error) {
+	track, err := validateTrack(relPath)
+	if err != nil {
+		return libraryFile{}, err
+	}
+	info, err := library.Stat(track)
+	if err != nil {
+		return libraryFile{}, err
+	}
+	return libraryFile{AudioFile: audioFileFromPath(track), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// localLibraryPath is where a track is on disk, when the library is a local folder and the
+// track isn't inside an archive
+func localLibraryPath(relPath string) (string, bool) {
+	storage := ownLibrary()
+	if z, ok := storage.(*zipStorage); ok {
+		storage = z.Storage
+	}
+	dir, ok := storage.(*dirStorage)
+	if !ok {
+		return "", false
+	}
+	p, err := dir.resolve(relPath)
+	return p, err == nil
+}
+
+// resolveAudioDir turns the -dir option into an absolute path, defaulting to the current directory
+func resolveAudioDir(dir string) (string, error) {
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("error getting current directory: %w", err)
+		}
+		dir = wd
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return "", fmt.Errorf("directory does not exist: %s", dir)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("error resolving directory path: %w", err)
+	}
+	return abs, nil
+}
+
+// openLibrary picks the storage for the -dir option: an s3:// bucket, a webdav(s):// share,
+// an sftp:// server, a URL a storage plugin handles, or a local directory that's returned
+// as an absolute path
+func openLibrary(dir string) (Storage, string, error) {
+	var storage Storage
+	var err error
+	switch {
+	case strings.HasPrefix(dir, "s3://"):
+		storage, err = newS3Storage(dir)
+	case strings.HasPrefix(dir, "webdav://"), strings.HasPrefix(dir, "webdavs://"):
+		storage, err = newWebDAVStorage(dir)
+	case strings.HasPrefix(dir, "sftp://"):
+		storage, err = newSFTPStorage(dir)
+	case pluginForScheme(dir) != nil:
+		storage = newPluginStorage(pluginForScheme(dir), dir)
+	default:
+		if dir, err = resolveAudioDir(dir); err == nil {
+			storage = newDirStorage(dir)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	mounted, err := withMounts(newZipStorage(storage))
+	return mounted, dir, err
+}
+
+// localLibrary reports whether the library is a directory on this machine
+func localLibrary() bool {
+	storage := ownLibrary()
+	if z, ok := storage.(*zipStorage); ok {
+		storage = z.Storage
+	}
+	_, ok := storage.(*dirStorage)
+	return ok
+}
+
+// writableLibrary returns the library's storage if files can be added to it
+func writableLibrary() (writableStorage, bool) {
+	storage := ownLibrary()
+	if z, ok := storage.(*zipStorage); ok {
+		storage = z.Storage
+	}
+	w, ok := storage.(writableStorage)
+	return w, ok
+}
+
+// libraryName is the name of the library's root folder, bucket prefix or share
+func libraryName() string {
+	if info, err := library.Stat(""); err == nil {
+		return info.Name()
+	}
+	return filepath.Base(audioDir)
+}
+
+// redactedLibrary hides any password in a remote library URL, for logs
+func redactedLibrary(dir string) string {
+	if u, err := url.Parse(dir); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return dir
+}
+
+// serveLibraryFile streams a library file, handling range requests and conditional GETs
+func serveLibraryFile(w http.ResponseWriter, r *http.Request, relPath string) {
+	f, err := library.Open(relPath)
+	if errors.Is(err, errInvalidPath) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	noteStreamFile(r, libraryFile{AudioFile: audioFileFromPath(relPath), Size: info.Size(), ModTime: info.ModTime()})
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
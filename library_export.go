@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ExportedTrack is a row of the library export: a file with its tags and the caller's
+// stats for it
+type ExportedTrack struct {
+	ScannedFile
+	PlayCount  int       `json:"playCount"`
+	Rating     int       `json:"rating"`
+	Favorite   bool      `json:"favorite"`
+	LastPlayed time.Time `json:"lastPlayed,omitzero"`
+}
+
+var errExportFormat = errors.New("format must be csv or json")
+
+// exportColumns are the CSV header, in the order exportRow writes them
+var exportColumns = []string{
+	"path", "size", "modified", "title", "artist", "album", "genre", "track", "year",
+	"duration", "bpm", "key", "playCount", "rating", "favorite", "lastPlayed",
+}
+
+func registerLibraryExportRoutes() {
+	handleFunc("GET /api/export", exportLibrary)
+}
+
+// exportLibrary sends the whole library with tags, play counts and ratings, as a file to
+// open in a spreadsheet
+func exportLibrary(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, errExportFormat.Error(), http.StatusBadRequest)
+		return
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", attachmentFilename("library."+format))
+	writeLibraryExport(w, format, files, stateFor(r).stats.Snapshot())
+}
+
+// writeLibraryExport writes files as CSV or a JSON array, reading their tags as it goes
+func writeLibraryExport(w io.Writer, format string, files []libraryFile, stats map[string]TrackStats) error {
+	switch format {
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write(exportColumns)
+		for _, f := range files {
+			if err := out.Write(exportRow(exportedTrack(f, stats))); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	case "json":
+		tracks := make([]ExportedTrack, 0, len(files))
+		for _, f := range files {
+			tracks = append(tracks, exportedTrack(f, stats))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tracks)
+	}
+	return errExportFormat
+}
+
+func exportedTrack(f libraryFile, stats map[string]TrackStats) ExportedTrack {
+	s := stats[f.Path]
+	return ExportedTrack{
+		ScannedFile: ScannedFile{Path: f.Path, Size: f.Size, Modified: f.ModTime.UTC(), TrackMeta: trackMeta(f)},
+		PlayCount:   s.PlayCount,
+		Rating:      s.Rating,
+		Favorite:    s.Favorite,
+		LastPlayed:  s.LastPlayed,
+	}
+}
+
+// exportRow leaves numbers that aren't known empty rather than 0, so a spreadsheet's
+// averages aren't thrown off
+func exportRow(t ExportedTrack) []string {
+	number := func(n float64) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	lastPlayed := ""
+	if !t.LastPlayed.IsZero() {
+		lastPlayed = t.LastPlayed.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		t.Path, strconv.FormatInt(t.Size, 10), t.Modified.Format(time.RFC3339),
+		t.Title, t.Artist, t.Album, t.Genre, number(float64(t.Track)), number(float64(t.Year)),
+		number(t.Duration), number(t.BPM), t.Key,
+		strconv.Itoa(t.PlayCount), number(float64(t.Rating)), strconv.FormatBool(t.Favorite), lastPlayed,
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	logLevel  = new(slog.LevelVar)
+	logFormat = "text"
+)
+
+// levelFlag lets -log-level set the level slog checks, so a reload can change it
+type levelFlag struct{ *slog.LevelVar }
+
+func (f levelFlag) String() string {
+	if f.LevelVar == nil {
+		return slog.LevelInfo.String()
+	}
+	return f.Level().String()
+}
+
+func (f levelFlag) Set(s string) error {
+	return f.UnmarshalText([]byte(s))
+}
+
+// setupLogging sends all output, including the standard log package's, through slog on stderr
+func setupLogging() error {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("-log-format must be text or json, not %q", logFormat)
+	}
+	return nil
+}
+
+// fatal logs an error and exits, like log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLogInfo collects details that are only known deeper in the handler chain
+type requestLogInfo struct {
+	user string
+}
+
+// logRequests writes one line per request once it's finished, streams included,
+// and the same request to the -access-log file when there is one
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ip := clientIP(r).String()
+		info := &requestLogInfo{}
+		if user, _, ok := r.BasicAuth(); ok {
+			info.user = user
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		stream := isStreamRequest(r)
+		if stream {
+			metrics.StreamStarted()
+		}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), logInfoContextKey, info)))
+		if stream {
+			metrics.StreamEnded()
+		}
+		metrics.ObserveRequest(r, rec.status, rec.bytes, time.Since(start))
+
+		if accessLog != nil {
+			if err := accessLog.Write(combinedLogLine(r, ip, info.user, rec.status, rec.bytes, start)); err != nil {
+				slog.Error("Error writing access log", "err", err)
+			}
+		}
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"bytes", rec.bytes,
+			"ip", ip,
+			"user", info.user,
+			"request_id", requestID(r),
+		)
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	freeLoginAttempts = 5                // Failures allowed before lockouts start
+	maxLoginLockout   = 15 * time.Minute // Lockouts double with each failure up to this
+)
+
+// LoginGuard counts failed sign-ins per client IP and per account name, so guessing is
+// slowed down whether it comes from one address or is spread across many.
+type LoginGuard struct {
+	mu      sync.Mutex
+	entries map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count       int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+var loginGuard = newLoginGuard()
+
+func newLoginGuard() *LoginGuard {
+	g := &LoginGuard{entries: map[string]*loginFailures{}}
+	go g.prune()
+	return g
+}
+
+func loginGuardKeys(ip netip.Addr, name string) []string {
+	keys := []string{"ip:" + ip.String()}
+	if name != "" {
+		keys = append(keys, "user:"+strings.ToLower(name))
+	}
+	return keys
+}
+
+// Locked returns how long until ip, or the account called name, may try again
+func (g *LoginGuard) Locked(ip netip.Addr, name string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var wait time.Duration
+	for _, key := range loginGuardKeys(ip, name) {
+		if e, ok := g.entries[key]; ok {
+			wait = max(wait, time.Until(e.lockedUntil))
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt and returns the lockout it triggered, if any
+func (g *LoginGuard) Fail(ip netip.Addr, name string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var lockout time.Duration
+	for _, key := range loginGuardKeys(ip, name) {
+		e, ok := g.entries[key]
+		if !ok {
+			e = &loginFailures{}
+			g.entries[key] = e
+		}
+		e.count++
+		e.lastFailure = now
+		if over := e.count - freeLoginAttempts; over > 0 {
+			d := maxLoginLockout
+			if over < 20 {
+				d = min(time.Second<<over, maxLoginLockout)
+			}
+			e.lockedUntil = now.Add(d)
+			lockout = max(lockout, d)
+		}
+	}
+	return lockout
+}
+
+// Succeed clears the account's failures. The IP's are left to expire, so a
+// valid login to one account doesn't reset guessing at others.
+func (g *LoginGuard) Succeed(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, "user:"+strings.ToLower(name))
+}
+
+// prune forgets failures once they're well in the past
+func (g *LoginGuard) prune() {
+	for range time.Tick(time.Minute) {
+		g.mu.Lock()
+		for key, e := range g.entries {
+			if time.Since(e.lastFailure) > maxLoginLockout && time.Now().After(e.lockedUntil) {
+				delete(g.entries, key)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
+
+// loginThrottled answers 429 when the client or account is locked out
+func loginThrottled(w http.ResponseWriter, r *http.Request, name string) bool {
+	wait := loginGuard.Locked(clientIP(r), name)
+	if wait <= 0 {
+		return false
+	}
+	seconds := int(wait.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many failed sign-in attempts, try again in "+strconv.Itoa(seconds)+"s", http.StatusTooManyRequests)
+	return true
+}
+
+// authFailed records a bad credential and logs one line per failure with the client IP
+// right after the message, so fail2ban can match it with
+//
+//	failregex = msg="auth failure" ip=<HOST>        (text logs)
+//	failregex = "msg":"auth failure","ip":"<HOST>"  (JSON logs)
+func authFailed(r *http.Request, via, name string) {
+	ip := clientIP(r)
+	lockout := loginGuard.Fail(ip, name)
+	slog.Warn("auth failure", "ip", ip.String(), "user", name, "via", via, "path", r.URL.Path, "lockout", lockout)
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,11 +21,23 @@ import (
 var indexHTML string
 
 var audioDir string
+var dataDir string
+
+var audioExts = map[string]bool{
+	".mp3":  true,
+	".wav":  true,
+	".flac": true,
+	".m4a":  true,
+	".aac":  true,
+	".ogg":  true,
+}
 
 type AudioFile struct {
-	Name   string `json:"name"`
-	Path   string `json:"path"`
-	Folder string `json:"folder"`
+	Name        string       `json:"name"`
+	Path        string       `json:"path"`
+	Folder      string       `json:"folder"`      // Immediate parent folder's name
+	Dir         string       `json:"dir"`         // Parent folder's path in the library, "" for the root
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs"` // Each folder from the top down to Dir
 }
 
 type PaginatedResponse struct {
@@ -31,228 +46,658 @@ type PaginatedResponse struct {
 	PerPage    int         `json:"perPage"`
 	Total      int         `json:"total"`
 	TotalPages int         `json:"totalPages"`
+	Facets     FileFacets  `json:"facets"`
+
+	// Set when browsing a folder with ?dir=
+	Dir         *string       `json:"dir,omitempty"`
+	Breadcrumbs []Breadcrumb  `json:"breadcrumbs,omitempty"`
+	Folders     []*FolderNode `json:"folders,omitempty"` // Its subfolders
+
+	// Set with ?groupBy=, in place of Files
+	Groups []*FileGroup `json:"groups,omitempty"`
+
+	// Set when searching: how well each file on the page matched, by path
+	Scores map[string]float64 `json:"scores,omitempty"`
 }
 
 func main() {
+	// Anything that isn't a command, like a directory or an option, is for serve
+	c, args := commands[0], os.Args[1:]
+	if len(args) > 0 {
+		if found, ok := findCommand(args[0]); ok {
+			c, args = found, args[1:]
+		}
+	}
+	if err := c.run(args); err != nil {
+		log.Fatal(c.name, " failed: ", err)
+	}
+}
+
+// runServe implements "beatgraze serve", which is also what beatgraze does without a command
+func runServe(args []string) error {
 	var port string
-	var help bool
+	var help, showVersion bool
+	var importOnStart bool
+	var addUser, addUserRole string
 
 	flag.StringVar(&port, "port", "8080", "Port to serve on")
 	flag.StringVar(&port, "p", "8080", "Port to serve on (shorthand)")
-	flag.StringVar(&audioDir, "dir", "", "Directory to serve audio files from (default: current directory)")
+	flag.StringVar(&listenAddr, "listen", "", "Address to listen on, e.g. 127.0.0.1:8080, unix:/run/beatgraze.sock or systemd for socket activation (overrides -port)")
+	flag.StringVar(&socketMode, "socket-mode", socketMode, "Permissions for a -listen unix: socket, in octal")
+	flag.StringVar(&configPath, "config", "", "Read options from this TOML file (default: $BEATGRAZE_CONFIG)")
+	flag.Var(levelFlag{logLevel}, "log-level", "Least important log messages to show: debug, info (the default), warn or error")
+	flag.StringVar(&logFormat, "log-format", logFormat, "Log as text or json")
+	flag.StringVar(&accessLogConfig.Path, "access-log", "", "Also write requests to this file in Apache combined log format")
+	flag.IntVar(&accessLogConfig.MaxSizeMB, "access-log-max-mb", accessLogConfig.MaxSizeMB, "Rotate the access log once it reaches this many megabytes (0 to leave it to logrotate)")
+	flag.IntVar(&accessLogConfig.Keep, "access-log-keep", accessLogConfig.Keep, "Number of rotated access logs to keep")
+	flag.StringVar(&debugAddr, "debug-addr", "", "Serve pprof and runtime stats without auth on this address, e.g. 127.0.0.1:6060 (admins can always reach them on the main port)")
+	flag.IntVar(&maxStreams, "max-streams", maxStreams, "Most audio streams to serve at once across all clients (0 for no limit)")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "How long to let in-flight requests and streams finish when stopping")
+	flag.StringVar(&basePath, "base-path", "", "URL prefix a reverse proxy serves beatgraze under, e.g. /beatgraze")
+	flag.BoolVar(&showQR, "qr", showQR, "Print a QR code of the server's LAN address at startup, when run in a terminal, for opening the player on a phone")
+	flag.BoolVar(&mdnsEnabled, "mdns", false, "Advertise the server on the local network over mDNS/Bonjour as _beatgraze._tcp")
+	flag.StringVar(&mdnsName, "mdns-name", "", "Name to advertise over mDNS (default: Beatgraze (<library folder>))")
+	flag.BoolVar(&dlnaEnabled, "dlna", false, "Advertise the library as a DLNA/UPnP media server, browsable without signing in from the local network")
+	flag.StringVar(&dlnaName, "dlna-name", "", "Name to show DLNA players (default: Beatgraze (<library folder>))")
+	flag.StringVar(&castURL, "cast-url", "", "URL cast devices reach this server on, e.g. http://192.168.1.10:8080 (default: worked out from the listen address)")
+	flag.StringVar(&audioDir, "dir", "", "Directory to serve audio files from, or an s3://bucket/prefix, webdavs://user@host/path or sftp://user@host/path URL (default: current directory)")
 	flag.StringVar(&audioDir, "d", "", "Directory to serve audio files from (shorthand)")
+	flag.StringVar(&cacheConfig.Dir, "cache-dir", "", "Where to cache blocks of remote library files (default: <data>/cache)")
+	flag.IntVar(&cacheConfig.SizeMB, "cache-size-mb", cacheConfig.SizeMB, "Disk space for caching remote library files, in MB (0 to disable)")
+	flag.IntVar(&uploadConfig.MaxMB, "upload-max-mb", uploadConfig.MaxMB, "Largest file that can be uploaded to the library, in MB")
+	flag.IntVar(&uploadConfig.QuotaMB, "upload-quota-mb", 0, "Total each non-admin user may upload, in MB, unless they're given their own quota (0 for no limit)")
+	flag.BoolVar(&davWrite, "dav-write", false, "Let admins add, move and delete library files over WebDAV at /dav/, which is otherwise read-only")
+	flag.IntVar(&uploadConfig.TotalMB, "upload-total-mb", 0, "Total all users together may upload, in MB (0 for no limit)")
+	flag.StringVar(&inboxConfig.Dir, "inbox", "", "Folder to watch for new tracks, which are moved into the library under -organize-layout")
+	flag.BoolVar(&inboxConfig.MusicBrainz, "inbox-musicbrainz", false, "Look up inbox tracks on MusicBrainz when their tags are incomplete")
+	flag.IntVar(&jobWorkers, "job-workers", jobWorkers, "How many background jobs, like conversions and rescans, run at once")
+	flag.StringVar(&ffmpegPath, "ffmpeg", ffmpegPath, "ffmpeg to convert files with")
+	flag.StringVar(&lastfmAPIKey, "lastfm-api-key", "", "Last.fm API key, which lets users connect their Last.fm accounts for scrobbling")
+	flag.StringVar(&lastfmSecret, "lastfm-secret", "", "Shared secret that goes with -lastfm-api-key (better set as $BEATGRAZE_LASTFM_SECRET)")
+	flag.StringVar(&listenBrainzURL, "listenbrainz-url", listenBrainzURL, "ListenBrainz API to scrobble to, for self-hosted or compatible servers")
+	flag.StringVar(&mpdAddr, "mpd-addr", "", "Serve the MPD protocol on this address, e.g. 127.0.0.1:6600, so MPD clients can browse and drive the jukebox")
+	flag.StringVar(&jukeboxPlayer, "jukebox-player", jukeboxPlayer, "Player the jukebox plays tracks through on the server's speakers; must take ffplay's options")
+	flag.StringVar(&snapcastTarget, "snapcast", "", "Play the jukebox into Snapcast for multi-room audio: snapserver's pipe, e.g. /tmp/snapfifo, or tcp://host:port for a tcp source")
+	flag.StringVar(&mqttBroker, "mqtt", "", "MQTT broker to publish what's playing and library events to, and take jukebox commands from: mqtt://[user:pass@]host[:port] or mqtts://")
+	flag.StringVar(&mqttTopic, "mqtt-topic", mqttTopic, "Topic the MQTT messages go under, e.g. beatgraze/nowplaying and beatgraze/control")
+	flag.StringVar(&mountList, "mount", "", "Show other beatgraze servers' libraries as read-only folders, as comma-separated name=https://key@host/path, where key is an API key there")
+	flag.StringVar(&syncTo, "sync-to", "", "Another beatgraze server to push new and changed files, playlists and stats to, e.g. https://music.example.com")
+	flag.StringVar(&syncKey, "sync-key", "", "API key of an admin on the -sync-to server (better set as $BEATGRAZE_SYNC_KEY)")
+	flag.DurationVar(&syncInterval, "sync-interval", syncInterval, "How often to push to -sync-to (0 to push only at startup)")
+	flag.Var(&scheduleRescan, "schedule-rescan", "When to rescan the whole library, as a crontab time like \"30 4 * * *\" or @daily")
+	flag.Var(&schedulePrune, "schedule-prune", "When to drop cached tags of deleted files, abandoned uploads and expired trash, as a crontab time")
+	flag.Var(&scheduleBackfill, "schedule-backfill", "When to read the tags of files that haven't had them read yet, as a crontab time")
+	flag.Var(&scheduleBackup, "schedule-backup", "When to back up users, playlists and other state to <data>/backups, as a crontab time")
+	flag.IntVar(&backupKeep, "backup-keep", backupKeep, "Number of backups to keep (0 to keep them all)")
+	flag.Var(&schedulePlaylists, "schedule-playlists", "When to rebuild the generated playlists, as a crontab time")
+	flag.StringVar(&hookConfig.FilesAdded, "hook-files-added", "", "Command to run when tracks show up in the library, given the event as JSON on stdin and $BEATGRAZE_FILES")
+	flag.StringVar(&hookConfig.FilesRemoved, "hook-files-removed", "", "Command to run when tracks go from the library, given $BEATGRAZE_PATHS")
+	flag.StringVar(&hookConfig.ScanCompleted, "hook-scan-completed", "", "Command to run when a library scan finishes, given $BEATGRAZE_FILES as the count")
+	flag.StringVar(&hookConfig.TrackPlayed, "hook-track-played", "", "Command to run when someone plays a track, given $BEATGRAZE_USER and $BEATGRAZE_PATH")
+	flag.StringVar(&hookConfig.UploadReceived, "hook-upload-received", "", "Command to run when an upload finishes, given $BEATGRAZE_PATH")
+	flag.DurationVar(&hookConfig.Timeout, "hook-timeout", hookConfig.Timeout, "How long a hook command may run before it's stopped (0 for no limit)")
+	flag.StringVar(&organizeLayout, "organize-layout", organizeLayout, "Where organizing puts each track: {artist}, {album}, {title}, {track}, {year} and {genre} become its tags")
+	flag.IntVar(&trashDays, "trash-days", trashDays, "Days to keep deleted library files in the trash before removing them for good (0 to keep them)")
+	flag.StringVar(&pluginsDir, "plugins", "", "Folder of plugin programs to start, which can add metadata providers, analyzers and storage (default: <data>/plugins)")
+	flag.StringVar(&dataDir, "data", "", "Directory to store playlists and other state (default: user config dir)")
+	flag.StringVar(&storeKind, "store", storeKind, "Where to keep users, playlists, history and other state: bolt for a database file in -data, json for a file per store, or a postgres:// URL to share between servers")
+	flag.BoolVar(&importOnStart, "import-playlists", false, "Import M3U/M3U8/PLS playlist files found in the library on startup")
+	flag.IntVar(&dailyMixCount, "daily-mixes", dailyMixCount, "Number of auto-generated Daily Graze playlists (0 to disable)")
+	flag.StringVar(&authCredentials, "auth", "", "Require HTTP Basic auth with the given user:pass")
+	flag.StringVar(&authToken, "auth-token", "", "Require a token, sent as \"Authorization: Bearer <token>\" or ?token=")
+	flag.StringVar(&addUser, "add-user", "", "Create an account (name or name:password) and exit; the first account is an admin")
+	flag.StringVar(&addUserRole, "role", string(roleListener), "Role for -add-user: admin, listener or guest")
+	flag.StringVar(&oidcConfig.Issuer, "oidc-issuer", "", "OIDC issuer URL to sign in through (e.g. https://auth.example.com)")
+	flag.StringVar(&oidcConfig.ClientID, "oidc-client-id", "", "OIDC client ID")
+	flag.StringVar(&oidcConfig.ClientSecret, "oidc-client-secret", "", "OIDC client secret (better set as $BEATGRAZE_OIDC_CLIENT_SECRET)")
+	flag.StringVar(&oidcConfig.RedirectURL, "oidc-redirect-url", "", "OIDC callback URL (default: <this server>/auth/oidc/callback)")
+	flag.StringVar(&oidcConfig.RolesClaim, "oidc-roles-claim", "groups", "ID token claim listing the user's groups")
+	flag.StringVar(&oidcConfig.RoleMap, "oidc-role-map", "*=listener", "Map groups to roles, e.g. \"admins=admin,family=listener,*=guest\"; without a match or * users are refused")
+	flag.StringVar(&accessConfig.Allow, "allow", "", "Only accept clients from these comma-separated CIDRs or addresses")
+	flag.StringVar(&accessConfig.Deny, "deny", "", "Refuse clients from these comma-separated CIDRs or addresses")
+	flag.StringVar(&accessConfig.TrustedProxies, "trusted-proxies", "", "Reverse proxies whose X-Forwarded-For is believed, as CIDRs")
+	flag.Float64Var(&accessConfig.APIRate, "api-rate", 0, "Per-IP limit on page and API requests per second (0 for none)")
+	flag.IntVar(&accessConfig.APIBurst, "api-burst", accessConfig.APIBurst, "Per-IP burst allowance for -api-rate")
+	flag.Float64Var(&accessConfig.StreamRate, "stream-rate", 0, "Per-IP limit on audio stream requests per second (0 for none)")
+	flag.IntVar(&accessConfig.StreamBurst, "stream-burst", accessConfig.StreamBurst, "Per-IP burst allowance for -stream-rate")
+	flag.StringVar(&tlsConfig.CertFile, "tls-cert", "", "Serve HTTPS with this certificate file")
+	flag.StringVar(&tlsConfig.KeyFile, "tls-key", "", "Private key for -tls-cert")
+	flag.StringVar(&tlsConfig.ACMEDomains, "acme-domain", "", "Get certificates from Let's Encrypt for these comma-separated domains (serves on 443 and 80)")
+	flag.StringVar(&tlsConfig.ACMEEmail, "acme-email", "", "Contact email for Let's Encrypt")
+	flag.StringVar(&tlsConfig.ACMECache, "acme-cache", "", "Directory to keep Let's Encrypt certificates in (default: <data>/acme)")
+	flag.StringVar(&tlsConfig.RedirectHTTP, "redirect-http", "", "Also listen for plain HTTP on this address (e.g. :80) and redirect to HTTPS")
+	flag.BoolVar(&disableHTTP2, "no-http2", false, "Only speak HTTP/1.1 over HTTPS, for debugging clients or proxies that mishandle HTTP/2")
+	flag.BoolVar(&enableHTTP3, "http3", false, "Also serve HTTP/3 over QUIC on the HTTPS port's UDP side, advertised with Alt-Svc")
+	flag.BoolVar(&tlsConfig.HSTS, "hsts", tlsConfig.HSTS, "Send Strict-Transport-Security over HTTPS")
+	flag.StringVar(&corsOrigins, "cors-origin", "", "Let these comma-separated origins (or *) call the API from the browser")
+	flag.BoolVar(&readOnly, "read-only", false, "Refuse every request that would change anything, for archives that must not be modified")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
 	flag.BoolVar(&help, "help", false, "Show help")
 	flag.BoolVar(&help, "h", false, "Show help (shorthand)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "🎵 Beatgraze - Web-based audio file player\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] [directory]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Options:\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [serve] [options] [directory]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s <command> [options]\n\n", os.Args[0])
+		printCommands(os.Stderr)
+		fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for a command's options.\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options for serve:\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nAny option can also go in the -config file (port = 3000, or [tls] cert = ...) or in\n")
+		fmt.Fprintf(os.Stderr, "the environment as BEATGRAZE_<OPTION> (BEATGRAZE_PORT, BEATGRAZE_TLS_CERT). The command\n")
+		fmt.Fprintf(os.Stderr, "line wins over the environment, which wins over the config file.\n")
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s                    # Serve current directory on port 8080\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -p 3000            # Serve current directory on port 3000\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d /path/to/music  # Serve specific directory\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s /path/to/music     # Serve specific directory (positional)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -auth me:secret    # Require a login\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -listen unix:/run/beatgraze.sock  # Listen on a socket behind a reverse proxy\n", os.Args[0])
 	}
 
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	if help {
 		flag.Usage()
 		os.Exit(0)
 	}
+	if showVersion {
+		fmt.Println(versionInfo())
+		os.Exit(0)
+	}
+	if err := applyConfig(); err != nil {
+		log.Fatal("Error in configuration: ", err)
+	}
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+
+	if authCredentials != "" && !strings.Contains(authCredentials, ":") {
+		fatal("-auth must be in the form user:pass")
+	}
+	if readOnly && importOnStart {
+		fatal("-import-playlists can't be used with -read-only")
+	}
+	if readOnly && inboxConfig.Dir != "" {
+		fatal("-inbox can't be used with -read-only")
+	}
+	if err := validateTLSConfig(); err != nil {
+		fatal(err.Error())
+	}
+	// Let's Encrypt needs the standard port unless told otherwise
+	portSet := false
+	flag.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "port" || f.Name == "p" })
+	if tlsConfig.ACMEDomains != "" && !portSet {
+		port = "443"
+	}
+	basePath = normalizeBasePath(basePath)
+	indexHTML = injectBasePath(indexHTML)
+	if listenAddr == "" && systemdActivated() {
+		listenAddr = "systemd"
+	}
+	if listenAddr == "" {
+		listenAddr = ":" + port
+	}
+	if err := setupAccess(); err != nil {
+		fatal("Error in access settings", "err", err)
+	}
 
 	// Handle positional argument for directory
 	if flag.NArg() > 0 {
 		audioDir = flag.Arg(0)
 	}
 
-	// Default to current directory if none specified
-	if audioDir == "" {
-		var err error
-		audioDir, err = os.Getwd()
-		if err != nil {
-			log.Fatal("Error getting current directory:", err)
+	var err error
+	if dataDir == "" {
+		if dataDir, err = defaultDataDir(); err != nil {
+			fatal("Error locating config directory", "err", err)
 		}
 	}
+	// Before the library, which may be on a storage plugin
+	if pluginsDir == "" {
+		pluginsDir = filepath.Join(dataDir, "plugins")
+	}
+	startPlugins(pluginsDir)
+	storage, dir, err := openLibrary(audioDir)
+	if err != nil {
+		fatal(err.Error())
+	}
+	library.Store(storage)
+	audioDir = dir
 
-	// Validate directory exists
-	if _, err := os.Stat(audioDir); os.IsNotExist(err) {
-		log.Fatalf("Directory does not exist: %s", audioDir)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		fatal("Error creating data directory", "err", err)
+	}
+	if stateStore, err = openDataStore(storeKind, dataDir); err != nil {
+		fatal("Error opening data store", "err", err)
 	}
+	defer stateStore.Close()
 
-	// Convert to absolute path
-	var err error
-	audioDir, err = filepath.Abs(audioDir)
+	defaultState, err = loadUserState(dataDir)
+	if err != nil {
+		fatal("Error loading playlists and play stats", "err", err)
+	}
+	users, err = loadUserStore(filepath.Join(dataDir, "users.json"))
+	if err != nil {
+		fatal("Error loading users", "err", err)
+	}
+	if oidcConfig.Issuer != "" {
+		if err := setupOIDC(context.Background()); err != nil {
+			fatal("Error setting up OIDC", "err", err)
+		}
+	}
+	if addUser != "" {
+		u, err := addAccount(addUser, addUserRole)
+		if err != nil {
+			fatal("Error creating user", "err", err)
+		}
+		slog.Info("Created user", "name", u.Name, "role", u.Role)
+		return nil
+	}
+	syncTransfers, err = loadSyncTransfers(filepath.Join(dataDir, "sync.json"))
 	if err != nil {
-		log.Fatal("Error resolving directory path:", err)
+		fatal("Error loading sync transfers", "err", err)
+	}
+	crates, err = loadCrateStore(filepath.Join(dataDir, "crates.json"))
+	if err != nil {
+		fatal("Error loading crates", "err", err)
+	}
+	stations, err = loadStationStore(filepath.Join(dataDir, "stations.json"))
+	if err != nil {
+		fatal("Error loading stations", "err", err)
+	}
+	if accessLogConfig.Path != "" {
+		accessLog, err = openAccessLog(accessLogConfig.Path, int64(accessLogConfig.MaxSizeMB)<<20, accessLogConfig.Keep)
+		if err != nil {
+			fatal("Error opening access log", "err", err)
+		}
+	}
+	auditLog = &AuditLog{path: filepath.Join(dataDir, "audit.jsonl")}
+	// Mounted servers are remote too
+	remote := !localLibrary() || library != ownLibrary()
+	if remote && cacheConfig.SizeMB > 0 {
+		dir := cacheConfig.Dir
+		if dir == "" {
+			dir = filepath.Join(dataDir, "cache")
+		}
+		diskCache, err = openBlockCache(dir, int64(cacheConfig.SizeMB)<<20)
+		if err != nil {
+			fatal("Error opening cache", "err", err)
+		}
+	}
+	if remote {
+		if err := loadMetaCache(filepath.Join(dataDir, "metadata-cache.json")); err != nil {
+			slog.Warn("Ignoring unreadable metadata cache", "err", err)
+		}
+		go saveMetaCachePeriodically()
+	}
+	shares, err = loadShareStore(filepath.Join(dataDir, "shares.json"))
+	if err != nil {
+		fatal("Error loading share links", "err", err)
+	}
+	jobs, err = loadJobQueue(filepath.Join(dataDir, "jobs.json"))
+	if err != nil {
+		fatal("Error loading jobs", "err", err)
+	}
+	scheduler, err = loadScheduler(filepath.Join(dataDir, "schedule.json"))
+	if err != nil {
+		fatal("Error loading schedule", "err", err)
+	}
+	uploads, err = loadUploadStore(filepath.Join(dataDir, "uploads.json"), filepath.Join(dataDir, "uploads"))
+	if err != nil {
+		fatal("Error loading uploads", "err", err)
+	}
+	trash, err = loadTrashStore(filepath.Join(dataDir, "trash.json"), filepath.Join(dataDir, "trash"))
+	if err != nil {
+		fatal("Error loading trash", "err", err)
+	}
+	if !readOnly {
+		go trash.purgePeriodically()
+	}
+	scrobbles, err = loadScrobbleStore(filepath.Join(dataDir, "scrobbles.json"))
+	if err != nil {
+		fatal("Error loading scrobbling accounts", "err", err)
+	}
+	if !readOnly {
+		go scrobbles.retryPeriodically()
+	}
+	webhooks, err = loadWebhookStore(filepath.Join(dataDir, "webhooks.json"))
+	if err != nil {
+		fatal("Error loading webhooks", "err", err)
+	}
+	go dispatchWebhooks()
+	go dispatchHooks()
+	inbox, err = loadInboxStore(filepath.Join(dataDir, "inbox.json"))
+	if err != nil {
+		fatal("Error loading inbox review queue", "err", err)
+	}
+	if inboxConfig.Dir != "" {
+		if inboxConfig.Dir, err = resolveAudioDir(inboxConfig.Dir); err != nil {
+			fatal("Error in -inbox", "err", err)
+		}
+		if _, ok := writableLibrary(); !ok {
+			fatal("-inbox needs a library on a local disk")
+		}
+		go watchInbox(context.Background())
 	}
 
-	http.HandleFunc("/", serveIndex)
-	http.HandleFunc("/api/files", getAudioFiles)
-	http.HandleFunc("/audio/", serveAudio)
+	if importOnStart {
+		results, err := importLibraryPlaylists(defaultState.playlists)
+		if err != nil {
+			fatal("Error importing playlists", "err", err)
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				slog.Warn("Skipped playlist", "source", result.Source, "err", result.Error)
+				continue
+			}
+			slog.Info("Imported playlist", "source", result.Source, "tracks", result.Tracks, "missing", len(result.Missing))
+		}
+	}
+
+	dailyMixStatePath := filepath.Join(dataDir, "dailymix.json")
+	if dailyMixCount > 0 && !readOnly {
+		go runDailyMixes(dailyMixStatePath)
+	}
 
-	fmt.Printf("🎵 Beatgraze running at http://localhost:%s\n", port)
-	fmt.Printf("📁 Serving audio files from: %s\n", audioDir)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	handleFunc("/", serveIndex)
+	handleFunc("/api/files", getAudioFiles)
+	handleFunc("GET /api/tree", getFolderTree)
+	handleFunc("GET /api/random", getRandomFiles)
+	handleFunc("/audio/", serveAudio)
+	registerPlaylistRoutes()
+	registerLibraryExportRoutes()
+	handleFunc("POST /api/playlists/daily/refresh", requireRole(roleAdmin, refreshDailyMixesHandler(dailyMixStatePath)))
+	registerStatsRoutes()
+	registerCrateRoutes()
+	registerStationRoutes()
+	registerCollectionRoutes()
+	registerUserRoutes()
+	registerAPIKeyRoutes()
+	registerOIDCRoutes()
+	registerShareRoutes()
+	registerCSRFRoutes()
+	registerAuditRoutes()
+	registerReloadRoutes()
+	registerMetricsRoutes()
+	registerDebugRoutes()
+	registerVersionRoutes()
+	registerQRRoutes()
+	registerUploadRoutes()
+	registerFileRoutes()
+	registerDAVRoutes()
+	registerOrganizeRoutes()
+	registerInboxRoutes()
+	registerConvertRoutes()
+	registerJobRoutes()
+	registerScheduleRoutes()
+	registerAPIRoutes()
+	registerGraphQLRoutes()
+	registerGRPCRoutes()
+	registerEventRoutes()
+	registerSubsonicRoutes()
+	registerDLNARoutes()
+	registerCastRoutes()
+	registerJukeboxRoutes()
+	registerScrobbleRoutes()
+	registerWebhookRoutes()
+	registerPodcastRoutes()
+	registerFeedRoutes()
+	registerHomeAssistantRoutes()
+	registerSearchRoutes()
+	registerAdminRoutes()
+	registerSyncRoutes()
+	go jobs.run(context.Background())
+	go scheduler.run(context.Background())
+	go watchLibrary(context.Background())
+
+	scheme := "http"
+	if tlsEnabled() {
+		scheme = "https"
+	}
+	slog.Info("Beatgraze running",
+		"version", versionInfo().Version,
+		"url", displayURL(scheme, listenAddr)+basePath,
+		"library", redactedLibrary(audioDir),
+		"data", dataDir,
+		"auth", authCredentials != "" || authToken != "" || users.Count() > 0 || oidcEnabled(),
+		"readOnly", readOnly,
+	)
+	printStartupQR(scheme)
+	if oidcEnabled() {
+		slog.Info("Signing in through OIDC", "issuer", oidcConfig.Issuer)
+	}
+	go reloadOnSIGHUP()
+	if debugAddr != "" {
+		go serveDebug(debugAddr)
+	}
+	if mpdAddr != "" {
+		go serveMPD(mpdAddr)
+	}
+	if mqttBroker != "" {
+		go runMQTT()
+	}
+	if syncTo != "" {
+		if syncKey == "" {
+			fatal(errSyncNotConfigured.Error())
+		}
+		go syncPeriodically()
+	}
+	handler := withBasePath(withRequestID(versionedAPI(jsonErrors(holdSettings(logRequests(limitAccess(limitStreams(blockWrites(securityHeaders(withCORS(csrfProtect(requireAuth(adminOnlyDebug(trackStreams(auditWrites(http.DefaultServeMux))))))))))))))))
+	if err := serve(listenAddr, handler); err != nil {
+		fatal(err.Error())
+	}
+	slog.Info("Stopped")
+	return nil
 }
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
+	// The page answers every path the app might link to, but not API endpoints that don't exist
+	if r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(indexHTML))
 }
 
 func getAudioFiles(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
-	pageStr := r.URL.Query().Get("page")
-	perPageStr := r.URL.Query().Get("perPage")
+	page, perPage := pageParams(r)
 	searchQuery := strings.TrimSpace(r.URL.Query().Get("search"))
-
-	page := 1
-	perPage := 200
-
-	if pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
-		}
+	filter, err := parseFileFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	if perPageStr != "" {
-		if pp, err := strconv.Atoi(perPageStr); err == nil && pp > 0 && pp <= 1000 {
-			perPage = pp
-		}
+	groupBy := r.URL.Query().Get("groupBy")
+	if _, ok := fileGroupers[groupBy]; groupBy != "" && !ok {
+		http.Error(w, errInvalidGroupBy.Error(), http.StatusBadRequest)
+		return
 	}
-	var audioFiles []AudioFile
-	audioExts := map[string]bool{
-		".mp3":  true,
-		".wav":  true,
-		".flac": true,
-		".m4a":  true,
-		".aac":  true,
-		".ogg":  true,
+	groupItems := defaultGroupItems
+	if n, err := strconv.Atoi(r.URL.Query().Get("groupItems")); err == nil && n > 0 {
+		groupItems = min(n, maxGroupItems)
 	}
 
-	err := filepath.Walk(audioDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if info.IsDir() {
-			return nil
-		}
-
-		ext := strings.ToLower(filepath.Ext(path))
-		if audioExts[ext] {
-			relPath, _ := filepath.Rel(audioDir, path)
-			folderName := filepath.Dir(relPath)
-			if folderName == "." {
-				folderName = "" // Root directory
-			} else {
-				folderName = filepath.Base(folderName) // Just the immediate parent folder name
-			}
-			audioFiles = append(audioFiles, AudioFile{
-				Name:   info.Name(),
-				Path:   relPath,
-				Folder: folderName,
-			})
-		}
-		return nil
-	})
-
+	files, err := scanLibrary()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// Filter by search query if provided
+	var scores map[string]float64
 	if searchQuery != "" {
-		var filteredFiles []AudioFile
-
-		// Check for dir: syntax
-		if strings.HasPrefix(searchQuery, "dir:") {
-			dirQuery := strings.TrimSpace(strings.TrimPrefix(searchQuery, "dir:"))
-			// Remove leading ./ if present
-			dirQuery = strings.TrimPrefix(dirQuery, "./")
-
-			// Split by space to separate directory and filename filters
-			parts := strings.SplitN(dirQuery, " ", 2)
-			dirFilter := parts[0]
-			var filenameFilter string
-			if len(parts) > 1 {
-				filenameFilter = strings.TrimSpace(parts[1])
-			}
+		if scores, err = searchFiles(files, stateFor(r).stats.Snapshot(), searchQuery, r.URL.Query().Get("fuzzy") == "true"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-			for _, file := range audioFiles {
-				// Check if file is in the specified directory
-				dirMatch := file.Folder == dirFilter || (dirFilter == "" && file.Folder == "")
-
-				if dirMatch {
-					// If no filename filter, include all files in the directory
-					if filenameFilter == "" {
-						filteredFiles = append(filteredFiles, file)
-					} else {
-						// Apply case-insensitive filename filter
-						if strings.Contains(strings.ToLower(file.Name), strings.ToLower(filenameFilter)) {
-							filteredFiles = append(filteredFiles, file)
-						}
-					}
-				}
+	// Browse one folder, and below it unless recursive=false
+	var dir *string
+	var folder *FolderNode
+	if r.URL.Query().Has("dir") {
+		d := strings.Trim(r.URL.Query().Get("dir"), "/")
+		if d != "" {
+			clean, err := cleanLibraryPath(d)
+			if err != nil {
+				http.Error(w, "Invalid dir", http.StatusBadRequest)
+				return
+			}
+			d = filepath.ToSlash(clean)
+		}
+		folder = buildFolderTree(files)
+		for _, part := range strings.Split(d, "/") {
+			if part == "" {
+				continue
 			}
-		} else {
-			// Regular text search
-			searchLower := strings.ToLower(searchQuery)
-			for _, file := range audioFiles {
-				if strings.Contains(strings.ToLower(file.Name), searchLower) ||
-					strings.Contains(strings.ToLower(file.Path), searchLower) ||
-					strings.Contains(strings.ToLower(file.Folder), searchLower) {
-					filteredFiles = append(filteredFiles, file)
+			var next *FolderNode
+			for _, sub := range folder.Folders {
+				if sub.Name == part {
+					next = sub
 				}
 			}
+			if next == nil {
+				http.Error(w, "Folder not found", http.StatusNotFound)
+				return
+			}
+			folder = next
+		}
+		recursive := r.URL.Query().Get("recursive") != "false"
+		inDir := files[:0:0]
+		for _, f := range files {
+			if inFolder(f.Path, d, recursive) {
+				inDir = append(inDir, f)
+			}
+		}
+		files, dir = inDir, &d
+	}
+
+	// Filter by search query if provided
+	if scores != nil {
+		var filteredFiles []libraryFile
+		for _, file := range files {
+			if _, ok := scores[file.Path]; ok {
+				filteredFiles = append(filteredFiles, file)
+			}
 		}
-		audioFiles = filteredFiles
-	} // Sort files by name for consistent pagination
+		files = filteredFiles
+	}
+	files, facets := filter.apply(files)
+
+	audioFiles := make([]AudioFile, len(files))
+	for i, f := range files {
+		audioFiles[i] = f.AudioFile
+	}
+
+	// Sort files by name for consistent pagination, best matches first when searching
 	sort.Slice(audioFiles, func(i, j int) bool {
 		return audioFiles[i].Name < audioFiles[j].Name
 	})
+	if scores != nil {
+		sortByRelevance(audioFiles, func(f AudioFile) string { return f.Path }, scores)
+	}
+
+	response := PaginatedResponse{
+		Page:    page,
+		PerPage: perPage,
+		Facets:  facets,
+	}
+	// Grouping pages through the groups rather than the files
+	shown := audioFiles
+	if groupBy != "" {
+		byPath := make(map[string]libraryFile, len(files))
+		for _, f := range files {
+			byPath[f.Path] = f
+		}
+		groups := groupFiles(audioFiles, byPath, fileGroupers[groupBy], groupItems)
+		if scores == nil {
+			sort.SliceStable(groups, func(i, j int) bool { return strings.ToLower(groups[i].Key) < strings.ToLower(groups[j].Key) })
+		}
+		start, end, totalPages := paginate(len(groups), page, perPage)
+		response.Files, response.Groups = []AudioFile{}, groups[start:end]
+		response.Total, response.TotalPages = len(groups), totalPages
+		shown = nil
+		for _, g := range response.Groups {
+			shown = append(shown, g.Files...)
+		}
+	} else {
+		start, end, totalPages := paginate(len(audioFiles), page, perPage)
+		response.Files, response.Total, response.TotalPages = audioFiles[start:end], len(audioFiles), totalPages
+		shown = response.Files
+	}
+	if dir != nil {
+		response.Dir, response.Breadcrumbs, response.Folders = dir, breadcrumbs(*dir), folder.subfolders()
+	}
+	if scores != nil {
+		response.Scores = make(map[string]float64, len(shown))
+		for _, f := range shown {
+			response.Scores[f.Path] = math.Round(scores[f.Path]*1000) / 1000
+		}
+	}
 
-	total := len(audioFiles)
-	totalPages := (total + perPage - 1) / perPage
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Calculate pagination bounds
-	start := (page - 1) * perPage
-	end := start + perPage
+func pageParams(r *http.Request) (page, perPage int) {
+	pageStr := r.URL.Query().Get("page")
+	perPageStr := r.URL.Query().Get("perPage")
+
+	page = 1
+	perPage = 200
 
-	if start >= total {
-		// Page out of range, return empty
-		start = total
-		end = total
-	} else if end > total {
-		end = total
+	if pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
 	}
 
-	paginatedFiles := audioFiles[start:end]
+	if perPageStr != "" {
+		if pp, err := strconv.Atoi(perPageStr); err == nil && pp > 0 && pp <= 1000 {
+			perPage = pp
+		}
+	}
+	return page, perPage
+}
 
-	response := PaginatedResponse{
-		Files:      paginatedFiles,
-		Page:       page,
-		PerPage:    perPage,
-		Total:      total,
-		TotalPages: totalPages,
+// paginate returns the slice bounds for a page along with the page count
+func paginate(total, page, perPage int) (start, end, totalPages int) {
+	totalPages = (total + perPage - 1) / perPage
+
+	// Page out of range, return empty. Checked before multiplying, which a huge page would overflow.
+	if page-1 >= totalPages {
+		return total, total, totalPages
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	// Calculate pagination bounds
+	start = (page - 1) * perPage
+	end = min(start+perPage, total)
+	return start, end, totalPages
 }
 
-func serveAudio(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/audio/")
-	fullPath := filepath.Join(audioDir, path)
+func audioFileFromPath(relPath string) AudioFile {
+	folderName := filepath.Dir(relPath)
+	if folderName == "." {
+		folderName = "" // Root directory
+	} else {
+		folderName = filepath.Base(folderName) // Just the immediate parent folder name
+	}
+	dir := libraryDir(filepath.ToSlash(relPath))
+	return AudioFile{
+		Name:        filepath.Base(relPath),
+		Path:        relPath,
+		Folder:      folderName,
+		Dir:         dir,
+		Breadcrumbs: folderCrumbs(dir),
+	}
+}
 
-	// Security check: ensure the resolved path is within audioDir
-	if !strings.HasPrefix(fullPath, audioDir) {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
+func serveAudio(w http.ResponseWriter, r *http.Request) {
+	relPath := strings.TrimPrefix(r.URL.Path, "/audio/")
+	if icyEligible(r, relPath) {
+		serveICYFile(w, r, relPath)
 		return
 	}
-
-	http.ServeFile(w, r, fullPath)
+	serveLibraryFile(w, r, relPath)
 }
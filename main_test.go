@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		total, page, perPage   int
+		start, end, totalPages int
+	}{
+		{0, 1, 200, 0, 0, 0},
+		{5, 1, 2, 0, 2, 3},
+		{5, 3, 2, 4, 5, 3},
+		{5, 4, 2, 5, 5, 3},
+		{5, math.MaxInt, 2, 5, 5, 3},
+		{5, math.MaxInt, 1000, 5, 5, 1},
+	}
+	for _, tt := range tests {
+		start, end, totalPages := paginate(tt.total, tt.page, tt.perPage)
+		if start != tt.start || end != tt.end || totalPages != tt.totalPages {
+			t.Errorf("paginate(%d, %d, %d) = %d, %d, %d; want %d, %d, %d", tt.total, tt.page, tt.perPage,
+				start, end, totalPages, tt.start, tt.end, tt.totalPages)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	mdnsEnabled bool
+	mdnsName    string
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const mdnsTTL = 120
+
+// mdnsAdvertiser answers multicast DNS queries for beatgraze, so clients on the LAN
+// can find it as _beatgraze._tcp (and as a plain web server) without an address
+type mdnsAdvertiser struct {
+	conn     *net.UDPConn
+	instance string // Display name, e.g. "Music"
+	host     dnsmessage.Name
+	port     uint16
+	services []dnsmessage.Name
+	txt      []string
+}
+
+func mdnsLabel(s string) string {
+	// Dots would split the name into extra labels
+	return strings.ReplaceAll(strings.TrimSpace(s), ".", "-")
+}
+
+// advertiseMDNS runs until the process exits; addr is the listener, whose port gets advertised
+func advertiseMDNS(addr net.Addr) {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		slog.Warn("mDNS needs a TCP listener, not advertising")
+		return
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		slog.Warn("Not advertising over mDNS", "err", err)
+		return
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	name := mdnsName
+	if name == "" {
+		name = "Beatgraze (" + libraryName() + ")"
+	}
+	web := "_http._tcp.local."
+	if tlsEnabled() {
+		web = "_https._tcp.local."
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		slog.Warn("Not advertising over mDNS", "err", err)
+		return
+	}
+	a := &mdnsAdvertiser{
+		conn:     conn,
+		instance: mdnsLabel(name),
+		host:     dnsmessage.MustNewName(mdnsLabel(hostname) + ".local."),
+		port:     uint16(tcp.Port),
+		services: []dnsmessage.Name{dnsmessage.MustNewName("_beatgraze._tcp.local."), dnsmessage.MustNewName(web)},
+		txt:      []string{"path=" + prefixed("/"), "library=" + libraryName(), "version=" + versionInfo().Version},
+	}
+	slog.Info("Advertising over mDNS", "name", a.instance, "host", a.host.String(), "port", a.port)
+
+	// Announce twice, a second apart, as RFC 6762 suggests
+	for i := 0; i < 2; i++ {
+		a.send(a.announcement(), mdnsGroup)
+		time.Sleep(time.Second)
+	}
+	a.serve()
+}
+
+func (a *mdnsAdvertiser) instanceName(service dnsmessage.Name) dnsmessage.Name {
+	return dnsmessage.MustNewName(a.instance + "." + service.String())
+}
+
+func (a *mdnsAdvertiser) header() dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Class: dnsmessage.ClassINET, TTL: mdnsTTL}
+}
+
+// records returns everything we'd say about one service type
+func (a *mdnsAdvertiser) records(service dnsmessage.Name) []dnsmessage.Resource {
+	instance := a.instanceName(service)
+	h := a.header()
+	ptr, srv, txt := h, h, h
+	ptr.Name, srv.Name, txt.Name = service, instance, instance
+	return []dnsmessage.Resource{
+		{Header: ptr, Body: &dnsmessage.PTRResource{PTR: instance}},
+		{Header: srv, Body: &dnsmessage.SRVResource{Target: a.host, Port: a.port}},
+		{Header: txt, Body: &dnsmessage.TXTResource{TXT: a.txt}},
+	}
+}
+
+func (a *mdnsAdvertiser) addressRecords() []dnsmessage.Resource {
+	var records []dnsmessage.Resource
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		h := a.header()
+		h.Name = a.host
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			records = append(records, dnsmessage.Resource{Header: h, Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+		} else if !ipnet.IP.IsLinkLocalUnicast() {
+			records = append(records, dnsmessage.Resource{Header: h, Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ipnet.IP.To16())}})
+		}
+	}
+	return records
+}
+
+func (a *mdnsAdvertiser) announcement() dnsmessage.Message {
+	msg := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	for _, service := range a.services {
+		msg.Answers = append(msg.Answers, a.records(service)...)
+	}
+	msg.Additionals = a.addressRecords()
+	return msg
+}
+
+func (a *mdnsAdvertiser) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			slog.Warn("mDNS advertising stopped", "err", err)
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || query.Response {
+			continue
+		}
+		if reply, ok := a.answer(query); ok {
+			// Queries from a port other than 5353 are one-shot resolvers that want a unicast reply
+			to := mdnsGroup
+			if from.Port != mdnsGroup.Port {
+				reply.ID = query.ID
+				reply.Questions = query.Questions
+				to = from
+			}
+			a.send(reply, to)
+		}
+	}
+}
+
+func (a *mdnsAdvertiser) answer(query dnsmessage.Message) (dnsmessage.Message, bool) {
+	reply := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	browse := dnsmessage.MustNewName("_services._dns-sd._udp.local.")
+	for _, q := range query.Questions {
+		name := strings.ToLower(q.Name.String())
+		switch {
+		case name == browse.String():
+			for _, service := range a.services {
+				h := a.header()
+				h.Name = browse
+				reply.Answers = append(reply.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.PTRResource{PTR: service}})
+			}
+		case name == strings.ToLower(a.host.String()):
+			reply.Answers = append(reply.Answers, a.addressRecords()...)
+		default:
+			for _, service := range a.services {
+				if name == strings.ToLower(service.String()) || name == strings.ToLower(a.instanceName(service).String()) {
+					reply.Answers = append(reply.Answers, a.records(service)...)
+					reply.Additionals = a.addressRecords()
+				}
+			}
+		}
+	}
+	return reply, len(reply.Answers) > 0
+}
+
+func (a *mdnsAdvertiser) send(msg dnsmessage.Message, to *net.UDPAddr) {
+	packet, err := msg.Pack()
+	if err != nil {
+		slog.Warn("Couldn't build mDNS reply", "err", err)
+		return
+	}
+	if _, err := a.conn.WriteToUDP(packet, to); err != nil {
+		slog.Debug("Couldn't send mDNS reply", "err", err)
+	}
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+type TrackMeta struct {
+	Title  string  `json:"title,omitempty"`
+	Artist string  `json:"artist,omitempty"`
+	Album  string  `json:"album,omitempty"`
+	Genre  string  `json:"genre,omitempty"`
+	Key    string  `json:"key,omitempty"`
+	BPM    float64 `json:"bpm,omitempty"`
+	Track  int     `json:"track,omitempty"`
+	Year   int     `json:"year,omitempty"`
+
+	Duration float64 `json:"duration,omitempty"` // Seconds, from container headers
+}
+
+type metaEntry struct {
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+	Meta    TrackMeta `json:"meta"`
+}
+
+// metaCache avoids re-parsing tags for files that haven't changed since the last read.
+// For remote libraries, where every read is a round trip, it's also kept on disk.
+var metaCache = struct {
+	sync.Mutex
+	entries map[string]metaEntry
+	path    string // Empty when the cache isn't persisted
+	dirty   bool
+}{entries: map[string]metaEntry{}}
+
+func loadMetaCache(path string) error {
+	metaCache.Lock()
+	defer metaCache.Unlock()
+	metaCache.path = path
+	return loadJSON(path, &metaCache.entries)
+}
+
+// saveMetaCache writes the cache out if anything's been added since the last save
+func saveMetaCache() error {
+	metaCache.Lock()
+	defer metaCache.Unlock()
+	if metaCache.path == "" || !metaCache.dirty {
+		return nil
+	}
+	metaCache.dirty = false
+	return saveJSON(metaCache.path, metaCache.entries)
+}
+
+func saveMetaCachePeriodically() {
+	for range time.Tick(time.Minute) {
+		if err := saveMetaCache(); err != nil {
+			slog.Error("Error saving metadata cache", "err", err)
+		}
+	}
+}
+
+func trackMeta(f libraryFile) TrackMeta {
+	metaCache.Lock()
+	entry, ok := metaCache.entries[f.Path]
+	metaCache.Unlock()
+	if ok && entry.ModTime.Equal(f.ModTime) && entry.Size == f.Size {
+		return entry.Meta
+	}
+
+	meta, _ := readTags(f.Path)
+	meta = pluginMeta(f, meta)
+	metaCache.Lock()
+	metaCache.entries[f.Path] = metaEntry{ModTime: f.ModTime, Size: f.Size, Meta: meta}
+	metaCache.dirty = true
+	metaCache.Unlock()
+	return meta
+}
+
+// hasMeta reports whether f's tags are cached and up to date
+func hasMeta(f libraryFile) bool {
+	metaCache.Lock()
+	defer metaCache.Unlock()
+	entry, ok := metaCache.entries[f.Path]
+	return ok && entry.ModTime.Equal(f.ModTime) && entry.Size == f.Size
+}
+
+// pruneMeta drops the cached tags of files no longer in the library, returning how many
+func pruneMeta(files []libraryFile) int {
+	present := make(map[string]bool, len(files))
+	for _, f := range files {
+		present[f.Path] = true
+	}
+	metaCache.Lock()
+	defer metaCache.Unlock()
+	n := 0
+	for path := range metaCache.entries {
+		if !present[path] {
+			delete(metaCache.entries, path)
+			n++
+		}
+	}
+	if n > 0 {
+		metaCache.dirty = true
+	}
+	return n
+}
+
+// forgetMeta empties the cache, so tags are read afresh, returning how many tracks it held
+func forgetMeta() int {
+	metaCache.Lock()
+	defer metaCache.Unlock()
+	n := len(metaCache.entries)
+	metaCache.entries = map[string]metaEntry{}
+	metaCache.dirty = true
+	return n
+}
+
+// readTags extracts whatever tags the container supports; missing tags are not an error
+func readTags(path string) (TrackMeta, error) {
+	file, err := library.Open(path)
+	if err != nil {
+		return TrackMeta{}, err
+	}
+	defer file.Close()
+	return parseTags(file, path)
+}
+
+// parseTags reads tags from an open file, picking the format by the name's extension
+func parseTags(file io.ReadSeeker, path string) (TrackMeta, error) {
+	var meta TrackMeta
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3", ".aac":
+		if err = readID3v2(file, &meta); err != nil || meta == (TrackMeta{}) {
+			err = readID3v1(file, &meta)
+		}
+	case ".flac":
+		err = readFLACTags(file, &meta)
+	case ".ogg":
+		err = readOggTags(file, &meta)
+	case ".m4a":
+		err = readMP4Tags(file, &meta)
+	case ".wav":
+		err = readWAVTags(file, &meta)
+	}
+	meta.Duration = readDuration(file, path)
+	return meta, err
+}
+
+var errNoTags = errors.New("no tags found")
+
+// ID3v2
+
+func readID3v2(r io.ReadSeeker, meta *TrackMeta) error {
+	r.Seek(0, io.SeekStart)
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if string(header[:3]) != "ID3" {
+		return errNoTags
+	}
+	return parseID3v2(header, r, meta)
+}
+
+func parseID3v2(header []byte, r io.Reader, meta *TrackMeta) error {
+	major := header[3]
+	flags := header[5]
+	size := syncsafe(header[6:10])
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if flags&0x80 != 0 && major < 4 {
+		data = bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+	}
+	if flags&0x40 != 0 && len(data) >= 4 {
+		// Skip the extended header
+		ext := int(binary.BigEndian.Uint32(data[:4])) + 4
+		if major == 4 {
+			ext = syncsafe(data[:4])
+		}
+		if ext > len(data) {
+			return errNoTags
+		}
+		data = data[ext:]
+	}
+
+	idLen, headerLen := 4, 10
+	if major == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(data) >= headerLen && data[0] != 0 {
+		id := string(data[:idLen])
+		var frameSize int
+		switch major {
+		case 2:
+			frameSize = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(data[4:8]))
+		default:
+			frameSize = syncsafe(data[4:8])
+		}
+		if frameSize <= 0 || headerLen+frameSize > len(data) {
+			break
+		}
+		body := data[headerLen : headerLen+frameSize]
+		data = data[headerLen+frameSize:]
+		if id[0] != 'T' || len(body) < 2 {
+			continue
+		}
+		setID3Field(meta, id, decodeID3Text(body[0], body[1:]))
+	}
+	return nil
+}
+
+func setID3Field(meta *TrackMeta, id, value string) {
+	switch id {
+	case "TIT2", "TT2":
+		meta.Title = value
+	case "TPE1", "TP1":
+		meta.Artist = value
+	case "TALB", "TAL":
+		meta.Album = value
+	case "TCON", "TCO":
+		meta.Genre = id3Genre(value)
+	case "TKEY", "TKE":
+		meta.Key = value
+	case "TBPM", "TBP":
+		meta.BPM, _ = strconv.ParseFloat(value, 64)
+	case "TRCK", "TRK":
+		meta.Track = leadingInt(value)
+	case "TYER", "TYE", "TDRC":
+		meta.Year = leadingInt(value)
+	}
+}
+
+func decodeID3Text(encoding byte, b []byte) string {
+	var s string
+	switch encoding {
+	case 0:
+		s = string(latin1ToUTF8(b))
+	case 1, 2:
+		s = decodeUTF16(b, encoding == 2)
+	default:
+		s = string(b)
+	}
+	// v2.4 allows several null-separated values; the first one is enough here
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		var u uint16
+		if bigEndian {
+			u = binary.BigEndian.Uint16(b[i:])
+		} else {
+			u = binary.LittleEndian.Uint16(b[i:])
+		}
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// ID3v1 is a fixed 128 byte block at the very end of the file
+func readID3v1(r io.ReadSeeker, meta *TrackMeta) error {
+	if _, err := r.Seek(-128, io.SeekEnd); err != nil {
+		return err
+	}
+	b := make([]byte, 128)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if string(b[:3]) != "TAG" {
+		return errNoTags
+	}
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(string(latin1ToUTF8(b)))
+	}
+	meta.Title = field(b[3:33])
+	meta.Artist = field(b[33:63])
+	meta.Album = field(b[63:93])
+	meta.Year = leadingInt(field(b[93:97]))
+	if b[125] == 0 && b[126] != 0 {
+		meta.Track = int(b[126])
+	}
+	if int(b[127]) < len(id3Genres) {
+		meta.Genre = id3Genres[b[127]]
+	}
+	return nil
+}
+
+// id3Genre resolves the "(17)" / "17" numeric genre references older taggers write
+func id3Genre(value string) string {
+	s := value
+	if strings.HasPrefix(s, "(") {
+		if end := strings.IndexByte(s, ')'); end > 0 {
+			if rest := strings.TrimSpace(s[end+1:]); rest != "" {
+				return rest
+			}
+			s = s[1:end]
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(id3Genres) {
+		return id3Genres[n]
+	}
+	return value
+}
+
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock",
+	"Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack",
+	"Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop",
+	"Instrumental Rock", "Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic",
+	"Pop-Folk", "Eurodance", "Dream", "Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40",
+	"Christian Rap", "Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal", "Acid Punk",
+	"Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+}
+
+// Vorbis comments (FLAC and Ogg)
+
+func parseVorbisComments(b []byte, meta *TrackMeta) error {
+	readString := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || 4+n > len(b) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+	if _, ok := readString(); !ok { // vendor string
+		return errNoTags
+	}
+	if len(b) < 4 {
+		return errNoTags
+	}
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := readString()
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			meta.Title = value
+		case "ARTIST":
+			meta.Artist = value
+		case "ALBUM":
+			meta.Album = value
+		case "GENRE":
+			meta.Genre = value
+		case "BPM", "TEMPO":
+			meta.BPM, _ = strconv.ParseFloat(value, 64)
+		case "INITIALKEY", "KEY":
+			meta.Key = value
+		case "TRACKNUMBER":
+			meta.Track = leadingInt(value)
+		case "DATE", "YEAR":
+			meta.Year = leadingInt(value)
+		}
+	}
+	return nil
+}
+
+func readFLACTags(r io.ReadSeeker, meta *TrackMeta) error {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if string(magic[:3]) == "ID3" {
+		// Some taggers prepend ID3 to FLAC; use it and skip past for the native blocks
+		r.Seek(0, io.SeekStart)
+		header := make([]byte, 10)
+		io.ReadFull(r, header)
+		r.Seek(int64(syncsafe(header[6:10])), io.SeekCurrent)
+		io.ReadFull(r, magic)
+	}
+	if string(magic) != "fLaC" {
+		return errNoTags
+	}
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		if blockType == 4 {
+			block := make([]byte, length)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return err
+			}
+			return parseVorbisComments(block, meta)
+		}
+		if last {
+			return errNoTags
+		}
+		if _, err := r.Seek(int64(length), io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+}
+
+// readOggPackets reassembles the first n logical packets of an Ogg stream
+func readOggPackets(r io.Reader, n int) ([][]byte, error) {
+	var packets [][]byte
+	var current []byte
+	header := make([]byte, 27)
+	for len(packets) < n {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return packets, err
+		}
+		if string(header[:4]) != "OggS" {
+			return packets, errNoTags
+		}
+		lacing := make([]byte, header[26])
+		if _, err := io.ReadFull(r, lacing); err != nil {
+			return packets, err
+		}
+		for _, l := range lacing {
+			segment := make([]byte, l)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return packets, err
+			}
+			current = append(current, segment...)
+			if l < 255 {
+				packets = append(packets, current)
+				current = nil
+			}
+		}
+		// Comment packets are small; refuse to buffer something pathological
+		if len(current) > 1<<22 {
+			return packets, errNoTags
+		}
+	}
+	return packets, nil
+}
+
+func readOggTags(r io.Reader, meta *TrackMeta) error {
+	packets, err := readOggPackets(r, 2)
+	if len(packets) < 2 {
+		return err
+	}
+	comment := packets[1]
+	switch {
+	case bytes.HasPrefix(comment, []byte("\x03vorbis")):
+		return parseVorbisComments(comment[7:], meta)
+	case bytes.HasPrefix(comment, []byte("OpusTags")):
+		return parseVorbisComments(comment[8:], meta)
+	}
+	return errNoTags
+}
+
+// MP4 / M4A
+
+func readMP4Tags(r io.ReadSeeker, meta *TrackMeta) error {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	ilst, err := findMP4Atom(r, 0, end, []string{"moov", "udta", "meta", "ilst"})
+	if err != nil {
+		return err
+	}
+	return walkMP4Atoms(r, ilst[0], ilst[1], func(kind string, start, end int64) error {
+		data, err := findMP4Atom(r, start, end, []string{"data"})
+		if err != nil || data[1]-data[0] < 8 || data[1]-data[0] > 1<<16 {
+			return nil
+		}
+		buf := make([]byte, data[1]-data[0])
+		r.Seek(data[0], io.SeekStart)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil
+		}
+		value := buf[8:] // type indicator + locale
+		text := strings.TrimSpace(string(value))
+		switch kind {
+		case "\xa9nam":
+			meta.Title = text
+		case "\xa9ART":
+			meta.Artist = text
+		case "\xa9alb":
+			meta.Album = text
+		case "\xa9gen":
+			meta.Genre = text
+		case "gnre":
+			if len(value) >= 2 {
+				if n := int(binary.BigEndian.Uint16(value)) - 1; n >= 0 && n < len(id3Genres) {
+					meta.Genre = id3Genres[n]
+				}
+			}
+		case "\xa9day":
+			meta.Year = leadingInt(text)
+		case "tmpo":
+			if len(value) >= 2 {
+				meta.BPM = float64(binary.BigEndian.Uint16(value))
+			}
+		case "trkn":
+			if len(value) >= 4 {
+				meta.Track = int(binary.BigEndian.Uint16(value[2:]))
+			}
+		}
+		return nil
+	})
+}
+
+// findMP4Atom follows a path of nested atoms and returns the [start, end) of the last one's payload
+func findMP4Atom(r io.ReadSeeker, start, end int64, path []string) ([2]int64, error) {
+	var found [2]int64
+	var match bool
+	err := walkMP4Atoms(r, start, end, func(kind string, s, e int64) error {
+		if match || kind != path[0] {
+			return nil
+		}
+		if kind == "meta" {
+			s += 4 // meta is a full box with version and flags before its children
+		}
+		if len(path) == 1 {
+			found, match = [2]int64{s, e}, true
+			return nil
+		}
+		if inner, err := findMP4Atom(r, s, e, path[1:]); err == nil {
+			found, match = inner, true
+		}
+		return nil
+	})
+	if err != nil {
+		return found, err
+	}
+	if !match {
+		return found, errNoTags
+	}
+	return found, nil
+}
+
+func walkMP4Atoms(r io.ReadSeeker, start, end int64, fn func(kind string, start, end int64) error) error {
+	header := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerLen = 16
+		}
+		if size < headerLen || pos+size > end {
+			return nil
+		}
+		if err := fn(kind, pos+headerLen, pos+size); err != nil {
+			return err
+		}
+		pos += size
+	}
+	return nil
+}
+
+// WAV: LIST/INFO chunks and embedded ID3 chunks
+
+func readWAVTags(r io.ReadSeeker, meta *TrackMeta) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return errNoTags
+	}
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		next := size + size%2
+		switch {
+		case id == "LIST" && size >= 4 && size < 1<<20:
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return err
+			}
+			if string(body[:4]) == "INFO" {
+				parseRIFFInfo(body[4:], meta)
+			}
+			next -= size
+		case (id == "id3 " || id == "ID3 ") && size >= 10:
+			id3 := make([]byte, 10)
+			if _, err := io.ReadFull(r, id3); err != nil {
+				return err
+			}
+			if string(id3[:3]) == "ID3" {
+				parseID3v2(id3, r, meta)
+			}
+			return nil
+		}
+		if _, err := r.Seek(next, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+}
+
+func parseRIFFInfo(b []byte, meta *TrackMeta) {
+	for len(b) >= 8 {
+		id := string(b[:4])
+		size := int(binary.LittleEndian.Uint32(b[4:8]))
+		if 8+size > len(b) {
+			return
+		}
+		value := strings.TrimSpace(strings.TrimRight(string(b[8:8+size]), "\x00"))
+		switch id {
+		case "INAM":
+			meta.Title = value
+		case "IART":
+			meta.Artist = value
+		case "IPRD":
+			meta.Album = value
+		case "IGNR":
+			meta.Genre = value
+		case "ICRD":
+			meta.Year = leadingInt(value)
+		case "ITRK", "IPRT":
+			meta.Track = leadingInt(value)
+		}
+		b = b[8+size+size%2:]
+	}
+}
+
+// leadingInt parses "3/12" or "2019-04-01" style values into their first number
+func leadingInt(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus's default buckets, in seconds
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+	for i, le := range durationBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type requestKey struct {
+	route, method string
+	status        int
+}
+
+// Metrics is what /metrics reports, kept in memory since the process started
+type Metrics struct {
+	mu            sync.Mutex
+	requests      map[requestKey]uint64
+	durations     map[string]*histogram // By route
+	bytesServed   map[string]uint64     // By kind: audio or other
+	activeStreams int
+	scans         histogram
+	libraryFiles  int
+	cacheHits     uint64
+	cacheMisses   uint64
+	jobsQueued    map[string]int // By kind
+}
+
+var metrics = &Metrics{
+	requests:    map[requestKey]uint64{},
+	durations:   map[string]*histogram{},
+	bytesServed: map[string]uint64{},
+}
+
+// metricsRoute labels r by the pattern it matched rather than its path, so IDs and tokens
+// don't turn into an unbounded number of series
+func metricsRoute(r *http.Request) string {
+	_, pattern := http.DefaultServeMux.Handler(r)
+	if pattern == "" {
+		return "unmatched"
+	}
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
+func (m *Metrics) StreamStarted() {
+	m.mu.Lock()
+	m.activeStreams++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) StreamEnded() {
+	m.mu.Lock()
+	m.activeStreams--
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ObserveRequest(r *http.Request, status int, bytes int64, elapsed time.Duration) {
+	route := metricsRoute(r)
+	kind := "other"
+	if isStreamRequest(r) {
+		kind = "audio"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{route, r.Method, status}]++
+	h, ok := m.durations[route]
+	if !ok {
+		h = &histogram{}
+		m.durations[route] = h
+	}
+	h.observe(elapsed.Seconds())
+	m.bytesServed[kind] += uint64(bytes)
+}
+
+func (m *Metrics) ObserveScan(files int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scans.observe(elapsed.Seconds())
+	m.libraryFiles = files
+}
+
+// SetJobsQueued records how many jobs of each kind are waiting to start
+func (m *Metrics) SetJobsQueued(counts map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobsQueued = counts
+}
+
+func (m *Metrics) ObserveCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+func registerMetricsRoutes() {
+	handleFunc("GET /metrics", requireRole(roleAdmin, serveMetrics))
+}
+
+// serveMetrics writes the Prometheus text exposition format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m := metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP beatgraze_http_requests_total Requests handled, by route, method and status.\n")
+	b.WriteString("# TYPE beatgraze_http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "beatgraze_http_requests_total{route=%q,method=%q,status=\"%d\"} %d\n", k.route, k.method, k.status, m.requests[k])
+	}
+
+	b.WriteString("# HELP beatgraze_http_request_duration_seconds Time to finish a request, including streaming the response.\n")
+	b.WriteString("# TYPE beatgraze_http_request_duration_seconds histogram\n")
+	routes := make([]string, 0, len(m.durations))
+	for route := range m.durations {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		writeHistogram(&b, "beatgraze_http_request_duration_seconds", fmt.Sprintf("route=%q,", route), m.durations[route])
+	}
+
+	b.WriteString("# HELP beatgraze_active_streams Audio responses currently being sent.\n")
+	b.WriteString("# TYPE beatgraze_active_streams gauge\n")
+	fmt.Fprintf(&b, "beatgraze_active_streams %d\n", m.activeStreams)
+
+	b.WriteString("# HELP beatgraze_bytes_served_total Response body bytes sent, by kind.\n")
+	b.WriteString("# TYPE beatgraze_bytes_served_total counter\n")
+	for _, kind := range []string{"audio", "other"} {
+		fmt.Fprintf(&b, "beatgraze_bytes_served_total{kind=%q} %d\n", kind, m.bytesServed[kind])
+	}
+
+	b.WriteString("# HELP beatgraze_library_scan_duration_seconds Time taken to walk the library.\n")
+	b.WriteString("# TYPE beatgraze_library_scan_duration_seconds histogram\n")
+	writeHistogram(&b, "beatgraze_library_scan_duration_seconds", "", &m.scans)
+
+	b.WriteString("# HELP beatgraze_library_files Audio files found by the latest scan.\n")
+	b.WriteString("# TYPE beatgraze_library_files gauge\n")
+	fmt.Fprintf(&b, "beatgraze_library_files %d\n", m.libraryFiles)
+
+	b.WriteString("# HELP beatgraze_jobs_queued Background jobs waiting to start, by kind.\n")
+	b.WriteString("# TYPE beatgraze_jobs_queued gauge\n")
+	kinds := make([]string, 0, len(jobKinds))
+	for kind := range jobKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "beatgraze_jobs_queued{kind=%q} %d\n", kind, m.jobsQueued[kind])
+	}
+
+	b.WriteString("# HELP beatgraze_transcodes_queued Conversions waiting for ffmpeg.\n")
+	b.WriteString("# TYPE beatgraze_transcodes_queued gauge\n")
+	fmt.Fprintf(&b, "beatgraze_transcodes_queued %d\n", m.jobsQueued["convert"])
+
+	if diskCache != nil {
+		b.WriteString("# HELP beatgraze_cache_requests_total Remote file blocks looked up in the disk cache, by result.\n")
+		b.WriteString("# TYPE beatgraze_cache_requests_total counter\n")
+		fmt.Fprintf(&b, "beatgraze_cache_requests_total{result=\"hit\"} %d\n", m.cacheHits)
+		fmt.Fprintf(&b, "beatgraze_cache_requests_total{result=\"miss\"} %d\n", m.cacheMisses)
+		b.WriteString("# HELP beatgraze_cache_bytes Bytes held in the disk cache.\n")
+		b.WriteString("# TYPE beatgraze_cache_bytes gauge\n")
+		fmt.Fprintf(&b, "beatgraze_cache_bytes %d\n", diskCache.Size())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// writeHistogram writes cumulative buckets; labels is either empty or ends with a comma
+func writeHistogram(b *strings.Builder, name, labels string, h *histogram) {
+	var cumulative uint64
+	for i, le := range durationBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(b, "%s_bucket{%sle=%q} %d\n", name, labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(b, "%s_count%s %d\n", name, labels, h.count)
+}
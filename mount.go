@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// mountList is -mount: other beatgraze servers whose libraries show up here as read-only
+// folders, as comma-separated name=https://key@host/path entries. The key is an API key on
+// that server; streams from it are proxied through this one.
+var mountList string
+
+// mountStorage is the library plus the mounted servers, each as a top-level folder
+type mountStorage struct {
+	Storage
+	mounts map[string]*remoteStorage
+}
+
+// withMounts adds the servers in -mount to storage
+func withMounts(storage Storage) (Storage, error) {
+	if strings.TrimSpace(mountList) == "" {
+		return storage, nil
+	}
+	m := &mountStorage{Storage: storage, mounts: map[string]*remoteStorage{}}
+	for _, entry := range strings.Split(mountList, ",") {
+		name, rawURL, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return nil, fmt.Errorf("invalid -mount %q, expected name=https://key@host/path", redactedLibrary(entry))
+		}
+		if m.mounts[name] != nil {
+			return nil, fmt.Errorf("-mount %s is given twice", name)
+		}
+		remote, err := newBeatgrazeStorage(name, rawURL)
+		if err != nil {
+			return nil, err
+		}
+		m.mounts[name] = remote
+	}
+	return m, nil
+}
+
+// split finds the mount a library path is in, with the path within it
+func (m *mountStorage) split(relPath string) (*remoteStorage, string, bool) {
+	clean, err := remoteCleanPath(relPath)
+	if err != nil {
+		return nil, "", false
+	}
+	name, rest, _ := strings.Cut(clean, "/")
+	remote, ok := m.mounts[name]
+	return remote, rest, ok
+}
+
+func (m *mountStorage) Open(relPath string) (File, error) {
+	if remote, rest, ok := m.split(relPath); ok {
+		return remote.Open(rest)
+	}
+	return m.Storage.Open(relPath)
+}
+
+func (m *mountStorage) Stat(relPath string) (fs.FileInfo, error) {
+	if remote, rest, ok := m.split(relPath); ok {
+		return remote.Stat(rest)
+	}
+	return m.Storage.Stat(relPath)
+}
+
+// Walk goes through the library and then each mount. A server that can't be reached is
+// left out rather than failing the whole scan.
+func (m *mountStorage) Walk(fn func(path string, info fs.FileInfo) error) error {
+	if err := m.Storage.Walk(func(p string, info fs.FileInfo) error {
+		if _, _, ok := m.split(p); ok {
+			return nil // Hidden by the mount
+		}
+		return fn(p, info)
+	}); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(m.mounts))
+	for name := range m.mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := m.mounts[name].Walk(func(p string, info fs.FileInfo) error {
+			return fn(name+"/"+p, info)
+		})
+		if err != nil {
+			slog.Warn("Error listing mounted library", "mount", name, "err", err)
+		}
+	}
+	return nil
+}
+
+func (m *mountStorage) Watch(ctx context.Context, onChange func()) error {
+	for _, remote := range m.mounts {
+		go remote.Watch(ctx, onChange)
+	}
+	return m.Storage.Watch(ctx, onChange)
+}
+
+// isMounted reports whether a library path is on a mounted server
+func isMounted(relPath string) bool {
+	m, ok := library.Load().(*mountStorage)
+	if !ok {
+		return false
+	}
+	_, _, ok = m.split(relPath)
+	return ok
+}
+
+// ownLibrary is the library without any mounts, for what should only cover this server's
+// own files
+func ownLibrary() Storage {
+	storage := library.Load()
+	if m, ok := storage.(*mountStorage); ok {
+		return m.Storage
+	}
+	return storage
+}
+
+// beatgrazeClient reads another beatgraze server's library through its API
+type beatgrazeClient struct {
+	base   *url.URL // Always ends with "/"
+	key    string
+	client *http.Client
+}
+
+func newBeatgrazeStorage(name, rawURL string) (*remoteStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid -mount %s location %q, expected https://key@host/path", name, redactedLibrary(rawURL))
+	}
+	c := &beatgrazeClient{client: &http.Client{}}
+	if u.User != nil {
+		c.key = u.User.Username()
+	}
+	c.base = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/"}
+	return &remoteStorage{id: c.base.String(), name: name, list: c.list, read: c.read}, nil
+}
+
+func (c *beatgrazeClient) get(ref string, header http.Header) (*http.Response, error) {
+	target, err := c.base.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	req.Header.Set("User-Agent", "beatgraze/"+versionInfo().Version)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s from %s", resp.Status, c.base.Redacted())
+	}
+	return resp, nil
+}
+
+// list reads the server's manifest, which covers its own files but not what it mounts, so
+// two servers can mount each other
+func (c *beatgrazeClient) list() (map[string]remoteFileInfo, error) {
+	resp, err := c.get("api/sync/manifest", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var manifest struct {
+		Files []SyncFile `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	files := make(map[string]remoteFileInfo, len(manifest.Files))
+	for _, f := range manifest.Files {
+		files[f.Path] = remoteFileInfo{name: path.Base(f.Path), size: f.Size, modTime: f.Modified}
+	}
+	return files, nil
+}
+
+func (c *beatgrazeClient) read(relPath string, offset int64) (io.ReadCloser, error) {
+	segments := strings.Split(relPath, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	resp, err := c.get("audio/"+strings.Join(segments, "/"), header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		resp.Body.Close()
+		return nil, errors.New("mounted server ignored the range request")
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,1519 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mpdAddr is where MPD clients like ncmpcpp and MALP connect, e.g. 127.0.0.1:6600; off when empty
+var mpdAddr string
+
+// mpdProtocolVersion is what clients are told on connecting, which decides the commands they try
+const mpdProtocolVersion = "0.23.0"
+
+// MPD's ACK codes
+const (
+	mpdErrArg        = 2
+	mpdErrPassword   = 3
+	mpdErrPermission = 4
+	mpdErrUnknown    = 5
+	mpdErrNoExist    = 50
+	mpdErrSystem     = 52
+)
+
+type mpdError struct {
+	code    int
+	message string
+}
+
+func (e *mpdError) Error() string {
+	return e.message
+}
+
+func mpdErr(code int, format string, args ...any) error {
+	return &mpdError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+type mpdCommand struct {
+	role Role // Least role that may run it; "" for commands allowed before the password
+	run  func(c *mpdConn, args []string) error
+}
+
+// mpdCommands is filled in by init, since "commands" lists it
+var mpdCommands map[string]mpdCommand
+
+func init() {
+	mpdCommands = map[string]mpdCommand{
+		"ping":        {"", func(c *mpdConn, args []string) error { return nil }},
+		"password":    {"", (*mpdConn).password},
+		"commands":    {"", (*mpdConn).commands},
+		"notcommands": {"", (*mpdConn).notCommands},
+		"binarylimit": {"", (*mpdConn).setBinaryLimit},
+		"tagtypes":    {"", (*mpdConn).tagTypes},
+
+		"status":       {roleGuest, (*mpdConn).status},
+		"stats":        {roleGuest, (*mpdConn).stats},
+		"currentsong":  {roleGuest, (*mpdConn).currentSong},
+		"playlistinfo": {roleGuest, (*mpdConn).playlistInfo},
+		"playlistid":   {roleGuest, (*mpdConn).playlistID},
+		// Every song is sent as changed, whichever version the client last saw
+		"plchanges":      {roleGuest, func(c *mpdConn, args []string) error { return c.playlistInfo(nil) }},
+		"plchangesposid": {roleGuest, (*mpdConn).playlistChangesPosID},
+		"outputs":        {roleGuest, (*mpdConn).outputs},
+		"decoders":       {roleGuest, func(c *mpdConn, args []string) error { return nil }},
+		"urlhandlers":    {roleGuest, func(c *mpdConn, args []string) error { return nil }},
+		"replay_gain_status": {roleGuest, func(c *mpdConn, args []string) error {
+			c.line("replay_gain_mode", "off")
+			return nil
+		}},
+		"getvol":           {roleGuest, (*mpdConn).getVolume},
+		"lsinfo":           {roleGuest, (*mpdConn).lsInfo},
+		"listall":          {roleGuest, func(c *mpdConn, args []string) error { return c.listAll(args, false) }},
+		"listallinfo":      {roleGuest, func(c *mpdConn, args []string) error { return c.listAll(args, true) }},
+		"find":             {roleGuest, func(c *mpdConn, args []string) error { return c.find(args, false) }},
+		"search":           {roleGuest, func(c *mpdConn, args []string) error { return c.find(args, true) }},
+		"count":            {roleGuest, (*mpdConn).count},
+		"list":             {roleGuest, (*mpdConn).list},
+		"albumart":         {roleGuest, (*mpdConn).albumArt},
+		"readpicture":      {roleGuest, func(c *mpdConn, args []string) error { return nil }},
+		"listplaylists":    {roleGuest, (*mpdConn).listPlaylists},
+		"listplaylist":     {roleGuest, func(c *mpdConn, args []string) error { return c.listPlaylist(args, false) }},
+		"listplaylistinfo": {roleGuest, func(c *mpdConn, args []string) error { return c.listPlaylist(args, true) }},
+
+		"add":           {roleListener, (*mpdConn).add},
+		"addid":         {roleListener, (*mpdConn).addID},
+		"load":          {roleListener, (*mpdConn).load},
+		"delete":        {roleListener, (*mpdConn).delete},
+		"deleteid":      {roleListener, (*mpdConn).deleteID},
+		"clear":         {roleListener, func(c *mpdConn, args []string) error { jukebox.Clear(); return nil }},
+		"move":          {roleListener, (*mpdConn).move},
+		"moveid":        {roleListener, (*mpdConn).moveID},
+		"shuffle":       {roleListener, func(c *mpdConn, args []string) error { jukebox.Shuffle(); return nil }},
+		"play":          {roleListener, (*mpdConn).play},
+		"playid":        {roleListener, (*mpdConn).playID},
+		"pause":         {roleListener, (*mpdConn).pause},
+		"stop":          {roleListener, func(c *mpdConn, args []string) error { jukebox.Stop(); return nil }},
+		"next":          {roleListener, func(c *mpdConn, args []string) error { return jukebox.Next() }},
+		"previous":      {roleListener, func(c *mpdConn, args []string) error { return jukebox.Previous() }},
+		"seek":          {roleListener, (*mpdConn).seek},
+		"seekid":        {roleListener, (*mpdConn).seekID},
+		"seekcur":       {roleListener, (*mpdConn).seekCurrent},
+		"setvol":        {roleListener, (*mpdConn).setVolume},
+		"volume":        {roleListener, (*mpdConn).changeVolume},
+		"repeat":        {roleListener, func(c *mpdConn, args []string) error { return c.option("repeat", args) }},
+		"random":        {roleListener, func(c *mpdConn, args []string) error { return c.option("random", args) }},
+		"single":        {roleListener, func(c *mpdConn, args []string) error { return c.option("single", args) }},
+		"consume":       {roleListener, func(c *mpdConn, args []string) error { return c.option("consume", args) }},
+		"enableoutput":  {roleListener, (*mpdConn).output},
+		"disableoutput": {roleListener, (*mpdConn).output},
+		"toggleoutput":  {roleListener, (*mpdConn).output},
+		"update":        {roleAdmin, (*mpdConn).update},
+		"rescan":        {roleAdmin, (*mpdConn).update},
+	}
+}
+
+// serveMPD answers MPD clients, which drive the jukebox and browse the library
+func serveMPD(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("MPD listener stopped", "err", err)
+		return
+	}
+	slog.Info("Serving MPD clients", "addr", ln.Addr().String())
+	go func() {
+		<-shuttingDown.Done()
+		ln.Close()
+		jukebox.Stop()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if shuttingDown.Err() == nil {
+				slog.Error("MPD listener stopped", "err", err)
+			}
+			return
+		}
+		go newMPDConn(conn).serve()
+	}
+}
+
+// mpdConn is one client connection
+type mpdConn struct {
+	conn net.Conn
+	w    *bufio.Writer
+	// r carries the signed-in account, so the helpers HTTP handlers use for roles, stats
+	// and playlists work here too
+	r           *http.Request
+	authed      bool
+	keyReadOnly bool // Signed in with a read-only API key
+	binaryLimit int
+	changed     map[string]bool // Subsystems that changed since the client last idled
+}
+
+func newMPDConn(conn net.Conn) *mpdConn {
+	r := (&http.Request{
+		Method:     "MPD",
+		URL:        &url.URL{Path: "/mpd"},
+		Header:     http.Header{},
+		RemoteAddr: conn.RemoteAddr().String(),
+	}).WithContext(context.Background())
+	return &mpdConn{
+		conn:        conn,
+		w:           bufio.NewWriter(conn),
+		r:           r,
+		authed:      authCredentials == "" && authToken == "" && users.Count() == 0 && !oidcEnabled(),
+		binaryLimit: 8192,
+		changed:     map[string]bool{},
+	}
+}
+
+func (c *mpdConn) serve() {
+	defer c.conn.Close()
+	ip := clientIP(c.r)
+	if !currentAccess().allows(ip) {
+		return
+	}
+
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(c.conn)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	player, stopPlayer := jukeboxChanges.Watch()
+	defer stopPlayer()
+	libraryEvents, stopLibraryEvents := events.Watch()
+	defer stopLibraryEvents()
+	// Changes are gathered all the time, since MPD reports what happened between idles
+	gather := func() {
+		for {
+			select {
+			case s := <-player:
+				c.changed[s] = true
+			case e := <-libraryEvents:
+				if strings.HasPrefix(e.Type, "files.") {
+					c.changed["database"] = true
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	fmt.Fprintf(c.w, "OK MPD %s\n", mpdProtocolVersion)
+	c.w.Flush()
+	for {
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		case <-shuttingDown.Done():
+			return
+		}
+		if !ok {
+			return
+		}
+		gather()
+		name, args, err := mpdSplit(line)
+		switch {
+		case err != nil:
+			c.ack(0, "", err)
+		case name == "close":
+			return
+		case name == "idle":
+			if !c.idle(args, lines, player, libraryEvents) {
+				return
+			}
+		case name == "command_list_begin" || name == "command_list_ok_begin":
+			var list []string
+			for {
+				if line, ok = <-lines; !ok {
+					return
+				}
+				if line == "command_list_end" {
+					break
+				}
+				list = append(list, line)
+			}
+			c.runList(list, name == "command_list_ok_begin")
+		default:
+			if err := c.run(name, args); err != nil {
+				c.ack(0, name, err)
+			} else {
+				c.w.WriteString("OK\n")
+			}
+		}
+		if c.w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (c *mpdConn) runList(list []string, listOK bool) {
+	for i, line := range list {
+		name, args, err := mpdSplit(line)
+		if err == nil {
+			err = c.run(name, args)
+		}
+		if err != nil {
+			c.ack(i, name, err)
+			return
+		}
+		if listOK {
+			c.w.WriteString("list_OK\n")
+		}
+	}
+	c.w.WriteString("OK\n")
+}
+
+// idle waits for one of the subsystems in args, or any when there are none, to change. It
+// returns false if the client went away.
+func (c *mpdConn) idle(args []string, lines <-chan string, player <-chan string, libraryEvents <-chan Event) bool {
+	wanted := func(s string) bool {
+		if len(args) == 0 {
+			return true
+		}
+		for _, a := range args {
+			if a == s {
+				return true
+			}
+		}
+		return false
+	}
+	report := func() bool {
+		found := false
+		for s := range c.changed {
+			if wanted(s) {
+				c.line("changed", s)
+				delete(c.changed, s)
+				found = true
+			}
+		}
+		return found
+	}
+	for {
+		if report() {
+			c.w.WriteString("OK\n")
+			return true
+		}
+		select {
+		case s := <-player:
+			c.changed[s] = true
+		case e := <-libraryEvents:
+			if strings.HasPrefix(e.Type, "files.") {
+				c.changed["database"] = true
+			}
+		case line, ok := <-lines:
+			if !ok || line != "noidle" {
+				// Anything but noidle while idling is a protocol error, which ends the connection
+				return false
+			}
+			c.w.WriteString("OK\n")
+			return true
+		case <-shuttingDown.Done():
+			return false
+		}
+	}
+}
+
+func (c *mpdConn) run(name string, args []string) error {
+	cmd, ok := mpdCommands[name]
+	if !ok {
+		return mpdErr(mpdErrUnknown, "unknown command %q", name)
+	}
+	if !c.allowed(cmd) {
+		return mpdErr(mpdErrPermission, "you don't have permission for %q", name)
+	}
+	err := cmd.run(c, args)
+	var me *mpdError
+	switch {
+	case err == nil, errors.As(err, &me):
+		return err
+	case errors.Is(err, errJukeboxNoTrack):
+		return mpdErr(mpdErrArg, "Bad song index")
+	case errors.Is(err, errJukeboxEmpty):
+		return mpdErr(mpdErrNoExist, "%v", err)
+	}
+	return mpdErr(mpdErrSystem, "%v", err)
+}
+
+func (c *mpdConn) allowed(cmd mpdCommand) bool {
+	if cmd.role == "" {
+		return true
+	}
+	if !c.authed || c.keyReadOnly && cmd.role != roleGuest {
+		return false
+	}
+	return hasRole(c.r, cmd.role)
+}
+
+func (c *mpdConn) ack(index int, name string, err error) {
+	code := mpdErrSystem
+	var me *mpdError
+	if errors.As(err, &me) {
+		code = me.code
+	}
+	fmt.Fprintf(c.w, "ACK [%d@%d] {%s} %s\n", code, index, name, err)
+}
+
+func (c *mpdConn) line(key string, value any) {
+	fmt.Fprintf(c.w, "%s: %v\n", key, value)
+}
+
+// mpdSplit parses a command line: words separated by spaces, or double-quoted with
+// backslash escapes
+func mpdSplit(line string) (string, []string, error) {
+	var words []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+			continue
+		case '"':
+			var b strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return "", nil, mpdErr(mpdErrArg, "Missing closing '\"'")
+			}
+			i++
+			words = append(words, b.String())
+		default:
+			start := i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+			words = append(words, line[start:i])
+		}
+	}
+	if len(words) == 0 {
+		return "", nil, mpdErr(mpdErrUnknown, "No command given")
+	}
+	return words[0], words[1:], nil
+}
+
+// mpdArgs checks how many arguments a command got
+func mpdArgs(args []string, least, most int) error {
+	if len(args) < least || len(args) > most {
+		return mpdErr(mpdErrArg, "wrong number of arguments")
+	}
+	return nil
+}
+
+func mpdInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, mpdErr(mpdErrArg, "Integer expected: %s", s)
+	}
+	return n, nil
+}
+
+func mpdSeconds(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, mpdErr(mpdErrArg, "Number expected: %s", s)
+	}
+	return f, nil
+}
+
+// mpdRange parses POS or START:END, where a missing END runs to max
+func mpdRange(s string, max int) (int, int, error) {
+	startText, endText, isRange := strings.Cut(s, ":")
+	start, err := mpdInt(startText)
+	if err != nil || start < 0 {
+		return 0, 0, mpdErr(mpdErrArg, "Bad range: %s", s)
+	}
+	end := start + 1
+	if isRange {
+		end = max
+		if endText != "" {
+			if end, err = mpdInt(endText); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if end < start {
+		return 0, 0, mpdErr(mpdErrArg, "Bad range: %s", s)
+	}
+	return start, end, nil
+}
+
+func (c *mpdConn) password(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	secret := args[0]
+	name, pass, _ := strings.Cut(secret, ":")
+	if strings.HasPrefix(secret, apiKeyPrefix) {
+		name = ""
+	}
+	if wait := loginGuard.Locked(clientIP(c.r), name); wait > 0 {
+		return mpdErr(mpdErrPassword, "too many failed attempts, try again in %ds", int(wait.Seconds())+1)
+	}
+	switch {
+	case authCredentials == "" && authToken == "" && users.Count() == 0:
+		// Nothing to sign in to
+		return nil
+	case strings.HasPrefix(secret, apiKeyPrefix):
+		if key, u, ok := users.LookupAPIKey(secret); ok {
+			c.signIn(u, key.Scope != scopeReadWrite)
+			return nil
+		}
+	case authToken != "" && secureCompare(secret, authToken),
+		authCredentials != "" && secureCompare(secret, authCredentials):
+		c.signIn(nil, false)
+		return nil
+	default:
+		if u, ok := users.Authenticate(name, pass); ok {
+			loginGuard.Succeed(name)
+			c.signIn(&u, false)
+			return nil
+		}
+	}
+	authFailed(c.r, "mpd", name)
+	return mpdErr(mpdErrPassword, "incorrect password")
+}
+
+func (c *mpdConn) signIn(u *User, readOnlyKey bool) {
+	c.r = c.r.WithContext(context.WithValue(c.r.Context(), userContextKey, u))
+	c.authed, c.keyReadOnly = true, readOnlyKey
+}
+
+func (c *mpdConn) commands(args []string) error {
+	for _, name := range c.commandNames(true) {
+		c.line("command", name)
+	}
+	return nil
+}
+
+func (c *mpdConn) notCommands(args []string) error {
+	for _, name := range c.commandNames(false) {
+		c.line("command", name)
+	}
+	return nil
+}
+
+func (c *mpdConn) commandNames(allowed bool) []string {
+	var names []string
+	for name, cmd := range mpdCommands {
+		if c.allowed(cmd) == allowed {
+			names = append(names, name)
+		}
+	}
+	if allowed {
+		names = append(names, "close", "idle", "noidle", "command_list_begin", "command_list_ok_begin", "command_list_end")
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *mpdConn) setBinaryLimit(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	n, err := mpdInt(args[0])
+	if err != nil || n < 64 || n > 1<<20 {
+		return mpdErr(mpdErrArg, "Value too small or too large")
+	}
+	c.binaryLimit = n
+	return nil
+}
+
+// mpdTagTypes are the tags songs are described with
+var mpdTagTypes = []string{"Artist", "Album", "Title", "Track", "Genre", "Date"}
+
+func (c *mpdConn) tagTypes(args []string) error {
+	// Changing which tags are sent isn't supported, but clients may ask
+	if len(args) > 0 {
+		return nil
+	}
+	for _, t := range mpdTagTypes {
+		c.line("tagtype", t)
+	}
+	return nil
+}
+
+// mpdTag reads a tag by the name MPD uses for it
+func mpdTag(f libraryFile, meta TrackMeta, tag string) string {
+	switch strings.ToLower(tag) {
+	case "artist", "albumartist", "artistsort", "albumartistsort":
+		return meta.Artist
+	case "album", "albumsort":
+		return meta.Album
+	case "title":
+		return meta.Title
+	case "genre":
+		return meta.Genre
+	case "track":
+		if meta.Track > 0 {
+			return strconv.Itoa(meta.Track)
+		}
+	case "date", "originaldate":
+		if meta.Year > 0 {
+			return strconv.Itoa(meta.Year)
+		}
+	case "file":
+		return f.Path
+	}
+	return ""
+}
+
+func (c *mpdConn) song(f libraryFile) {
+	meta := trackMeta(f)
+	c.line("file", f.Path)
+	c.line("Last-Modified", f.ModTime.UTC().Format(time.RFC3339))
+	for _, t := range mpdTagTypes {
+		if v := mpdTag(f, meta, t); v != "" {
+			c.line(t, v)
+		}
+	}
+	if meta.Duration > 0 {
+		c.line("Time", int(meta.Duration+0.5))
+		c.line("duration", fmt.Sprintf("%.3f", meta.Duration))
+	}
+}
+
+func (c *mpdConn) queueItem(pos int, item JukeboxItem) {
+	if f, err := libraryFileAt(item.Path); err == nil {
+		c.song(f)
+	} else {
+		c.line("file", item.Path)
+	}
+	c.line("Pos", pos)
+	c.line("Id", item.ID)
+}
+
+func (c *mpdConn) status(args []string) error {
+	s := jukebox.Status()
+	flag := func(on bool) int {
+		if on {
+			return 1
+		}
+		return 0
+	}
+	c.line("volume", s.Volume)
+	c.line("repeat", flag(s.Repeat))
+	c.line("random", flag(s.Random))
+	c.line("single", flag(s.Single))
+	c.line("consume", flag(s.Consume))
+	c.line("playlist", s.Version)
+	c.line("playlistlength", s.Length)
+	c.line("state", s.State)
+	if s.Current >= 0 {
+		c.line("song", s.Current)
+		c.line("songid", s.CurrentID)
+		var duration float64
+		if q := jukebox.Queue(); s.Current < len(q) {
+			if f, err := libraryFileAt(q[s.Current].Path); err == nil {
+				duration = trackMeta(f).Duration
+			}
+		}
+		if s.State != "stop" {
+			c.line("time", fmt.Sprintf("%d:%d", int(s.Elapsed), int(duration+0.5)))
+			c.line("elapsed", fmt.Sprintf("%.3f", s.Elapsed))
+			c.line("duration", fmt.Sprintf("%.3f", duration))
+		}
+	}
+	return nil
+}
+
+func (c *mpdConn) stats(args []string) error {
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	artists, albums := map[string]bool{}, map[string]bool{}
+	var playtime float64
+	var updated time.Time
+	for _, f := range files {
+		meta := trackMeta(f)
+		if meta.Artist != "" {
+			artists[meta.Artist] = true
+		}
+		if meta.Album != "" {
+			albums[meta.Artist+"\x00"+meta.Album] = true
+		}
+		playtime += meta.Duration
+		if f.ModTime.After(updated) {
+			updated = f.ModTime
+		}
+	}
+	c.line("artists", len(artists))
+	c.line("albums", len(albums))
+	c.line("songs", len(files))
+	c.line("uptime", int(time.Since(startTime).Seconds()))
+	c.line("db_playtime", int(playtime))
+	c.line("db_update", updated.Unix())
+	c.line("playtime", 0)
+	return nil
+}
+
+func (c *mpdConn) currentSong(args []string) error {
+	s := jukebox.Status()
+	if q := jukebox.Queue(); s.Current >= 0 && s.Current < len(q) {
+		c.queueItem(s.Current, q[s.Current])
+	}
+	return nil
+}
+
+func (c *mpdConn) playlistInfo(args []string) error {
+	if err := mpdArgs(args, 0, 1); err != nil {
+		return err
+	}
+	q := jukebox.Queue()
+	start, end := 0, len(q)
+	if len(args) == 1 {
+		var err error
+		if start, end, err = mpdRange(args[0], len(q)); err != nil {
+			return err
+		}
+		if start >= len(q) {
+			return mpdErr(mpdErrArg, "Bad song index")
+		}
+		end = min(end, len(q))
+	}
+	for i := start; i < end; i++ {
+		c.queueItem(i, q[i])
+	}
+	return nil
+}
+
+func (c *mpdConn) playlistID(args []string) error {
+	if err := mpdArgs(args, 0, 1); err != nil {
+		return err
+	}
+	q := jukebox.Queue()
+	for i, item := range q {
+		if len(args) == 0 || args[0] == strconv.Itoa(item.ID) {
+			c.queueItem(i, item)
+			if len(args) > 0 {
+				return nil
+			}
+		}
+	}
+	if len(args) > 0 {
+		return mpdErr(mpdErrNoExist, "No such song")
+	}
+	return nil
+}
+
+func (c *mpdConn) playlistChangesPosID(args []string) error {
+	for i, item := range jukebox.Queue() {
+		c.line("cpos", i)
+		c.line("Id", item.ID)
+	}
+	return nil
+}
+
+func (c *mpdConn) outputs(args []string) error {
+	c.line("outputid", 0)
+	if snapcastTarget != "" {
+		c.line("outputname", "beatgraze snapcast")
+		c.line("plugin", "snapcast")
+	} else {
+		c.line("outputname", "beatgraze jukebox")
+		c.line("plugin", jukeboxPlayer)
+	}
+	c.line("outputenabled", 1)
+	return nil
+}
+
+// output accepts enabling and disabling the one output, which is always on
+func (c *mpdConn) output(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	if args[0] != "0" {
+		return mpdErr(mpdErrNoExist, "No such audio output")
+	}
+	return nil
+}
+
+func (c *mpdConn) getVolume(args []string) error {
+	c.line("volume", jukebox.Status().Volume)
+	return nil
+}
+
+func (c *mpdConn) setVolume(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	v, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	if v < 0 || v > 100 {
+		return mpdErr(mpdErrArg, "Invalid volume value")
+	}
+	return jukebox.SetVolume(v)
+}
+
+func (c *mpdConn) changeVolume(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	change, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	return jukebox.SetVolume(min(max(jukebox.Status().Volume+change, 0), 100))
+}
+
+func (c *mpdConn) option(name string, args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "0":
+		return jukebox.SetOption(name, false)
+	case "1", "oneshot":
+		return jukebox.SetOption(name, true)
+	}
+	return mpdErr(mpdErrArg, "Boolean (0/1) expected: %s", args[0])
+}
+
+// lookupURI finds the songs at a library path: the one track, or every track below a folder
+func lookupURI(uri string) ([]libraryFile, error) {
+	uri = strings.Trim(uri, "/")
+	if f, err := libraryFileAt(uri); err == nil {
+		return []libraryFile{f}, nil
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, err
+	}
+	var found []libraryFile
+	for _, f := range files {
+		if inFolder(f.Path, uri, true) {
+			found = append(found, f)
+		}
+	}
+	if len(found) == 0 {
+		return nil, mpdErr(mpdErrNoExist, "No such directory")
+	}
+	return found, nil
+}
+
+func (c *mpdConn) userID() string {
+	if u := currentUser(c.r); u != nil {
+		return u.ID
+	}
+	return ""
+}
+
+func (c *mpdConn) add(args []string) error {
+	if err := mpdArgs(args, 1, 2); err != nil {
+		return err
+	}
+	files, err := lookupURI(args[0])
+	if err != nil {
+		return err
+	}
+	pos := -1
+	if len(args) == 2 {
+		if pos, err = mpdInt(args[1]); err != nil {
+			return err
+		}
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	_, err = jukebox.Add(paths, c.userID(), pos)
+	return err
+}
+
+func (c *mpdConn) addID(args []string) error {
+	if err := mpdArgs(args, 1, 2); err != nil {
+		return err
+	}
+	f, err := libraryFileAt(strings.Trim(args[0], "/"))
+	if err != nil {
+		return mpdErr(mpdErrNoExist, "No such song")
+	}
+	pos := -1
+	if len(args) == 2 {
+		if pos, err = mpdInt(args[1]); err != nil {
+			return err
+		}
+	}
+	ids, err := jukebox.Add([]string{f.Path}, c.userID(), pos)
+	if err != nil {
+		return err
+	}
+	c.line("Id", ids[0])
+	return nil
+}
+
+func (c *mpdConn) delete(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	start, end, err := mpdRange(args[0], jukebox.Status().Length)
+	if err != nil {
+		return err
+	}
+	return jukebox.Delete(start, end)
+}
+
+func (c *mpdConn) deleteID(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	id, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	return jukebox.DeleteID(id)
+}
+
+func (c *mpdConn) move(args []string) error {
+	if err := mpdArgs(args, 2, 2); err != nil {
+		return err
+	}
+	from, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := mpdInt(args[1])
+	if err != nil {
+		return err
+	}
+	return jukebox.Move(from, to)
+}
+
+func (c *mpdConn) moveID(args []string) error {
+	if err := mpdArgs(args, 2, 2); err != nil {
+		return err
+	}
+	id, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := mpdInt(args[1])
+	if err != nil {
+		return err
+	}
+	return jukebox.MoveID(id, to)
+}
+
+func (c *mpdConn) play(args []string) error {
+	if err := mpdArgs(args, 0, 1); err != nil {
+		return err
+	}
+	pos := -1
+	if len(args) == 1 {
+		var err error
+		if pos, err = mpdInt(args[0]); err != nil {
+			return err
+		}
+	}
+	return jukebox.Play(pos)
+}
+
+func (c *mpdConn) playID(args []string) error {
+	if err := mpdArgs(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return jukebox.Play(-1)
+	}
+	id, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	return jukebox.PlayID(id)
+}
+
+func (c *mpdConn) pause(args []string) error {
+	if err := mpdArgs(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return jukebox.Pause(nil)
+	}
+	pause := args[0] == "1"
+	return jukebox.Pause(&pause)
+}
+
+func (c *mpdConn) seek(args []string) error {
+	if err := mpdArgs(args, 2, 2); err != nil {
+		return err
+	}
+	pos, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	seconds, err := mpdSeconds(args[1])
+	if err != nil {
+		return err
+	}
+	return jukebox.Seek(pos, max(seconds, 0))
+}
+
+func (c *mpdConn) seekID(args []string) error {
+	if err := mpdArgs(args, 2, 2); err != nil {
+		return err
+	}
+	id, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	seconds, err := mpdSeconds(args[1])
+	if err != nil {
+		return err
+	}
+	return jukebox.SeekID(id, max(seconds, 0))
+}
+
+// seekCurrent takes an absolute time, or one starting with + or - relative to now
+func (c *mpdConn) seekCurrent(args []string) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	seconds, err := mpdSeconds(args[0])
+	if err != nil {
+		return err
+	}
+	relative := strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-")
+	return jukebox.SeekCurrent(seconds, relative)
+}
+
+// update has nothing to do, since every listing reads the library as it is, but clients
+// wait for the update they started to finish
+func (c *mpdConn) update(args []string) error {
+	c.line("updating_db", 1)
+	c.changed["update"] = true
+	c.changed["database"] = true
+	return nil
+}
+
+// lsInfo lists a folder's subfolders and songs, or describes one song. The root also lists
+// the stored playlists, as older clients expect.
+func (c *mpdConn) lsInfo(args []string) error {
+	if err := mpdArgs(args, 0, 1); err != nil {
+		return err
+	}
+	uri := ""
+	if len(args) == 1 {
+		uri = strings.Trim(args[0], "/")
+	}
+	if f, err := libraryFileAt(uri); err == nil && uri != "" {
+		c.song(f)
+		return nil
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	node := buildFolderTree(files).find(uri)
+	if node == nil {
+		return mpdErr(mpdErrNoExist, "No such directory")
+	}
+	for _, sub := range node.Folders {
+		c.line("directory", sub.Path)
+	}
+	for _, f := range files {
+		if f.Dir == node.Path {
+			c.song(f)
+		}
+	}
+	if uri == "" {
+		return c.listPlaylists(nil)
+	}
+	return nil
+}
+
+func (c *mpdConn) listAll(args []string, info bool) error {
+	if err := mpdArgs(args, 0, 1); err != nil {
+		return err
+	}
+	uri := ""
+	if len(args) == 1 {
+		uri = strings.Trim(args[0], "/")
+	}
+	files, err := lookupURI(uri)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{"": true}
+	for _, f := range files {
+		for _, crumb := range folderCrumbs(f.Dir) {
+			if !seen[crumb.Path] {
+				seen[crumb.Path] = true
+				c.line("directory", crumb.Path)
+			}
+		}
+		if info {
+			c.song(f)
+		} else {
+			c.line("file", f.Path)
+		}
+	}
+	return nil
+}
+
+// mpdFilter picks songs for find, search, count and list
+type mpdFilter func(f libraryFile, meta TrackMeta) bool
+
+// parseMPDFilter reads a filter expression like "((artist == 'x') AND (album == 'y'))", or the
+// older TAG VALUE pairs, stopping at sort, window or group. fold makes matching case-insensitive
+// and pairs match substrings, the way search differs from find.
+func parseMPDFilter(args []string, fold bool) (mpdFilter, []string, error) {
+	var filters []mpdFilter
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "sort", "window", "group":
+			return allOf(filters), args, nil
+		}
+		if strings.HasPrefix(args[0], "(") {
+			p := &mpdExprParser{s: args[0], fold: fold}
+			filter, err := p.parse()
+			if err == nil && p.pos < len(p.s) {
+				err = fmt.Errorf("unparsed garbage after expression")
+			}
+			if err != nil {
+				return nil, nil, mpdErr(mpdErrArg, "%v", err)
+			}
+			filters = append(filters, filter)
+			args = args[1:]
+			continue
+		}
+		if len(args) < 2 {
+			return nil, nil, mpdErr(mpdErrArg, "Incorrect number of filter arguments")
+		}
+		op := "=="
+		if fold {
+			op = "contains"
+		}
+		filter, err := mpdCompare(args[0], op, args[1], fold)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, filter)
+		args = args[2:]
+	}
+	return allOf(filters), nil, nil
+}
+
+func allOf(filters []mpdFilter) mpdFilter {
+	return func(f libraryFile, meta TrackMeta) bool {
+		for _, filter := range filters {
+			if !filter(f, meta) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// mpdCompare matches a tag against a value; "any" checks every tag and the path, and "base"
+// matches songs in and below a folder
+func mpdCompare(tag, op, value string, fold bool) (mpdFilter, error) {
+	tag = strings.ToLower(tag)
+	if tag == "base" {
+		dir := strings.Trim(value, "/")
+		return func(f libraryFile, meta TrackMeta) bool { return inFolder(f.Path, dir, true) }, nil
+	}
+	if fold {
+		value = strings.ToLower(value)
+	}
+	var match func(s string) bool
+	switch op {
+	case "==", "!=":
+		match = func(s string) bool { return s == value }
+	case "contains":
+		match = func(s string) bool { return strings.Contains(s, value) }
+	case "starts_with":
+		match = func(s string) bool { return strings.HasPrefix(s, value) }
+	case "=~", "!~":
+		pattern := value
+		if fold {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, mpdErr(mpdErrArg, "Invalid regular expression: %v", err)
+		}
+		match = re.MatchString
+	default:
+		return nil, mpdErr(mpdErrArg, "Unknown filter operator %q", op)
+	}
+	negate := op == "!=" || op == "!~"
+	var tags []string
+	switch tag {
+	case "any":
+		tags = append([]string{"file"}, mpdTagTypes...)
+	case "file", "artist", "albumartist", "artistsort", "albumartistsort", "album", "albumsort", "title", "genre", "track", "date", "originaldate":
+		tags = []string{tag}
+	default:
+		return nil, mpdErr(mpdErrArg, "Unknown filter type %q", tag)
+	}
+	return func(f libraryFile, meta TrackMeta) bool {
+		for _, t := range tags {
+			v := mpdTag(f, meta, t)
+			if fold {
+				v = strings.ToLower(v)
+			}
+			if match(v) {
+				return !negate
+			}
+		}
+		return negate
+	}, nil
+}
+
+// mpdExprParser reads MPD filter expressions
+type mpdExprParser struct {
+	s    string
+	pos  int
+	fold bool
+}
+
+func (p *mpdExprParser) space() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *mpdExprParser) expect(s string) error {
+	p.space()
+	if !strings.HasPrefix(p.s[p.pos:], s) {
+		return fmt.Errorf("expected %q at %d", s, p.pos)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+func (p *mpdExprParser) parse() (mpdFilter, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	p.space()
+	switch {
+	case strings.HasPrefix(p.s[p.pos:], "!"):
+		p.pos++
+		inner, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		return func(f libraryFile, meta TrackMeta) bool { return !inner(f, meta) }, p.expect(")")
+	case strings.HasPrefix(p.s[p.pos:], "("):
+		var filters []mpdFilter
+		for {
+			filter, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+			p.space()
+			if strings.HasPrefix(p.s[p.pos:], ")") {
+				p.pos++
+				return allOf(filters), nil
+			}
+			if err := p.expect("AND"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	tag := p.word()
+	if tag == "" {
+		return nil, fmt.Errorf("expected a tag at %d", p.pos)
+	}
+	op := "=="
+	if strings.ToLower(tag) != "base" {
+		p.space()
+		if op = p.operator(); op == "" {
+			return nil, fmt.Errorf("expected an operator at %d", p.pos)
+		}
+	}
+	value, err := p.quoted()
+	if err != nil {
+		return nil, err
+	}
+	filter, err := mpdCompare(tag, op, value, p.fold)
+	if err != nil {
+		return nil, err
+	}
+	return filter, p.expect(")")
+}
+
+func (p *mpdExprParser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] >= 'a' && p.s[p.pos] <= 'z' || p.s[p.pos] >= 'A' && p.s[p.pos] <= 'Z' || p.s[p.pos] == '_' || p.s[p.pos] == '-') {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *mpdExprParser) operator() string {
+	for _, op := range []string{"==", "!=", "=~", "!~"} {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	switch w := p.word(); w {
+	case "contains", "starts_with":
+		return w
+	}
+	return ""
+}
+
+func (p *mpdExprParser) quoted() (string, error) {
+	p.space()
+	if p.pos >= len(p.s) || p.s[p.pos] != '\'' && p.s[p.pos] != '"' {
+		return "", fmt.Errorf("expected a quoted value at %d", p.pos)
+	}
+	quote := p.s[p.pos]
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '\\':
+			if p.pos+1 < len(p.s) {
+				p.pos++
+			}
+		case quote:
+			p.pos++
+			return b.String(), nil
+		}
+		b.WriteByte(p.s[p.pos])
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// matching runs a filter over the library, returning the songs that pass and whatever
+// arguments followed the filter
+func matching(args []string, fold bool) ([]libraryFile, []string, error) {
+	filter, rest, err := parseMPDFilter(args, fold)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, nil, err
+	}
+	var found []libraryFile
+	for _, f := range files {
+		if filter(f, trackMeta(f)) {
+			found = append(found, f)
+		}
+	}
+	return found, rest, nil
+}
+
+func (c *mpdConn) find(args []string, fold bool) error {
+	if len(args) == 0 {
+		return mpdErr(mpdErrArg, "too few arguments")
+	}
+	found, rest, err := matching(args, fold)
+	if err != nil {
+		return err
+	}
+	start, end := 0, len(found)
+	for len(rest) >= 2 {
+		switch strings.ToLower(rest[0]) {
+		case "sort":
+			tag := rest[1]
+			desc := strings.HasPrefix(tag, "-")
+			tag = strings.TrimPrefix(tag, "-")
+			sort.SliceStable(found, func(i, j int) bool {
+				a, b := strings.ToLower(mpdTag(found[i], trackMeta(found[i]), tag)), strings.ToLower(mpdTag(found[j], trackMeta(found[j]), tag))
+				if desc {
+					return a > b
+				}
+				return a < b
+			})
+		case "window":
+			if start, end, err = mpdRange(rest[1], len(found)); err != nil {
+				return err
+			}
+		default:
+			return mpdErr(mpdErrArg, "Unknown argument %q", rest[0])
+		}
+		rest = rest[2:]
+	}
+	for i := start; i < min(end, len(found)); i++ {
+		c.song(found[i])
+	}
+	return nil
+}
+
+func (c *mpdConn) count(args []string) error {
+	found, _, err := matching(args, false)
+	if err != nil {
+		return err
+	}
+	var playtime float64
+	for _, f := range found {
+		playtime += trackMeta(f).Duration
+	}
+	c.line("songs", len(found))
+	c.line("playtime", int(playtime))
+	return nil
+}
+
+// list shows each value of a tag once, among the songs matching any filter. An old-style
+// "list album ARTIST" is short for filtering on that artist.
+func (c *mpdConn) list(args []string) error {
+	if len(args) == 0 {
+		return mpdErr(mpdErrArg, "too few arguments")
+	}
+	tag := args[0]
+	filterArgs := args[1:]
+	if strings.EqualFold(tag, "album") && len(filterArgs) == 1 && !strings.HasPrefix(filterArgs[0], "(") {
+		filterArgs = []string{"artist", filterArgs[0]}
+	}
+	found, _, err := matching(filterArgs, false)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	var values []string
+	for _, f := range found {
+		v := mpdTag(f, trackMeta(f), tag)
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	key := tag
+	for _, t := range append([]string{"file"}, mpdTagTypes...) {
+		if strings.EqualFold(t, tag) {
+			key = t
+		}
+	}
+	for _, v := range values {
+		c.line(key, v)
+	}
+	return nil
+}
+
+// albumArt sends a song's folder cover in chunks of the client's binary limit
+func (c *mpdConn) albumArt(args []string) error {
+	if err := mpdArgs(args, 2, 2); err != nil {
+		return err
+	}
+	offset, err := mpdInt(args[1])
+	if err != nil || offset < 0 {
+		return mpdErr(mpdErrArg, "Bad offset")
+	}
+	uri := strings.Trim(args[0], "/")
+	dir := uri
+	if _, err := libraryFileAt(uri); err == nil {
+		dir = libraryDir(uri)
+	}
+	cover, ok := folderCover(dir)
+	if !ok {
+		return mpdErr(mpdErrNoExist, "No file exists")
+	}
+	f, err := library.Open(cover)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if offset > int(info.Size()) {
+		return mpdErr(mpdErrArg, "Offset too large")
+	}
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	chunk := make([]byte, min(c.binaryLimit, int(info.Size())-offset))
+	if _, err := io.ReadFull(f, chunk); err != nil {
+		return err
+	}
+	c.line("size", info.Size())
+	c.line("binary", len(chunk))
+	c.w.Write(chunk)
+	c.w.WriteString("\n")
+	return nil
+}
+
+func (c *mpdConn) listPlaylists(args []string) error {
+	for _, p := range stateFor(c.r).playlists.List() {
+		c.line("playlist", p.Name)
+		c.line("Last-Modified", p.Updated.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// storedPlaylist finds one of the account's playlists by name, MPD having no other way to
+// refer to them
+func (c *mpdConn) storedPlaylist(name string) (Playlist, error) {
+	for _, p := range stateFor(c.r).playlists.List() {
+		if p.Name == name {
+			err := materializePlaylist(&p, stateFor(c.r).stats)
+			return p, err
+		}
+	}
+	return Playlist{}, mpdErr(mpdErrNoExist, "No such playlist")
+}
+
+func (c *mpdConn) listPlaylist(args []string, info bool) error {
+	if err := mpdArgs(args, 1, 1); err != nil {
+		return err
+	}
+	p, err := c.storedPlaylist(args[0])
+	if err != nil {
+		return err
+	}
+	for _, t := range p.Tracks {
+		if !info {
+			c.line("file", t)
+		} else if f, err := libraryFileAt(t); err == nil {
+			c.song(f)
+		}
+	}
+	return nil
+}
+
+func (c *mpdConn) load(args []string) error {
+	if err := mpdArgs(args, 1, 2); err != nil {
+		return err
+	}
+	p, err := c.storedPlaylist(args[0])
+	if err != nil {
+		return err
+	}
+	tracks := p.Tracks
+	if len(args) == 2 {
+		start, end, err := mpdRange(args[1], len(tracks))
+		if err != nil {
+			return err
+		}
+		tracks = tracks[min(start, len(tracks)):min(end, len(tracks))]
+	}
+	_, err = jukebox.Add(tracks, c.userID(), -1)
+	return err
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// mqttBroker is where to publish what's playing and library events, as
+	// mqtt://[user:pass@]host[:port], or mqtts:// for TLS
+	mqttBroker string
+	mqttTopic  = "beatgraze"
+)
+
+const (
+	mqttKeepAlive   = 60 * time.Second
+	mqttMaxPacket   = 1 << 20
+	mqttMaxRetryGap = time.Minute
+)
+
+// MQTT 3.1.1 packet types, shifted into the first byte's top bits
+const (
+	mqttConnect    = 1 << 4
+	mqttConnAck    = 2 << 4
+	mqttPublish    = 3 << 4
+	mqttSubscribe  = 8<<4 | 2 // Subscribe's reserved flags must be 0010
+	mqttSubAck     = 9 << 4
+	mqttPingReq    = 12 << 4
+	mqttPingResp   = 13 << 4
+	mqttDisconnect = 14 << 4
+)
+
+// Topics, under mqttTopic. status, nowplaying, jukebox and queue are retained, so
+// subscribers such as Home Assistant see the current state as soon as they connect.
+//
+//	status          online or offline
+//	nowplaying      the latest play from any client
+//	jukebox         the jukebox's state and current track
+//	queue           the jukebox queue
+//	events/<type>   library events, as on /api/events
+//	control         commands for the jukebox: play, pause, toggle, stop, next, previous,
+//	                and volume <0-100> or volume +/-<change>
+func mqttTopicFor(name string) string {
+	return strings.TrimSuffix(mqttTopic, "/") + "/" + name
+}
+
+// runMQTT stays connected to the broker until shutdown, reconnecting with growing waits
+func runMQTT() {
+	wait := time.Second
+	for {
+		conn, err := mqttDial()
+		if err == nil {
+			wait = time.Second
+			err = mqttSession(conn)
+			conn.Close()
+		}
+		if shuttingDown.Err() != nil {
+			return
+		}
+		slog.Warn("MQTT connection failed", "broker", redactURL(mqttBroker), "err", err, "retry", wait)
+		select {
+		case <-shuttingDown.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, mqttMaxRetryGap)
+	}
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// mqttDial connects and logs in, leaving "offline" as the will for the broker to publish
+// should the connection drop
+func mqttDial() (net.Conn, error) {
+	u, err := url.Parse(mqttBroker)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "mqtts", "ssl", "tls":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unknown scheme %q, want mqtt:// or mqtts://", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	flags := byte(0x02 | 0x04 | 0x20) // Clean session, with a retained will
+	var login []byte
+	if user := u.User.Username(); user != "" {
+		flags |= 0x80
+		login = mqttString(login, user)
+		if pass, ok := u.User.Password(); ok {
+			flags |= 0x40
+			login = mqttString(login, pass)
+		}
+	}
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = mqttString(body, "beatgraze-"+hostname)
+	body = mqttString(body, mqttTopicFor("status"))
+	body = mqttString(body, "offline")
+	body = append(body, login...)
+
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+	if _, err := conn.Write(mqttPacket(mqttConnect, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	kind, ack, err := readMQTTPacket(bufio.NewReader(conn))
+	if err == nil && (kind&0xf0 != mqttConnAck || len(ack) != 2) {
+		err = errors.New("broker didn't acknowledge the connection")
+	}
+	if err == nil && ack[1] != 0 {
+		err = fmt.Errorf("broker refused the connection: %s", mqttRefusals[ack[1]])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+var mqttRefusals = map[byte]string{
+	1: "unsupported protocol version",
+	2: "client ID rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// mqttSession publishes until the connection fails or the server shuts down. Only this
+// goroutine writes to the connection; another reads from it.
+func mqttSession(conn net.Conn) error {
+	write := func(packet []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(15 * time.Second))
+		_, err := conn.Write(packet)
+		return err
+	}
+	publish := func(name string, retain bool, v any) error {
+		payload, ok := v.([]byte)
+		if !ok {
+			var err error
+			if payload, err = json.Marshal(v); err != nil {
+				return err
+			}
+		}
+		kind := byte(mqttPublish)
+		if retain {
+			kind |= 1
+		}
+		return write(mqttPacket(kind, append(mqttString(nil, mqttTopicFor(name)), payload...)))
+	}
+
+	all, stopEvents := events.Watch()
+	defer stopEvents()
+	plays, stopPlays := nowPlaying.Watch()
+	defer stopPlays()
+	changes, stopChanges := jukeboxChanges.Watch()
+	defer stopChanges()
+
+	sub := binary.BigEndian.AppendUint16(nil, 1)
+	sub = append(mqttString(sub, mqttTopicFor("control")), 0)
+	if err := write(mqttPacket(mqttSubscribe, sub)); err != nil {
+		return err
+	}
+	for _, err := range []error{
+		publish("status", true, []byte("online")),
+		publish("jukebox", true, jukeboxState()),
+		publish("queue", true, jukeboxQueue()),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	slog.Info("Connected to MQTT broker", "broker", redactURL(mqttBroker), "topic", mqttTopic)
+
+	commands := make(chan string, 16)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readMQTT(conn, commands)
+	}()
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-shuttingDown.Done():
+			publish("status", true, []byte("offline"))
+			write([]byte{mqttDisconnect, 0})
+			return nil
+		case err = <-readErr:
+			return err
+		case <-ping.C:
+			err = write([]byte{mqttPingReq, 0})
+		case cmd := <-commands:
+			if err := jukeboxControl(cmd); err != nil {
+				slog.Warn("MQTT control command failed", "command", cmd, "err", err)
+			}
+		case e := <-all:
+			err = publish("events/"+e.Type, false, e.Data)
+		case p := <-plays:
+			err = publish("nowplaying", true, struct {
+				NowPlaying
+				File AudioFile `json:"file"`
+			}{p, audioFileFromPath(p.Path)})
+		case change := <-changes:
+			if change == "playlist" {
+				err = publish("queue", true, jukeboxQueue())
+			}
+			if err == nil {
+				err = publish("jukebox", true, jukeboxState())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readMQTT passes on commands published to the control topic
+func readMQTT(conn net.Conn, commands chan<- string) error {
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 2))
+		kind, body, err := readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+		switch kind & 0xf0 {
+		case mqttPublish:
+			if len(body) < 2 {
+				return errors.New("short PUBLISH packet")
+			}
+			n := int(binary.BigEndian.Uint16(body))
+			if len(body) < 2+n {
+				return errors.New("short PUBLISH packet")
+			}
+			topic, payload := string(body[2:2+n]), body[2+n:]
+			// The subscription asks for QoS 0, so nothing needs acknowledging, but skip the
+			// packet ID should a broker send one anyway
+			if kind>>1&3 > 0 {
+				if len(payload) < 2 {
+					return errors.New("short PUBLISH packet")
+				}
+				payload = payload[2:]
+			}
+			if topic == mqttTopicFor("control") {
+				select {
+				case commands <- string(payload):
+				default:
+				}
+			}
+		case mqttSubAck:
+			if len(body) == 3 && body[2] == 0x80 {
+				slog.Warn("MQTT broker refused the control topic subscription", "topic", mqttTopicFor("control"))
+			}
+		case mqttPingResp:
+		}
+	}
+}
+
+// mqttPacket puts a fixed header, with its variable-length remaining length, on body
+func mqttPacket(kind byte, body []byte) []byte {
+	packet := []byte{kind}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("bad MQTT packet length")
+		}
+	}
+	if n > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("MQTT packet of %d bytes is too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return kind, body, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// linkPreviewBots are the user agents of chat apps and social sites fetching a link to show
+// a preview of it, which shouldn't use up a share link's uses
+var linkPreviewBots = []string{"discordbot", "slackbot", "slack-imgproxy", "twitterbot", "facebookexternalhit",
+	"telegrambot", "whatsapp", "linkedinbot", "mastodon", "redditbot", "embedly", "iframely", "skypeuripreview"}
+
+func linkPreviewBot(r *http.Request) bool {
+	ua := strings.ToLower(r.UserAgent())
+	for _, bot := range linkPreviewBots {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// shareCoverPath finds the artwork for a share: its folder's cover, or that of the folder of
+// its first track
+func shareCoverPath(share Share, tracks []string) (string, bool) {
+	dir := ""
+	switch {
+	case share.Type == "folder":
+		dir = filepath.ToSlash(share.Target)
+		if dir == "." {
+			dir = ""
+		}
+	case len(tracks) > 0:
+		dir = libraryDir(filepath.ToSlash(tracks[0]))
+	default:
+		return "", false
+	}
+	return folderCover(dir)
+}
+
+func shareCover(w http.ResponseWriter, r *http.Request) {
+	share, err := shares.Resolve(r.PathValue("token"), false)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	tracks, err := shareTracks(share)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	cover, ok := shareCoverPath(share, tracks)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	serveLibraryFile(w, r, cover)
+}
+
+// imageSize reads an image's dimensions from its header
+func imageSize(relPath string) (int, int, bool) {
+	f, err := library.Open(relPath)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
+
+// sharePreview is what link previews show of a share
+type sharePreview struct {
+	URL          string // Absolute URLs
+	Title        string
+	Artist       string
+	Description  string
+	Image        string
+	ImageWidth   int
+	ImageHeight  int
+	Audio        string
+	AudioType    string
+	Player       string // The embeddable player
+	PlayerWidth  int
+	PlayerHeight int
+}
+
+// previewShare describes a share for Open Graph tags and oEmbed
+func previewShare(r *http.Request, token string, share Share, tracks []string) sharePreview {
+	p := sharePreview{
+		URL:          externalURL(r, "/s/"+token),
+		Title:        share.Name,
+		Player:       externalURL(r, "/embed/"+token),
+		PlayerWidth:  480,
+		PlayerHeight: 400,
+	}
+	switch {
+	case share.Type == "track" && len(tracks) == 1:
+		p.PlayerHeight = 120
+		if f, err := libraryFileAt(tracks[0]); err == nil {
+			meta := trackMeta(f)
+			p.Title = firstNonEmpty(meta.Title, share.Name)
+			p.Artist = meta.Artist
+			p.Description = meta.Artist
+			if meta.Album != "" && p.Description != "" {
+				p.Description += " · "
+			}
+			p.Description += meta.Album
+		}
+	case len(tracks) == 1:
+		p.Description = "1 track"
+	default:
+		p.Description = fmt.Sprintf("%d tracks", len(tracks))
+	}
+	if len(tracks) > 0 {
+		p.Audio = externalURL(r, "/s/"+token+"/"+filepath.ToSlash(tracks[0]))
+		p.AudioType = dlnaMime(tracks[0])
+	}
+	if cover, ok := shareCoverPath(share, tracks); ok {
+		p.Image = externalURL(r, "/s/"+token+"/cover")
+		p.ImageWidth, p.ImageHeight, _ = imageSize(cover)
+	}
+	return p
+}
+
+// getOEmbed describes a share link for sites that embed links pasted into them, following
+// oEmbed (https://oembed.com) with a "rich" embed of the share's player
+func getOEmbed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if f := q.Get("format"); f != "" && f != "json" {
+		http.Error(w, "Only JSON is supported", http.StatusNotImplemented)
+		return
+	}
+	u, err := url.Parse(q.Get("url"))
+	if err != nil {
+		http.Error(w, "Invalid url", http.StatusBadRequest)
+		return
+	}
+	rest, ok := strings.CutPrefix(u.Path, prefixed("/s/"))
+	token, _, _ := strings.Cut(rest, "/")
+	if !ok || token == "" {
+		http.Error(w, "url must be a share link", http.StatusNotFound)
+		return
+	}
+	share, err := shares.Resolve(token, false)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	tracks, err := shareTracks(share)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	p := previewShare(r, token, share, tracks)
+
+	width, height := p.PlayerWidth, p.PlayerHeight
+	if n, err := strconv.Atoi(q.Get("maxwidth")); err == nil && n > 0 {
+		width = min(width, n)
+	}
+	if n, err := strconv.Atoi(q.Get("maxheight")); err == nil && n > 0 {
+		height = min(height, n)
+	}
+	resp := map[string]any{
+		"version":       "1.0",
+		"type":          "rich",
+		"provider_name": "beatgraze",
+		"provider_url":  externalURL(r, "/"),
+		"title":         p.Title,
+		"width":         width,
+		"height":        height,
+		"html": fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="autoplay" title="%s"></iframe>`,
+			html.EscapeString(p.Player), width, height, html.EscapeString(p.Title)),
+		"cache_age": int(time.Until(share.Expires).Seconds()),
+	}
+	if p.Artist != "" {
+		resp["author_name"] = p.Artist
+	}
+	// oEmbed only takes a thumbnail along with its size
+	if p.Image != "" && p.ImageWidth > 0 {
+		resp["thumbnail_url"] = p.Image
+		resp["thumbnail_width"] = p.ImageWidth
+		resp["thumbnail_height"] = p.ImageHeight
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateCookie = "beatgraze_oidc"
+
+// OIDCConfig holds the -oidc-* flags
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // Optional; worked out from the request when empty
+	RolesClaim   string
+	RoleMap      string // "group=role,..." with "*" as the fallback, e.g. "admins=admin,*=listener"
+}
+
+var oidcConfig OIDCConfig
+
+var oidcLogin struct {
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	roles    map[string]Role
+}
+
+// setupOIDC discovers the provider, so a bad issuer fails at startup rather than at first login
+func setupOIDC(ctx context.Context) error {
+	if oidcConfig.ClientID == "" {
+		return errors.New("-oidc-client-id is required with -oidc-issuer")
+	}
+	roles, err := parseRoleMap(oidcConfig.RoleMap)
+	if err != nil {
+		return err
+	}
+	provider, err := oidc.NewProvider(ctx, oidcConfig.Issuer)
+	if err != nil {
+		return err
+	}
+	oidcLogin.provider = provider
+	oidcLogin.verifier = provider.Verifier(&oidc.Config{ClientID: oidcConfig.ClientID})
+	oidcLogin.roles = roles
+	return nil
+}
+
+func oidcEnabled() bool {
+	return oidcLogin.provider != nil
+}
+
+func parseRoleMap(s string) (map[string]Role, error) {
+	roles := map[string]Role{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid role mapping %q, want group=role", pair)
+		}
+		role, err := parseRole(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		roles[strings.TrimSpace(group)] = role
+	}
+	return roles, nil
+}
+
+// mapRole picks the most privileged role any of the user's groups grant, or the "*" fallback.
+// Without a match or fallback the user isn't let in at all.
+func mapRole(groups []string) (Role, bool) {
+	best, found := Role(""), false
+	for _, g := range groups {
+		if role, ok := oidcLogin.roles[g]; ok && roleRank[role] > roleRank[best] {
+			best, found = role, true
+		}
+	}
+	if !found {
+		best, found = oidcLogin.roles["*"]
+	}
+	return best, found
+}
+
+func oauthConfig(r *http.Request) *oauth2.Config {
+	redirect := oidcConfig.RedirectURL
+	if redirect == "" {
+		redirect = externalURL(r, "/auth/oidc/callback")
+	}
+	return &oauth2.Config{
+		ClientID:     oidcConfig.ClientID,
+		ClientSecret: oidcConfig.ClientSecret,
+		RedirectURL:  redirect,
+		Endpoint:     oidcLogin.provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+}
+
+// UpsertOIDC finds the account linked to an OIDC subject, creating it on first sign-in.
+// The provider owns roles, so they're refreshed from the claims every time.
+func (s *UserStore) UpsertOIDC(subject, name string, role Role) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.Users {
+		if u.OIDCSubject == subject {
+			if u.Role != role {
+				u.Role = role
+				if err := saveJSON(s.path, s); err != nil {
+					return User{}, err
+				}
+			}
+			return *u, nil
+		}
+	}
+
+	// Never take over a local account that happens to share the name
+	if s.nameTaken(name) {
+		sum := sha256.Sum256([]byte(subject))
+		name += "-" + hex.EncodeToString(sum[:2])
+	}
+	return s.add(&User{ID: newID(), Name: name, Role: role, OIDCSubject: subject, Created: time.Now().UTC()})
+}
+
+func registerOIDCRoutes() {
+	handleFunc("GET /auth/oidc/login", oidcStart)
+	handleFunc("GET /auth/oidc/callback", oidcCallback)
+}
+
+func oidcStart(w http.ResponseWriter, r *http.Request) {
+	state, nonce, verifier := newID()+newID(), newID()+newID(), oauth2.GenerateVerifier()
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state + "." + nonce + "." + verifier,
+		Path:     prefixed("/auth/oidc/"),
+		MaxAge:   600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil,
+	})
+	url := oauthConfig(r).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: prefixed("/auth/oidc/"), MaxAge: -1})
+	parts := strings.SplitN(cookie.Value, ".", 3)
+	if len(parts) != 3 || !secureCompare(r.URL.Query().Get("state"), parts[0]) {
+		http.Error(w, "Invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, "Sign-in failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := oauthConfig(r).Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(parts[2]))
+	if err != nil {
+		http.Error(w, "Sign-in failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Sign-in failed: no ID token", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := oidcLogin.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != parts[1] {
+		http.Error(w, "Sign-in failed: invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	role, ok := mapRole(claimStrings(claims[oidcConfig.RolesClaim]))
+	if !ok {
+		http.Error(w, "Your account isn't allowed to use beatgraze", http.StatusForbidden)
+		return
+	}
+	name := idToken.Subject
+	for _, claim := range []string{"preferred_username", "email", "name"} {
+		if v, ok := claims[claim].(string); ok && v != "" {
+			name = v
+			break
+		}
+	}
+
+	u, err := users.UpsertOIDC(idToken.Issuer+"|"+idToken.Subject, name, role)
+	if err == nil {
+		err = startSession(w, r, u)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, prefixed("/"), http.StatusFound)
+}
+
+// claimStrings accepts a claim holding either a list of strings or a single space-separated string
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// organizeLayout is where organizing puts each track, built from its tags; the extension is kept
+var organizeLayout = "{artist}/{album}/{track} - {title}"
+
+var organizeFields = regexp.MustCompile(`\{(\w+)\}`)
+
+var emptyBrackets = strings.NewReplacer("()", "", "[]", "")
+
+// organizeMu stops two runs from moving the same files at once
+var organizeMu sync.Mutex
+
+var errOrganizeRunning = errors.New("the library is already being organized")
+
+type organizeMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type organizeSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type organizePlan struct {
+	Moves   []organizeMove `json:"moves"`
+	Skipped []organizeSkip `json:"skipped"`
+	Applied bool           `json:"applied"`
+}
+
+// validateLayout checks a layout only uses fields organizing knows about and names each track
+func validateLayout(layout string) error {
+	if !strings.Contains(layout, "{title}") {
+		return errors.New("layout must include {title}, or tracks would collide")
+	}
+	for _, m := range organizeFields.FindAllStringSubmatch(layout, -1) {
+		switch m[1] {
+		case "artist", "album", "title", "track", "year", "genre":
+		default:
+			return fmt.Errorf("unknown layout field {%s}", m[1])
+		}
+	}
+	if strings.HasPrefix(layout, "/") || strings.Contains(layout, "..") {
+		return errors.New("layout must stay inside the library")
+	}
+	return nil
+}
+
+// organizeName makes a tag safe to use as a file or folder name on any OS
+func organizeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > 120 {
+		s = s[:120]
+		for !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+	}
+	return strings.Trim(s, " .")
+}
+
+// organizedPath is where layout puts a track with the given tags
+func organizedPath(layout string, f libraryFile, meta TrackMeta) string {
+	ext := path.Ext(f.Path)
+	values := map[string]string{
+		"artist": firstNonEmpty(meta.Artist, "Unknown Artist"),
+		"album":  firstNonEmpty(meta.Album, "Unknown Album"),
+		"title":  firstNonEmpty(meta.Title, strings.TrimSuffix(path.Base(f.Path), ext)),
+		"genre":  meta.Genre,
+	}
+	if meta.Track > 0 {
+		values["track"] = fmt.Sprintf("%02d", meta.Track)
+	}
+	if meta.Year > 0 {
+		values["year"] = strconv.Itoa(meta.Year)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(layout, "/") {
+		segment = organizeFields.ReplaceAllStringFunc(segment, func(field string) string {
+			return organizeName(values[strings.Trim(field, "{}")])
+		})
+		// Missing tags leave separators dangling, like " - Title" without a track number
+		segment = strings.Join(strings.Fields(emptyBrackets.Replace(segment)), " ")
+		segment = strings.Trim(segment, " -_.")
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "/") + strings.ToLower(ext)
+}
+
+// planOrganize works out where every track should move to, without touching anything
+func planOrganize(layout string) (organizePlan, error) {
+	plan := organizePlan{Moves: []organizeMove{}, Skipped: []organizeSkip{}}
+	files, err := scanLibrary()
+	if err != nil {
+		return plan, err
+	}
+	claimed := map[string]string{} // Lowercased target to the track taking it
+	for _, f := range files {
+		if insideArchive(f.Path) {
+			plan.Skipped = append(plan.Skipped, organizeSkip{f.Path, "inside a zip archive"})
+			continue
+		}
+		to := organizedPath(layout, f, trackMeta(f))
+		if to == f.Path {
+			continue
+		}
+		// Guard against case-insensitive filesystems as well as exact clashes
+		key := strings.ToLower(to)
+		if other, ok := claimed[key]; ok {
+			plan.Skipped = append(plan.Skipped, organizeSkip{f.Path, "same tags as " + other})
+			continue
+		}
+		if !strings.EqualFold(to, f.Path) {
+			if _, err := library.Stat(to); !errors.Is(err, fs.ErrNotExist) {
+				plan.Skipped = append(plan.Skipped, organizeSkip{f.Path, to + " already exists"})
+				continue
+			}
+		}
+		claimed[key] = f.Path
+		plan.Moves = append(plan.Moves, organizeMove{From: f.Path, To: to})
+	}
+	return plan, nil
+}
+
+// insideArchive reports whether a library path is a file inside a zip
+func insideArchive(p string) bool {
+	dir := path.Dir(p)
+	for _, part := range strings.Split(dir, "/") {
+		if isZipPath(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// organizeLibrary plans and, unless dryRun, carries out a reorganization. Moves happen all
+// or nothing: if one fails, those already made are undone before playlists, crates, stats
+// and shares are pointed at the new paths.
+func organizeLibrary(layout string, dryRun bool) (organizePlan, error) {
+	if err := validateLayout(layout); err != nil {
+		return organizePlan{}, fmt.Errorf("%w: %v", errInvalidPath, err)
+	}
+	storage, ok := writableLibrary()
+	if !ok && !dryRun {
+		return organizePlan{}, errLibraryNotWritable
+	}
+	if !organizeMu.TryLock() {
+		return organizePlan{}, errOrganizeRunning
+	}
+	defer organizeMu.Unlock()
+
+	plan, err := planOrganize(layout)
+	if err != nil || dryRun || len(plan.Moves) == 0 {
+		return plan, err
+	}
+	for i, m := range plan.Moves {
+		if err := storage.Rename(m.From, m.To); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				storage.Rename(plan.Moves[j].To, plan.Moves[j].From)
+			}
+			return plan, fmt.Errorf("moving %s: %w", m.From, err)
+		}
+	}
+	moved := make(map[string]string, len(plan.Moves))
+	for _, m := range plan.Moves {
+		moved[m.From] = m.To
+	}
+	updateLibraryPaths(func(p string) (string, bool) {
+		to, ok := moved[p]
+		return to, ok
+	})
+	plan.Applied = true
+	return plan, nil
+}
+
+func registerOrganizeRoutes() {
+	handleFunc("POST /api/organize", requireRole(roleAdmin, organizeHandler))
+}
+
+// organizeHandler takes {"layout": ..., "dryRun": true} to preview moves before making them
+func organizeHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Layout string `json:"layout"`
+		DryRun bool   `json:"dryRun"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Layout == "" {
+		req.Layout = organizeLayout
+	}
+	plan, err := organizeLibrary(req.Layout, req.DryRun)
+	switch {
+	case errors.Is(err, errLibraryNotWritable):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, errOrganizeRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		writeFileError(w, err)
+	default:
+		writeJSON(w, http.StatusOK, plan)
+	}
+}
+
+// runOrganize implements "beatgraze organize", for reorganizing while the server isn't running
+func runOrganize(args []string) error {
+	fs := flag.NewFlagSet("organize", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Only print the moves that would be made")
+	fs.StringVar(&organizeLayout, "layout", organizeLayout, "Where to put each track: {artist}, {album}, {title}, {track}, {year} and {genre} become its tags")
+	fs.StringVar(&audioDir, "dir", "", "Library to organize (default: current directory)")
+	fs.StringVar(&dataDir, "data", "", "State directory whose playlists, crates, stats and shares should follow the moves (default: user config dir)")
+	fs.StringVar(&storeKind, "store", storeKind, "Where the server keeps its state: bolt, json or a postgres:// URL")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s organize [options] [directory]\n\nMoves tracks into folders named after their tags. Stop the server first.\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		audioDir = fs.Arg(0)
+	}
+
+	storage, dir, err := openLibrary(audioDir)
+	if err != nil {
+		return err
+	}
+	library.Store(storage)
+	audioDir = dir
+	if dataDir == "" {
+		if dataDir, err = defaultDataDir(); err != nil {
+			return err
+		}
+	}
+	if !*dryRun {
+		if stateStore, err = openDataStore(storeKind, dataDir); err != nil {
+			return err
+		}
+		defer stateStore.Close()
+		if defaultState, err = loadUserState(dataDir); err != nil {
+			return err
+		}
+		if users, err = loadUserStore(filepath.Join(dataDir, "users.json")); err != nil {
+			return err
+		}
+		if crates, err = loadCrateStore(filepath.Join(dataDir, "crates.json")); err != nil {
+			return err
+		}
+		if stations, err = loadStationStore(filepath.Join(dataDir, "stations.json")); err != nil {
+			return err
+		}
+		if shares, err = loadShareStore(filepath.Join(dataDir, "shares.json")); err != nil {
+			return err
+		}
+	}
+
+	plan, err := organizeLibrary(organizeLayout, *dryRun)
+	for _, m := range plan.Moves {
+		fmt.Printf("%s -> %s\n", m.From, m.To)
+	}
+	for _, s := range plan.Skipped {
+		fmt.Printf("skipped %s: %s\n", s.Path, s.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	if plan.Applied {
+		fmt.Printf("Moved %d tracks\n", len(plan.Moves))
+	} else {
+		fmt.Printf("%d tracks would move\n", len(plan.Moves))
+	}
+	return nil
+}
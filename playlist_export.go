@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+}
+
+func exportPlaylist(w http.ResponseWriter, r *http.Request) {
+	p, err := stateFor(r).playlists.Get(r.PathValue("id"))
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	if err := materializePlaylist(&p, stateFor(r).stats); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "m3u8"
+	}
+	paths := r.URL.Query().Get("paths")
+	if paths == "" {
+		paths = "relative"
+	}
+	if paths != "relative" && paths != "url" {
+		http.Error(w, "paths must be relative or url", http.StatusBadRequest)
+		return
+	}
+
+	// Relative entries are relative to the library root, so the file works when saved there
+	location := func(track string) string {
+		if paths == "url" {
+			return streamURL(r, track)
+		}
+		return filepath.ToSlash(track)
+	}
+
+	switch format {
+	case "m3u8":
+		w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+		w.Header().Set("Content-Disposition", attachmentFilename(p.Name+".m3u8"))
+		w.Write([]byte(playlistM3U8(p, location)))
+
+	case "xspf":
+		doc := xspfPlaylist{
+			Version: "1",
+			XMLNS:   "http://xspf.org/ns/0/",
+			Title:   p.Name,
+		}
+		for _, track := range p.Tracks {
+			loc := location(track)
+			if paths == "relative" {
+				loc = (&url.URL{Path: loc}).EscapedPath() // XSPF locations must be URIs
+			}
+			doc.Tracks = append(doc.Tracks, xspfTrack{Location: loc, Title: trackTitle(track)})
+		}
+		w.Header().Set("Content-Type", "application/xspf+xml; charset=utf-8")
+		w.Header().Set("Content-Disposition", attachmentFilename(p.Name+".xspf"))
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		enc.Encode(doc)
+
+	default:
+		http.Error(w, "format must be m3u8 or xspf", http.StatusBadRequest)
+	}
+}
+
+// playlistM3U8 writes out a playlist with location giving each track's entry
+func playlistM3U8(p Playlist, location func(track string) string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#PLAYLIST:%s\n", p.Name)
+	for _, track := range p.Tracks {
+		fmt.Fprintf(&b, "#EXTINF:-1,%s\n", trackTitle(track))
+		b.WriteString(location(track) + "\n")
+	}
+	return b.String()
+}
+
+// streamURL builds an absolute /audio/ URL for a track as seen by the requesting client
+func streamURL(r *http.Request, track string) string {
+	return externalURL(r, "/audio/"+filepath.ToSlash(track))
+}
+
+// externalURL makes an absolute URL for path on this server, honouring reverse proxies
+func externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// Only a trusted proxy says how the client reached it
+	if proto := r.Header.Get("X-Forwarded-Proto"); fromTrustedProxy(r) && (proto == "http" || proto == "https") {
+		scheme = proto
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: prefixed(path)}
+	return u.String()
+}
+
+func trackTitle(track string) string {
+	name := filepath.Base(track)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func attachmentFilename(name string) string {
+	return fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name))
+}
+
+// runExport implements "beatgraze export", writing every playlist to a .m3u8 file, in a
+// folder per account when there are accounts, or with -format the whole library as CSV or JSON
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	libraryFlags(fs)
+	stateFlags(fs)
+	format := fs.String("format", "m3u8", "m3u8 for the playlists, or csv or json for the library with play counts and ratings")
+	out := fs.String("out", "", "Folder to write the playlists to (default: current directory), or file for the library (default: standard output)")
+	only := fs.String("user", "", "Only export this account's playlists, straight into -out; for the library, whose stats to include")
+	commandUsage(fs, "[options] [directory]", "Writes playlists out as M3U8 files. Their tracks are relative to the library folder, so\nthey play when copied there. With -format csv or json, writes the library instead.")
+	fs.Parse(args)
+	if *format != "m3u8" && *format != "csv" && *format != "json" {
+		return errors.New("-format must be m3u8, csv or json")
+	}
+	if err := openCommandLibrary(fs); err != nil {
+		return err
+	}
+	if err := openCommandState(); err != nil {
+		return err
+	}
+	defer stateStore.Close()
+	if *format != "m3u8" {
+		return exportLibraryFile(*format, *out, *only)
+	}
+	if *out == "" {
+		*out = "."
+	}
+
+	type export struct {
+		dir   string
+		state *userState
+	}
+	var exports []export
+	switch {
+	case *only != "":
+		u, ok := users.Lookup(*only)
+		if !ok {
+			return fmt.Errorf("no account named %q", *only)
+		}
+		exports = append(exports, export{*out, users.State(u.ID)})
+	case users.Count() == 0:
+		exports = append(exports, export{*out, defaultState})
+	default:
+		for _, u := range users.List() {
+			exports = append(exports, export{filepath.Join(*out, organizeName(u.Name)), users.State(u.ID)})
+		}
+	}
+
+	written := 0
+	for _, e := range exports {
+		list := e.state.playlists.List()
+		if err := materializePlaylists(list, e.state.stats); err != nil {
+			return err
+		}
+		if len(list) > 0 {
+			if err := os.MkdirAll(e.dir, 0755); err != nil {
+				return err
+			}
+		}
+		for _, p := range list {
+			file := filepath.Join(e.dir, organizeName(p.Name)+".m3u8")
+			if err := os.WriteFile(file, []byte(playlistM3U8(p, filepath.ToSlash)), 0644); err != nil {
+				return err
+			}
+			fmt.Println(file)
+			written++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d playlists\n", written)
+	return nil
+}
+
+// exportLibraryFile writes the library export to file, or standard output if it's empty
+func exportLibraryFile(format, file, userName string) error {
+	st := defaultState
+	switch {
+	case userName != "":
+		u, ok := users.Lookup(userName)
+		if !ok {
+			return fmt.Errorf("no account named %q", userName)
+		}
+		st = users.State(u.ID)
+	case users.Count() > 0:
+		return errors.New("there are accounts, so say whose play counts and ratings to export with -user")
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		return writeLibraryExport(os.Stdout, format, files, st.stats.Snapshot())
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := writeLibraryExport(f, format, files, st.stats.Snapshot()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d tracks\n", len(files))
+	return nil
+}
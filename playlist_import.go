@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var playlistExts = map[string]bool{
+	".m3u":  true,
+	".m3u8": true,
+	".pls":  true,
+}
+
+type PlaylistImportResult struct {
+	Source  string   `json:"source"`
+	ID      string   `json:"id,omitempty"`
+	Name    string   `json:"name"`
+	Tracks  int      `json:"tracks"`
+	Missing []string `json:"missing,omitempty"`
+	Updated bool     `json:"updated"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// ImportFrom creates a playlist for a file on disk, or refreshes the one previously imported from it.
+// The lock is held throughout, so two imports of the same file can't both create one.
+func (s *PlaylistStore) ImportFrom(source, name string, tracks []string) (Playlist, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	for id, old := range s.playlists {
+		if old.Source != source {
+			continue
+		}
+		p := old.clone()
+		p.Tracks = append([]string{}, tracks...)
+		p.Updated = now
+		s.playlists[id] = &p
+		if err := s.save(); err != nil {
+			s.playlists[id] = old
+			return Playlist{}, true, err
+		}
+		return p.clone(), true, nil
+	}
+	p := &Playlist{ID: newID(), Name: name, Tracks: append([]string{}, tracks...), Source: source, Created: now, Updated: now}
+	s.playlists[p.ID] = p
+	if err := s.save(); err != nil {
+		delete(s.playlists, p.ID)
+		return Playlist{}, false, err
+	}
+	return p.clone(), false, nil
+}
+
+// importLibraryPlaylists finds every playlist file in the library and imports it
+func importLibraryPlaylists(ps *PlaylistStore) ([]PlaylistImportResult, error) {
+	var sources []string
+	libraryPaths := map[string]string{}
+	err := library.Walk(func(path string, info fs.FileInfo) error {
+		ext := strings.ToLower(filepath.Ext(path))
+		relPath := filepath.FromSlash(path)
+		if playlistExts[ext] {
+			sources = append(sources, relPath)
+		} else if audioExts[ext] {
+			libraryPaths[strings.ToLower(filepath.ToSlash(relPath))] = relPath
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PlaylistImportResult, 0, len(sources))
+	for _, source := range sources {
+		results = append(results, importPlaylistFile(ps, source, libraryPaths))
+	}
+	return results, nil
+}
+
+func importPlaylistFile(ps *PlaylistStore, source string, libraryPaths map[string]string) PlaylistImportResult {
+	name := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	result := PlaylistImportResult{Source: source, Name: name}
+
+	data, err := readLibraryFile(source)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	// Plain .m3u files are usually Latin-1; only trust UTF-8 when it decodes cleanly
+	if !utf8.Valid(data) {
+		data = latin1ToUTF8(data)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var entries []string
+	if strings.ToLower(filepath.Ext(source)) == ".pls" {
+		entries = parsePLS(data)
+	} else {
+		entries = parseM3U(data)
+	}
+
+	var tracks []string
+	for _, entry := range entries {
+		if track, ok := resolvePlaylistEntry(entry, filepath.Dir(source), libraryPaths); ok {
+			tracks = append(tracks, track)
+		} else {
+			result.Missing = append(result.Missing, entry)
+		}
+	}
+
+	p, updated, err := ps.ImportFrom(source, name, tracks)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.ID = p.ID
+	result.Tracks = len(tracks)
+	result.Updated = updated
+	return result
+}
+
+func parseM3U(data []byte) []string {
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries
+}
+
+func parsePLS(data []byte) []string {
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && strings.HasPrefix(strings.ToLower(key), "file") {
+			entries = append(entries, strings.TrimSpace(value))
+		}
+	}
+	return entries
+}
+
+// resolvePlaylistEntry maps a playlist line to a library-relative track path.
+// Relative entries resolve against the playlist's folder, absolute ones against
+// the library root, and anything else (e.g. a path from another machine) falls
+// back to the longest path suffix that exists in the library.
+func resolvePlaylistEntry(entry, playlistDir string, libraryPaths map[string]string) (string, bool) {
+	if u, err := url.Parse(entry); err == nil && u.Scheme == "file" {
+		entry = u.Path
+	} else if err == nil && len(u.Scheme) > 1 {
+		return "", false // Remote streams can't be part of a library playlist
+	}
+	entry = strings.ReplaceAll(entry, "\\", "/")
+
+	var candidates []string
+	isAbs := strings.HasPrefix(entry, "/") || (len(entry) > 2 && entry[1] == ':' && entry[2] == '/')
+	if isAbs {
+		if rel, err := filepath.Rel(audioDir, filepath.FromSlash(entry)); err == nil {
+			candidates = append(candidates, rel)
+		}
+	} else {
+		candidates = append(candidates, filepath.Join(playlistDir, filepath.FromSlash(entry)))
+	}
+
+	for _, candidate := range candidates {
+		if track, err := validateTrack(candidate); err == nil {
+			return track, true
+		}
+	}
+
+	parts := strings.Split(strings.Trim(entry, "/"), "/")
+	for i := range parts {
+		suffix := strings.Join(parts[i:], "/")
+		if track, ok := libraryPaths[strings.ToLower(suffix)]; ok {
+			return track, true
+		}
+	}
+	return "", false
+}
+
+func latin1ToUTF8(data []byte) []byte {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return []byte(string(runes))
+}
+
+func importPlaylists(w http.ResponseWriter, r *http.Request) {
+	results, err := importLibraryPlaylists(stateFor(r).playlists)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Playlist struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Tracks  []string  `json:"tracks"`
+	Source  string    `json:"source,omitempty"` // Library-relative playlist file this was imported from
+	Rules   string    `json:"rules,omitempty"`  // Smart playlists regenerate Tracks from these on every read
+	Auto    string    `json:"auto,omitempty"`   // Set on playlists the server generates and refreshes itself
+	Sort    string    `json:"sort,omitempty"`
+	Limit   int       `json:"limit,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+type PlaylistStore struct {
+	mu        sync.Mutex
+	path      string
+	playlists map[string]*Playlist
+}
+
+var errPlaylistNotFound = errors.New("playlist not found")
+var errSmartPlaylist = errors.New("smart playlist tracks are generated from its rules")
+var errGeneratedPlaylist = errors.New("playlist is generated automatically and can't be edited")
+
+func loadPlaylistStore(path string) (*PlaylistStore, error) {
+	s := &PlaylistStore{path: path, playlists: map[string]*Playlist{}}
+	var list []*Playlist
+	if err := loadJSON(path, &list); err != nil {
+		return nil, err
+	}
+	for _, p := range list {
+		s.playlists[p.ID] = p
+	}
+	return s, nil
+}
+
+// save must be called with mu held
+func (s *PlaylistStore) save() error {
+	list := make([]*Playlist, 0, len(s.playlists))
+	for _, p := range s.playlists {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return saveJSON(s.path, list)
+}
+
+func (s *PlaylistStore) List() []Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Playlist, 0, len(s.playlists))
+	for _, p := range s.playlists {
+		list = append(list, p.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+func (s *PlaylistStore) Get(id string) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[id]
+	if !ok {
+		return Playlist{}, errPlaylistNotFound
+	}
+	return p.clone(), nil
+}
+
+func (s *PlaylistStore) Create(name string, tracks []string) (Playlist, error) {
+	return s.Add(Playlist{Name: name, Tracks: tracks})
+}
+
+// Add stores a new playlist built from template, assigning its ID and timestamps
+func (s *PlaylistStore) Add(template Playlist) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	p := &template
+	p.ID = newID()
+	p.Tracks = append([]string{}, template.Tracks...)
+	p.Created = now
+	p.Updated = now
+	s.playlists[p.ID] = p
+	if err := s.save(); err != nil {
+		delete(s.playlists, p.ID)
+		return Playlist{}, err
+	}
+	return p.clone(), nil
+}
+
+// Update applies fn to a copy of the playlist and only keeps the result if it persists
+func (s *PlaylistStore) Update(id string, fn func(p *Playlist) error) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.playlists[id]
+	if !ok {
+		return Playlist{}, errPlaylistNotFound
+	}
+	p := old.clone()
+	if err := fn(&p); err != nil {
+		return Playlist{}, err
+	}
+	p.Updated = time.Now().UTC()
+	s.playlists[id] = &p
+	if err := s.save(); err != nil {
+		s.playlists[id] = old
+		return Playlist{}, err
+	}
+	return p.clone(), nil
+}
+
+// MovePaths points tracks and import sources at files that have moved to their new paths
+func (s *PlaylistStore) MovePaths(move func(path string) (string, bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, p := range s.playlists {
+		for i, track := range p.Tracks {
+			if moved, ok := move(track); ok {
+				p.Tracks[i], changed = moved, true
+			}
+		}
+		if moved, ok := move(p.Source); ok {
+			p.Source, changed = moved, true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// Merge stores playlists from another instance under their own IDs, replacing ours when
+// theirs were updated later, and reports how many changed
+func (s *PlaylistStore) Merge(list []Playlist) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := 0
+	for _, p := range list {
+		if p.ID == "" || p.Auto != "" {
+			continue
+		}
+		if old, ok := s.playlists[p.ID]; ok && !p.Updated.After(old.Updated) {
+			continue
+		}
+		c := p.clone()
+		s.playlists[p.ID] = &c
+		changed++
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	return changed, s.save()
+}
+
+func (s *PlaylistStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.playlists[id]
+	if !ok {
+		return errPlaylistNotFound
+	}
+	delete(s.playlists, id)
+	if err := s.save(); err != nil {
+		s.playlists[id] = old
+		return err
+	}
+	return nil
+}
+
+// trackEditError reports why a playlist's tracks can't be edited by hand, if they can't
+func (p *Playlist) trackEditError() error {
+	switch {
+	case p.Rules != "":
+		return errSmartPlaylist
+	case p.Auto != "":
+		return errGeneratedPlaylist
+	}
+	return nil
+}
+
+func (p *Playlist) clone() Playlist {
+	c := *p
+	c.Tracks = append([]string{}, p.Tracks...)
+	return c
+}
+
+// validateTrack checks that a playlist entry points at an audio file inside the library
+func validateTrack(relPath string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(relPath))
+	if !audioExts[strings.ToLower(filepath.Ext(clean))] {
+		return "", fmt.Errorf("not an audio file: %s", relPath)
+	}
+	info, err := library.Stat(relPath)
+	if errors.Is(err, errInvalidPath) {
+		return "", fmt.Errorf("invalid track path: %s", relPath)
+	}
+	if err != nil || info.IsDir() {
+		return "", fmt.Errorf("track not found: %s", relPath)
+	}
+	return clean, nil
+}
+
+func validateTracks(paths []string) ([]string, error) {
+	tracks := make([]string, 0, len(paths))
+	for _, path := range paths {
+		clean, err := validateTrack(path)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, clean)
+	}
+	return tracks, nil
+}
+
+// materializePlaylists fills in the tracks of any smart playlists, scanning the library at most once
+func materializePlaylists(list []Playlist, stats *StatsStore) error {
+	var files []libraryFile
+	var allStats map[string]TrackStats
+	for i := range list {
+		if list[i].Rules == "" {
+			continue
+		}
+		if files == nil {
+			var err error
+			if files, err = scanLibrary(); err != nil {
+				return err
+			}
+			allStats = stats.Snapshot()
+		}
+		tracks, err := evaluateRules(files, allStats, list[i].Rules, list[i].Sort, list[i].Limit)
+		if err != nil {
+			return fmt.Errorf("playlist %s: %w", list[i].Name, err)
+		}
+		list[i].Tracks = tracks
+	}
+	return nil
+}
+
+func materializePlaylist(p *Playlist, stats *StatsStore) error {
+	list := []Playlist{*p}
+	err := materializePlaylists(list, stats)
+	*p = list[0]
+	return err
+}
+
+func validateSmartOptions(rules, sortBy string, limit int) error {
+	if _, err := parseRules(rules); err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	if err := sortRuleTracks(nil, sortBy); err != nil {
+		return err
+	}
+	if limit < 0 {
+		return errors.New("limit cannot be negative")
+	}
+	return nil
+}
+
+func registerPlaylistRoutes() {
+	handleFunc("GET /api/playlists", listPlaylists)
+	handleFunc("POST /api/playlists", requireRole(roleListener, createPlaylist))
+	handleFunc("POST /api/playlists/import", requireRole(roleAdmin, importPlaylists))
+	handleFunc("POST /api/playlists/preview", previewSmartPlaylist)
+	handleFunc("GET /api/playlists/{id}", getPlaylist)
+	handleFunc("PATCH /api/playlists/{id}", requireRole(roleListener, updatePlaylist))
+	handleFunc("DELETE /api/playlists/{id}", requireRole(roleListener, deletePlaylist))
+	handleFunc("POST /api/playlists/{id}/duplicate", requireRole(roleListener, duplicatePlaylist))
+	handleFunc("POST /api/playlists/{id}/tracks", requireRole(roleListener, addPlaylistTracks))
+	handleFunc("DELETE /api/playlists/{id}/tracks/{index}", requireRole(roleListener, removePlaylistTrack))
+	handleFunc("POST /api/playlists/{id}/reorder", requireRole(roleListener, reorderPlaylist))
+	handleFunc("GET /api/playlists/{id}/export", exportPlaylist)
+}
+
+func writePlaylistError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPlaylistNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errSmartPlaylist) || errors.Is(err, errGeneratedPlaylist) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func listPlaylists(w http.ResponseWriter, r *http.Request) {
+	list := stateFor(r).playlists.List()
+	if err := materializePlaylists(list, stateFor(r).stats); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func createPlaylist(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Tracks []string `json:"tracks"`
+		Rules  string   `json:"rules"`
+		Sort   string   `json:"sort"`
+		Limit  int      `json:"limit"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Playlist name is required", http.StatusBadRequest)
+		return
+	}
+	req.Rules = strings.TrimSpace(req.Rules)
+	if req.Rules != "" {
+		if len(req.Tracks) > 0 {
+			http.Error(w, errSmartPlaylist.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateSmartOptions(req.Rules, req.Sort, req.Limit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	tracks, err := validateTracks(req.Tracks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := stateFor(r).playlists.Add(Playlist{Name: req.Name, Tracks: tracks, Rules: req.Rules, Sort: req.Sort, Limit: req.Limit})
+	if err == nil {
+		err = materializePlaylist(&p, stateFor(r).stats)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func getPlaylist(w http.ResponseWriter, r *http.Request) {
+	p, err := stateFor(r).playlists.Get(r.PathValue("id"))
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	if err := materializePlaylist(&p, stateFor(r).stats); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// updatePlaylist handles rename, wholesale track replacement and editing smart playlist rules
+func updatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   *string   `json:"name"`
+		Tracks *[]string `json:"tracks"`
+		Rules  *string   `json:"rules"`
+		Sort   *string   `json:"sort"`
+		Limit  *int      `json:"limit"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var tracks []string
+	if req.Tracks != nil {
+		var err error
+		if tracks, err = validateTracks(*req.Tracks); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	p, err := stateFor(r).playlists.Update(r.PathValue("id"), func(p *Playlist) error {
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				return errors.New("playlist name cannot be empty")
+			}
+			p.Name = name
+		}
+		if p.Auto != "" && (req.Tracks != nil || req.Rules != nil) {
+			return errGeneratedPlaylist
+		}
+		if req.Rules != nil {
+			p.Rules = strings.TrimSpace(*req.Rules)
+		}
+		if req.Sort != nil {
+			p.Sort = *req.Sort
+		}
+		if req.Limit != nil {
+			p.Limit = *req.Limit
+		}
+		if p.Rules == "" {
+			if req.Tracks != nil {
+				p.Tracks = tracks
+			}
+			return nil
+		}
+		if req.Tracks != nil {
+			return errSmartPlaylist
+		}
+		p.Tracks = nil
+		return validateSmartOptions(p.Rules, p.Sort, p.Limit)
+	})
+	if err == nil {
+		err = materializePlaylist(&p, stateFor(r).stats)
+	}
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func deletePlaylist(w http.ResponseWriter, r *http.Request) {
+	if err := stateFor(r).playlists.Delete(r.PathValue("id")); err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func duplicatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	// Body is optional; without a name we derive one from the original
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	src, err := stateFor(r).playlists.Get(r.PathValue("id"))
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = src.Name + " (copy)"
+	}
+	p, err := stateFor(r).playlists.Add(Playlist{Name: name, Tracks: src.Tracks, Rules: src.Rules, Sort: src.Sort, Limit: src.Limit})
+	if err == nil {
+		err = materializePlaylist(&p, stateFor(r).stats)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+// addPlaylistTracks inserts tracks at position, or appends when position is omitted
+func addPlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Paths    []string `json:"paths"`
+		Position *int     `json:"position"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	tracks, err := validateTracks(req.Paths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := stateFor(r).playlists.Update(r.PathValue("id"), func(p *Playlist) error {
+		if err := p.trackEditError(); err != nil {
+			return err
+		}
+		pos := len(p.Tracks)
+		if req.Position != nil {
+			pos = *req.Position
+		}
+		if pos < 0 || pos > len(p.Tracks) {
+			return fmt.Errorf("position out of range: %d", pos)
+		}
+		p.Tracks = append(p.Tracks[:pos], append(tracks, p.Tracks[pos:]...)...)
+		return nil
+	})
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func removePlaylistTrack(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		http.Error(w, "Invalid track index", http.StatusBadRequest)
+		return
+	}
+	p, err := stateFor(r).playlists.Update(r.PathValue("id"), func(p *Playlist) error {
+		if err := p.trackEditError(); err != nil {
+			return err
+		}
+		if index < 0 || index >= len(p.Tracks) {
+			return fmt.Errorf("track index out of range: %d", index)
+		}
+		p.Tracks = append(p.Tracks[:index], p.Tracks[index+1:]...)
+		return nil
+	})
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// reorderPlaylist moves a single track from one index to another
+func reorderPlaylist(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From int `json:"from"`
+		To   int `json:"to"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	p, err := stateFor(r).playlists.Update(r.PathValue("id"), func(p *Playlist) error {
+		if err := p.trackEditError(); err != nil {
+			return err
+		}
+		n := len(p.Tracks)
+		if req.From < 0 || req.From >= n || req.To < 0 || req.To >= n {
+			return fmt.Errorf("track index out of range")
+		}
+		track := p.Tracks[req.From]
+		p.Tracks = append(p.Tracks[:req.From], p.Tracks[req.From+1:]...)
+		p.Tracks = append(p.Tracks[:req.To], append([]string{track}, p.Tracks[req.To:]...)...)
+		return nil
+	})
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// previewSmartPlaylist evaluates rules without saving them, for building smart playlists interactively
+func previewSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rules string `json:"rules"`
+		Sort  string `json:"sort"`
+		Limit int    `json:"limit"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := validateSmartOptions(req.Rules, req.Sort, req.Limit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tracks, err := evaluateRules(files, stateFor(r).stats.Snapshot(), req.Rules, req.Sort, req.Limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": tracks, "total": len(tracks)})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Plugins are programs in the -plugins folder that add to what beatgraze can do, in any
+// language. Each is started with the server and speaks JSON over its stdin and stdout, one
+// message per line: requests are {"id": 1, "method": "...", "params": {...}} and each gets
+// back {"id": 1, "result": ...} or {"id": 1, "error": "..."}, in any order. What a plugin
+// writes to stderr goes to the log, and it should exit when its stdin closes.
+//
+// The first request is "describe", answered with what the plugin provides:
+//
+//	{"name": "discogs", "metadata": true, "analyzer": false, "schemes": ["ipfs"]}
+//
+// and then, depending on that:
+//
+//   - "metadata" with {"path", "meta"}, for a metadata provider to fill in tags a file
+//     lacks. It answers with tags as in /api/files' meta; only ones the file didn't have
+//     are used.
+//   - "analyze" with {"path", "file", "meta"}, for an analyzer to work out things like
+//     bpm and key from the audio. file is where it is on disk, empty when the library
+//     isn't local. It answers the same way.
+//   - "storage.list" with {"url"}, for a library at a URL whose scheme the plugin named,
+//     answered with {"files": [{"path", "size", "modified"}]}.
+//   - "storage.read" with {"url", "path", "offset", "length"}, answered with {"data"} as
+//     base64, shorter than length only at the end of the file.
+
+// pluginsDir is -plugins: the folder plugins are started from, by default <data>/plugins
+var pluginsDir string
+
+const (
+	// pluginTimeout is how long a plugin has to answer before the request fails
+	pluginTimeout = 30 * time.Second
+	// pluginReadSize is how much of a file a storage plugin is asked for at once
+	pluginReadSize = 1 << 20
+	// maxPluginMessage is the longest line a plugin may send, enough for a read's base64
+	maxPluginMessage = 4 << 20
+)
+
+var errPluginStopped = errors.New("plugin has stopped")
+
+// pluginInfo is a plugin's answer to "describe"
+type pluginInfo struct {
+	Name     string   `json:"name"`
+	Metadata bool     `json:"metadata"`
+	Analyzer bool     `json:"analyzer"`
+	Schemes  []string `json:"schemes"`
+}
+
+type pluginResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// plugin is a running plugin process
+type plugin struct {
+	path string
+	info pluginInfo
+
+	mu      sync.Mutex
+	stdin   io.WriteCloser
+	nextID  int64
+	pending map[int64]chan pluginResponse
+	err     error // Why it stopped, once it has
+}
+
+// plugins are the ones that started, in name order
+var plugins []*plugin
+
+// startPlugins runs every executable in dir. One that won't start or describe itself is
+// logged and left out.
+func startPlugins(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Error reading plugins folder", "dir", dir, "err", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0111 == 0 || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		p, err := startPlugin(filepath.Join(dir, e.Name()))
+		if err != nil {
+			slog.Warn("Error starting plugin", "plugin", e.Name(), "err", err)
+			continue
+		}
+		slog.Info("Started plugin", "plugin", p.info.Name, "metadata", p.info.Metadata, "analyzer", p.info.Analyzer, "schemes", strings.Join(p.info.Schemes, ","))
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].info.Name < plugins[j].info.Name })
+}
+
+func startPlugin(file string) (*plugin, error) {
+	cmd := exec.Command(file)
+	cmd.Dir = filepath.Dir(file)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &plugin{path: file, stdin: stdin, pending: map[int64]chan pluginResponse{}}
+	go p.logStderr(stderr)
+	go func() {
+		p.readResponses(stdout)
+		err := cmd.Wait()
+		if err == nil {
+			err = errPluginStopped
+		}
+		p.stop(err)
+	}()
+	var info pluginInfo
+	if err := p.call(context.Background(), "describe", nil, &info); err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("describe: %w", err)
+	}
+	if info.Name == "" {
+		info.Name = filepath.Base(file)
+	}
+	p.info = info
+	return p, nil
+}
+
+func (p *plugin) logStderr(stderr io.Reader) {
+	lines := bufio.NewScanner(stderr)
+	for lines.Scan() {
+		slog.Info("Plugin: "+lines.Text(), "plugin", filepath.Base(p.path))
+	}
+}
+
+func (p *plugin) readResponses(stdout io.Reader) {
+	lines := bufio.NewScanner(stdout)
+	lines.Buffer(make([]byte, 64<<10), maxPluginMessage)
+	for lines.Scan() {
+		var resp pluginResponse
+		if err := json.Unmarshal(lines.Bytes(), &resp); err != nil {
+			slog.Warn("Plugin sent something other than JSON", "plugin", filepath.Base(p.path), "err", err)
+			continue
+		}
+		p.mu.Lock()
+		ch := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+	}
+	if err := lines.Err(); err != nil {
+		slog.Warn("Error reading from plugin", "plugin", filepath.Base(p.path), "err", err)
+	}
+}
+
+// stop fails every request still waiting, and any made from now on
+func (p *plugin) stop(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	slog.Warn("Plugin stopped", "plugin", filepath.Base(p.path), "err", err)
+	p.err = err
+	for id, ch := range p.pending {
+		ch <- pluginResponse{ID: id, Error: err.Error()}
+		delete(p.pending, id)
+	}
+	p.stdin.Close()
+}
+
+// call sends a request and decodes the result into result, which may be nil
+func (p *plugin) call(ctx context.Context, method string, params, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	ch := make(chan pluginResponse, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return p.err
+	}
+	p.nextID++
+	id := p.nextID
+	line, err := json.Marshal(struct {
+		ID     int64  `json:"id"`
+		Method string `json:"method"`
+		Params any    `json:"params,omitempty"`
+	}{id, method, params})
+	if err == nil {
+		// Waiting before writing, since the answer can come straight back
+		p.pending[id] = ch
+		if _, err = p.stdin.Write(append(line, '\n')); err != nil {
+			delete(p.pending, id)
+		}
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != "" {
+			return fmt.Errorf("plugin %s: %s", p.info.Name, resp.Error)
+		}
+		if result == nil || len(resp.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return fmt.Errorf("plugin %s: %s: %w", p.info.Name, method, ctx.Err())
+	}
+}
+
+// pluginMeta lets metadata and analyzer plugins fill in what a file's tags left out
+func pluginMeta(f libraryFile, meta TrackMeta) TrackMeta {
+	for _, p := range plugins {
+		if p.info.Metadata {
+			meta = callMetaPlugin(p, "metadata", map[string]any{"path": f.Path, "meta": meta}, meta)
+		}
+		if p.info.Analyzer {
+			file, _ := localLibraryPath(f.Path)
+			meta = callMetaPlugin(p, "analyze", map[string]any{"path": f.Path, "file": file, "meta": meta}, meta)
+		}
+	}
+	return meta
+}
+
+func callMetaPlugin(p *plugin, method string, params map[string]any, meta TrackMeta) TrackMeta {
+	var found TrackMeta
+	if err := p.call(context.Background(), method, params, &found); err != nil {
+		slog.Debug("Plugin found nothing", "plugin", p.info.Name, "path", params["path"], "err", err)
+		return meta
+	}
+	fillMeta(&meta, found)
+	return meta
+}
+
+// fillMeta copies the fields of from that meta doesn't have
+func fillMeta(meta *TrackMeta, from TrackMeta) {
+	for _, f := range []struct{ to, from *string }{
+		{&meta.Title, &from.Title}, {&meta.Artist, &from.Artist}, {&meta.Album, &from.Album},
+		{&meta.Genre, &from.Genre}, {&meta.Key, &from.Key},
+	} {
+		if *f.to == "" {
+			*f.to = *f.from
+		}
+	}
+	if meta.BPM == 0 {
+		meta.BPM = from.BPM
+	}
+	if meta.Track == 0 {
+		meta.Track = from.Track
+	}
+	if meta.Year == 0 {
+		meta.Year = from.Year
+	}
+	if meta.Duration == 0 {
+		meta.Duration = from.Duration
+	}
+}
+
+// pluginForScheme is the storage plugin for a library URL, if any
+func pluginForScheme(dir string) *plugin {
+	scheme, _, ok := strings.Cut(dir, "://")
+	if !ok {
+		return nil
+	}
+	for _, p := range plugins {
+		for _, s := range p.info.Schemes {
+			if strings.EqualFold(s, scheme) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// newPluginStorage is a library a storage plugin lists and reads
+func newPluginStorage(p *plugin, rawURL string) *remoteStorage {
+	name := path.Base(strings.TrimSuffix(rawURL, "/"))
+	return &remoteStorage{
+		id:   rawURL,
+		name: name,
+		list: func() (map[string]remoteFileInfo, error) {
+			var listing struct {
+				Files []SyncFile `json:"files"`
+			}
+			if err := p.call(context.Background(), "storage.list", map[string]string{"url": rawURL}, &listing); err != nil {
+				return nil, err
+			}
+			files := make(map[string]remoteFileInfo, len(listing.Files))
+			for _, f := range listing.Files {
+				files[f.Path] = remoteFileInfo{name: path.Base(f.Path), size: f.Size, modTime: f.Modified}
+			}
+			return files, nil
+		},
+		read: func(relPath string, offset int64) (io.ReadCloser, error) {
+			return &pluginReader{p: p, url: rawURL, path: relPath, offset: offset}, nil
+		},
+	}
+}
+
+// pluginReader reads a file from a storage plugin a block at a time
+type pluginReader struct {
+	p      *plugin
+	url    string
+	path   string
+	offset int64
+	buf    []byte
+	eof    bool
+}
+
+func (r *pluginReader) Read(b []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		var chunk struct {
+			Data []byte `json:"data"`
+		}
+		params := map[string]any{"url": r.url, "path": r.path, "offset": r.offset, "length": pluginReadSize}
+		if err := r.p.call(context.Background(), "storage.read", params, &chunk); err != nil {
+			return 0, err
+		}
+		r.buf, r.offset, r.eof = chunk.Data, r.offset+int64(len(chunk.Data)), len(chunk.Data) < pluginReadSize
+		if len(r.buf) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *pluginReader) Close() error {
+	return nil
+}
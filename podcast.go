@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	ITunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	PubDate     string       `xml:"pubDate,omitempty"`
+	Generator   string       `xml:"generator"`
+	Image       *rssImage    `xml:"image,omitempty"`
+	Author      string       `xml:"itunes:author,omitempty"`
+	ITunesImage *itunesImage `xml:"itunes:image,omitempty"`
+	Items       []rssItem    `xml:"item"`
+}
+
+type rssImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link,omitempty"`
+	Description string       `xml:"description,omitempty"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Author      string       `xml:"itunes:author,omitempty"`
+	Duration    string       `xml:"itunes:duration,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func registerPodcastRoutes() {
+	handleFunc("GET /feed/{name...}", requireRole(roleListener, getFolderPodcast))
+}
+
+// getFolderPodcast serves a folder's tracks as a podcast, newest first, at /feed/<folder>.xml
+// (/feed/.xml for the top of the library). ?recursive=1 takes in its subfolders too. Podcast
+// apps can't sign in, so they need Basic credentials in the feed's URL, or an API key or
+// -auth-token as ?token=, which is passed on to the episodes.
+func getFolderPodcast(w http.ResponseWriter, r *http.Request) {
+	dir, ok := strings.CutSuffix(r.PathValue("name"), ".xml")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	dir = strings.Trim(dir, "/")
+	if dir != "" && path.Clean(dir) != dir {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "1"
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dir != "" && buildFolderTree(files).find(dir) == nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	var episodes []libraryFile
+	for _, f := range files {
+		if inFolder(f.Path, dir, recursive) {
+			episodes = append(episodes, f)
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool { return episodes[i].ModTime.After(episodes[j].ModTime) })
+
+	link := func(p string) string {
+		u := streamURL(r, p)
+		if token := r.URL.Query().Get("token"); token != "" {
+			u += "?" + url.Values{"token": {token}}.Encode()
+		}
+		return u
+	}
+	title := path.Base(dir)
+	if dir == "" {
+		title = libraryName()
+	}
+	ch := rssChannel{
+		Title:       title,
+		Link:        externalURL(r, "/"),
+		Description: fmt.Sprintf("Tracks in %s on beatgraze", title),
+		Generator:   "beatgraze " + versionInfo().Version,
+	}
+	if cover, ok := folderCover(dir); ok {
+		ch.Image = &rssImage{URL: link(cover), Title: title, Link: ch.Link}
+		ch.ITunesImage = &itunesImage{Href: ch.Image.URL}
+	}
+	artists := map[string]bool{}
+	for _, f := range episodes {
+		meta := trackMeta(f)
+		item := rssItem{
+			Title:       firstNonEmpty(meta.Title, trackTitle(f.Path)),
+			Description: meta.Album,
+			GUID:        rssGUID{Value: f.Path},
+			PubDate:     f.ModTime.UTC().Format(time.RFC1123Z),
+			Enclosure:   rssEnclosure{URL: link(f.Path), Length: f.Size, Type: dlnaMime(f.Path)},
+			Author:      meta.Artist,
+		}
+		if meta.Duration > 0 {
+			item.Duration = podcastDuration(meta.Duration)
+		}
+		if meta.Artist != "" {
+			artists[meta.Artist] = true
+		}
+		ch.Items = append(ch.Items, item)
+	}
+	// A channel whose tracks are all by one artist is theirs
+	if len(artists) == 1 {
+		for a := range artists {
+			ch.Author = a
+		}
+	}
+	if len(episodes) > 0 {
+		ch.PubDate = episodes[0].ModTime.UTC().Format(time.RFC1123Z)
+		w.Header().Set("Last-Modified", episodes[0].ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(rssFeed{Version: "2.0", ITunes: itunesNS, Channel: ch})
+}
+
+// podcastDuration writes seconds the way iTunes wants them, as H:MM:SS
+func podcastDuration(seconds float64) string {
+	s := int(seconds + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
@@ -0,0 +1,1686 @@
+// The gRPC API, served on the same port as the web UI and HTTP API. Calls authenticate the
+// same way too: send an "authorization" header with Basic credentials, a bearer API key or
+// the -token secret.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: proto/beatgraze.proto
+
+package beatgrazepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Track struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Path          string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Folder        string                 `protobuf:"bytes,3,opt,name=folder,proto3" json:"folder,omitempty"`
+	Dir           string                 `protobuf:"bytes,4,opt,name=dir,proto3" json:"dir,omitempty"`
+	Size          int64                  `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	Modified      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=modified,proto3" json:"modified,omitempty"`
+	Tags          *TrackTags             `protobuf:"bytes,7,opt,name=tags,proto3" json:"tags,omitempty"` // Only when asked for, since reading tags is slow on big libraries
+	Stats         *TrackStats            `protobuf:"bytes,8,opt,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Track) Reset() {
+	*x = Track{}
+	mi := &file_proto_beatgraze_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Track) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Track) ProtoMessage() {}
+
+func (x *Track) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Track.ProtoReflect.Descriptor instead.
+func (*Track) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Track) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Track) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Track) GetFolder() string {
+	if x != nil {
+		return x.Folder
+	}
+	return ""
+}
+
+func (x *Track) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+func (x *Track) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Track) GetModified() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Modified
+	}
+	return nil
+}
+
+func (x *Track) GetTags() *TrackTags {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Track) GetStats() *TrackStats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type TrackTags struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Title         string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Artist        string                 `protobuf:"bytes,2,opt,name=artist,proto3" json:"artist,omitempty"`
+	Album         string                 `protobuf:"bytes,3,opt,name=album,proto3" json:"album,omitempty"`
+	Genre         string                 `protobuf:"bytes,4,opt,name=genre,proto3" json:"genre,omitempty"`
+	Key           string                 `protobuf:"bytes,5,opt,name=key,proto3" json:"key,omitempty"`
+	Bpm           float64                `protobuf:"fixed64,6,opt,name=bpm,proto3" json:"bpm,omitempty"`
+	Track         int32                  `protobuf:"varint,7,opt,name=track,proto3" json:"track,omitempty"`
+	Year          int32                  `protobuf:"varint,8,opt,name=year,proto3" json:"year,omitempty"`
+	Duration      float64                `protobuf:"fixed64,9,opt,name=duration,proto3" json:"duration,omitempty"` // Seconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrackTags) Reset() {
+	*x = TrackTags{}
+	mi := &file_proto_beatgraze_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrackTags) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackTags) ProtoMessage() {}
+
+func (x *TrackTags) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackTags.ProtoReflect.Descriptor instead.
+func (*TrackTags) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TrackTags) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *TrackTags) GetArtist() string {
+	if x != nil {
+		return x.Artist
+	}
+	return ""
+}
+
+func (x *TrackTags) GetAlbum() string {
+	if x != nil {
+		return x.Album
+	}
+	return ""
+}
+
+func (x *TrackTags) GetGenre() string {
+	if x != nil {
+		return x.Genre
+	}
+	return ""
+}
+
+func (x *TrackTags) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *TrackTags) GetBpm() float64 {
+	if x != nil {
+		return x.Bpm
+	}
+	return 0
+}
+
+func (x *TrackTags) GetTrack() int32 {
+	if x != nil {
+		return x.Track
+	}
+	return 0
+}
+
+func (x *TrackTags) GetYear() int32 {
+	if x != nil {
+		return x.Year
+	}
+	return 0
+}
+
+func (x *TrackTags) GetDuration() float64 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+type TrackStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rating        int32                  `protobuf:"varint,1,opt,name=rating,proto3" json:"rating,omitempty"`
+	PlayCount     int32                  `protobuf:"varint,2,opt,name=play_count,json=playCount,proto3" json:"play_count,omitempty"`
+	LastPlayed    *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=last_played,json=lastPlayed,proto3" json:"last_played,omitempty"`
+	Favorite      bool                   `protobuf:"varint,4,opt,name=favorite,proto3" json:"favorite,omitempty"`
+	Position      float64                `protobuf:"fixed64,5,opt,name=position,proto3" json:"position,omitempty"` // Seconds into the track to resume from
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrackStats) Reset() {
+	*x = TrackStats{}
+	mi := &file_proto_beatgraze_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrackStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackStats) ProtoMessage() {}
+
+func (x *TrackStats) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackStats.ProtoReflect.Descriptor instead.
+func (*TrackStats) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TrackStats) GetRating() int32 {
+	if x != nil {
+		return x.Rating
+	}
+	return 0
+}
+
+func (x *TrackStats) GetPlayCount() int32 {
+	if x != nil {
+		return x.PlayCount
+	}
+	return 0
+}
+
+func (x *TrackStats) GetLastPlayed() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastPlayed
+	}
+	return nil
+}
+
+func (x *TrackStats) GetFavorite() bool {
+	if x != nil {
+		return x.Favorite
+	}
+	return false
+}
+
+func (x *TrackStats) GetPosition() float64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+type ListTracksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Search        string                 `protobuf:"bytes,1,opt,name=search,proto3" json:"search,omitempty"` // Like the search box, including dir: queries
+	Dir           string                 `protobuf:"bytes,2,opt,name=dir,proto3" json:"dir,omitempty"`
+	Shallow       bool                   `protobuf:"varint,3,opt,name=shallow,proto3" json:"shallow,omitempty"`                   // Only tracks directly in dir, not in its subfolders
+	Filter        string                 `protobuf:"bytes,4,opt,name=filter,proto3" json:"filter,omitempty"`                      // Smart playlist rules, like "genre=techno AND bpm 120-130"
+	Sort          string                 `protobuf:"bytes,5,opt,name=sort,proto3" json:"sort,omitempty"`                          // A smart playlist sort field, like "-plays"; by name when empty
+	PageSize      int32                  `protobuf:"varint,6,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"` // Default 200, at most 1000
+	Offset        int32                  `protobuf:"varint,7,opt,name=offset,proto3" json:"offset,omitempty"`
+	IncludeTags   bool                   `protobuf:"varint,8,opt,name=include_tags,json=includeTags,proto3" json:"include_tags,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTracksRequest) Reset() {
+	*x = ListTracksRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTracksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTracksRequest) ProtoMessage() {}
+
+func (x *ListTracksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTracksRequest.ProtoReflect.Descriptor instead.
+func (*ListTracksRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListTracksRequest) GetSearch() string {
+	if x != nil {
+		return x.Search
+	}
+	return ""
+}
+
+func (x *ListTracksRequest) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+func (x *ListTracksRequest) GetShallow() bool {
+	if x != nil {
+		return x.Shallow
+	}
+	return false
+}
+
+func (x *ListTracksRequest) GetFilter() string {
+	if x != nil {
+		return x.Filter
+	}
+	return ""
+}
+
+func (x *ListTracksRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *ListTracksRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListTracksRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ListTracksRequest) GetIncludeTags() bool {
+	if x != nil {
+		return x.IncludeTags
+	}
+	return false
+}
+
+type ListTracksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tracks        []*Track               `protobuf:"bytes,1,rep,name=tracks,proto3" json:"tracks,omitempty"`
+	Total         int32                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTracksResponse) Reset() {
+	*x = ListTracksResponse{}
+	mi := &file_proto_beatgraze_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTracksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTracksResponse) ProtoMessage() {}
+
+func (x *ListTracksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTracksResponse.ProtoReflect.Descriptor instead.
+func (*ListTracksResponse) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListTracksResponse) GetTracks() []*Track {
+	if x != nil {
+		return x.Tracks
+	}
+	return nil
+}
+
+func (x *ListTracksResponse) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+type GetTrackRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrackRequest) Reset() {
+	*x = GetTrackRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrackRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrackRequest) ProtoMessage() {}
+
+func (x *GetTrackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrackRequest.ProtoReflect.Descriptor instead.
+func (*GetTrackRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetTrackRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type GetFolderTreeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"` // The folder to start from; the library root when empty
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetFolderTreeRequest) Reset() {
+	*x = GetFolderTreeRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetFolderTreeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFolderTreeRequest) ProtoMessage() {}
+
+func (x *GetFolderTreeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFolderTreeRequest.ProtoReflect.Descriptor instead.
+func (*GetFolderTreeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetFolderTreeRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type Folder struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Path          string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Files         int32                  `protobuf:"varint,3,opt,name=files,proto3" json:"files,omitempty"` // Tracks directly in this folder
+	Total         int32                  `protobuf:"varint,4,opt,name=total,proto3" json:"total,omitempty"` // Tracks in this folder and below
+	Folders       []*Folder              `protobuf:"bytes,5,rep,name=folders,proto3" json:"folders,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Folder) Reset() {
+	*x = Folder{}
+	mi := &file_proto_beatgraze_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Folder) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Folder) ProtoMessage() {}
+
+func (x *Folder) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Folder.ProtoReflect.Descriptor instead.
+func (*Folder) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Folder) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Folder) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Folder) GetFiles() int32 {
+	if x != nil {
+		return x.Files
+	}
+	return 0
+}
+
+func (x *Folder) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *Folder) GetFolders() []*Folder {
+	if x != nil {
+		return x.Folders
+	}
+	return nil
+}
+
+type ScanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanRequest) Reset() {
+	*x = ScanRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanRequest) ProtoMessage() {}
+
+func (x *ScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanRequest.ProtoReflect.Descriptor instead.
+func (*ScanRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{8}
+}
+
+type ScanProgress struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Scanned       int32                  `protobuf:"varint,1,opt,name=scanned,proto3" json:"scanned,omitempty"`
+	Total         int32                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"` // The track just read
+	Done          bool                   `protobuf:"varint,4,opt,name=done,proto3" json:"done,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanProgress) Reset() {
+	*x = ScanProgress{}
+	mi := &file_proto_beatgraze_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanProgress) ProtoMessage() {}
+
+func (x *ScanProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanProgress.ProtoReflect.Descriptor instead.
+func (*ScanProgress) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ScanProgress) GetScanned() int32 {
+	if x != nil {
+		return x.Scanned
+	}
+	return 0
+}
+
+func (x *ScanProgress) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *ScanProgress) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ScanProgress) GetDone() bool {
+	if x != nil {
+		return x.Done
+	}
+	return false
+}
+
+type Playlist struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Tracks        []string               `protobuf:"bytes,3,rep,name=tracks,proto3" json:"tracks,omitempty"`
+	Source        string                 `protobuf:"bytes,4,opt,name=source,proto3" json:"source,omitempty"`
+	Rules         string                 `protobuf:"bytes,5,opt,name=rules,proto3" json:"rules,omitempty"`
+	Auto          string                 `protobuf:"bytes,6,opt,name=auto,proto3" json:"auto,omitempty"`
+	Sort          string                 `protobuf:"bytes,7,opt,name=sort,proto3" json:"sort,omitempty"`
+	Limit         int32                  `protobuf:"varint,8,opt,name=limit,proto3" json:"limit,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=created,proto3" json:"created,omitempty"`
+	Updated       *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=updated,proto3" json:"updated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Playlist) Reset() {
+	*x = Playlist{}
+	mi := &file_proto_beatgraze_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Playlist) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Playlist) ProtoMessage() {}
+
+func (x *Playlist) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Playlist.ProtoReflect.Descriptor instead.
+func (*Playlist) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Playlist) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Playlist) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Playlist) GetTracks() []string {
+	if x != nil {
+		return x.Tracks
+	}
+	return nil
+}
+
+func (x *Playlist) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Playlist) GetRules() string {
+	if x != nil {
+		return x.Rules
+	}
+	return ""
+}
+
+func (x *Playlist) GetAuto() string {
+	if x != nil {
+		return x.Auto
+	}
+	return ""
+}
+
+func (x *Playlist) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *Playlist) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *Playlist) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Playlist) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+type ListPlaylistsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPlaylistsRequest) Reset() {
+	*x = ListPlaylistsRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPlaylistsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPlaylistsRequest) ProtoMessage() {}
+
+func (x *ListPlaylistsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPlaylistsRequest.ProtoReflect.Descriptor instead.
+func (*ListPlaylistsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{11}
+}
+
+type ListPlaylistsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Playlists     []*Playlist            `protobuf:"bytes,1,rep,name=playlists,proto3" json:"playlists,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPlaylistsResponse) Reset() {
+	*x = ListPlaylistsResponse{}
+	mi := &file_proto_beatgraze_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPlaylistsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPlaylistsResponse) ProtoMessage() {}
+
+func (x *ListPlaylistsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPlaylistsResponse.ProtoReflect.Descriptor instead.
+func (*ListPlaylistsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ListPlaylistsResponse) GetPlaylists() []*Playlist {
+	if x != nil {
+		return x.Playlists
+	}
+	return nil
+}
+
+type GetPlaylistRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPlaylistRequest) Reset() {
+	*x = GetPlaylistRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPlaylistRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPlaylistRequest) ProtoMessage() {}
+
+func (x *GetPlaylistRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPlaylistRequest.ProtoReflect.Descriptor instead.
+func (*GetPlaylistRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *GetPlaylistRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type CreatePlaylistRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Tracks        []string               `protobuf:"bytes,2,rep,name=tracks,proto3" json:"tracks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreatePlaylistRequest) Reset() {
+	*x = CreatePlaylistRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreatePlaylistRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreatePlaylistRequest) ProtoMessage() {}
+
+func (x *CreatePlaylistRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreatePlaylistRequest.ProtoReflect.Descriptor instead.
+func (*CreatePlaylistRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *CreatePlaylistRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CreatePlaylistRequest) GetTracks() []string {
+	if x != nil {
+		return x.Tracks
+	}
+	return nil
+}
+
+type AddTracksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Tracks        []string               `protobuf:"bytes,2,rep,name=tracks,proto3" json:"tracks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddTracksRequest) Reset() {
+	*x = AddTracksRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddTracksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddTracksRequest) ProtoMessage() {}
+
+func (x *AddTracksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddTracksRequest.ProtoReflect.Descriptor instead.
+func (*AddTracksRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *AddTracksRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AddTracksRequest) GetTracks() []string {
+	if x != nil {
+		return x.Tracks
+	}
+	return nil
+}
+
+type DeletePlaylistRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeletePlaylistRequest) Reset() {
+	*x = DeletePlaylistRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeletePlaylistRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeletePlaylistRequest) ProtoMessage() {}
+
+func (x *DeletePlaylistRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeletePlaylistRequest.ProtoReflect.Descriptor instead.
+func (*DeletePlaylistRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *DeletePlaylistRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type DeletePlaylistResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeletePlaylistResponse) Reset() {
+	*x = DeletePlaylistResponse{}
+	mi := &file_proto_beatgraze_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeletePlaylistResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeletePlaylistResponse) ProtoMessage() {}
+
+func (x *DeletePlaylistResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeletePlaylistResponse.ProtoReflect.Descriptor instead.
+func (*DeletePlaylistResponse) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{17}
+}
+
+type RecordPlayRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordPlayRequest) Reset() {
+	*x = RecordPlayRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordPlayRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordPlayRequest) ProtoMessage() {}
+
+func (x *RecordPlayRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordPlayRequest.ProtoReflect.Descriptor instead.
+func (*RecordPlayRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *RecordPlayRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type SetPositionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Position      float64                `protobuf:"fixed64,2,opt,name=position,proto3" json:"position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetPositionRequest) Reset() {
+	*x = SetPositionRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetPositionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetPositionRequest) ProtoMessage() {}
+
+func (x *SetPositionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetPositionRequest.ProtoReflect.Descriptor instead.
+func (*SetPositionRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *SetPositionRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SetPositionRequest) GetPosition() float64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+type SetRatingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Rating        int32                  `protobuf:"varint,2,opt,name=rating,proto3" json:"rating,omitempty"` // 0 to 5
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetRatingRequest) Reset() {
+	*x = SetRatingRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetRatingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetRatingRequest) ProtoMessage() {}
+
+func (x *SetRatingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetRatingRequest.ProtoReflect.Descriptor instead.
+func (*SetRatingRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *SetRatingRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SetRatingRequest) GetRating() int32 {
+	if x != nil {
+		return x.Rating
+	}
+	return 0
+}
+
+type SetFavoriteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Favorite      bool                   `protobuf:"varint,2,opt,name=favorite,proto3" json:"favorite,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetFavoriteRequest) Reset() {
+	*x = SetFavoriteRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetFavoriteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetFavoriteRequest) ProtoMessage() {}
+
+func (x *SetFavoriteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetFavoriteRequest.ProtoReflect.Descriptor instead.
+func (*SetFavoriteRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *SetFavoriteRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SetFavoriteRequest) GetFavorite() bool {
+	if x != nil {
+		return x.Favorite
+	}
+	return false
+}
+
+type WatchNowPlayingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchNowPlayingRequest) Reset() {
+	*x = WatchNowPlayingRequest{}
+	mi := &file_proto_beatgraze_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchNowPlayingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchNowPlayingRequest) ProtoMessage() {}
+
+func (x *WatchNowPlayingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchNowPlayingRequest.ProtoReflect.Descriptor instead.
+func (*WatchNowPlayingRequest) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{22}
+}
+
+type NowPlaying struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	Track         *Track                 `protobuf:"bytes,2,opt,name=track,proto3" json:"track,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NowPlaying) Reset() {
+	*x = NowPlaying{}
+	mi := &file_proto_beatgraze_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NowPlaying) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NowPlaying) ProtoMessage() {}
+
+func (x *NowPlaying) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_beatgraze_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NowPlaying.ProtoReflect.Descriptor instead.
+func (*NowPlaying) Descriptor() ([]byte, []int) {
+	return file_proto_beatgraze_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *NowPlaying) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *NowPlaying) GetTrack() *Track {
+	if x != nil {
+		return x.Track
+	}
+	return nil
+}
+
+func (x *NowPlaying) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+var File_proto_beatgraze_proto protoreflect.FileDescriptor
+
+const file_proto_beatgraze_proto_rawDesc = "" +
+	"\n" +
+	"\x15proto/beatgraze.proto\x12\fbeatgraze.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x82\x02\n" +
+	"\x05Track\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x16\n" +
+	"\x06folder\x18\x03 \x01(\tR\x06folder\x12\x10\n" +
+	"\x03dir\x18\x04 \x01(\tR\x03dir\x12\x12\n" +
+	"\x04size\x18\x05 \x01(\x03R\x04size\x126\n" +
+	"\bmodified\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\bmodified\x12+\n" +
+	"\x04tags\x18\a \x01(\v2\x17.beatgraze.v1.TrackTagsR\x04tags\x12.\n" +
+	"\x05stats\x18\b \x01(\v2\x18.beatgraze.v1.TrackStatsR\x05stats\"\xcf\x01\n" +
+	"\tTrackTags\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x16\n" +
+	"\x06artist\x18\x02 \x01(\tR\x06artist\x12\x14\n" +
+	"\x05album\x18\x03 \x01(\tR\x05album\x12\x14\n" +
+	"\x05genre\x18\x04 \x01(\tR\x05genre\x12\x10\n" +
+	"\x03key\x18\x05 \x01(\tR\x03key\x12\x10\n" +
+	"\x03bpm\x18\x06 \x01(\x01R\x03bpm\x12\x14\n" +
+	"\x05track\x18\a \x01(\x05R\x05track\x12\x12\n" +
+	"\x04year\x18\b \x01(\x05R\x04year\x12\x1a\n" +
+	"\bduration\x18\t \x01(\x01R\bduration\"\xb8\x01\n" +
+	"\n" +
+	"TrackStats\x12\x16\n" +
+	"\x06rating\x18\x01 \x01(\x05R\x06rating\x12\x1d\n" +
+	"\n" +
+	"play_count\x18\x02 \x01(\x05R\tplayCount\x12;\n" +
+	"\vlast_played\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"lastPlayed\x12\x1a\n" +
+	"\bfavorite\x18\x04 \x01(\bR\bfavorite\x12\x1a\n" +
+	"\bposition\x18\x05 \x01(\x01R\bposition\"\xdb\x01\n" +
+	"\x11ListTracksRequest\x12\x16\n" +
+	"\x06search\x18\x01 \x01(\tR\x06search\x12\x10\n" +
+	"\x03dir\x18\x02 \x01(\tR\x03dir\x12\x18\n" +
+	"\ashallow\x18\x03 \x01(\bR\ashallow\x12\x16\n" +
+	"\x06filter\x18\x04 \x01(\tR\x06filter\x12\x12\n" +
+	"\x04sort\x18\x05 \x01(\tR\x04sort\x12\x1b\n" +
+	"\tpage_size\x18\x06 \x01(\x05R\bpageSize\x12\x16\n" +
+	"\x06offset\x18\a \x01(\x05R\x06offset\x12!\n" +
+	"\finclude_tags\x18\b \x01(\bR\vincludeTags\"W\n" +
+	"\x12ListTracksResponse\x12+\n" +
+	"\x06tracks\x18\x01 \x03(\v2\x13.beatgraze.v1.TrackR\x06tracks\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x05R\x05total\"%\n" +
+	"\x0fGetTrackRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"*\n" +
+	"\x14GetFolderTreeRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"\x8c\x01\n" +
+	"\x06Folder\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x14\n" +
+	"\x05files\x18\x03 \x01(\x05R\x05files\x12\x14\n" +
+	"\x05total\x18\x04 \x01(\x05R\x05total\x12.\n" +
+	"\afolders\x18\x05 \x03(\v2\x14.beatgraze.v1.FolderR\afolders\"\r\n" +
+	"\vScanRequest\"f\n" +
+	"\fScanProgress\x12\x18\n" +
+	"\ascanned\x18\x01 \x01(\x05R\ascanned\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x05R\x05total\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x12\n" +
+	"\x04done\x18\x04 \x01(\bR\x04done\"\x9e\x02\n" +
+	"\bPlaylist\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06tracks\x18\x03 \x03(\tR\x06tracks\x12\x16\n" +
+	"\x06source\x18\x04 \x01(\tR\x06source\x12\x14\n" +
+	"\x05rules\x18\x05 \x01(\tR\x05rules\x12\x12\n" +
+	"\x04auto\x18\x06 \x01(\tR\x04auto\x12\x12\n" +
+	"\x04sort\x18\a \x01(\tR\x04sort\x12\x14\n" +
+	"\x05limit\x18\b \x01(\x05R\x05limit\x124\n" +
+	"\acreated\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\aupdated\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\"\x16\n" +
+	"\x14ListPlaylistsRequest\"M\n" +
+	"\x15ListPlaylistsResponse\x124\n" +
+	"\tplaylists\x18\x01 \x03(\v2\x16.beatgraze.v1.PlaylistR\tplaylists\"$\n" +
+	"\x12GetPlaylistRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"C\n" +
+	"\x15CreatePlaylistRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06tracks\x18\x02 \x03(\tR\x06tracks\":\n" +
+	"\x10AddTracksRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06tracks\x18\x02 \x03(\tR\x06tracks\"'\n" +
+	"\x15DeletePlaylistRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x18\n" +
+	"\x16DeletePlaylistResponse\"'\n" +
+	"\x11RecordPlayRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"D\n" +
+	"\x12SetPositionRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1a\n" +
+	"\bposition\x18\x02 \x01(\x01R\bposition\">\n" +
+	"\x10SetRatingRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
+	"\x06rating\x18\x02 \x01(\x05R\x06rating\"D\n" +
+	"\x12SetFavoriteRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1a\n" +
+	"\bfavorite\x18\x02 \x01(\bR\bfavorite\"\x18\n" +
+	"\x16WatchNowPlayingRequest\"{\n" +
+	"\n" +
+	"NowPlaying\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\x12)\n" +
+	"\x05track\x18\x02 \x01(\v2\x13.beatgraze.v1.TrackR\x05track\x12.\n" +
+	"\x04time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04time2\xa6\x02\n" +
+	"\aLibrary\x12O\n" +
+	"\n" +
+	"ListTracks\x12\x1f.beatgraze.v1.ListTracksRequest\x1a .beatgraze.v1.ListTracksResponse\x12>\n" +
+	"\bGetTrack\x12\x1d.beatgraze.v1.GetTrackRequest\x1a\x13.beatgraze.v1.Track\x12I\n" +
+	"\rGetFolderTree\x12\".beatgraze.v1.GetFolderTreeRequest\x1a\x14.beatgraze.v1.Folder\x12?\n" +
+	"\x04Scan\x12\x19.beatgraze.v1.ScanRequest\x1a\x1a.beatgraze.v1.ScanProgress0\x012\x9f\x03\n" +
+	"\tPlaylists\x12X\n" +
+	"\rListPlaylists\x12\".beatgraze.v1.ListPlaylistsRequest\x1a#.beatgraze.v1.ListPlaylistsResponse\x12G\n" +
+	"\vGetPlaylist\x12 .beatgraze.v1.GetPlaylistRequest\x1a\x16.beatgraze.v1.Playlist\x12M\n" +
+	"\x0eCreatePlaylist\x12#.beatgraze.v1.CreatePlaylistRequest\x1a\x16.beatgraze.v1.Playlist\x12C\n" +
+	"\tAddTracks\x12\x1e.beatgraze.v1.AddTracksRequest\x1a\x16.beatgraze.v1.Playlist\x12[\n" +
+	"\x0eDeletePlaylist\x12#.beatgraze.v1.DeletePlaylistRequest\x1a$.beatgraze.v1.DeletePlaylistResponse2\x85\x03\n" +
+	"\bPlayback\x12G\n" +
+	"\n" +
+	"RecordPlay\x12\x1f.beatgraze.v1.RecordPlayRequest\x1a\x18.beatgraze.v1.TrackStats\x12I\n" +
+	"\vSetPosition\x12 .beatgraze.v1.SetPositionRequest\x1a\x18.beatgraze.v1.TrackStats\x12E\n" +
+	"\tSetRating\x12\x1e.beatgraze.v1.SetRatingRequest\x1a\x18.beatgraze.v1.TrackStats\x12I\n" +
+	"\vSetFavorite\x12 .beatgraze.v1.SetFavoriteRequest\x1a\x18.beatgraze.v1.TrackStats\x12S\n" +
+	"\x0fWatchNowPlaying\x12$.beatgraze.v1.WatchNowPlayingRequest\x1a\x18.beatgraze.v1.NowPlaying0\x01B\x1dZ\x1bbeatgraze/proto/beatgrazepbb\x06proto3"
+
+var (
+	file_proto_beatgraze_proto_rawDescOnce sync.Once
+	file_proto_beatgraze_proto_rawDescData []byte
+)
+
+func file_proto_beatgraze_proto_rawDescGZIP() []byte {
+	file_proto_beatgraze_proto_rawDescOnce.Do(func() {
+		file_proto_beatgraze_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_beatgraze_proto_rawDesc), len(file_proto_beatgraze_proto_rawDesc)))
+	})
+	return file_proto_beatgraze_proto_rawDescData
+}
+
+var file_proto_beatgraze_proto_msgTypes = make([]protoimpl.MessageInfo, 24)
+var file_proto_beatgraze_proto_goTypes = []any{
+	(*Track)(nil),                  // 0: beatgraze.v1.Track
+	(*TrackTags)(nil),              // 1: beatgraze.v1.TrackTags
+	(*TrackStats)(nil),             // 2: beatgraze.v1.TrackStats
+	(*ListTracksRequest)(nil),      // 3: beatgraze.v1.ListTracksRequest
+	(*ListTracksResponse)(nil),     // 4: beatgraze.v1.ListTracksResponse
+	(*GetTrackRequest)(nil),        // 5: beatgraze.v1.GetTrackRequest
+	(*GetFolderTreeRequest)(nil),   // 6: beatgraze.v1.GetFolderTreeRequest
+	(*Folder)(nil),                 // 7: beatgraze.v1.Folder
+	(*ScanRequest)(nil),            // 8: beatgraze.v1.ScanRequest
+	(*ScanProgress)(nil),           // 9: beatgraze.v1.ScanProgress
+	(*Playlist)(nil),               // 10: beatgraze.v1.Playlist
+	(*ListPlaylistsRequest)(nil),   // 11: beatgraze.v1.ListPlaylistsRequest
+	(*ListPlaylistsResponse)(nil),  // 12: beatgraze.v1.ListPlaylistsResponse
+	(*GetPlaylistRequest)(nil),     // 13: beatgraze.v1.GetPlaylistRequest
+	(*CreatePlaylistRequest)(nil),  // 14: beatgraze.v1.CreatePlaylistRequest
+	(*AddTracksRequest)(nil),       // 15: beatgraze.v1.AddTracksRequest
+	(*DeletePlaylistRequest)(nil),  // 16: beatgraze.v1.DeletePlaylistRequest
+	(*DeletePlaylistResponse)(nil), // 17: beatgraze.v1.DeletePlaylistResponse
+	(*RecordPlayRequest)(nil),      // 18: beatgraze.v1.RecordPlayRequest
+	(*SetPositionRequest)(nil),     // 19: beatgraze.v1.SetPositionRequest
+	(*SetRatingRequest)(nil),       // 20: beatgraze.v1.SetRatingRequest
+	(*SetFavoriteRequest)(nil),     // 21: beatgraze.v1.SetFavoriteRequest
+	(*WatchNowPlayingRequest)(nil), // 22: beatgraze.v1.WatchNowPlayingRequest
+	(*NowPlaying)(nil),             // 23: beatgraze.v1.NowPlaying
+	(*timestamppb.Timestamp)(nil),  // 24: google.protobuf.Timestamp
+}
+var file_proto_beatgraze_proto_depIdxs = []int32{
+	24, // 0: beatgraze.v1.Track.modified:type_name -> google.protobuf.Timestamp
+	1,  // 1: beatgraze.v1.Track.tags:type_name -> beatgraze.v1.TrackTags
+	2,  // 2: beatgraze.v1.Track.stats:type_name -> beatgraze.v1.TrackStats
+	24, // 3: beatgraze.v1.TrackStats.last_played:type_name -> google.protobuf.Timestamp
+	0,  // 4: beatgraze.v1.ListTracksResponse.tracks:type_name -> beatgraze.v1.Track
+	7,  // 5: beatgraze.v1.Folder.folders:type_name -> beatgraze.v1.Folder
+	24, // 6: beatgraze.v1.Playlist.created:type_name -> google.protobuf.Timestamp
+	24, // 7: beatgraze.v1.Playlist.updated:type_name -> google.protobuf.Timestamp
+	10, // 8: beatgraze.v1.ListPlaylistsResponse.playlists:type_name -> beatgraze.v1.Playlist
+	0,  // 9: beatgraze.v1.NowPlaying.track:type_name -> beatgraze.v1.Track
+	24, // 10: beatgraze.v1.NowPlaying.time:type_name -> google.protobuf.Timestamp
+	3,  // 11: beatgraze.v1.Library.ListTracks:input_type -> beatgraze.v1.ListTracksRequest
+	5,  // 12: beatgraze.v1.Library.GetTrack:input_type -> beatgraze.v1.GetTrackRequest
+	6,  // 13: beatgraze.v1.Library.GetFolderTree:input_type -> beatgraze.v1.GetFolderTreeRequest
+	8,  // 14: beatgraze.v1.Library.Scan:input_type -> beatgraze.v1.ScanRequest
+	11, // 15: beatgraze.v1.Playlists.ListPlaylists:input_type -> beatgraze.v1.ListPlaylistsRequest
+	13, // 16: beatgraze.v1.Playlists.GetPlaylist:input_type -> beatgraze.v1.GetPlaylistRequest
+	14, // 17: beatgraze.v1.Playlists.CreatePlaylist:input_type -> beatgraze.v1.CreatePlaylistRequest
+	15, // 18: beatgraze.v1.Playlists.AddTracks:input_type -> beatgraze.v1.AddTracksRequest
+	16, // 19: beatgraze.v1.Playlists.DeletePlaylist:input_type -> beatgraze.v1.DeletePlaylistRequest
+	18, // 20: beatgraze.v1.Playback.RecordPlay:input_type -> beatgraze.v1.RecordPlayRequest
+	19, // 21: beatgraze.v1.Playback.SetPosition:input_type -> beatgraze.v1.SetPositionRequest
+	20, // 22: beatgraze.v1.Playback.SetRating:input_type -> beatgraze.v1.SetRatingRequest
+	21, // 23: beatgraze.v1.Playback.SetFavorite:input_type -> beatgraze.v1.SetFavoriteRequest
+	22, // 24: beatgraze.v1.Playback.WatchNowPlaying:input_type -> beatgraze.v1.WatchNowPlayingRequest
+	4,  // 25: beatgraze.v1.Library.ListTracks:output_type -> beatgraze.v1.ListTracksResponse
+	0,  // 26: beatgraze.v1.Library.GetTrack:output_type -> beatgraze.v1.Track
+	7,  // 27: beatgraze.v1.Library.GetFolderTree:output_type -> beatgraze.v1.Folder
+	9,  // 28: beatgraze.v1.Library.Scan:output_type -> beatgraze.v1.ScanProgress
+	12, // 29: beatgraze.v1.Playlists.ListPlaylists:output_type -> beatgraze.v1.ListPlaylistsResponse
+	10, // 30: beatgraze.v1.Playlists.GetPlaylist:output_type -> beatgraze.v1.Playlist
+	10, // 31: beatgraze.v1.Playlists.CreatePlaylist:output_type -> beatgraze.v1.Playlist
+	10, // 32: beatgraze.v1.Playlists.AddTracks:output_type -> beatgraze.v1.Playlist
+	17, // 33: beatgraze.v1.Playlists.DeletePlaylist:output_type -> beatgraze.v1.DeletePlaylistResponse
+	2,  // 34: beatgraze.v1.Playback.RecordPlay:output_type -> beatgraze.v1.TrackStats
+	2,  // 35: beatgraze.v1.Playback.SetPosition:output_type -> beatgraze.v1.TrackStats
+	2,  // 36: beatgraze.v1.Playback.SetRating:output_type -> beatgraze.v1.TrackStats
+	2,  // 37: beatgraze.v1.Playback.SetFavorite:output_type -> beatgraze.v1.TrackStats
+	23, // 38: beatgraze.v1.Playback.WatchNowPlaying:output_type -> beatgraze.v1.NowPlaying
+	25, // [25:39] is the sub-list for method output_type
+	11, // [11:25] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_proto_beatgraze_proto_init() }
+func file_proto_beatgraze_proto_init() {
+	if File_proto_beatgraze_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_beatgraze_proto_rawDesc), len(file_proto_beatgraze_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   24,
+			NumExtensions: 0,
+			NumServices:   3,
+		},
+		GoTypes:           file_proto_beatgraze_proto_goTypes,
+		DependencyIndexes: file_proto_beatgraze_proto_depIdxs,
+		MessageInfos:      file_proto_beatgraze_proto_msgTypes,
+	}.Build()
+	File_proto_beatgraze_proto = out.File
+	file_proto_beatgraze_proto_goTypes = nil
+	file_proto_beatgraze_proto_depIdxs = nil
+}
@@ -0,0 +1,759 @@
+// The gRPC API, served on the same port as the web UI and HTTP API. Calls authenticate the
+// same way too: send an "authorization" header with Basic credentials, a bearer API key or
+// the -token secret.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: proto/beatgraze.proto
+
+package beatgrazepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Library_ListTracks_FullMethodName    = "/beatgraze.v1.Library/ListTracks"
+	Library_GetTrack_FullMethodName      = "/beatgraze.v1.Library/GetTrack"
+	Library_GetFolderTree_FullMethodName = "/beatgraze.v1.Library/GetFolderTree"
+	Library_Scan_FullMethodName          = "/beatgraze.v1.Library/Scan"
+)
+
+// LibraryClient is the client API for Library service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LibraryClient interface {
+	ListTracks(ctx context.Context, in *ListTracksRequest, opts ...grpc.CallOption) (*ListTracksResponse, error)
+	GetTrack(ctx context.Context, in *GetTrackRequest, opts ...grpc.CallOption) (*Track, error)
+	GetFolderTree(ctx context.Context, in *GetFolderTreeRequest, opts ...grpc.CallOption) (*Folder, error)
+	// Scan walks the library and reads every track's tags, reporting progress as it goes
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanProgress], error)
+}
+
+type libraryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLibraryClient(cc grpc.ClientConnInterface) LibraryClient {
+	return &libraryClient{cc}
+}
+
+func (c *libraryClient) ListTracks(ctx context.Context, in *ListTracksRequest, opts ...grpc.CallOption) (*ListTracksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTracksResponse)
+	err := c.cc.Invoke(ctx, Library_ListTracks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryClient) GetTrack(ctx context.Context, in *GetTrackRequest, opts ...grpc.CallOption) (*Track, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Track)
+	err := c.cc.Invoke(ctx, Library_GetTrack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryClient) GetFolderTree(ctx context.Context, in *GetFolderTreeRequest, opts ...grpc.CallOption) (*Folder, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Folder)
+	err := c.cc.Invoke(ctx, Library_GetFolderTree_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Library_ServiceDesc.Streams[0], Library_Scan_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ScanRequest, ScanProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Library_ScanClient = grpc.ServerStreamingClient[ScanProgress]
+
+// LibraryServer is the server API for Library service.
+// All implementations must embed UnimplementedLibraryServer
+// for forward compatibility.
+type LibraryServer interface {
+	ListTracks(context.Context, *ListTracksRequest) (*ListTracksResponse, error)
+	GetTrack(context.Context, *GetTrackRequest) (*Track, error)
+	GetFolderTree(context.Context, *GetFolderTreeRequest) (*Folder, error)
+	// Scan walks the library and reads every track's tags, reporting progress as it goes
+	Scan(*ScanRequest, grpc.ServerStreamingServer[ScanProgress]) error
+	mustEmbedUnimplementedLibraryServer()
+}
+
+// UnimplementedLibraryServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLibraryServer struct{}
+
+func (UnimplementedLibraryServer) ListTracks(context.Context, *ListTracksRequest) (*ListTracksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTracks not implemented")
+}
+func (UnimplementedLibraryServer) GetTrack(context.Context, *GetTrackRequest) (*Track, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTrack not implemented")
+}
+func (UnimplementedLibraryServer) GetFolderTree(context.Context, *GetFolderTreeRequest) (*Folder, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFolderTree not implemented")
+}
+func (UnimplementedLibraryServer) Scan(*ScanRequest, grpc.ServerStreamingServer[ScanProgress]) error {
+	return status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedLibraryServer) mustEmbedUnimplementedLibraryServer() {}
+func (UnimplementedLibraryServer) testEmbeddedByValue()                 {}
+
+// UnsafeLibraryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LibraryServer will
+// result in compilation errors.
+type UnsafeLibraryServer interface {
+	mustEmbedUnimplementedLibraryServer()
+}
+
+func RegisterLibraryServer(s grpc.ServiceRegistrar, srv LibraryServer) {
+	// If the following call pancis, it indicates UnimplementedLibraryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Library_ServiceDesc, srv)
+}
+
+func _Library_ListTracks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTracksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServer).ListTracks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Library_ListTracks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServer).ListTracks(ctx, req.(*ListTracksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Library_GetTrack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTrackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServer).GetTrack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Library_GetTrack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServer).GetTrack(ctx, req.(*GetTrackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Library_GetFolderTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFolderTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServer).GetFolderTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Library_GetFolderTree_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServer).GetFolderTree(ctx, req.(*GetFolderTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Library_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LibraryServer).Scan(m, &grpc.GenericServerStream[ScanRequest, ScanProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Library_ScanServer = grpc.ServerStreamingServer[ScanProgress]
+
+// Library_ServiceDesc is the grpc.ServiceDesc for Library service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Library_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "beatgraze.v1.Library",
+	HandlerType: (*LibraryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListTracks",
+			Handler:    _Library_ListTracks_Handler,
+		},
+		{
+			MethodName: "GetTrack",
+			Handler:    _Library_GetTrack_Handler,
+		},
+		{
+			MethodName: "GetFolderTree",
+			Handler:    _Library_GetFolderTree_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			Handler:       _Library_Scan_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/beatgraze.proto",
+}
+
+const (
+	Playlists_ListPlaylists_FullMethodName  = "/beatgraze.v1.Playlists/ListPlaylists"
+	Playlists_GetPlaylist_FullMethodName    = "/beatgraze.v1.Playlists/GetPlaylist"
+	Playlists_CreatePlaylist_FullMethodName = "/beatgraze.v1.Playlists/CreatePlaylist"
+	Playlists_AddTracks_FullMethodName      = "/beatgraze.v1.Playlists/AddTracks"
+	Playlists_DeletePlaylist_FullMethodName = "/beatgraze.v1.Playlists/DeletePlaylist"
+)
+
+// PlaylistsClient is the client API for Playlists service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PlaylistsClient interface {
+	ListPlaylists(ctx context.Context, in *ListPlaylistsRequest, opts ...grpc.CallOption) (*ListPlaylistsResponse, error)
+	GetPlaylist(ctx context.Context, in *GetPlaylistRequest, opts ...grpc.CallOption) (*Playlist, error)
+	CreatePlaylist(ctx context.Context, in *CreatePlaylistRequest, opts ...grpc.CallOption) (*Playlist, error)
+	AddTracks(ctx context.Context, in *AddTracksRequest, opts ...grpc.CallOption) (*Playlist, error)
+	DeletePlaylist(ctx context.Context, in *DeletePlaylistRequest, opts ...grpc.CallOption) (*DeletePlaylistResponse, error)
+}
+
+type playlistsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPlaylistsClient(cc grpc.ClientConnInterface) PlaylistsClient {
+	return &playlistsClient{cc}
+}
+
+func (c *playlistsClient) ListPlaylists(ctx context.Context, in *ListPlaylistsRequest, opts ...grpc.CallOption) (*ListPlaylistsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListPlaylistsResponse)
+	err := c.cc.Invoke(ctx, Playlists_ListPlaylists_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playlistsClient) GetPlaylist(ctx context.Context, in *GetPlaylistRequest, opts ...grpc.CallOption) (*Playlist, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Playlist)
+	err := c.cc.Invoke(ctx, Playlists_GetPlaylist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playlistsClient) CreatePlaylist(ctx context.Context, in *CreatePlaylistRequest, opts ...grpc.CallOption) (*Playlist, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Playlist)
+	err := c.cc.Invoke(ctx, Playlists_CreatePlaylist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playlistsClient) AddTracks(ctx context.Context, in *AddTracksRequest, opts ...grpc.CallOption) (*Playlist, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Playlist)
+	err := c.cc.Invoke(ctx, Playlists_AddTracks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playlistsClient) DeletePlaylist(ctx context.Context, in *DeletePlaylistRequest, opts ...grpc.CallOption) (*DeletePlaylistResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeletePlaylistResponse)
+	err := c.cc.Invoke(ctx, Playlists_DeletePlaylist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PlaylistsServer is the server API for Playlists service.
+// All implementations must embed UnimplementedPlaylistsServer
+// for forward compatibility.
+type PlaylistsServer interface {
+	ListPlaylists(context.Context, *ListPlaylistsRequest) (*ListPlaylistsResponse, error)
+	GetPlaylist(context.Context, *GetPlaylistRequest) (*Playlist, error)
+	CreatePlaylist(context.Context, *CreatePlaylistRequest) (*Playlist, error)
+	AddTracks(context.Context, *AddTracksRequest) (*Playlist, error)
+	DeletePlaylist(context.Context, *DeletePlaylistRequest) (*DeletePlaylistResponse, error)
+	mustEmbedUnimplementedPlaylistsServer()
+}
+
+// UnimplementedPlaylistsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPlaylistsServer struct{}
+
+func (UnimplementedPlaylistsServer) ListPlaylists(context.Context, *ListPlaylistsRequest) (*ListPlaylistsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPlaylists not implemented")
+}
+func (UnimplementedPlaylistsServer) GetPlaylist(context.Context, *GetPlaylistRequest) (*Playlist, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPlaylist not implemented")
+}
+func (UnimplementedPlaylistsServer) CreatePlaylist(context.Context, *CreatePlaylistRequest) (*Playlist, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePlaylist not implemented")
+}
+func (UnimplementedPlaylistsServer) AddTracks(context.Context, *AddTracksRequest) (*Playlist, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddTracks not implemented")
+}
+func (UnimplementedPlaylistsServer) DeletePlaylist(context.Context, *DeletePlaylistRequest) (*DeletePlaylistResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePlaylist not implemented")
+}
+func (UnimplementedPlaylistsServer) mustEmbedUnimplementedPlaylistsServer() {}
+func (UnimplementedPlaylistsServer) testEmbeddedByValue()                   {}
+
+// UnsafePlaylistsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PlaylistsServer will
+// result in compilation errors.
+type UnsafePlaylistsServer interface {
+	mustEmbedUnimplementedPlaylistsServer()
+}
+
+func RegisterPlaylistsServer(s grpc.ServiceRegistrar, srv PlaylistsServer) {
+	// If the following call pancis, it indicates UnimplementedPlaylistsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Playlists_ServiceDesc, srv)
+}
+
+func _Playlists_ListPlaylists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPlaylistsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaylistsServer).ListPlaylists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playlists_ListPlaylists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaylistsServer).ListPlaylists(ctx, req.(*ListPlaylistsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playlists_GetPlaylist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPlaylistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaylistsServer).GetPlaylist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playlists_GetPlaylist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaylistsServer).GetPlaylist(ctx, req.(*GetPlaylistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playlists_CreatePlaylist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePlaylistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaylistsServer).CreatePlaylist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playlists_CreatePlaylist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaylistsServer).CreatePlaylist(ctx, req.(*CreatePlaylistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playlists_AddTracks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddTracksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaylistsServer).AddTracks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playlists_AddTracks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaylistsServer).AddTracks(ctx, req.(*AddTracksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playlists_DeletePlaylist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePlaylistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaylistsServer).DeletePlaylist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playlists_DeletePlaylist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaylistsServer).DeletePlaylist(ctx, req.(*DeletePlaylistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Playlists_ServiceDesc is the grpc.ServiceDesc for Playlists service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Playlists_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "beatgraze.v1.Playlists",
+	HandlerType: (*PlaylistsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPlaylists",
+			Handler:    _Playlists_ListPlaylists_Handler,
+		},
+		{
+			MethodName: "GetPlaylist",
+			Handler:    _Playlists_GetPlaylist_Handler,
+		},
+		{
+			MethodName: "CreatePlaylist",
+			Handler:    _Playlists_CreatePlaylist_Handler,
+		},
+		{
+			MethodName: "AddTracks",
+			Handler:    _Playlists_AddTracks_Handler,
+		},
+		{
+			MethodName: "DeletePlaylist",
+			Handler:    _Playlists_DeletePlaylist_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/beatgraze.proto",
+}
+
+const (
+	Playback_RecordPlay_FullMethodName      = "/beatgraze.v1.Playback/RecordPlay"
+	Playback_SetPosition_FullMethodName     = "/beatgraze.v1.Playback/SetPosition"
+	Playback_SetRating_FullMethodName       = "/beatgraze.v1.Playback/SetRating"
+	Playback_SetFavorite_FullMethodName     = "/beatgraze.v1.Playback/SetFavorite"
+	Playback_WatchNowPlaying_FullMethodName = "/beatgraze.v1.Playback/WatchNowPlaying"
+)
+
+// PlaybackClient is the client API for Playback service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PlaybackClient interface {
+	RecordPlay(ctx context.Context, in *RecordPlayRequest, opts ...grpc.CallOption) (*TrackStats, error)
+	SetPosition(ctx context.Context, in *SetPositionRequest, opts ...grpc.CallOption) (*TrackStats, error)
+	SetRating(ctx context.Context, in *SetRatingRequest, opts ...grpc.CallOption) (*TrackStats, error)
+	SetFavorite(ctx context.Context, in *SetFavoriteRequest, opts ...grpc.CallOption) (*TrackStats, error)
+	// WatchNowPlaying sends each play as it's recorded: the caller's own, or everyone's for admins
+	WatchNowPlaying(ctx context.Context, in *WatchNowPlayingRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[NowPlaying], error)
+}
+
+type playbackClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPlaybackClient(cc grpc.ClientConnInterface) PlaybackClient {
+	return &playbackClient{cc}
+}
+
+func (c *playbackClient) RecordPlay(ctx context.Context, in *RecordPlayRequest, opts ...grpc.CallOption) (*TrackStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrackStats)
+	err := c.cc.Invoke(ctx, Playback_RecordPlay_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playbackClient) SetPosition(ctx context.Context, in *SetPositionRequest, opts ...grpc.CallOption) (*TrackStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrackStats)
+	err := c.cc.Invoke(ctx, Playback_SetPosition_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playbackClient) SetRating(ctx context.Context, in *SetRatingRequest, opts ...grpc.CallOption) (*TrackStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrackStats)
+	err := c.cc.Invoke(ctx, Playback_SetRating_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playbackClient) SetFavorite(ctx context.Context, in *SetFavoriteRequest, opts ...grpc.CallOption) (*TrackStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrackStats)
+	err := c.cc.Invoke(ctx, Playback_SetFavorite_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *playbackClient) WatchNowPlaying(ctx context.Context, in *WatchNowPlayingRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[NowPlaying], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Playback_ServiceDesc.Streams[0], Playback_WatchNowPlaying_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchNowPlayingRequest, NowPlaying]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Playback_WatchNowPlayingClient = grpc.ServerStreamingClient[NowPlaying]
+
+// PlaybackServer is the server API for Playback service.
+// All implementations must embed UnimplementedPlaybackServer
+// for forward compatibility.
+type PlaybackServer interface {
+	RecordPlay(context.Context, *RecordPlayRequest) (*TrackStats, error)
+	SetPosition(context.Context, *SetPositionRequest) (*TrackStats, error)
+	SetRating(context.Context, *SetRatingRequest) (*TrackStats, error)
+	SetFavorite(context.Context, *SetFavoriteRequest) (*TrackStats, error)
+	// WatchNowPlaying sends each play as it's recorded: the caller's own, or everyone's for admins
+	WatchNowPlaying(*WatchNowPlayingRequest, grpc.ServerStreamingServer[NowPlaying]) error
+	mustEmbedUnimplementedPlaybackServer()
+}
+
+// UnimplementedPlaybackServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPlaybackServer struct{}
+
+func (UnimplementedPlaybackServer) RecordPlay(context.Context, *RecordPlayRequest) (*TrackStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordPlay not implemented")
+}
+func (UnimplementedPlaybackServer) SetPosition(context.Context, *SetPositionRequest) (*TrackStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPosition not implemented")
+}
+func (UnimplementedPlaybackServer) SetRating(context.Context, *SetRatingRequest) (*TrackStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRating not implemented")
+}
+func (UnimplementedPlaybackServer) SetFavorite(context.Context, *SetFavoriteRequest) (*TrackStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetFavorite not implemented")
+}
+func (UnimplementedPlaybackServer) WatchNowPlaying(*WatchNowPlayingRequest, grpc.ServerStreamingServer[NowPlaying]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNowPlaying not implemented")
+}
+func (UnimplementedPlaybackServer) mustEmbedUnimplementedPlaybackServer() {}
+func (UnimplementedPlaybackServer) testEmbeddedByValue()                  {}
+
+// UnsafePlaybackServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PlaybackServer will
+// result in compilation errors.
+type UnsafePlaybackServer interface {
+	mustEmbedUnimplementedPlaybackServer()
+}
+
+func RegisterPlaybackServer(s grpc.ServiceRegistrar, srv PlaybackServer) {
+	// If the following call pancis, it indicates UnimplementedPlaybackServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Playback_ServiceDesc, srv)
+}
+
+func _Playback_RecordPlay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RecordPlayRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaybackServer).RecordPlay(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playback_RecordPlay_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaybackServer).RecordPlay(ctx, req.(*RecordPlayRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playback_SetPosition_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPositionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaybackServer).SetPosition(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playback_SetPosition_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaybackServer).SetPosition(ctx, req.(*SetPositionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playback_SetRating_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRatingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaybackServer).SetRating(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playback_SetRating_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaybackServer).SetRating(ctx, req.(*SetRatingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playback_SetFavorite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetFavoriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaybackServer).SetFavorite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Playback_SetFavorite_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaybackServer).SetFavorite(ctx, req.(*SetFavoriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Playback_WatchNowPlaying_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchNowPlayingRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PlaybackServer).WatchNowPlaying(m, &grpc.GenericServerStream[WatchNowPlayingRequest, NowPlaying]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Playback_WatchNowPlayingServer = grpc.ServerStreamingServer[NowPlaying]
+
+// Playback_ServiceDesc is the grpc.ServiceDesc for Playback service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Playback_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "beatgraze.v1.Playback",
+	HandlerType: (*PlaybackServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RecordPlay",
+			Handler:    _Playback_RecordPlay_Handler,
+		},
+		{
+			MethodName: "SetPosition",
+			Handler:    _Playback_SetPosition_Handler,
+		},
+		{
+			MethodName: "SetRating",
+			Handler:    _Playback_SetRating_Handler,
+		},
+		{
+			MethodName: "SetFavorite",
+			Handler:    _Playback_SetFavorite_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchNowPlaying",
+			Handler:       _Playback_WatchNowPlaying_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/beatgraze.proto",
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+	"rsc.io/qr"
+)
+
+// showQR is -qr: print a QR code of the server's LAN address at startup, so a phone can open
+// the player by pointing its camera at the terminal
+var showQR = true
+
+const (
+	qrScale    = 8  // Default pixels per module of /api/qr.png
+	maxQRScale = 32 // Largest ?scale= allowed, which makes a code well over a thousand pixels
+	qrBorder   = 2  // Modules of quiet zone around the terminal code; readers manage with less than the spec's 4
+)
+
+// lanURL is where other devices on the network reach the server: the listener's host when
+// it's bound to one, otherwise this machine's private IPv4 address. There's none for a Unix
+// socket or when only loopback is listened on.
+func lanURL(scheme, addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	if loopbackHost(host) {
+		return "", false
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		if host = lanIP(); host == "" {
+			return "", false
+		}
+	}
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port), Path: prefixed("/")}
+	return u.String(), true
+}
+
+func loopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// lanIP is the first private IPv4 address of this machine's interfaces, or failing that the
+// first other non-loopback one, which is what a phone on the same Wi-Fi can reach
+func lanIP() string {
+	addrs, _ := net.InterfaceAddrs()
+	fallback := ""
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.IsPrivate() {
+			return ipnet.IP.String()
+		}
+		if fallback == "" {
+			fallback = ipnet.IP.String()
+		}
+	}
+	return fallback
+}
+
+// printStartupQR shows the LAN address as a QR code when the server is run in a terminal;
+// under a service manager it would only clutter the log
+func printStartupQR(scheme string) {
+	if !showQR || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return
+	}
+	u, ok := lanURL(scheme, listenAddr)
+	if !ok {
+		return
+	}
+	code, err := qr.Encode(u, qr.L)
+	if err != nil {
+		slog.Warn("Error making QR code", "err", err)
+		return
+	}
+	writeTerminalQR(os.Stdout, code)
+	io.WriteString(os.Stdout, "Scan to open "+u+"\n")
+}
+
+// writeTerminalQR draws code with half blocks, two modules to a character, in explicit black
+// and white so it scans on dark and light terminals alike
+func writeTerminalQR(w io.Writer, code *qr.Code) {
+	var b strings.Builder
+	dark := func(x, y int) bool {
+		return code.Black(x-qrBorder, y-qrBorder)
+	}
+	size := code.Size + 2*qrBorder
+	for y := 0; y < size; y += 2 {
+		for x := 0; x < size; x++ {
+			// The top module is the foreground, the bottom one the background
+			fg, bg := "97", "107"
+			if dark(x, y) {
+				fg = "30"
+			}
+			if y+1 < size && dark(x, y+1) {
+				bg = "40"
+			}
+			b.WriteString("\x1b[" + fg + ";" + bg + "m▀")
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	io.WriteString(w, b.String())
+}
+
+func registerQRRoutes() {
+	handleFunc("GET /api/qr.png", getQRCode)
+}
+
+// getQRCode is a QR code of the address to open the player at. Asked from the server's own
+// machine, that's its LAN address rather than localhost, which a phone can't reach; otherwise
+// the address the request came in on, which works from anywhere it did.
+func getQRCode(w http.ResponseWriter, r *http.Request) {
+	u := externalURL(r, "/")
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if loopbackHost(host) {
+		scheme := "http"
+		if tlsEnabled() {
+			scheme = "https"
+		}
+		if lan, ok := lanURL(scheme, listenAddr); ok {
+			u = lan
+		}
+	}
+	code, err := qr.Encode(u, qr.L)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code.Scale = qrScale
+	if s := r.URL.Query().Get("scale"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxQRScale {
+			http.Error(w, "scale must be from 1 to "+strconv.Itoa(maxQRScale), http.StatusBadRequest)
+			return
+		}
+		code.Scale = n
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(code.PNG())
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// AccessConfig holds the network-facing limits: CIDR allow/deny lists and per-IP
+// token buckets, with streaming budgeted separately so a long listen doesn't eat API calls.
+type AccessConfig struct {
+	Allow          string
+	Deny           string
+	TrustedProxies string
+	APIRate        float64 // Requests per second; 0 disables
+	APIBurst       int
+	StreamRate     float64
+	StreamBurst    int
+}
+
+var accessConfig = AccessConfig{APIBurst: 60, StreamBurst: 30}
+
+// accessRules are the access settings as they apply to requests. A reload swaps in a new
+// set whole, so requests and background listeners always see one consistent set.
+type accessRules struct {
+	allow, deny, proxies []netip.Prefix
+	api, stream          *ipLimiter
+}
+
+var access atomic.Pointer[accessRules]
+
+// currentAccess is the access rules in effect, which are none before they're set up
+func currentAccess() *accessRules {
+	if a := access.Load(); a != nil {
+		return a
+	}
+	return &accessRules{}
+}
+
+// allows applies the deny list, then the allow list when there is one
+func (a *accessRules) allows(ip netip.Addr) bool {
+	return !containsAddr(a.deny, ip) && (len(a.allow) == 0 || containsAddr(a.allow, ip))
+}
+
+type ipLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	clients map[netip.Addr]*limiterEntry
+	done    chan struct{}
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newIPLimiter(perSecond float64, burst int) *ipLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	l := &ipLimiter{limit: rate.Limit(perSecond), burst: max(burst, 1), clients: map[netip.Addr]*limiterEntry{}, done: make(chan struct{})}
+	go l.prune()
+	return l
+}
+
+// replaceIPLimiter keeps old, and the clients it's tracking, when a reload leaves its settings alone
+func replaceIPLimiter(old *ipLimiter, perSecond float64, burst int) *ipLimiter {
+	if old != nil && old.limit == rate.Limit(perSecond) && old.burst == max(burst, 1) {
+		return old
+	}
+	if old != nil {
+		close(old.done)
+	}
+	return newIPLimiter(perSecond, burst)
+}
+
+func (l *ipLimiter) Allow(ip netip.Addr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.clients[ip]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = e
+	}
+	e.lastSeen = time.Now()
+	return e.limiter.Allow()
+}
+
+// prune forgets clients that have gone quiet so the map doesn't grow forever
+func (l *ipLimiter) prune() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		l.mu.Lock()
+		for ip, e := range l.clients {
+			if time.Since(e.lastSeen) > 10*time.Minute {
+				delete(l.clients, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// parsePrefixes reads a comma-separated list of CIDRs or bare addresses
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func setupAccess() error {
+	rules, err := parseAccess()
+	if err != nil {
+		return err
+	}
+	applyAccess(rules)
+	return nil
+}
+
+// parseAccess checks the access settings, without putting them into effect
+func parseAccess() (*accessRules, error) {
+	allow, err := parsePrefixes(accessConfig.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parsePrefixes(accessConfig.Deny)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := parsePrefixes(accessConfig.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &accessRules{allow: allow, deny: deny, proxies: proxies}, nil
+}
+
+// applyAccess puts rules into effect, with rate limiters for the current limits
+func applyAccess(rules *accessRules) {
+	old := currentAccess()
+	rules.api = replaceIPLimiter(old.api, accessConfig.APIRate, accessConfig.APIBurst)
+	rules.stream = replaceIPLimiter(old.stream, accessConfig.StreamRate, accessConfig.StreamBurst)
+	access.Store(rules)
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy reports whether the request came through a -trusted-proxies peer, or a
+// local proxy on the Unix socket, whose X-Forwarded headers can be believed
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return strings.HasPrefix(listenAddr, "unix:")
+	}
+	return containsAddr(currentAccess().proxies, ip.Unmap())
+}
+
+// clientIP is the peer address, or when that's a trusted proxy or a Unix socket, the
+// nearest untrusted hop in X-Forwarded-For (earlier entries are client-controlled).
+func clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := currentAccess().proxies
+	ip, err := netip.ParseAddr(host)
+	if err == nil {
+		ip = ip.Unmap()
+		if !containsAddr(proxies, ip) {
+			return ip
+		}
+	} else if !strings.HasPrefix(listenAddr, "unix:") {
+		return netip.Addr{}
+	}
+	// Peers on a Unix socket have no address; they're the local reverse proxy, so trust it
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !containsAddr(proxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func isStreamRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/rest/stream", "/rest/stream.view", "/rest/download", "/rest/download.view":
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/audio/") || strings.HasPrefix(r.URL.Path, "/radio/") || strings.HasPrefix(r.URL.Path, "/upnp/media/") || strings.HasPrefix(r.URL.Path, "/cast/") || strings.HasPrefix(r.URL.Path, "/ha/media/") || r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/dav/") || strings.HasPrefix(r.URL.Path, "/s/") && strings.Count(r.URL.Path, "/") > 2
+}
+
+var (
+	maxStreams    = 100
+	activeStreams atomic.Int64
+)
+
+// limitStreams caps concurrent audio responses across all clients, so slow readers holding
+// connections open can't exhaust file descriptors
+func limitStreams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxStreams <= 0 || !isStreamRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer activeStreams.Add(-1)
+		if activeStreams.Add(1) > int64(maxStreams) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Too many streams in progress", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitAccess applies the deny list, then the allow list (when set), then the rate limits
+func limitAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		rules := currentAccess()
+		if !rules.allows(ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		limiter, perSecond := rules.api, accessConfig.APIRate
+		if isStreamRequest(r) {
+			limiter, perSecond = rules.stream, accessConfig.StreamRate
+		}
+		if limiter != nil && !limiter.Allow(ip) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(max(1, 1/perSecond))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import "net/http"
+
+var readOnly bool
+
+// readOnlyExempt are writes that only touch the caller's sign-in, never the library or saved state
+var readOnlyExempt = map[string]bool{
+	"POST /api/login":  true,
+	"POST /api/logout": true,
+	// Reloading only rereads the config, which read-only mode itself comes from
+	"POST /api/admin/reload": true,
+}
+
+// queryRoutes are POSTs that only read, like GraphQL queries and the gRPC calls that fetch
+// things, so they're allowed wherever reads are
+var queryRoutes = map[string]bool{
+	"POST /api/graphql": true,
+}
+
+// blockWrites turns away every mutating request under -read-only, whoever is asking
+func blockWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		default:
+			if readOnly && !readOnlyExempt[r.Method+" "+r.URL.Path] && !queryRoutes[r.Method+" "+r.URL.Path] {
+				http.Error(w, "beatgraze is running in read-only mode", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
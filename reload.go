@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Options a reload applies in place; anything else only takes effect after a restart
+var reloadableFlags = []string{
+	"dir", "log-level", "auth", "auth-token", "read-only", "cors-origin",
+	"allow", "deny", "trusted-proxies", "api-rate", "api-burst", "stream-rate", "stream-burst", "max-streams",
+	"tls-cert", "tls-key",
+	"schedule-rescan", "schedule-prune", "schedule-backfill", "schedule-backup", "schedule-playlists",
+	"hook-files-added", "hook-files-removed", "hook-scan-completed", "hook-track-played", "hook-upload-received", "hook-timeout",
+}
+
+// settingsMu guards the reloadable options. Requests hold it for reading only until they start
+// their response, by which point access checks, auth and path lookups are done, so a reload
+// waits for those but never for a long stream.
+var settingsMu sync.RWMutex
+
+type settingsReleaser struct {
+	http.ResponseWriter
+	release func()
+}
+
+func (s *settingsReleaser) WriteHeader(status int) {
+	s.release()
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *settingsReleaser) Write(b []byte) (int, error) {
+	s.release()
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *settingsReleaser) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func holdSettings(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settingsMu.RLock()
+		release := sync.OnceFunc(settingsMu.RUnlock)
+		defer release()
+		ctx := context.WithValue(r.Context(), settingsContextKey, release)
+		next.ServeHTTP(&settingsReleaser{ResponseWriter: w, release: release}, r.WithContext(ctx))
+	})
+}
+
+// releaseSettings lets a handler give up its hold early, which a reload from the API has to
+func releaseSettings(r *http.Request) {
+	if release, ok := r.Context().Value(settingsContextKey).(func()); ok {
+		release()
+	}
+}
+
+// reloadConfig re-reads the config file and environment and applies the reloadable options,
+// rolling all of them back if any is invalid.
+func reloadConfig() error {
+	values, err := configValues()
+	if err != nil {
+		return err
+	}
+
+	// Pick up a file logrotate has moved aside
+	if accessLog != nil {
+		if err := accessLog.Reopen(); err != nil {
+			return fmt.Errorf("reopening access log: %v", err)
+		}
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	previous := map[string]string{}
+	var changed []string
+	for _, name := range reloadableFlags {
+		if commandLineFlags[name] {
+			continue
+		}
+		f := flag.Lookup(name)
+		value, ok := values[name]
+		if !ok {
+			value = f.DefValue
+		}
+		if name == "dir" {
+			if dir, err := resolveAudioDir(value); err == nil {
+				value = dir
+			}
+		}
+		if value == f.Value.String() {
+			continue
+		}
+		previous[name] = f.Value.String()
+		if err := f.Value.Set(value); err != nil {
+			restoreFlags(previous)
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		changed = append(changed, name)
+	}
+	if err := applyReloadedSettings(previous); err != nil {
+		restoreFlags(previous)
+		return err
+	}
+
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f != nil && !isReloadable(name) && value != f.Value.String() {
+			slog.Warn("Option changed but needs a restart to take effect", "option", name)
+		}
+	}
+	slog.Info("Reloaded settings", "changed", strings.Join(changed, ","))
+	return nil
+}
+
+func restoreFlags(previous map[string]string) {
+	for name, value := range previous {
+		flag.Lookup(name).Value.Set(value)
+	}
+}
+
+func isReloadable(name string) bool {
+	for _, n := range reloadableFlags {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyReloadedSettings checks the new options and rebuilds what's derived from them,
+// checking everything it can before changing anything.
+func applyReloadedSettings(previous map[string]string) error {
+	if authCredentials != "" && !strings.Contains(authCredentials, ":") {
+		return errors.New("-auth must be in the form user:pass")
+	}
+	if err := validateTLSConfig(); err != nil {
+		return err
+	}
+	if oldCert, ok := previous["tls-cert"]; ok && (oldCert == "" || tlsConfig.CertFile == "") {
+		return errors.New("turning HTTPS on or off needs a restart")
+	}
+	rules, err := parseAccess()
+	if err != nil {
+		return fmt.Errorf("access settings: %v", err)
+	}
+	var cert *tls.Certificate
+	if tlsConfig.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return fmt.Errorf("TLS certificate: %v", err)
+		}
+		cert = &c
+	}
+	var storage Storage
+	dir := audioDir
+	if _, ok := previous["dir"]; ok {
+		if storage, dir, err = openLibrary(audioDir); err != nil {
+			return err
+		}
+	}
+
+	// Nothing can fail from here on
+	if cert != nil {
+		certificate.Store(cert)
+	}
+	applyAccess(rules)
+	if storage != nil {
+		library.Store(storage)
+		audioDir = dir
+		go rescanLibrary()
+	}
+	// Jobs that change the library may have been waiting on -read-only
+	jobs.signal()
+	return nil
+}
+
+// reloadOnSIGHUP applies config changes whenever the process gets a SIGHUP
+func reloadOnSIGHUP() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		sdNotify("RELOADING=1")
+		if err := reloadConfig(); err != nil {
+			slog.Error("Reload failed, keeping the old settings", "err", err)
+		}
+		sdNotify("READY=1")
+	}
+}
+
+func registerReloadRoutes() {
+	handleFunc("POST /api/admin/reload", requireRole(roleAdmin, reloadHandler))
+}
+
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	releaseSettings(r)
+	if err := reloadConfig(); err != nil {
+		http.Error(w, "Reload failed, keeping the old settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"reloaded": true})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// remoteListingTTL is how long a remote library's listing is reused; every library scan
+// would otherwise walk the whole remote tree
+const remoteListingTTL = time.Minute
+
+// remoteStorage is a Storage over a server that can list everything under the library root
+// and read a file from an offset, like an S3 bucket or a WebDAV share
+type remoteStorage struct {
+	id   string // The library URL, which keys the disk cache
+	name string // Shown for the library root
+	list func() (map[string]remoteFileInfo, error)
+	read func(path string, offset int64) (io.ReadCloser, error)
+
+	mu      sync.Mutex
+	listed  time.Time
+	files   map[string]remoteFileInfo // By library path
+	dirs    map[string]bool
+	pending chan struct{} // Closed when an in-progress listing finishes
+	err     error
+}
+
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i remoteFileInfo) Name() string       { return i.name }
+func (i remoteFileInfo) Size() int64        { return i.size }
+func (i remoteFileInfo) ModTime() time.Time { return i.modTime }
+func (i remoteFileInfo) IsDir() bool        { return i.dir }
+func (i remoteFileInfo) Sys() any           { return nil }
+func (i remoteFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// listing returns the cached listing, refreshing it once it's older than remoteListingTTL.
+// Concurrent callers share a single refresh.
+func (r *remoteStorage) listing() (map[string]remoteFileInfo, map[string]bool, error) {
+	r.mu.Lock()
+	if r.files != nil && time.Since(r.listed) < remoteListingTTL {
+		defer r.mu.Unlock()
+		return r.files, r.dirs, nil
+	}
+	if wait := r.pending; wait != nil {
+		r.mu.Unlock()
+		<-wait
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.files == nil {
+			return nil, nil, r.err
+		}
+		return r.files, r.dirs, nil
+	}
+	done := make(chan struct{})
+	r.pending = done
+	r.mu.Unlock()
+
+	files, err := r.list()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = nil
+	close(done)
+	r.err = err
+	if err != nil {
+		// Keep serving the last good listing rather than an empty library
+		if r.files != nil {
+			return r.files, r.dirs, nil
+		}
+		return nil, nil, err
+	}
+	dirs := map[string]bool{"": true}
+	for p := range files {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	r.files, r.dirs, r.listed = files, dirs, time.Now()
+	return files, dirs, nil
+}
+
+func remoteCleanPath(relPath string) (string, error) {
+	clean, err := cleanLibraryPath(relPath)
+	if err != nil {
+		return "", err
+	}
+	clean = filepath.ToSlash(clean)
+	if clean == "." {
+		clean = ""
+	}
+	return clean, nil
+}
+
+func (r *remoteStorage) Stat(relPath string) (fs.FileInfo, error) {
+	clean, err := remoteCleanPath(relPath)
+	if err != nil {
+		return nil, err
+	}
+	files, dirs, err := r.listing()
+	if err != nil {
+		return nil, err
+	}
+	if info, ok := files[clean]; ok {
+		return info, nil
+	}
+	if dirs[clean] {
+		name := path.Base(clean)
+		if clean == "" {
+			name = r.name
+		}
+		return remoteFileInfo{name: name, dir: true}, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (r *remoteStorage) Open(relPath string) (File, error) {
+	info, err := r.Stat(relPath)
+	if err != nil {
+		return nil, err
+	}
+	clean, _ := remoteCleanPath(relPath)
+	return &remoteFile{storage: r, path: clean, info: info.(remoteFileInfo), cache: diskCache, block: -1}, nil
+}
+
+func (r *remoteStorage) Walk(fn func(path string, info fs.FileInfo) error) error {
+	files, _, err := r.listing()
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := fn(p, files[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *remoteStorage) Watch(ctx context.Context, onChange func()) error {
+	return pollForChanges(ctx, r, remoteListingTTL, onChange)
+}
+
+// remoteFile reads with ranged requests, starting a new one only when a seek moves away
+// from where the current response body has got to. With the disk cache on, it reads whole
+// blocks, from the cache when it can.
+type remoteFile struct {
+	storage *remoteStorage
+	path    string
+	info    remoteFileInfo
+	offset  int64
+	body    io.ReadCloser
+
+	cache      *blockCache
+	bodyOffset int64 // Where body has got to, when reading blocks
+	block      int64
+	blockData  []byte
+}
+
+func (f *remoteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.info.dir {
+		return 0, errors.New("is a directory")
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.cache != nil {
+		return f.readBlock(p)
+	}
+	if f.body == nil {
+		body, err := f.storage.read(f.path, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < f.info.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *remoteFile) readBlock(p []byte) (int, error) {
+	block := f.offset / cacheBlockSize
+	if block != f.block {
+		data, err := f.loadBlock(block)
+		if err != nil {
+			return 0, err
+		}
+		f.block, f.blockData = block, data
+	}
+	n := copy(p, f.blockData[f.offset-block*cacheBlockSize:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// loadBlock gets a block from the cache, or else from the server, carrying on with the
+// current response when it's already there so sequential playback stays one request
+func (f *remoteFile) loadBlock(block int64) ([]byte, error) {
+	start := block * cacheBlockSize
+	length := min(cacheBlockSize, f.info.size-start)
+	key := cacheKey(f.storage.id, f.path, f.info.size, f.info.modTime, block)
+	if data := f.cache.Get(key); int64(len(data)) == length {
+		return data, nil
+	}
+	if f.body != nil && f.bodyOffset != start {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.body == nil {
+		body, err := f.storage.read(f.path, start)
+		if err != nil {
+			return nil, err
+		}
+		f.body, f.bodyOffset = body, start
+	}
+	data := make([]byte, length)
+	n, err := io.ReadFull(f.body, data)
+	f.bodyOffset += int64(n)
+	if err != nil {
+		f.body.Close()
+		f.body = nil
+		return nil, err
+	}
+	f.cache.Put(key, data)
+	return data, nil
+}
+
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != f.offset && f.body != nil && f.cache == nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *remoteFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sync pushes this library to another beatgraze instance through its API: new and changed
+// files go up with tus, so a transfer cut off part way picks up where it stopped on the
+// next run, then playlists and stats are merged in. Nothing is deleted on the other side.
+
+// syncChunkSize is how much of a file each tus PATCH carries
+const syncChunkSize = 8 << 20
+
+var (
+	// syncTo is the instance to push to, like https://music.example.com
+	syncTo       string
+	syncKey      string // An API key for an admin on syncTo
+	syncInterval = time.Hour
+)
+
+var errSyncNotConfigured = errors.New("sync isn't set up; start the server with -sync-to and -sync-key")
+
+// SyncFile is a library file as the receiving instance sees it
+type SyncFile struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// SyncUserState is one set of playlists and stats
+type SyncUserState struct {
+	Playlists []Playlist            `json:"playlists"`
+	Stats     map[string]TrackStats `json:"stats"`
+}
+
+// SyncState is everything sync carries besides files: the shared state, and each account's
+// by name, which only lands on accounts of the same name
+type SyncState struct {
+	Shared SyncUserState            `json:"shared"`
+	Users  map[string]SyncUserState `json:"users"`
+}
+
+// SyncStatus is how the last sync went
+type SyncStatus struct {
+	Running  bool      `json:"running"`
+	Target   string    `json:"target,omitempty"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Files    int       `json:"files"`   // Pushed, whole or finishing a resumed transfer
+	Bytes    int64     `json:"bytes"`   // Sent this run
+	Skipped  []string  `json:"skipped"` // Files that couldn't be pushed, with why
+	Error    string    `json:"error,omitempty"`
+}
+
+var syncRun = struct {
+	sync.Mutex
+	status SyncStatus
+}{}
+
+// syncTransfer is an upload started on the other side, kept so it can be resumed
+type syncTransfer struct {
+	Location string    `json:"location"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// SyncTransfers remembers unfinished uploads by library path
+type SyncTransfers struct {
+	mu        sync.Mutex
+	path      string
+	Target    string                   `json:"target"`
+	Transfers map[string]*syncTransfer `json:"transfers"`
+}
+
+var syncTransfers *SyncTransfers
+
+func loadSyncTransfers(path string) (*SyncTransfers, error) {
+	s := &SyncTransfers{path: path, Transfers: map[string]*syncTransfer{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Transfers == nil || s.Target != syncTo {
+		// Uploads started on another instance are no use here
+		s.Transfers = map[string]*syncTransfer{}
+	}
+	s.Target = syncTo
+	return s, nil
+}
+
+func (s *SyncTransfers) get(relPath string) (syncTransfer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.Transfers[relPath]
+	if !ok {
+		return syncTransfer{}, false
+	}
+	return *t, true
+}
+
+func (s *SyncTransfers) set(relPath string, t *syncTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t == nil {
+		delete(s.Transfers, relPath)
+	} else {
+		s.Transfers[relPath] = t
+	}
+	return saveJSON(s.path, s)
+}
+
+func registerSyncRoutes() {
+	handleFunc("GET /api/sync/manifest", getSyncManifest)
+	handleFunc("PUT /api/sync/state", requireRole(roleAdmin, putSyncState))
+	handleFunc("GET /api/admin/sync", requireRole(roleAdmin, getSync))
+	handleFunc("POST /api/admin/sync", requireRole(roleAdmin, startSync))
+}
+
+// getSyncManifest lists the library for an instance deciding what to push here, or one
+// mounting it. Only this server's own files are listed, not what it mounts in turn.
+func getSyncManifest(w http.ResponseWriter, r *http.Request) {
+	files, err := scanStorage(ownLibrary())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := make([]SyncFile, 0, len(files))
+	for _, f := range files {
+		list = append(list, SyncFile{Path: filepath.ToSlash(f.Path), Size: f.Size, Modified: f.ModTime.UTC()})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"files": list})
+}
+
+// putSyncState merges another instance's playlists and stats into ours
+func putSyncState(w http.ResponseWriter, r *http.Request) {
+	var state SyncState
+	if err := readJSON(r, &state); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	byName := map[string]string{}
+	for _, u := range users.List() {
+		byName[u.Name] = u.ID
+	}
+	playlists := 0
+	merge := func(st *userState, from SyncUserState) error {
+		n, err := st.playlists.Merge(from.Playlists)
+		if err != nil {
+			return err
+		}
+		playlists += n
+		return st.stats.Merge(from.Stats)
+	}
+	if err := merge(defaultState, state.Shared); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unknown := []string{}
+	for name, from := range state.Users {
+		id, ok := byName[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if err := merge(users.State(id), from); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"playlists": playlists, "unknownUsers": unknown})
+}
+
+func getSync(w http.ResponseWriter, r *http.Request) {
+	syncRun.Lock()
+	defer syncRun.Unlock()
+	writeJSON(w, http.StatusOK, syncRun.status)
+}
+
+// startSync pushes to -sync-to now rather than waiting for the next interval
+func startSync(w http.ResponseWriter, r *http.Request) {
+	if syncTo == "" {
+		http.Error(w, errSyncNotConfigured.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, beginSync())
+}
+
+// beginSync starts a sync in the background unless one is already going
+func beginSync() SyncStatus {
+	syncRun.Lock()
+	defer syncRun.Unlock()
+	if !syncRun.status.Running {
+		syncRun.status = SyncStatus{Running: true, Target: redactURL(syncTo), Started: time.Now(), Skipped: []string{}}
+		go runSync(context.Background())
+	}
+	return syncRun.status
+}
+
+// syncPeriodically pushes to -sync-to at startup and every -sync-interval after
+func syncPeriodically() {
+	for {
+		beginSync()
+		if syncInterval <= 0 {
+			return
+		}
+		time.Sleep(syncInterval)
+	}
+}
+
+func runSync(ctx context.Context) {
+	c := &syncClient{client: &http.Client{}}
+	err := c.run(ctx)
+
+	syncRun.Lock()
+	defer syncRun.Unlock()
+	syncRun.status.Running, syncRun.status.Finished = false, time.Now()
+	if err != nil {
+		syncRun.status.Error = err.Error()
+		slog.Warn("Error syncing library", "to", redactURL(syncTo), "err", err)
+		return
+	}
+	slog.Info("Synced library", "to", redactURL(syncTo), "files", syncRun.status.Files, "bytes", syncRun.status.Bytes, "skipped", len(syncRun.status.Skipped))
+}
+
+type syncClient struct {
+	client *http.Client
+}
+
+func (c *syncClient) request(ctx context.Context, method, ref string, body io.Reader) (*http.Request, error) {
+	base, err := url.Parse(strings.TrimSuffix(syncTo, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	// Relative to the base path, except for locations the other side hands out, which
+	// already include it
+	target, err := base.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+syncKey)
+	req.Header.Set("User-Agent", "beatgraze/"+versionInfo().Version)
+	return req, nil
+}
+
+// do sends req, failing unless the response has one of the wanted statuses
+func (c *syncClient) do(req *http.Request, want ...int) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range want {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return nil, &syncError{status: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+}
+
+type syncError struct {
+	status int
+	msg    string
+}
+
+func (e *syncError) Error() string {
+	return fmt.Sprintf("%s %s", http.StatusText(e.status), e.msg)
+}
+
+func (c *syncClient) run(ctx context.Context) error {
+	req, err := c.request(ctx, http.MethodGet, "api/sync/manifest", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("listing files: %w", err)
+	}
+	var manifest struct {
+		Files []SyncFile `json:"files"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("listing files: %w", err)
+	}
+	theirs := make(map[string]SyncFile, len(manifest.Files))
+	for _, f := range manifest.Files {
+		theirs[f.Path] = f
+	}
+
+	files, err := scanStorage(ownLibrary())
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath := filepath.ToSlash(f.Path)
+		modified := f.ModTime.UTC().Truncate(time.Second)
+		other, exists := theirs[relPath]
+		if exists && other.Size == f.Size && other.Modified.Truncate(time.Second).Equal(modified) {
+			continue
+		}
+		if insideZip(relPath) {
+			continue
+		}
+		if err := c.push(ctx, relPath, f.Size, modified, exists); err != nil {
+			var se *syncError
+			if !errors.As(err, &se) {
+				return fmt.Errorf("pushing %s: %w", relPath, err)
+			}
+			// The other side turned this file down; the rest may still go
+			syncRun.Lock()
+			syncRun.status.Skipped = append(syncRun.status.Skipped, relPath+": "+err.Error())
+			syncRun.Unlock()
+			continue
+		}
+		syncRun.Lock()
+		syncRun.status.Files++
+		syncRun.Unlock()
+	}
+	return c.pushState(ctx)
+}
+
+// push uploads one file, resuming an earlier transfer of the same version if there is one
+func (c *syncClient) push(ctx context.Context, relPath string, size int64, modified time.Time, replace bool) error {
+	t, ok := syncTransfers.get(relPath)
+	offset := int64(-1)
+	if ok && t.Size == size && t.Modified.Equal(modified) {
+		offset = c.offset(ctx, t.Location)
+	}
+	if offset < 0 {
+		location, err := c.create(ctx, relPath, size, modified, replace)
+		if err != nil {
+			return err
+		}
+		t = syncTransfer{Location: location, Size: size, Modified: modified}
+		if err := syncTransfers.set(relPath, &t); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	f, err := library.Open(relPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for offset < size {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		chunk, err := io.ReadAll(io.LimitReader(f, syncChunkSize))
+		if err != nil {
+			return err
+		}
+		req, err := c.request(ctx, http.MethodPatch, t.Location, bytes.NewReader(chunk))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		resp, err := c.do(req, http.StatusNoContent)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		next, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || next <= offset {
+			return fmt.Errorf("bad Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+		}
+		syncRun.Lock()
+		syncRun.status.Bytes += next - offset
+		syncRun.Unlock()
+		offset = next
+	}
+	return syncTransfers.set(relPath, nil)
+}
+
+// offset asks how much of an earlier transfer arrived, or -1 if it's gone
+func (c *syncClient) offset(ctx context.Context, location string) int64 {
+	req, err := c.request(ctx, http.MethodHead, location, nil)
+	if err != nil {
+		return -1
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return -1
+	}
+	resp.Body.Close()
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return offset
+}
+
+func (c *syncClient) create(ctx context.Context, relPath string, size int64, modified time.Time, replace bool) (string, error) {
+	meta := []string{
+		"filename " + base64.StdEncoding.EncodeToString([]byte(path.Base(relPath))),
+		"folder " + base64.StdEncoding.EncodeToString([]byte(libraryDir(relPath))),
+		"modified " + base64.StdEncoding.EncodeToString([]byte(modified.Format(time.RFC3339))),
+	}
+	if replace {
+		meta = append(meta, "replace "+base64.StdEncoding.EncodeToString([]byte("true")))
+	}
+	req, err := c.request(ctx, http.MethodPost, "api/uploads/tus", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Upload-Metadata", strings.Join(meta, ","))
+	resp, err := c.do(req, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("no Location for the upload")
+	}
+	return location, nil
+}
+
+// pushState sends the shared playlists and stats, and each account's
+func (c *syncClient) pushState(ctx context.Context) error {
+	state := SyncState{Shared: syncUserState(defaultState), Users: map[string]SyncUserState{}}
+	for _, u := range users.List() {
+		state.Users[u.Name] = syncUserState(users.State(u.ID))
+	}
+	body, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	req, err := c.request(ctx, http.MethodPut, "api/sync/state", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("pushing playlists and stats: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func syncUserState(st *userState) SyncUserState {
+	var playlists []Playlist
+	for _, p := range st.playlists.List() {
+		// Generated playlists are made afresh on each side
+		if p.Auto == "" {
+			playlists = append(playlists, p)
+		}
+	}
+	return SyncUserState{Playlists: playlists, Stats: st.stats.Snapshot()}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Role decides what an account may do. Each role can do everything the ones below it can:
+// guests browse and stream, listeners also keep their own playlists, ratings and history,
+// and admins manage accounts and run library-wide jobs like rescans.
+type Role string
+
+const (
+	roleGuest    Role = "guest"
+	roleListener Role = "listener"
+	roleAdmin    Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	roleGuest:    1,
+	roleListener: 2,
+	roleAdmin:    3,
+}
+
+func parseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (want admin, listener or guest)", s)
+	}
+	return role, nil
+}
+
+// currentRole is the signed-in account's role. Operator credentials, and servers without
+// any accounts, get full access since the auth middleware already let them in; requests it
+// let through without signing in, like share links and DLNA players, are only guests.
+func currentRole(r *http.Request) Role {
+	if u := currentUser(r); u != nil {
+		return u.Role
+	}
+	if signedOut(r) {
+		return roleGuest
+	}
+	return roleAdmin
+}
+
+func hasRole(r *http.Request, role Role) bool {
+	return roleRank[currentRole(r)] >= roleRank[role]
+}
+
+// requireRole guards a route group so only role and above can use it
+func requireRole(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasRole(r, role) {
+			http.Error(w, fmt.Sprintf("This needs %s access", role), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
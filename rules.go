@@ -0,0 +1,565 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rules are small boolean expressions evaluated per track, e.g.
+//
+//	genre=techno AND bpm 126-132 AND rating>=4 AND lastPlayed>30d
+//
+// Conditions are `field op value` with op one of = != > >= < <= ~ (contains)
+// and !~, or `field lo-hi` for a numeric range. Conditions are joined with
+// AND (also implied by juxtaposition), OR and NOT, and grouped with
+// parentheses. Duration values (30d, 12h, 2w, 1y) on time fields compare the
+// age, so lastPlayed>30d means "last played more than 30 days ago, or never".
+// Date values (2024-01-31) compare the timestamp itself. Text compares without
+// regard to case or accents.
+
+type ruleNode interface {
+	eval(t *ruleTrack) bool
+}
+
+type andNode struct{ left, right ruleNode }
+type orNode struct{ left, right ruleNode }
+type notNode struct{ node ruleNode }
+
+func (n andNode) eval(t *ruleTrack) bool { return n.left.eval(t) && n.right.eval(t) }
+func (n orNode) eval(t *ruleTrack) bool  { return n.left.eval(t) || n.right.eval(t) }
+func (n notNode) eval(t *ruleTrack) bool { return !n.node.eval(t) }
+
+// ruleTrack is the view of a file the rules run against; tags are only read if a rule asks for them
+type ruleTrack struct {
+	file  libraryFile
+	stats TrackStats
+	meta  *TrackMeta
+}
+
+func (t *ruleTrack) tags() TrackMeta {
+	if t.meta == nil {
+		meta := trackMeta(t.file)
+		t.meta = &meta
+	}
+	return *t.meta
+}
+
+var stringFields = map[string]func(t *ruleTrack) string{
+	"name":   func(t *ruleTrack) string { return t.file.Name },
+	"path":   func(t *ruleTrack) string { return filepath.ToSlash(t.file.Path) },
+	"folder": func(t *ruleTrack) string { return t.file.Folder },
+	"ext":    func(t *ruleTrack) string { return strings.TrimPrefix(strings.ToLower(filepath.Ext(t.file.Name)), ".") },
+	"title":  func(t *ruleTrack) string { return t.tags().Title },
+	"artist": func(t *ruleTrack) string { return t.tags().Artist },
+	"album":  func(t *ruleTrack) string { return t.tags().Album },
+	"genre":  func(t *ruleTrack) string { return t.tags().Genre },
+	"key":    func(t *ruleTrack) string { return t.tags().Key },
+}
+
+// Number fields report false when the value is unknown so e.g. bpm<100 doesn't match untagged files
+var numberFields = map[string]func(t *ruleTrack) (float64, bool){
+	"bpm":    func(t *ruleTrack) (float64, bool) { v := t.tags().BPM; return v, v > 0 },
+	"year":   func(t *ruleTrack) (float64, bool) { v := t.tags().Year; return float64(v), v > 0 },
+	"track":  func(t *ruleTrack) (float64, bool) { v := t.tags().Track; return float64(v), v > 0 },
+	"rating": func(t *ruleTrack) (float64, bool) { return float64(t.stats.Rating), true },
+	"plays":  func(t *ruleTrack) (float64, bool) { return float64(t.stats.PlayCount), true },
+	"size":   func(t *ruleTrack) (float64, bool) { return float64(t.file.Size), true },
+	"duration": func(t *ruleTrack) (float64, bool) {
+		v := t.tags().Duration
+		return v, v > 0
+	},
+}
+
+var timeFields = map[string]func(t *ruleTrack) time.Time{
+	"added":      func(t *ruleTrack) time.Time { return t.file.ModTime },
+	"lastplayed": func(t *ruleTrack) time.Time { return t.stats.LastPlayed },
+}
+
+var fieldAliases = map[string]string{
+	"playcount": "plays",
+	"filename":  "name",
+	"dir":       "folder",
+	"mtime":     "added",
+	"played":    "lastplayed",
+}
+
+type condNode struct {
+	field string
+	op    string
+	value string
+
+	num     float64
+	hi      float64
+	isRange bool
+
+	dur   time.Duration
+	isDur bool
+	when  time.Time
+}
+
+func (c condNode) eval(t *ruleTrack) bool {
+	if get, ok := stringFields[c.field]; ok {
+		v := foldText(get(t))
+		switch c.op {
+		case "=":
+			return v == c.value
+		case "!=":
+			return v != c.value
+		case "~", ":":
+			return strings.Contains(v, c.value)
+		case "!~":
+			return !strings.Contains(v, c.value)
+		}
+		return compareOrdered(strings.Compare(v, c.value), c.op)
+	}
+
+	if get, ok := numberFields[c.field]; ok {
+		v, known := get(t)
+		if !known {
+			return false
+		}
+		if c.isRange {
+			return v >= c.num && v <= c.hi
+		}
+		return compareOrdered(compareFloat(v, c.num), c.op)
+	}
+
+	get := timeFields[c.field]
+	v := get(t)
+	if c.isDur {
+		// Compare ages; a zero time (never played) is infinitely old
+		age := time.Duration(1<<63 - 1)
+		if !v.IsZero() {
+			age = time.Since(v)
+		}
+		return compareOrdered(compareFloat(float64(age), float64(c.dur)), c.op)
+	}
+	if v.IsZero() {
+		return c.op == "!="
+	}
+	// A date means the whole day, so added=2024-01-31 is anything added that day
+	y, m, d := v.In(time.Local).Date()
+	return compareOrdered(time.Date(y, m, d, 0, 0, 0, 0, time.Local).Compare(c.when), c.op)
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareOrdered(cmp int, op string) bool {
+	switch op {
+	case "=", ":":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// Parsing
+
+type ruleToken struct {
+	kind  string // word, string, op, (, )
+	value string
+}
+
+func tokenizeRules(src string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, ruleToken{kind: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, ruleToken{kind: "string", value: string(runes[i+1 : end])})
+			i = end + 1
+		case strings.ContainsRune("=!<>~:", c):
+			end := i + 1
+			if end < len(runes) && strings.ContainsRune("=~", runes[end]) && c != ':' {
+				end++
+			}
+			op := string(runes[i:end])
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!'")
+			}
+			tokens = append(tokens, ruleToken{kind: "op", value: op})
+			i = end
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("()\"=!<>~:", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, ruleToken{kind: "word", value: string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+
+	// text, when set, makes a word or quoted phrase standing on its own a condition of its
+	// own, as search queries allow
+	text func(words string) ruleNode
+}
+
+func parseRules(src string) (ruleNode, error) {
+	return (&ruleParser{}).parse(src)
+}
+
+func (p *ruleParser) parse(src string) (ruleNode, error) {
+	tokens, err := tokenizeRules(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	p.tokens = tokens
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		if t.value == "" {
+			return nil, fmt.Errorf("unexpected %q", t.kind)
+		}
+		return nil, fmt.Errorf("unexpected %q", t.value)
+	}
+	return node, nil
+}
+
+func (p *ruleParser) peek() (ruleToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return ruleToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *ruleParser) keyword(word string) bool {
+	t, ok := p.peek()
+	if ok && t.kind == "word" && strings.EqualFold(t.value, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if p.keyword("AND") {
+			// explicit AND, fall through to parse the right hand side
+		} else if t, ok := p.peek(); !ok || t.kind == ")" || (t.kind == "word" && strings.EqualFold(t.value, "OR")) {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *ruleParser) parseUnary() (ruleNode, error) {
+	if p.keyword("NOT") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of rule")
+	}
+	if t.kind == "(" {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.kind != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	}
+	return p.parseCondition()
+}
+
+var validRuleOps = map[string]bool{"=": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true, "~": true, "!~": true, ":": true}
+
+var rangePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)-(\d+(?:\.\d+)?)$`)
+
+func (p *ruleParser) parseCondition() (ruleNode, error) {
+	t, _ := p.peek()
+	if p.text != nil && t.kind == "string" {
+		p.pos++
+		return p.text(t.value), nil
+	}
+	if t.kind != "word" {
+		return nil, fmt.Errorf("expected a field name")
+	}
+	p.pos++
+	field := strings.ToLower(t.value)
+	if p.text != nil {
+		next, ok := p.peek()
+		if !ok || (next.kind != "op" && (next.kind != "word" || !rangePattern.MatchString(next.value))) {
+			return p.text(t.value), nil
+		}
+		if field == "dir" && next.value == ":" {
+			return p.parseDir()
+		}
+	}
+	if alias, ok := fieldAliases[field]; ok {
+		field = alias
+	}
+
+	op := "="
+	next, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("missing value for %s", t.value)
+	}
+	if next.kind == "op" {
+		op = next.value
+		if !validRuleOps[op] {
+			return nil, fmt.Errorf("unknown operator %q", op)
+		}
+		p.pos++
+		if next, ok = p.peek(); !ok {
+			return nil, fmt.Errorf("missing value for %s", t.value)
+		}
+	} else if next.kind != "word" || !rangePattern.MatchString(next.value) {
+		return nil, fmt.Errorf("expected an operator after %s", t.value)
+	}
+	if next.kind != "word" && next.kind != "string" {
+		return nil, fmt.Errorf("missing value for %s", t.value)
+	}
+	p.pos++
+	return newCondition(field, op, next.value)
+}
+
+func newCondition(field, op, value string) (ruleNode, error) {
+	c := condNode{field: field, op: op, value: foldText(value)}
+
+	if _, ok := stringFields[field]; ok {
+		return c, nil
+	}
+
+	if _, ok := numberFields[field]; ok {
+		if m := rangePattern.FindStringSubmatch(value); m != nil && (op == "=" || op == ":") {
+			c.num, _ = strconv.ParseFloat(m[1], 64)
+			c.hi, _ = strconv.ParseFloat(m[2], 64)
+			c.isRange = true
+			return c, nil
+		}
+		num, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s needs a number, got %q", field, value)
+		}
+		if op == "~" || op == "!~" {
+			return nil, fmt.Errorf("%s doesn't support %s", field, op)
+		}
+		c.num = num
+		return c, nil
+	}
+
+	if _, ok := timeFields[field]; ok {
+		if dur, err := parseAge(value); err == nil {
+			c.dur, c.isDur = dur, true
+			return c, nil
+		}
+		when, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("%s needs an age like 30d or a date like 2024-01-31, got %q", field, value)
+		}
+		c.when = when
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("unknown field %q", field)
+}
+
+// parseAge accepts Go durations plus day, week and year suffixes
+func parseAge(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// evaluateRules runs a rule over the library and returns matching paths, sorted and limited
+func evaluateRules(files []libraryFile, allStats map[string]TrackStats, rules, sortBy string, limit int) ([]string, error) {
+	root, err := parseRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*ruleTrack
+	for _, f := range files {
+		t := &ruleTrack{file: f, stats: allStats[f.Path]}
+		if root.eval(t) {
+			matches = append(matches, t)
+		}
+	}
+
+	if err := sortRuleTracks(matches, sortBy); err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	tracks := make([]string, len(matches))
+	for i, t := range matches {
+		tracks[i] = t.file.Path
+	}
+	return tracks, nil
+}
+
+// trackQuery narrows and orders the library the way the GraphQL and gRPC APIs let clients ask
+type trackQuery struct {
+	Search    string // Like the search box
+	Fuzzy     bool   // Let search words through with typos
+	Filter    string // Rules
+	Sort      string
+	Dir       *string // Only tracks in this folder, and below it when Recursive
+	Recursive bool
+}
+
+func (q trackQuery) run(files []libraryFile, allStats map[string]TrackStats) ([]*ruleTrack, error) {
+	var root ruleNode
+	if q.Filter != "" {
+		var err error
+		if root, err = parseRules(q.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+	var scores map[string]float64
+	if search := strings.TrimSpace(q.Search); search != "" {
+		var err error
+		if scores, err = searchFiles(files, allStats, search, q.Fuzzy); err != nil {
+			return nil, err
+		}
+	}
+	var found []*ruleTrack
+	for _, f := range files {
+		if q.Dir != nil && !inFolder(f.Path, strings.Trim(*q.Dir, "/"), q.Recursive) {
+			continue
+		}
+		if _, ok := scores[f.Path]; scores != nil && !ok {
+			continue
+		}
+		t := &ruleTrack{file: f, stats: allStats[f.Path]}
+		if root != nil && !root.eval(t) {
+			continue
+		}
+		found = append(found, t)
+	}
+	if err := sortRuleTracks(found, q.Sort); err != nil {
+		return nil, err
+	}
+	// Searches without an order of their own come back best match first
+	if scores != nil && q.Sort == "" {
+		sortByRelevance(found, func(t *ruleTrack) string { return t.file.Path }, scores)
+	}
+	return found, nil
+}
+
+func sortRuleTracks(tracks []*ruleTrack, sortBy string) error {
+	desc := strings.HasPrefix(sortBy, "-")
+	field := strings.ToLower(strings.TrimPrefix(sortBy, "-"))
+	if alias, ok := fieldAliases[field]; ok {
+		field = alias
+	}
+
+	var less func(a, b *ruleTrack) bool
+	switch {
+	case field == "" || field == "random":
+		if field == "random" {
+			rand.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+		} else {
+			sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].file.Name < tracks[j].file.Name })
+		}
+		return nil
+	case stringFields[field] != nil:
+		get := stringFields[field]
+		less = func(a, b *ruleTrack) bool { return strings.ToLower(get(a)) < strings.ToLower(get(b)) }
+	case numberFields[field] != nil:
+		get := numberFields[field]
+		less = func(a, b *ruleTrack) bool { x, _ := get(a); y, _ := get(b); return x < y }
+	case timeFields[field] != nil:
+		get := timeFields[field]
+		less = func(a, b *ruleTrack) bool { return get(a).Before(get(b)) }
+	default:
+		return fmt.Errorf("cannot sort by %q", sortBy)
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if desc {
+			return less(tracks[j], tracks[i])
+		}
+		return less(tracks[i], tracks[j])
+	})
+	return nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Client talks to an S3 bucket, or anything speaking its API like MinIO, given as
+// s3://bucket/prefix. The endpoint and region can be set with ?endpoint= and ?region=, or
+// the usual AWS_ENDPOINT_URL_S3 and AWS_REGION variables.
+type s3Client struct {
+	bucket    string
+	prefix    string // Ends with "/" unless empty
+	endpoint  *url.URL
+	pathStyle bool
+	region    string
+	creds     *awsCredentials
+	client    *http.Client
+}
+
+func newS3Storage(rawURL string) (*remoteStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix", rawURL)
+	}
+	s := &s3Client{
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		region: firstNonEmpty(u.Query().Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), awsConfigValue("region"), "us-east-1"),
+		client: &http.Client{},
+	}
+	if s.prefix != "" {
+		s.prefix += "/"
+	}
+	endpoint := firstNonEmpty(u.Query().Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	if endpoint != "" {
+		// Custom endpoints like MinIO rarely have wildcard DNS for bucket subdomains
+		if s.endpoint, err = url.Parse(endpoint); err != nil || s.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+		}
+		s.pathStyle = true
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: "s3." + s.region + ".amazonaws.com"}
+		// Bucket names with dots break the virtual-host TLS certificate
+		s.pathStyle = strings.Contains(s.bucket, ".")
+	}
+	if s.creds, err = loadAWSCredentials(); err != nil {
+		return nil, err
+	}
+	return &remoteStorage{id: rawURL, name: path.Base(s.bucket + "/" + strings.TrimSuffix(s.prefix, "/")), list: s.list, read: s.read}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(s.endpoint.EscapedPath(), "/")
+	if s.pathStyle {
+		u.RawPath = base + "/" + awsURIEncode(s.bucket, false) + "/" + awsURIEncode(key, false)
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.RawPath = base + "/" + awsURIEncode(key, false)
+	}
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = awsCanonicalQuery(query)
+	return &u
+}
+
+func (s *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header) (*http.Response, error) {
+	u := s.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	creds, err := s.creds.get()
+	if err != nil {
+		return nil, err
+	}
+	signAWSRequest(req, creds, s.region, "s3", time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.Unmarshal(body, &s3Err)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fs.ErrNotExist
+		}
+		return nil, fmt.Errorf("S3 %s %s: %s %s %s", method, key, resp.Status, s3Err.Code, s3Err.Message)
+	}
+	return resp, nil
+}
+
+func (s *s3Client) list() (map[string]remoteFileInfo, error) {
+	objects := map[string]remoteFileInfo{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "max-keys": {"1000"}}
+		if s.prefix != "" {
+			query.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(context.Background(), http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading S3 listing: %v", err)
+		}
+		for _, obj := range page.Contents {
+			rel := strings.TrimPrefix(obj.Key, s.prefix)
+			if rel == "" || strings.HasSuffix(rel, "/") {
+				continue // Folder placeholder objects
+			}
+			objects[rel] = remoteFileInfo{name: path.Base(rel), size: obj.Size, modTime: obj.LastModified}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *s3Client) read(relPath string, offset int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	resp, err := s.do(context.Background(), http.MethodGet, s.prefix+relPath, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// AWS credentials, found the way the AWS CLI and SDKs look: environment variables, the
+// shared credentials file, then the ECS task role or EC2 instance role endpoints
+
+type awsKeys struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+type awsCredentials struct {
+	mu      sync.Mutex
+	keys    awsKeys
+	refresh func() (awsKeys, error) // For temporary credentials; nil when they're static
+}
+
+func (c *awsCredentials) get() (awsKeys, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refresh != nil && time.Until(c.keys.Expires) < 5*time.Minute {
+		keys, err := c.refresh()
+		if err != nil {
+			return awsKeys{}, fmt.Errorf("refreshing AWS credentials: %v", err)
+		}
+		c.keys = keys
+	}
+	return c.keys, nil
+}
+
+func awsProfile() string {
+	return firstNonEmpty(os.Getenv("AWS_PROFILE"), "default")
+}
+
+// readINISection returns the key/values of one section of an AWS-style INI file
+func readINISection(file, section string) map[string]string {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	values := map[string]string{}
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && inSection {
+			values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return values
+}
+
+func awsHomeFile(envVar, name string) string {
+	if f := os.Getenv(envVar); f != "" {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// awsConfigValue reads a setting for the current profile from ~/.aws/config
+func awsConfigValue(key string) string {
+	section := "profile " + awsProfile()
+	if awsProfile() == "default" {
+		section = "default"
+	}
+	return readINISection(awsHomeFile("AWS_CONFIG_FILE", "config"), section)[key]
+}
+
+func loadAWSCredentials() (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{keys: awsKeys{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}}, nil
+	}
+	file := readINISection(awsHomeFile("AWS_SHARED_CREDENTIALS_FILE", "credentials"), awsProfile())
+	if file["aws_access_key_id"] != "" && file["aws_secret_access_key"] != "" {
+		return &awsCredentials{keys: awsKeys{
+			AccessKeyID:     file["aws_access_key_id"],
+			SecretAccessKey: file["aws_secret_access_key"],
+			SessionToken:    file["aws_session_token"],
+		}}, nil
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return &awsCredentials{refresh: func() (awsKeys, error) {
+			return fetchAWSRoleCredentials("http://169.254.170.2"+uri, nil)
+		}}, nil
+	}
+	if _, err := imdsToken(); err == nil {
+		return &awsCredentials{refresh: fetchEC2RoleCredentials}, nil
+	}
+	return nil, errors.New("no AWS credentials found; set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or configure a profile in ~/.aws/credentials")
+}
+
+var metadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// imdsToken gets an IMDSv2 session token, which also tells us we're on EC2
+func imdsToken() (string, error) {
+	req, _ := http.NewRequest(http.MethodPut, "http://169.254.169.254/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	token, err := io.ReadAll(resp.Body)
+	return string(token), err
+}
+
+func fetchEC2RoleCredentials() (awsKeys, error) {
+	token, err := imdsToken()
+	if err != nil {
+		return awsKeys{}, err
+	}
+	header := http.Header{"X-aws-ec2-metadata-token": {token}}
+	base := "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+	req, _ := http.NewRequest(http.MethodGet, base, nil)
+	req.Header = header
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return awsKeys{}, err
+	}
+	role, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return awsKeys{}, errors.New("this instance has no IAM role")
+	}
+	return fetchAWSRoleCredentials(base+strings.TrimSpace(string(role)), header)
+}
+
+func fetchAWSRoleCredentials(endpoint string, header http.Header) (awsKeys, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsKeys{}, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return awsKeys{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return awsKeys{}, errors.New(resp.Status)
+	}
+	var creds struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&creds); err != nil {
+		return awsKeys{}, err
+	}
+	return awsKeys{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, SessionToken: creds.Token, Expires: creds.Expiration}, nil
+}
+
+// Signature Version 4, as described in the AWS general reference
+
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func awsCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signAWSRequest adds SigV4 headers to a request without a body
+func signAWSRequest(req *http.Request, keys awsKeys, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if keys.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", keys.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+keys.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keys.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maintenance that runs on its own, set with -schedule-<task> or in the config file's
+// [schedule] section as crontab times, like
+//
+//	[schedule]
+//	rescan = "30 4 * * *"
+//	backup = "@daily"
+//
+// Each run queues a job, so it shows in /api/jobs and waits its turn with everything else.
+
+var (
+	scheduleRescan    cronFlag
+	schedulePrune     cronFlag
+	scheduleBackfill  cronFlag
+	scheduleBackup    cronFlag
+	schedulePlaylists cronFlag
+)
+
+// backupKeep is how many backups to keep in <data>/backups
+var backupKeep = 7
+
+// scheduledTask is maintenance that can run on a schedule
+type scheduledTask struct {
+	name string // As in -schedule-<name>
+	kind string // The job it queues
+	when *cronFlag
+}
+
+var scheduledTasks = []scheduledTask{
+	{name: "rescan", kind: "rescan", when: &scheduleRescan},
+	{name: "prune", kind: "prune", when: &schedulePrune},
+	{name: "backfill", kind: "backfill", when: &scheduleBackfill},
+	{name: "backup", kind: "backup", when: &scheduleBackup},
+	{name: "playlists", kind: "playlists", when: &schedulePlaylists},
+}
+
+// cronFlag is an option holding a schedule, empty for never
+type cronFlag struct {
+	spec     string
+	schedule *cronSchedule
+}
+
+func (f *cronFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.spec
+}
+
+func (f *cronFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		f.spec, f.schedule = "", nil
+		return nil
+	}
+	schedule, err := parseCron(value)
+	if err != nil {
+		return err
+	}
+	f.spec, f.schedule = value, schedule
+	return nil
+}
+
+// cronSchedule is the five fields of a crontab line as bit sets: minute, hour, day of month,
+// month and day of week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// As in cron, when both days are restricted either can match
+	anyDOM, anyDOW bool
+}
+
+var cronShorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	if full, ok := cronShorthands[strings.ToLower(spec)]; ok {
+		spec = full
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q should be five crontab fields, minute hour day month weekday, or like @daily", spec)
+	}
+	var c cronSchedule
+	ranges := []struct {
+		set    *uint64
+		lo, hi int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+	for i, r := range ranges {
+		set, err := parseCronField(fields[i], r.lo, r.hi)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", spec, err)
+		}
+		*r.set = set
+	}
+	// Sunday is 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	c.anyDOM, c.anyDOW = fields[2] == "*", fields[4] == "*"
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never comes round", spec)
+	}
+	return &c, nil
+}
+
+// parseCronField reads a comma-separated list of *, n or n-m, each optionally with /step
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		expr, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if expr != "*" {
+			first, last, isRange := strings.Cut(expr, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				to = hi
+			}
+			if from < lo || to > hi || from > to {
+				return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+			}
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	if c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+// next is the first time after t the schedule comes round, or zero if it doesn't within
+// the next few years, like on the 31st of February
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			// Skip straight to the next minute that's set in this hour, if there is one
+			if later := c.minute >> (t.Minute() + 1) << (t.Minute() + 1); later != 0 {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), bits.TrailingZeros64(later), 0, 0, t.Location())
+			} else {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			}
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// TaskRun is how a scheduled task last went
+type TaskRun struct {
+	JobID    string    `json:"jobId,omitempty"`
+	Queued   time.Time `json:"queued"`
+	Finished time.Time `json:"finished,omitzero"`
+	Status   jobStatus `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Manual   bool      `json:"manual,omitempty"` // Run from the API rather than on schedule
+}
+
+// ScheduledTaskStatus is a task as GET /api/admin/schedule shows it
+type ScheduledTaskStatus struct {
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	Schedule string    `json:"schedule,omitempty"`
+	Next     time.Time `json:"next,omitzero"`
+	LastRun  *TaskRun  `json:"lastRun,omitempty"`
+}
+
+// Scheduler queues tasks when they're due and remembers how each last went
+type Scheduler struct {
+	mu   sync.Mutex
+	path string
+	Runs map[string]*TaskRun `json:"runs"`
+}
+
+var scheduler *Scheduler
+
+var (
+	errTaskNotFound = errors.New("no such scheduled task")
+	errTaskRunning  = errors.New("the task's last run hasn't finished yet")
+)
+
+func loadScheduler(path string) (*Scheduler, error) {
+	s := &Scheduler{path: path, Runs: map[string]*TaskRun{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Runs == nil {
+		s.Runs = map[string]*TaskRun{}
+	}
+	return s, nil
+}
+
+func findScheduledTask(name string) (scheduledTask, bool) {
+	for _, t := range scheduledTasks {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return scheduledTask{}, false
+}
+
+// run queues each task as its time comes round, until ctx is done. A run that's missed
+// because the server was down is skipped rather than made up.
+func (s *Scheduler) run(ctx context.Context) {
+	for {
+		now := time.Now()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+		}
+		minute := time.Now().Truncate(time.Minute)
+		s.refresh()
+		settingsMu.RLock()
+		var due []scheduledTask
+		for _, t := range scheduledTasks {
+			if c := t.when.schedule; c != nil && c.next(minute.Add(-time.Minute)).Equal(minute) {
+				due = append(due, t)
+			}
+		}
+		settingsMu.RUnlock()
+		for _, t := range due {
+			if _, err := s.Start(t, false); err != nil {
+				slog.Warn("Skipped scheduled task", "task", t.name, "err", err)
+			}
+		}
+	}
+}
+
+// Start queues a task's job now, unless its last run is still going
+func (s *Scheduler) Start(t scheduledTask, manual bool) (TaskRun, error) {
+	s.refresh()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last := s.Runs[t.name]; last != nil && (last.Status == jobQueued || last.Status == jobRunning) {
+		return *last, errTaskRunning
+	}
+	job, err := jobs.Add(t.kind, "", 0, nil)
+	if err != nil {
+		return TaskRun{}, err
+	}
+	run := &TaskRun{JobID: job.ID, Queued: job.Created, Status: job.Status, Manual: manual}
+	s.Runs[t.name] = run
+	return *run, saveJSON(s.path, s)
+}
+
+// refresh picks up how the jobs of unfinished runs have gone since
+func (s *Scheduler) refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, run := range s.Runs {
+		if run.Status != jobQueued && run.Status != jobRunning {
+			continue
+		}
+		job, err := jobs.Get(run.JobID, "")
+		if err != nil {
+			// Finished jobs are forgotten after a day
+			run.Status, run.Error = jobFailed, "job record is gone"
+		} else {
+			run.Status, run.Error, run.Finished = job.Status, job.Error, job.Finished
+		}
+		changed = true
+	}
+	if changed {
+		if err := saveJSON(s.path, s); err != nil {
+			slog.Error("Error saving schedule", "err", err)
+		}
+	}
+}
+
+// List is every task with its schedule and last run; the caller holds settingsMu, as
+// requests do
+func (s *Scheduler) List() []ScheduledTaskStatus {
+	s.refresh()
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]ScheduledTaskStatus, 0, len(scheduledTasks))
+	for _, t := range scheduledTasks {
+		status := ScheduledTaskStatus{Name: t.name, Kind: t.kind, Schedule: t.when.spec}
+		if t.when.schedule != nil {
+			status.Next = t.when.schedule.next(now)
+		}
+		if run := s.Runs[t.name]; run != nil {
+			copied := *run
+			status.LastRun = &copied
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+func registerScheduleRoutes() {
+	handleFunc("GET /api/admin/schedule", requireRole(roleAdmin, listSchedule))
+	handleFunc("POST /api/admin/schedule/{name}", requireRole(roleAdmin, runScheduledTask))
+}
+
+func listSchedule(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, scheduler.List())
+}
+
+// runScheduledTask runs a task now, whether or not it has a schedule
+func runScheduledTask(w http.ResponseWriter, r *http.Request) {
+	t, ok := findScheduledTask(r.PathValue("name"))
+	if !ok {
+		http.Error(w, errTaskNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	run, err := scheduler.Start(t, true)
+	switch {
+	case errors.Is(err, errTaskRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func init() {
+	registerJobKind("prune", &jobKind{concurrency: 1, run: runPrune})
+	registerJobKind("backfill", &jobKind{concurrency: 1, run: runBackfill})
+	registerJobKind("backup", &jobKind{concurrency: 1, run: runBackup})
+	registerJobKind("playlists", &jobKind{concurrency: 1, writes: true, run: runPlaylistRefresh})
+}
+
+// runPrune clears out what's no longer needed: cached tags of files that have gone,
+// uploads nobody finished and trash past -trash-days
+func runPrune(ctx context.Context, run *jobRun) error {
+	run.Progress(0, "Listing files")
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Tags    int `json:"tags"`
+		Uploads int `json:"uploads"`
+		Trash   int `json:"trash"`
+	}{Tags: pruneMeta(files)}
+	if err := saveMetaCache(); err != nil {
+		return err
+	}
+	if !readOnly {
+		run.Progress(0.5, "Emptying trash")
+		if result.Uploads, err = uploads.PruneAbandoned(); err != nil {
+			return err
+		}
+		before := len(trash.List())
+		trash.purgeExpired()
+		result.Trash = before - len(trash.List())
+	}
+	slog.Info("Pruned caches", "tags", result.Tags, "uploads", result.Uploads, "trash", result.Trash)
+	return run.Save(result)
+}
+
+// runBackfill reads the tags of files that haven't had them read yet, so searches, smart
+// playlists and mixes don't wait on them later
+func runBackfill(ctx context.Context, run *jobRun) error {
+	run.Progress(0, "Listing files")
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	var missing []libraryFile
+	for _, f := range files {
+		if !hasMeta(f) {
+			missing = append(missing, f)
+		}
+	}
+	for i, f := range missing {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if i%100 == 0 {
+			run.Progress(float64(i)/float64(len(missing)), "Reading tags")
+		}
+		trackMeta(f)
+	}
+	libraryIndex.Update(files)
+	if err := saveMetaCache(); err != nil {
+		return err
+	}
+	return run.Save(map[string]int{"read": len(missing)})
+}
+
+// runBackup copies every stored document into a zip in <data>/backups, keeping the newest
+// -backup-keep. Restoring one means unzipping it into an empty data directory and starting
+// with -store json, or letting the default store import the files.
+func runBackup(ctx context.Context, run *jobRun) error {
+	dir := filepath.Join(dataDir, "backups")
+	name, n, err := writeBackup(ctx, dir, run.Progress)
+	if err != nil {
+		return err
+	}
+	removeOldBackups(dir)
+	slog.Info("Backed up state", "file", name, "documents", n)
+	return run.Save(map[string]any{"file": name, "documents": n})
+}
+
+// writeBackup zips up the state store into dir, returning the file's name and how many
+// documents went in
+func writeBackup(ctx context.Context, dir string, progress func(fraction float64, message string)) (string, int, error) {
+	keys, err := stateStore.Keys()
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, err
+	}
+	name := "beatgraze-" + time.Now().Format("20060102-150405") + ".zip"
+	tmp, err := os.CreateTemp(dir, name+".tmp*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	zw := zip.NewWriter(tmp)
+	for i, key := range keys {
+		if err := ctx.Err(); err != nil {
+			tmp.Close()
+			return "", 0, err
+		}
+		progress(float64(i)/float64(len(keys)), "Copying "+key)
+		data, err := stateStore.Load(key)
+		if err != nil {
+			tmp.Close()
+			return "", 0, fmt.Errorf("reading %s: %w", key, err)
+		}
+		w, err := zw.Create(key)
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			tmp.Close()
+			return "", 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	return name, len(keys), os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+func removeOldBackups(dir string) {
+	if backupKeep <= 0 {
+		return
+	}
+	old, _ := filepath.Glob(filepath.Join(dir, "beatgraze-*.zip"))
+	sort.Strings(old) // The names sort by time
+	for len(old) > backupKeep {
+		if err := os.Remove(old[0]); err != nil {
+			slog.Error("Error removing old backup", "file", old[0], "err", err)
+		}
+		old = old[1:]
+	}
+}
+
+// runPlaylistRefresh builds the generated playlists afresh. Smart playlists are evaluated
+// whenever they're read, so they're always current already.
+func runPlaylistRefresh(ctx context.Context, run *jobRun) error {
+	if dailyMixCount <= 0 {
+		return nil
+	}
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return refreshDailyMixesFor(time.Now().Format("2006-01-02"), filepath.Join(dataDir, "dailymix.json"))
+}
+
+// runBackupCommand implements "beatgraze backup"
+func runBackupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	stateFlags(fs)
+	out := fs.String("out", "", "Folder to write the backup to (default: <data>/backups)")
+	commandUsage(fs, "[options]", "Zips up users, playlists, history and the rest of the server's state. With the default\nbolt store, stop the server first.")
+	fs.Parse(args)
+	if err := openCommandState(); err != nil {
+		return err
+	}
+	defer stateStore.Close()
+	dir := *out
+	if dir == "" {
+		dir = filepath.Join(dataDir, "backups")
+	}
+	name, n, err := writeBackup(context.Background(), dir, func(float64, string) {})
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		removeOldBackups(dir)
+	}
+	fmt.Printf("Backed up %d documents to %s\n", n, filepath.Join(dir, name))
+	return nil
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	lastfmAPIKey    string
+	lastfmSecret    string
+	listenBrainzURL = "https://api.listenbrainz.org"
+)
+
+const (
+	lastfmAPI         = "https://ws.audioscrobbler.com/2.0/"
+	lastfmStateCookie = "beatgraze_lastfm_state"
+
+	// Last.fm's rules, which ListenBrainz follows too: tracks over 30 seconds count once
+	// half of them, or four minutes, has played
+	scrobbleMinLength = 30 * time.Second
+	scrobbleMaxWait   = 4 * time.Minute
+
+	scrobbleRetryEvery = 5 * time.Minute
+	maxScrobbleQueue   = 10000
+	maxScrobbleTries   = 50
+)
+
+// errScrobbleRejected marks a scrobble the service turned down for good, which isn't retried
+var errScrobbleRejected = errors.New("rejected")
+
+type LastFMAccount struct {
+	User       string `json:"user"`
+	SessionKey string `json:"sessionKey"`
+}
+
+type ListenBrainzAccount struct {
+	User  string `json:"user"`
+	Token string `json:"token"`
+}
+
+// ScrobbleAccounts are where one user's plays get sent
+type ScrobbleAccounts struct {
+	LastFM       *LastFMAccount       `json:"lastfm,omitempty"`
+	ListenBrainz *ListenBrainzAccount `json:"listenbrainz,omitempty"`
+}
+
+// ScrobbleTrack is what the services are told about a track
+type ScrobbleTrack struct {
+	Path     string  `json:"path"`
+	Artist   string  `json:"artist"`
+	Title    string  `json:"title"`
+	Album    string  `json:"album,omitempty"`
+	Track    int     `json:"track,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// Scrobble is a finished play one service hasn't accepted yet, kept until it's back
+type Scrobble struct {
+	UserID   string        `json:"userId,omitempty"`
+	Service  string        `json:"service"` // lastfm or listenbrainz
+	Track    ScrobbleTrack `json:"track"`
+	Time     time.Time     `json:"time"`
+	Attempts int           `json:"attempts"`
+}
+
+// ScrobbleStore holds each user's scrobbling accounts and the plays waiting to be resent
+type ScrobbleStore struct {
+	mu       sync.Mutex
+	path     string
+	Accounts map[string]*ScrobbleAccounts `json:"accounts"` // Keyed by user ID, "" without accounts
+	Queue    []Scrobble                   `json:"queue"`
+
+	// Plays waiting to have been listened to long enough, one per client
+	pending map[string]*time.Timer
+}
+
+var scrobbles *ScrobbleStore
+
+func loadScrobbleStore(path string) (*ScrobbleStore, error) {
+	s := &ScrobbleStore{path: path, Accounts: map[string]*ScrobbleAccounts{}, pending: map[string]*time.Timer{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Accounts == nil {
+		s.Accounts = map[string]*ScrobbleAccounts{}
+	}
+	return s, nil
+}
+
+func (s *ScrobbleStore) Get(userID string) ScrobbleAccounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.Accounts[userID]; ok {
+		return *a
+	}
+	return ScrobbleAccounts{}
+}
+
+// Update changes one user's accounts
+func (s *ScrobbleStore) Update(userID string, update func(a *ScrobbleAccounts)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.Accounts[userID]
+	if !ok {
+		a = &ScrobbleAccounts{}
+		s.Accounts[userID] = a
+	}
+	update(a)
+	if a.LastFM == nil && a.ListenBrainz == nil {
+		delete(s.Accounts, userID)
+	}
+	return saveJSON(s.path, s)
+}
+
+// Queued counts one user's plays waiting to be resent
+func (s *ScrobbleStore) Queued(userID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, sc := range s.Queue {
+		if sc.UserID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// enqueue keeps a play the service couldn't take for now, dropping the oldest when full
+func (s *ScrobbleStore) enqueue(sc Scrobble) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Queue = append(s.Queue, sc)
+	if len(s.Queue) > maxScrobbleQueue {
+		s.Queue = s.Queue[len(s.Queue)-maxScrobbleQueue:]
+	}
+	if err := saveJSON(s.path, s); err != nil {
+		slog.Warn("Error saving scrobble queue", "err", err)
+	}
+}
+
+// wait starts the clock on a play, replacing whatever the same client had waiting
+func (s *ScrobbleStore) wait(client string, after time.Duration, scrobble func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.pending[client]; ok {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(after, func() {
+		s.mu.Lock()
+		current := s.pending[client] == t
+		if current {
+			delete(s.pending, client)
+		}
+		s.mu.Unlock()
+		if current {
+			scrobble()
+		}
+	})
+	s.pending[client] = t
+}
+
+// skip forgets the play a client had waiting, once it's been scrobbled some other way
+func (s *ScrobbleStore) skip(client string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.pending[client]; ok {
+		t.Stop()
+		delete(s.pending, client)
+	}
+}
+
+// retryPeriodically resends queued plays, dropping any a service turns down for good
+func (s *ScrobbleStore) retryPeriodically() {
+	ticker := time.NewTicker(scrobbleRetryEvery)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.retry()
+	}
+}
+
+func (s *ScrobbleStore) retry() {
+	s.mu.Lock()
+	queue := s.Queue
+	s.Queue = nil
+	s.mu.Unlock()
+	if len(queue) == 0 {
+		return
+	}
+
+	var failed []Scrobble
+	for i, sc := range queue {
+		err := sc.send(s.Get(sc.UserID))
+		if err == nil || errors.Is(err, errScrobbleRejected) {
+			if err != nil {
+				slog.Warn("Dropping scrobble", "service", sc.Service, "path", sc.Track.Path, "err", err)
+			}
+			continue
+		}
+		if sc.Attempts++; sc.Attempts < maxScrobbleTries {
+			failed = append(failed, sc)
+		}
+		if isOffline(err) {
+			// Everything else would fail the same way
+			failed = append(failed, queue[i+1:]...)
+			break
+		}
+	}
+	s.mu.Lock()
+	s.Queue = append(failed, s.Queue...)
+	err := saveJSON(s.path, s)
+	s.mu.Unlock()
+	if err != nil {
+		slog.Warn("Error saving scrobble queue", "err", err)
+	}
+}
+
+// isOffline reports a failure to reach the service at all, as opposed to it refusing one play
+func isOffline(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// send submits a finished play to its service
+func (sc Scrobble) send(accounts ScrobbleAccounts) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	switch {
+	case sc.Service == "lastfm" && accounts.LastFM != nil:
+		return lastfmCall(ctx, "track.scrobble", lastfmTrackParams(sc.Track, accounts.LastFM, sc.Time), nil)
+	case sc.Service == "listenbrainz" && accounts.ListenBrainz != nil:
+		return listenBrainzSubmit(ctx, accounts.ListenBrainz.Token, "single", sc.Track, sc.Time)
+	}
+	return fmt.Errorf("%w: the account was disconnected", errScrobbleRejected)
+}
+
+// scrobbleClient is who a request plays for and which client it comes from, or false when
+// scrobbling is turned off for this browser
+func scrobbleClient(r *http.Request) (userID, client string, ok bool) {
+	if u := currentUser(r); u != nil {
+		userID = u.ID
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if !users.SessionScrobbles(cookie.Value) {
+			return "", "", false
+		}
+		return userID, "session:" + hashSecret(cookie.Value), true
+	}
+	return userID, "client:" + requestUserName(r) + "@" + clientIP(r).String(), true
+}
+
+// scrobbleTrack looks up the tags the services need, which have to include an artist
+func scrobbleTrack(relPath string) (ScrobbleTrack, bool) {
+	f, err := libraryFileAt(relPath)
+	if err != nil {
+		return ScrobbleTrack{}, false
+	}
+	meta := trackMeta(f)
+	t := ScrobbleTrack{
+		Path:     f.Path,
+		Artist:   meta.Artist,
+		Title:    firstNonEmpty(meta.Title, strings.TrimSuffix(f.Name, path.Ext(f.Name))),
+		Album:    meta.Album,
+		Track:    meta.Track,
+		Duration: meta.Duration,
+	}
+	return t, t.Artist != ""
+}
+
+// scrobbleStarted announces a track a client just started, then scrobbles it once enough of
+// it has played, unless the same client moves on first
+func scrobbleStarted(userID, client, relPath string) {
+	if readOnly {
+		return
+	}
+	accounts := scrobbles.Get(userID)
+	if accounts.LastFM == nil && accounts.ListenBrainz == nil {
+		return
+	}
+	go func() {
+		t, ok := scrobbleTrack(relPath)
+		if !ok {
+			scrobbles.skip(client)
+			return
+		}
+		started := time.Now()
+		sendNowPlaying(userID, t)
+		length := time.Duration(t.Duration * float64(time.Second))
+		if length <= scrobbleMinLength {
+			// Too short to count, or we can't tell how long it is
+			scrobbles.skip(client)
+			return
+		}
+		scrobbles.wait(client, min(length/2, scrobbleMaxWait), func() { submitScrobble(userID, t, started) })
+	}()
+}
+
+// scrobbleFinished scrobbles a play the client says is done, as Subsonic clients do
+func scrobbleFinished(userID, client, relPath string, at time.Time) {
+	if readOnly {
+		return
+	}
+	scrobbles.skip(client)
+	go func() {
+		if t, ok := scrobbleTrack(relPath); ok {
+			submitScrobble(userID, t, at)
+		}
+	}()
+}
+
+// scrobbleNowPlaying only announces a track, for clients that scrobble it themselves later
+func scrobbleNowPlaying(userID, relPath string) {
+	go func() {
+		if t, ok := scrobbleTrack(relPath); ok {
+			sendNowPlaying(userID, t)
+		}
+	}()
+}
+
+// sendNowPlaying is best effort; it isn't worth retrying once the track's over
+func sendNowPlaying(userID string, t ScrobbleTrack) {
+	accounts := scrobbles.Get(userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if a := accounts.LastFM; a != nil {
+		if err := lastfmCall(ctx, "track.updateNowPlaying", lastfmTrackParams(t, a, time.Time{}), nil); err != nil {
+			slog.Debug("Error sending now playing to Last.fm", "err", err)
+		}
+	}
+	if a := accounts.ListenBrainz; a != nil {
+		if err := listenBrainzSubmit(ctx, a.Token, "playing_now", t, time.Time{}); err != nil {
+			slog.Debug("Error sending now playing to ListenBrainz", "err", err)
+		}
+	}
+}
+
+func submitScrobble(userID string, t ScrobbleTrack, at time.Time) {
+	accounts := scrobbles.Get(userID)
+	var services []string
+	if accounts.LastFM != nil {
+		services = append(services, "lastfm")
+	}
+	if accounts.ListenBrainz != nil {
+		services = append(services, "listenbrainz")
+	}
+	for _, service := range services {
+		sc := Scrobble{UserID: userID, Service: service, Track: t, Time: at.UTC()}
+		err := sc.send(accounts)
+		switch {
+		case errors.Is(err, errScrobbleRejected):
+			slog.Warn("Scrobble rejected", "service", service, "path", t.Path, "err", err)
+		case err != nil:
+			slog.Info("Queueing scrobble to retry", "service", service, "path", t.Path, "err", err)
+			sc.Attempts = 1
+			scrobbles.enqueue(sc)
+		}
+	}
+}
+
+func lastfmTrackParams(t ScrobbleTrack, a *LastFMAccount, at time.Time) url.Values {
+	params := url.Values{"artist": {t.Artist}, "track": {t.Title}, "sk": {a.SessionKey}}
+	if t.Album != "" {
+		params.Set("album", t.Album)
+	}
+	if t.Track > 0 {
+		params.Set("trackNumber", strconv.Itoa(t.Track))
+	}
+	if t.Duration > 0 {
+		params.Set("duration", strconv.Itoa(int(t.Duration+0.5)))
+	}
+	if !at.IsZero() {
+		params.Set("timestamp", strconv.FormatInt(at.Unix(), 10))
+	}
+	return params
+}
+
+// lastfmSign makes api_sig: every parameter sorted by name, run together, then the secret
+func lastfmSign(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "format" && k != "callback" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + params.Get(k))
+	}
+	b.WriteString(lastfmSecret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastfmCall makes a signed API call. Errors Last.fm says won't go away by retrying, like a
+// revoked session, are errScrobbleRejected.
+func lastfmCall(ctx context.Context, method string, params url.Values, result any) error {
+	if lastfmAPIKey == "" || lastfmSecret == "" {
+		return fmt.Errorf("%w: Last.fm isn't set up on this server", errScrobbleRejected)
+	}
+	params.Set("method", method)
+	params.Set("api_key", lastfmAPIKey)
+	params.Set("api_sig", lastfmSign(params))
+	params.Set("format", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lastfmAPI, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "beatgraze/"+versionInfo().Version)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("Last.fm: %s", resp.Status)
+	}
+	var failure struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &failure)
+	switch failure.Error {
+	case 0:
+	case 11, 16, 29: // Service offline, temporarily unavailable, rate limited
+		return fmt.Errorf("Last.fm: %s", failure.Message)
+	default:
+		return fmt.Errorf("%w: Last.fm: %s", errScrobbleRejected, failure.Message)
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("Last.fm: %s", resp.Status)
+	}
+	if result != nil {
+		return json.Unmarshal(body, result)
+	}
+	return nil
+}
+
+// listenBrainzSubmit sends a listen, or what's playing now when at is zero
+func listenBrainzSubmit(ctx context.Context, token, listenType string, t ScrobbleTrack, at time.Time) error {
+	info := map[string]any{"media_player": "beatgraze", "submission_client": "beatgraze", "submission_client_version": versionInfo().Version}
+	if t.Duration > 0 {
+		info["duration_ms"] = int(t.Duration * 1000)
+	}
+	if t.Track > 0 {
+		info["tracknumber"] = t.Track
+	}
+	meta := map[string]any{"artist_name": t.Artist, "track_name": t.Title, "additional_info": info}
+	if t.Album != "" {
+		meta["release_name"] = t.Album
+	}
+	listen := map[string]any{"track_metadata": meta}
+	if !at.IsZero() {
+		listen["listened_at"] = at.Unix()
+	}
+	body, err := json.Marshal(map[string]any{"listen_type": listenType, "payload": []any{listen}})
+	if err != nil {
+		return err
+	}
+	resp, err := listenBrainzRequest(ctx, http.MethodPost, "/1/submit-listens", token, string(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func listenBrainzRequest(ctx context.Context, method, endpoint, token, body string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(listenBrainzURL, "/")+endpoint, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "beatgraze/"+versionInfo().Version)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		resp.Body.Close()
+		return nil, fmt.Errorf("ListenBrainz: %s", resp.Status)
+	case resp.StatusCode >= 400:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: ListenBrainz: %s", errScrobbleRejected, resp.Status)
+	}
+	return resp, nil
+}
+
+func registerScrobbleRoutes() {
+	handleFunc("GET /api/scrobble", requireRole(roleListener, getScrobbling))
+	handleFunc("PUT /api/scrobble/session", requireRole(roleListener, setSessionScrobbling))
+	handleFunc("GET /api/scrobble/lastfm/connect", requireRole(roleListener, connectLastFM))
+	handleFunc("GET /api/scrobble/lastfm/callback", requireRole(roleListener, lastfmCallback))
+	handleFunc("DELETE /api/scrobble/lastfm", requireRole(roleListener, disconnectScrobbling("lastfm")))
+	handleFunc("PUT /api/scrobble/listenbrainz", requireRole(roleListener, connectListenBrainz))
+	handleFunc("DELETE /api/scrobble/listenbrainz", requireRole(roleListener, disconnectScrobbling("listenbrainz")))
+}
+
+func getScrobbling(w http.ResponseWriter, r *http.Request) {
+	userID := uploadOwner(r)
+	accounts := scrobbles.Get(userID)
+	type account struct {
+		Connected bool   `json:"connected"`
+		User      string `json:"user,omitempty"`
+	}
+	resp := struct {
+		LastFM          account `json:"lastfm"`
+		ListenBrainz    account `json:"listenbrainz"`
+		LastFMAvailable bool    `json:"lastfmAvailable"` // Whether the server has a Last.fm API key
+		Session         bool    `json:"session"`         // Whether this browser's plays are scrobbled
+		Queued          int     `json:"queued"`
+	}{LastFMAvailable: lastfmAPIKey != "" && lastfmSecret != "", Queued: scrobbles.Queued(userID)}
+	if a := accounts.LastFM; a != nil {
+		resp.LastFM = account{true, a.User}
+	}
+	if a := accounts.ListenBrainz; a != nil {
+		resp.ListenBrainz = account{true, a.User}
+	}
+	_, _, resp.Session = scrobbleClient(r)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// setSessionScrobbling turns scrobbling off or back on for the browser asking, e.g. one
+// left playing at a party, without disconnecting the accounts
+func setSessionScrobbling(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		http.Error(w, "Only signed-in browsers can turn scrobbling off for themselves", http.StatusBadRequest)
+		return
+	}
+	if err := users.SetSessionScrobbling(cookie.Value, req.Enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !req.Enabled {
+		scrobbles.skip("session:" + hashSecret(cookie.Value))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// connectLastFM sends the browser to Last.fm to allow scrobbling, which comes back to
+// lastfmCallback with a token to swap for a session key
+func connectLastFM(w http.ResponseWriter, r *http.Request) {
+	if lastfmAPIKey == "" || lastfmSecret == "" {
+		http.Error(w, "Last.fm isn't set up on this server; it needs -lastfm-api-key and -lastfm-secret", http.StatusNotImplemented)
+		return
+	}
+	state := newID() + newID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     lastfmStateCookie,
+		Value:    state,
+		Path:     prefixed("/api/scrobble/lastfm/"),
+		MaxAge:   600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil,
+	})
+	callback := externalURL(r, "/api/scrobble/lastfm/callback") + "?state=" + state
+	http.Redirect(w, r, "https://www.last.fm/api/auth/?api_key="+url.QueryEscape(lastfmAPIKey)+"&cb="+url.QueryEscape(callback), http.StatusFound)
+}
+
+func lastfmCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(lastfmStateCookie)
+	if err != nil || !secureCompare(r.URL.Query().Get("state"), cookie.Value) {
+		http.Error(w, "Connecting Last.fm expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: lastfmStateCookie, Value: "", Path: prefixed("/api/scrobble/lastfm/"), MaxAge: -1})
+	if readOnly {
+		http.Error(w, "beatgraze is running in read-only mode", http.StatusForbidden)
+		return
+	}
+	var result struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+	err = lastfmCall(r.Context(), "auth.getSession", url.Values{"token": {r.URL.Query().Get("token")}}, &result)
+	if err == nil && result.Session.Key == "" {
+		err = errors.New("Last.fm didn't return a session")
+	}
+	if err != nil {
+		http.Error(w, "Connecting Last.fm failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	err = scrobbles.Update(uploadOwner(r), func(a *ScrobbleAccounts) {
+		a.LastFM = &LastFMAccount{User: result.Session.Name, SessionKey: result.Session.Key}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, prefixed("/"), http.StatusFound)
+}
+
+func connectListenBrainz(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := readJSON(r, &req); err != nil || strings.TrimSpace(req.Token) == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	token := strings.TrimSpace(req.Token)
+	resp, err := listenBrainzRequest(r.Context(), http.MethodGet, "/1/validate-token", token, "")
+	if errors.Is(err, errScrobbleRejected) {
+		http.Error(w, "ListenBrainz doesn't accept that token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Checking the token failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Valid {
+		http.Error(w, "ListenBrainz doesn't accept that token", http.StatusBadRequest)
+		return
+	}
+	err = scrobbles.Update(uploadOwner(r), func(a *ScrobbleAccounts) {
+		a.ListenBrainz = &ListenBrainzAccount{User: result.UserName, Token: token}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func disconnectScrobbling(service string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := scrobbles.Update(uploadOwner(r), func(a *ScrobbleAccounts) {
+			if service == "lastfm" {
+				a.LastFM = nil
+			} else {
+				a.ListenBrainz = nil
+			}
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// searchFields are what the index takes words from, with how much a match in each counts
+var searchFields = []struct {
+	name   string
+	weight float64
+	get    func(f libraryFile, meta TrackMeta) string
+}{
+	{"title", 3, func(_ libraryFile, m TrackMeta) string { return m.Title }},
+	{"artist", 2.5, func(_ libraryFile, m TrackMeta) string { return m.Artist }},
+	{"name", 2, func(f libraryFile, _ TrackMeta) string { return trackTitle(f.Name) }},
+	{"album", 2, func(_ libraryFile, m TrackMeta) string { return m.Album }},
+	{"folder", 1.5, func(f libraryFile, _ TrackMeta) string { return f.Folder }},
+	{"genre", 1, func(_ libraryFile, m TrackMeta) string { return m.Genre }},
+	{"path", 0.5, func(f libraryFile, _ TrackMeta) string { return path.Dir(f.Path) }},
+}
+
+// prefixMatchWeight scales what a word counts when the query only starts it, so "kick"
+// ranks tracks called Kick above those called Kicks
+const prefixMatchWeight = 0.6
+
+// fuzzyMatchWeight is what a word a typo away counts for, halved for each typo more
+const fuzzyMatchWeight = 0.5
+
+// searchDoc is a file as the index last saw it
+type searchDoc struct {
+	modTime time.Time
+	size    int64
+	terms   map[string]float64 // Word to its weight in this file
+
+	// What suggestions are made of
+	name, dir, artist string
+}
+
+// searchIndex is an inverted index from words to the files they're in, kept in step with the
+// library by its rescans, so a search only looks at files sharing its words
+type searchIndex struct {
+	mu       sync.Mutex
+	docs     map[string]*searchDoc
+	postings map[string]map[string]float64 // Word to the files it's in, with its weight in each
+	terms    []string                      // Every word, sorted for prefix lookups; nil when stale
+	filled   bool                          // Updated since it was made or last reset
+}
+
+var libraryIndex = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{docs: map[string]*searchDoc{}, postings: map[string]map[string]float64{}}
+}
+
+// Update brings the index in step with the library
+func (ix *searchIndex) Update(files []libraryFile) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.sync(files)
+	ix.filled = true
+}
+
+// Reset empties the index, which fills again on the next search, returning how many files it held
+func (ix *searchIndex) Reset() int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	n := len(ix.docs)
+	ix.docs, ix.postings, ix.terms, ix.filled = map[string]*searchDoc{}, map[string]map[string]float64{}, nil, false
+	return n
+}
+
+// fillLibraryIndex indexes the last listing of the library if the index hasn't been filled
+// since it was reset
+func fillLibraryIndex() error {
+	libraryIndex.mu.Lock()
+	filled := libraryIndex.filled
+	libraryIndex.mu.Unlock()
+	if filled {
+		return nil
+	}
+	files, err := listLibrary()
+	if err != nil {
+		return err
+	}
+	libraryIndex.Update(files)
+	return nil
+}
+
+// tokenize splits text into folded words of letters and digits
+func tokenize(s string) []string {
+	return strings.FieldsFunc(foldText(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// foldLetters spells out the letters that don't decompose into a plain letter and an accent
+var foldLetters = map[rune]string{
+	'ø': "o", 'Ø': "o", 'æ': "ae", 'Æ': "ae", 'œ': "oe", 'Œ': "oe", 'ł': "l", 'Ł': "l",
+	'đ': "d", 'Đ': "d", 'ð': "d", 'Ð': "d", 'þ': "th", 'Þ': "th", 'ß': "ss", 'ı': "i",
+}
+
+// foldText lowercases text and strips its accents, and turns full and half width forms and
+// the like into the ordinary characters, so Röyksopp, ROYKSOPP and Ｒöyksopp compare equal
+func foldText(s string) string {
+	ascii := true
+	for i := 0; i < len(s) && ascii; i++ {
+		ascii = s[i] < utf8.RuneSelf
+	}
+	if ascii {
+		return strings.ToLower(s)
+	}
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if spelled, ok := foldLetters[r]; ok {
+			b.WriteString(spelled)
+		} else {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// sync indexes new and changed files and drops those no longer in the library
+func (ix *searchIndex) sync(files []libraryFile) {
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[f.Path] = true
+		if doc, ok := ix.docs[f.Path]; ok && doc.modTime.Equal(f.ModTime) && doc.size == f.Size {
+			continue
+		}
+		ix.remove(f.Path)
+		ix.add(f)
+	}
+	if len(ix.docs) > len(seen) {
+		for p := range ix.docs {
+			if !seen[p] {
+				ix.remove(p)
+			}
+		}
+	}
+}
+
+func (ix *searchIndex) add(f libraryFile) {
+	meta := trackMeta(f)
+	doc := &searchDoc{modTime: f.ModTime, size: f.Size, terms: map[string]float64{},
+		name: f.Name, dir: f.Dir, artist: meta.Artist}
+	for _, field := range searchFields {
+		for _, term := range tokenize(field.get(f, meta)) {
+			doc.terms[term] += field.weight
+		}
+	}
+	for term, weight := range doc.terms {
+		posting, ok := ix.postings[term]
+		if !ok {
+			posting = map[string]float64{}
+			ix.postings[term] = posting
+			ix.terms = nil
+		}
+		posting[f.Path] = weight
+	}
+	ix.docs[f.Path] = doc
+}
+
+func (ix *searchIndex) remove(p string) {
+	doc, ok := ix.docs[p]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(ix.postings[term], p)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+			ix.terms = nil
+		}
+	}
+	delete(ix.docs, p)
+}
+
+// withPrefix lists the indexed words starting with prefix
+func (ix *searchIndex) withPrefix(prefix string) []string {
+	if ix.terms == nil {
+		ix.terms = make([]string, 0, len(ix.postings))
+		for term := range ix.postings {
+			ix.terms = append(ix.terms, term)
+		}
+		sort.Strings(ix.terms)
+	}
+	i := sort.SearchStrings(ix.terms, prefix)
+	j := i
+	for j < len(ix.terms) && strings.HasPrefix(ix.terms[j], prefix) {
+		j++
+	}
+	return ix.terms[i:j]
+}
+
+// Search finds the files containing every word of query, in full or as the start of a word,
+// scoring each by how rare the words are and which fields they're in. fuzzy also lets words
+// through with a typo or two, for less.
+func (ix *searchIndex) Search(query string, fuzzy bool) map[string]float64 {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return map[string]float64{}
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var scores map[string]float64
+	for _, word := range words {
+		// What a match on each term is worth against the word
+		matches := map[string]float64{}
+		for _, term := range ix.withPrefix(word) {
+			matches[term] = 1
+			if term != word {
+				matches[term] = prefixMatchWeight * float64(len(word)) / float64(len(term))
+			}
+		}
+		if fuzzy {
+			for term, dist := range ix.similar(word) {
+				if _, ok := matches[term]; !ok {
+					matches[term] = fuzzyMatchWeight / float64(dist)
+				}
+			}
+		}
+
+		// Each file counts its best match for the word
+		best := map[string]float64{}
+		for term, weight := range matches {
+			posting := ix.postings[term]
+			weight *= math.Log(1 + float64(len(ix.docs))/float64(len(posting)))
+			for p, w := range posting {
+				if s := w * weight; s > best[p] {
+					best[p] = s
+				}
+			}
+		}
+		if scores == nil {
+			scores = best
+			continue
+		}
+		for p, s := range scores {
+			if b, ok := best[p]; ok {
+				scores[p] = s + b
+			} else {
+				delete(scores, p)
+			}
+		}
+	}
+	return scores
+}
+
+// similar finds the indexed words a typo or two away from word, with how many
+func (ix *searchIndex) similar(word string) map[string]int {
+	n := utf8.RuneCountInString(word)
+	maxDist := typoAllowance(word)
+	found := map[string]int{}
+	if maxDist == 0 {
+		return found
+	}
+	for term := range ix.postings {
+		if m := utf8.RuneCountInString(term); m < n-maxDist || m > n+maxDist {
+			continue
+		}
+		if d := editDistance(word, term, maxDist); d > 0 && d <= maxDist {
+			found[term] = d
+		}
+	}
+	return found
+}
+
+// typoAllowance is how many typos fuzzy matching forgives in a word
+func typoAllowance(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n >= 7:
+		return 2
+	case n >= 3:
+		return 1
+	}
+	return 0
+}
+
+// editDistance counts the letters to insert, delete, change or swap with the next to turn a
+// into b, giving up with max+1 once it's past max
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	before := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], before[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		before, prev, cur = prev, cur, before
+	}
+	return prev[len(rb)]
+}
+
+// Search queries are rules (see rules.go) in which words and quoted phrases standing on their
+// own are looked up in the index, e.g.
+//
+//	artist:"four tet" AND (ext:flac OR ext:wav) bpm:120-128 NOT folder:samples
+//	kick dir:drums
+//
+// or re: and a regular expression (see searchRegex).
+// dir:<folder> keeps to the folder as named in the library's folder tags, either its name or
+// its full path; dir:<path>/** takes in everything below it. A query that doesn't parse is
+// searched for as plain words.
+
+// textNode matches the files the index found for some words
+type textNode struct{ scores map[string]float64 }
+
+func (n textNode) eval(t *ruleTrack) bool {
+	_, ok := n.scores[t.file.Path]
+	return ok
+}
+
+// onlyText reports whether a query is nothing but words and phrases, all of which must match
+func onlyText(n ruleNode) bool {
+	switch n := n.(type) {
+	case textNode:
+		return true
+	case andNode:
+		return onlyText(n.left) && onlyText(n.right)
+	}
+	return false
+}
+
+// dirNode matches the files directly in a folder, or anywhere below it when it ends in /**
+type dirNode string
+
+func (n dirNode) eval(t *ruleTrack) bool {
+	dir := strings.TrimPrefix(string(n), "./")
+	if subtree, ok := strings.CutSuffix(dir, "**"); ok {
+		return inFolder(filepath.ToSlash(t.file.Path), strings.Trim(subtree, "/"), true)
+	}
+	return t.file.Folder == dir || t.file.Dir == strings.Trim(dir, "/") || (dir == "" && t.file.Folder == "")
+}
+
+// parseDir reads dir:<folder>, where the folder may be left off for the library root
+func (p *ruleParser) parseDir() (ruleNode, error) {
+	p.pos++
+	dir := ""
+	if t, ok := p.peek(); ok && (t.kind == "word" || t.kind == "string") && !isRuleKeyword(t) {
+		dir = t.value
+		p.pos++
+	}
+	return dirNode(dir), nil
+}
+
+func isRuleKeyword(t ruleToken) bool {
+	return t.kind == "word" && (strings.EqualFold(t.value, "AND") || strings.EqualFold(t.value, "OR") || strings.EqualFold(t.value, "NOT"))
+}
+
+// Regular expressions are limited in length and compiled size, and given a while to run, as
+// a guard against patterns that would tie the server up
+const (
+	maxSearchRegexLen   = 500
+	maxSearchRegexInsts = 10000
+	searchRegexTimeout  = 5 * time.Second
+)
+
+var (
+	errSearchRegexTooBig  = errors.New("regular expression is too long or complex")
+	errSearchRegexTimeout = errors.New("regular expression search took too long")
+)
+
+// searchFiles matches a search box query against files, returning the relevance of each match.
+// Words alone are answered from the index, which is as of the last rescan; only rules and
+// regular expressions go through files one by one.
+func searchFiles(files []libraryFile, allStats map[string]TrackStats, query string, fuzzy bool) (map[string]float64, error) {
+	if pattern, ok := strings.CutPrefix(query, "re:"); ok {
+		return searchRegex(files, pattern)
+	}
+	if err := fillLibraryIndex(); err != nil {
+		return nil, err
+	}
+	var texts []textNode
+	p := &ruleParser{text: func(words string) ruleNode {
+		n := textNode{libraryIndex.Search(words, fuzzy)}
+		texts = append(texts, n)
+		return n
+	}}
+	root, err := p.parse(query)
+	if err != nil {
+		return libraryIndex.Search(query, fuzzy), nil
+	}
+	if onlyText(root) {
+		// Every file the words match is among the first word's, so there's no need to go
+		// through the rest
+		scores := map[string]float64{}
+		for match := range texts[0].scores {
+			if !root.eval(&ruleTrack{file: libraryFile{AudioFile: AudioFile{Path: match}}}) {
+				continue
+			}
+			for _, n := range texts {
+				scores[match] += n.scores[match]
+			}
+		}
+		return scores, nil
+	}
+	scores := map[string]float64{}
+	for _, f := range files {
+		if !root.eval(&ruleTrack{file: f, stats: allStats[f.Path]}) {
+			continue
+		}
+		score := 0.0
+		for _, n := range texts {
+			score += n.scores[f.Path]
+		}
+		scores[f.Path] = score
+	}
+	return scores, nil
+}
+
+// searchRegex matches re:<pattern> against file names and paths, e.g.
+// re:^\d{2} - .*\(live\) for numbered live recordings. (?i) makes it ignore case.
+func searchRegex(files []libraryFile, pattern string) (map[string]float64, error) {
+	if len(pattern) > maxSearchRegexLen {
+		return nil, errSearchRegexTooBig
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	if prog, err := syntax.Compile(parsed.Simplify()); err != nil || len(prog.Inst) > maxSearchRegexInsts {
+		return nil, errSearchRegexTooBig
+	}
+	re := regexp.MustCompile(pattern)
+
+	deadline := time.Now().Add(searchRegexTimeout)
+	scores := map[string]float64{}
+	for i, f := range files {
+		if i%1000 == 999 && time.Now().After(deadline) {
+			return nil, errSearchRegexTimeout
+		}
+		if re.MatchString(f.Name) || re.MatchString(filepath.ToSlash(f.Path)) {
+			scores[f.Path] = 1
+		}
+	}
+	return scores, nil
+}
+
+// sortByRelevance puts the best matches first, keeping the existing order among equals
+func sortByRelevance[T any](items []T, key func(T) string, scores map[string]float64) {
+	sort.SliceStable(items, func(i, j int) bool { return scores[key(items[i])] > scores[key(items[j])] })
+}
+
+const (
+	defaultSuggestions = 5
+	maxSuggestions     = 20
+)
+
+// Suggestion is a completion for the search box
+type Suggestion struct {
+	Value string `json:"value"`
+	Count int    `json:"count,omitempty"` // Files it would find
+	Path  string `json:"path,omitempty"`  // For files
+}
+
+func registerSearchRoutes() {
+	handleFunc("GET /api/suggest", getSuggestions)
+}
+
+// getSuggestions completes what's been typed so far, ?q=, into the artists, folders and files
+// it could be after, most files first. ?limit= caps each list. Typos are forgiven when nothing
+// matches as typed.
+func getSuggestions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultSuggestions
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		limit = min(n, maxSuggestions)
+	}
+	if err := fillLibraryIndex(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	artists, folders, found := libraryIndex.Suggest(q.Get("q"), limit)
+	writeJSON(w, http.StatusOK, map[string][]Suggestion{"artists": artists, "folders": folders, "files": found})
+}
+
+// Suggest finds the artists and folders whose names match every word of query, as the start
+// of a word, along with the best matching files
+func (ix *searchIndex) Suggest(query string, limit int) (artists, folders, found []Suggestion) {
+	words := tokenize(query)
+	artists, folders, found = []Suggestion{}, []Suggestion{}, []Suggestion{}
+	if len(words) == 0 {
+		return
+	}
+	fuzzy := false
+	scores := ix.Search(query, false)
+	if len(scores) == 0 {
+		fuzzy = true
+		scores = ix.Search(query, true)
+	}
+
+	ix.mu.Lock()
+	artistCounts, folderCounts := map[string]int{}, map[string]int{}
+	paths := make([]string, 0, len(scores))
+	for p := range scores {
+		doc, ok := ix.docs[p]
+		if !ok {
+			continue
+		}
+		if doc.artist != "" && matchesWords(doc.artist, words, fuzzy) {
+			artistCounts[doc.artist]++
+		}
+		if doc.dir != "" && matchesWords(path.Base(doc.dir), words, fuzzy) {
+			folderCounts[doc.dir]++
+		}
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if scores[paths[i]] != scores[paths[j]] {
+			return scores[paths[i]] > scores[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	for _, p := range paths[:min(limit, len(paths))] {
+		found = append(found, Suggestion{Value: ix.docs[p].name, Path: p})
+	}
+	ix.mu.Unlock()
+
+	return topSuggestions(artistCounts, limit), topSuggestions(folderCounts, limit), found
+}
+
+// matchesWords reports whether every word starts a word of value, or is a typo or two from one
+func matchesWords(value string, words []string, fuzzy bool) bool {
+	terms := tokenize(value)
+	for _, word := range words {
+		ok := false
+		for _, term := range terms {
+			if strings.HasPrefix(term, word) || (fuzzy && editDistance(word, term, typoAllowance(word)) <= typoAllowance(word)) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func topSuggestions(counts map[string]int, limit int) []Suggestion {
+	list := []Suggestion{}
+	for value, n := range counts {
+		list = append(list, Suggestion{Value: value, Count: n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Value < list[j].Value
+	})
+	return list[:min(limit, len(list))]
+}
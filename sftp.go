@@ -0,0 +1,605 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Just enough of SFTP version 3 (draft-ietf-secsh-filexfer-02) to list folders and read files
+
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpFlagRead = 1
+
+	sftpStatusEOF        = 1
+	sftpStatusNoSuchFile = 2
+
+	sftpAttrSize        = 0x1
+	sftpAttrUIDGID      = 0x2
+	sftpAttrPermissions = 0x4
+	sftpAttrTimes       = 0x8
+	sftpAttrExtended    = 0x80000000
+)
+
+const (
+	sftpChunkSize  = 32 << 10 // The largest read every server is required to honour
+	sftpReadAhead  = 16       // Reads kept in flight so playback isn't bound by round trips
+	sftpListConcur = 4
+)
+
+// sftpClient reads a library over SFTP, given as sftp://user@host/music for an absolute path
+// or sftp://user@host/~/music for one under the user's home. It authenticates with the SSH
+// agent or the usual ~/.ssh keys (?key= picks one) and checks ~/.ssh/known_hosts.
+type sftpClient struct {
+	addr   string
+	root   string // Ends with "/" unless it's the home directory
+	config *ssh.ClientConfig
+
+	mu   sync.Mutex
+	conn *sftpConn
+}
+
+func newSFTPStorage(rawURL string) (*remoteStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || u.User == nil {
+		return nil, fmt.Errorf("invalid SFTP location %q, expected sftp://user@host/path", rawURL)
+	}
+	c := &sftpClient{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	c.root = strings.TrimSuffix(u.Path, "/")
+	if rest, ok := strings.CutPrefix(c.root, "/~"); ok {
+		// Relative paths are resolved against the home directory by the server
+		c.root = strings.TrimPrefix(rest, "/")
+	}
+	if c.root != "" {
+		c.root += "/"
+	}
+
+	auth, err := sshAuthMethods(u.Query().Get("key"))
+	if err != nil {
+		return nil, err
+	}
+	knownHostsFile := u.Query().Get("known_hosts")
+	if knownHostsFile == "" {
+		home, _ := os.UserHomeDir()
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts (connect once with ssh to add %s): %v", u.Hostname(), err)
+	}
+	c.config = &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         15 * time.Second,
+	}
+
+	// Fail at startup rather than on the first scan if the server or folder is wrong
+	if _, err := c.readdir(""); err != nil {
+		return nil, err
+	}
+	name := path.Base("/" + strings.TrimSuffix(c.root, "/"))
+	if name == "/" {
+		name = u.Hostname()
+	}
+	return &remoteStorage{id: rawURL, name: name, list: c.list, read: c.read}, nil
+}
+
+// sshAuthMethods offers the agent's keys and then any private keys found on disk. Keys with
+// a passphrase are skipped unless SFTP_KEY_PASSPHRASE is set; add them to the agent instead.
+func sshAuthMethods(keyFile string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" && keyFile == "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	files := []string{keyFile}
+	if keyFile == "" {
+		home, _ := os.UserHomeDir()
+		files = nil
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			files = append(files, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if keyFile != "" {
+				return nil, err
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && os.Getenv("SFTP_KEY_PASSPHRASE") != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(os.Getenv("SFTP_KEY_PASSPHRASE")))
+		}
+		if err != nil {
+			if keyFile != "" {
+				return nil, fmt.Errorf("reading %s: %v", file, err)
+			}
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH keys found; start ssh-agent or pass ?key=/path/to/key")
+	}
+	return methods, nil
+}
+
+// sftpConn is one SFTP session. Requests are tagged with ids, so many can be in flight at
+// once and a single reader goroutine hands each response to whoever's waiting for it.
+type sftpConn struct {
+	ssh     *ssh.Client
+	w       io.Writer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan sftpPacket
+	err     error // Set once the connection has died
+}
+
+type sftpPacket struct {
+	typ  byte
+	data []byte
+}
+
+// connection returns the current session, reconnecting if the last one died
+func (c *sftpClient) connection() (*sftpConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.mu.Lock()
+		err := c.conn.err
+		c.conn.mu.Unlock()
+		if err == nil {
+			return c.conn, nil
+		}
+		c.conn.ssh.Close()
+		c.conn = nil
+	}
+	client, err := ssh.Dial("tcp", c.addr, c.config)
+	if err != nil {
+		return nil, fmt.Errorf("SFTP: %v", err)
+	}
+	conn, err := startSFTP(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("SFTP: %v", err)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+func startSFTP(client *ssh.Client) (*sftpConn, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(binary.BigEndian.AppendUint32([]byte{0, 0, 0, 5, sftpInit}, 3)); err != nil {
+		return nil, err
+	}
+	typ, _, err := readSFTPPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpVersion {
+		return nil, errors.New("server didn't start an SFTP session")
+	}
+	conn := &sftpConn{ssh: client, w: w, pending: map[uint32]chan sftpPacket{}}
+	go conn.readResponses(r)
+	return conn, nil
+}
+
+func readSFTPPacket(r io.Reader) (byte, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n == 0 || n > 1<<20 {
+		return 0, nil, errors.New("bad SFTP packet length")
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return body[0], body[1:], nil
+}
+
+func (c *sftpConn) readResponses(r io.Reader) {
+	var err error
+	for {
+		var typ byte
+		var body []byte
+		if typ, body, err = readSFTPPacket(r); err != nil {
+			break
+		}
+		if len(body) < 4 {
+			err = errors.New("short SFTP response")
+			break
+		}
+		id := binary.BigEndian.Uint32(body)
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- sftpPacket{typ: typ, data: body[4:]}
+		}
+	}
+	c.mu.Lock()
+	c.err = fmt.Errorf("SFTP connection lost: %v", err)
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	c.ssh.Close()
+}
+
+// send starts a request; the response arrives on the returned channel, which is closed
+// instead if the connection dies first
+func (c *sftpConn) send(typ byte, payload []byte) (chan sftpPacket, error) {
+	ch := make(chan sftpPacket, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+5))
+	packet = append(packet, typ)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = append(packet, payload...)
+	c.writeMu.Lock()
+	_, err := c.w.Write(packet)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (c *sftpConn) wait(ch chan sftpPacket) (sftpPacket, error) {
+	p, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return p, c.err
+	}
+	return p, nil
+}
+
+func (c *sftpConn) request(typ byte, payload []byte) (sftpPacket, error) {
+	ch, err := c.send(typ, payload)
+	if err != nil {
+		return sftpPacket{}, err
+	}
+	return c.wait(ch)
+}
+
+// sftpBuffer decodes the fields of a response, remembering if it ran off the end
+type sftpBuffer struct {
+	b   []byte
+	bad bool
+}
+
+func (b *sftpBuffer) uint32() uint32 {
+	if len(b.b) < 4 {
+		b.bad = true
+		return 0
+	}
+	v := binary.BigEndian.Uint32(b.b)
+	b.b = b.b[4:]
+	return v
+}
+
+func (b *sftpBuffer) uint64() uint64 {
+	return uint64(b.uint32())<<32 | uint64(b.uint32())
+}
+
+func (b *sftpBuffer) string() string {
+	n := b.uint32()
+	if uint32(len(b.b)) < n {
+		b.bad = true
+		return ""
+	}
+	s := string(b.b[:n])
+	b.b = b.b[n:]
+	return s
+}
+
+func (b *sftpBuffer) attrs() (size int64, mode uint32, modTime time.Time) {
+	flags := b.uint32()
+	if flags&sftpAttrSize != 0 {
+		size = int64(b.uint64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		b.uint32()
+		b.uint32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		mode = b.uint32()
+	}
+	if flags&sftpAttrTimes != 0 {
+		b.uint32()
+		modTime = time.Unix(int64(b.uint32()), 0)
+	}
+	if flags&sftpAttrExtended != 0 {
+		for n := b.uint32(); n > 0 && !b.bad; n-- {
+			b.string()
+			b.string()
+		}
+	}
+	return size, mode, modTime
+}
+
+func sftpString(s string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+}
+
+// sftpError turns a status response into an error, or nil for success
+func sftpError(p sftpPacket) error {
+	if p.typ != sftpStatus {
+		return fmt.Errorf("unexpected SFTP response type %d", p.typ)
+	}
+	b := &sftpBuffer{b: p.data}
+	code, msg := b.uint32(), b.string()
+	switch code {
+	case 0:
+		return nil
+	case sftpStatusEOF:
+		return io.EOF
+	case sftpStatusNoSuchFile:
+		return fs.ErrNotExist
+	}
+	return fmt.Errorf("SFTP error %d: %s", code, msg)
+}
+
+func (c *sftpConn) handle(p sftpPacket, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if p.typ != sftpHandle {
+		return "", sftpError(p)
+	}
+	return (&sftpBuffer{b: p.data}).string(), nil
+}
+
+func (c *sftpConn) close(handle string) {
+	if ch, err := c.send(sftpClose, sftpString(handle)); err == nil {
+		c.wait(ch)
+	}
+}
+
+const (
+	sftpModeType    = 0170000
+	sftpModeDir     = 0040000
+	sftpModeSymlink = 0120000
+)
+
+// readdir lists one folder, returning its children by library path
+func (c *sftpClient) readdir(dir string) (map[string]remoteFileInfo, error) {
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	full := c.root + dir
+	if full == "" {
+		full = "."
+	}
+	handle, err := conn.handle(conn.request(sftpOpendir, sftpString(full)))
+	if err != nil {
+		return nil, fmt.Errorf("SFTP listing %s: %v", full, err)
+	}
+	defer conn.close(handle)
+
+	entries := map[string]remoteFileInfo{}
+	for {
+		p, err := conn.request(sftpReaddir, sftpString(handle))
+		if err != nil {
+			return nil, err
+		}
+		if p.typ != sftpName {
+			if err := sftpError(p); err != io.EOF {
+				return nil, fmt.Errorf("SFTP listing %s: %v", full, err)
+			}
+			return entries, nil
+		}
+		b := &sftpBuffer{b: p.data}
+		for n := b.uint32(); n > 0 && !b.bad; n-- {
+			name := b.string()
+			b.string() // The ls -l style long name
+			size, mode, modTime := b.attrs()
+			if name == "." || name == ".." {
+				continue
+			}
+			rel := path.Join(dir, name)
+			if mode&sftpModeType == sftpModeSymlink {
+				// Follow links, as a local library would
+				s, err := conn.request(sftpStat, sftpString(c.root+rel))
+				if err != nil || s.typ != sftpAttrs {
+					continue
+				}
+				size, mode, modTime = (&sftpBuffer{b: s.data}).attrs()
+			}
+			entries[rel] = remoteFileInfo{name: name, size: size, modTime: modTime, dir: mode&sftpModeType == sftpModeDir}
+		}
+		if b.bad {
+			return nil, errors.New("malformed SFTP listing")
+		}
+	}
+}
+
+func (c *sftpClient) list() (map[string]remoteFileInfo, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		files    = map[string]remoteFileInfo{}
+		slots    = make(chan struct{}, sftpListConcur)
+	)
+	var walk func(dir string)
+	walk = func(dir string) {
+		defer wg.Done()
+		slots <- struct{}{}
+		entries, err := c.readdir(dir)
+		<-slots
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		for rel, info := range entries {
+			if info.dir {
+				wg.Add(1)
+				go walk(rel)
+			} else {
+				files[rel] = info
+			}
+		}
+	}
+	wg.Add(1)
+	walk("")
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return files, nil
+}
+
+func (c *sftpClient) read(relPath string, offset int64) (io.ReadCloser, error) {
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	payload := append(sftpString(c.root+relPath), 0, 0, 0, sftpFlagRead, 0, 0, 0, 0)
+	handle, err := conn.handle(conn.request(sftpOpen, payload))
+	if err != nil {
+		return nil, err
+	}
+	return &sftpReader{conn: conn, handle: handle, next: offset}, nil
+}
+
+// sftpReader reads a file sequentially, keeping several chunk requests in flight
+type sftpReader struct {
+	conn   *sftpConn
+	handle string
+	next   int64 // Offset of the next chunk to ask for
+	queue  []chan sftpPacket
+	offset []int64
+	buf    []byte
+	eof    bool
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		for len(r.queue) < sftpReadAhead {
+			payload := sftpString(r.handle)
+			payload = binary.BigEndian.AppendUint64(payload, uint64(r.next))
+			payload = binary.BigEndian.AppendUint32(payload, sftpChunkSize)
+			ch, err := r.conn.send(sftpRead, payload)
+			if err != nil {
+				return 0, err
+			}
+			r.queue = append(r.queue, ch)
+			r.offset = append(r.offset, r.next)
+			r.next += sftpChunkSize
+		}
+		ch, at := r.queue[0], r.offset[0]
+		r.queue, r.offset = r.queue[1:], r.offset[1:]
+		resp, err := r.conn.wait(ch)
+		if err != nil {
+			return 0, err
+		}
+		if resp.typ != sftpData {
+			err := sftpError(resp)
+			if err == io.EOF {
+				r.eof = true
+				continue
+			}
+			if err == nil {
+				err = errors.New("unexpected SFTP read response")
+			}
+			return 0, err
+		}
+		r.buf = []byte((&sftpBuffer{b: resp.data}).string())
+		if len(r.buf) < sftpChunkSize {
+			// Servers may send less than asked, leaving the later requests misaligned, so
+			// drop them and carry on from where this chunk ended
+			r.queue, r.offset = nil, nil
+			r.next = at + int64(len(r.buf))
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *sftpReader) Close() error {
+	// Responses to abandoned read-ahead requests are simply dropped when they arrive
+	r.conn.close(r.handle)
+	return nil
+}
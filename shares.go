@@ -0,0 +1,492 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShareLifetime = 7 * 24 * time.Hour
+	maxShareLifetime     = 365 * 24 * time.Hour
+	// shareUseWindow is how long the tracks of a share that's run out of uses go on playing
+	// after its last use, long enough to listen through what that open of the page was for
+	shareUseWindow = 6 * time.Hour
+)
+
+// Share grants access to one track, folder or playlist through a /s/{token} link.
+// The token carries its own expiry and signature; the record is kept for use counts and revocation.
+type Share struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"` // track, folder or playlist
+	Target   string    `json:"target"`
+	Name     string    `json:"name"`
+	OwnerID  string    `json:"ownerId,omitempty"`
+	Download bool      `json:"download"`
+	MaxUses  int       `json:"maxUses,omitempty"`
+	Uses     int       `json:"uses"`
+	LastUse  time.Time `json:"lastUse,omitzero"`
+	Expires  time.Time `json:"expires"`
+	Created  time.Time `json:"created"`
+}
+
+type ShareStore struct {
+	mu     sync.Mutex
+	path   string
+	Key    string            `json:"key"` // HMAC secret for signing tokens
+	Shares map[string]*Share `json:"shares"`
+}
+
+var shares *ShareStore
+
+var errShareNotFound = errors.New("share link not found")
+var errShareExpired = errors.New("share link has expired")
+var errShareUsedUp = errors.New("share link has been used the maximum number of times")
+
+func loadShareStore(path string) (*ShareStore, error) {
+	s := &ShareStore{path: path, Shares: map[string]*Share{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Shares == nil {
+		s.Shares = map[string]*Share{}
+	}
+	if s.Key == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		s.Key = hex.EncodeToString(key)
+		if err := saveJSON(path, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *ShareStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(s.Key))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Token is "<id>.<expiry>.<signature>"
+func (s *ShareStore) Token(share Share) string {
+	payload := share.ID + "." + strconv.FormatInt(share.Expires.Unix(), 36)
+	return payload + "." + s.sign(payload)
+}
+
+func (s *ShareStore) Create(share Share) (Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	share.ID = newID()
+	share.Created = now
+	// Drop expired links while we're writing anyway
+	for id, old := range s.Shares {
+		if now.After(old.Expires) {
+			delete(s.Shares, id)
+		}
+	}
+	s.Shares[share.ID] = &share
+	if err := saveJSON(s.path, s); err != nil {
+		delete(s.Shares, share.ID)
+		return Share{}, err
+	}
+	return share, nil
+}
+
+// Resolve checks a token's signature and expiry. Opening the share page counts as a use,
+// while the stream requests that follow it don't, but once the uses have run out streams
+// stop too, shareUseWindow after the last one.
+func (s *ShareStore) Resolve(token string, use bool) (Share, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(s.sign(parts[0]+"."+parts[1]))) {
+		return Share{}, errShareNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.Shares[parts[0]]
+	if !ok {
+		return Share{}, errShareNotFound
+	}
+	if time.Now().After(share.Expires) {
+		return Share{}, errShareExpired
+	}
+	if share.MaxUses > 0 && share.Uses >= share.MaxUses && (use || time.Since(share.LastUse) > shareUseWindow) {
+		return Share{}, errShareUsedUp
+	}
+	if use {
+		share.Uses++
+		share.LastUse = time.Now().UTC()
+		if err := saveJSON(s.path, s); err != nil {
+			return Share{}, err
+		}
+	}
+	return *share, nil
+}
+
+func (s *ShareStore) List(ownerID string, all bool) []Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Share{}
+	for _, share := range s.Shares {
+		if all || share.OwnerID == ownerID {
+			list = append(list, *share)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list
+}
+
+func (s *ShareStore) Delete(id, ownerID string, all bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.Shares[id]
+	if !ok || !all && share.OwnerID != ownerID {
+		return errShareNotFound
+	}
+	delete(s.Shares, id)
+	if err := saveJSON(s.path, s); err != nil {
+		s.Shares[id] = share
+		return err
+	}
+	return nil
+}
+
+// MovePaths keeps track and folder links working when their target moves
+func (s *ShareStore) MovePaths(move func(path string) (string, bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, share := range s.Shares {
+		if share.Type != "track" && share.Type != "folder" {
+			continue
+		}
+		if moved, ok := move(filepath.ToSlash(share.Target)); ok {
+			share.Target, changed = filepath.FromSlash(moved), true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return saveJSON(s.path, s)
+}
+
+// shareTracks lists the library paths a share gives access to
+func shareTracks(share Share) ([]string, error) {
+	switch share.Type {
+	case "track":
+		return []string{share.Target}, nil
+	case "folder":
+		files, err := scanLibrary()
+		if err != nil {
+			return nil, err
+		}
+		var tracks []string
+		prefix := share.Target + string(filepath.Separator)
+		for _, f := range files {
+			if share.Target == "." || strings.HasPrefix(f.Path, prefix) {
+				tracks = append(tracks, f.Path)
+			}
+		}
+		sort.Strings(tracks)
+		return tracks, nil
+	case "playlist":
+		st := users.State(share.OwnerID)
+		p, err := st.playlists.Get(share.Target)
+		if err != nil {
+			return nil, err
+		}
+		if err := materializePlaylist(&p, st.stats); err != nil {
+			return nil, err
+		}
+		return p.Tracks, nil
+	}
+	return nil, fmt.Errorf("unknown share type %q", share.Type)
+}
+
+// shareCovers checks a track is in a share, returning its path, or "" if it isn't. A folder's
+// checked by where the track is, without scanning for everything in it.
+func shareCovers(share Share, relPath string) (string, error) {
+	track, err := cleanLibraryPath(relPath)
+	if err != nil {
+		return "", nil
+	}
+	switch share.Type {
+	case "track":
+		if track != share.Target {
+			return "", nil
+		}
+	case "folder":
+		if share.Target != "." && !strings.HasPrefix(track, share.Target+string(filepath.Separator)) {
+			return "", nil
+		}
+		if _, err := validateTrack(track); err != nil {
+			return "", nil
+		}
+	default:
+		tracks, err := shareTracks(share)
+		if err != nil {
+			return "", err
+		}
+		if !slices.Contains(tracks, track) {
+			return "", nil
+		}
+	}
+	return track, nil
+}
+
+func registerShareRoutes() {
+	handleFunc("GET /api/share", listShares)
+	handleFunc("POST /api/share", requireRole(roleListener, createShare))
+	handleFunc("DELETE /api/share/{id}", requireRole(roleListener, deleteShare))
+	handleFunc("GET /s/{token}", openShare)
+	handleFunc("GET /s/{token}/cover", shareCover)
+	handleFunc("GET /embed/{token}", embedShare)
+	handleFunc("GET /oembed", getOEmbed)
+	handleFunc("GET /s/{token}/{path...}", streamShare)
+}
+
+func createShare(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type      string `json:"type"`
+		Target    string `json:"target"`
+		ExpiresIn string `json:"expiresIn"` // Duration like "24h" or "7d"
+		Download  bool   `json:"download"`
+		MaxUses   int    `json:"maxUses"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	lifetime := defaultShareLifetime
+	if req.ExpiresIn != "" {
+		d, err := parseAge(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxShareLifetime {
+			http.Error(w, "expiresIn must be a duration of up to a year, like 24h or 7d", http.StatusBadRequest)
+			return
+		}
+		lifetime = d
+	}
+	if req.MaxUses < 0 {
+		http.Error(w, "maxUses cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	share := Share{Type: req.Type, Download: req.Download, MaxUses: req.MaxUses, Expires: time.Now().Add(lifetime).UTC()}
+	if u := currentUser(r); u != nil {
+		share.OwnerID = u.ID
+	}
+	switch req.Type {
+	case "track":
+		track, err := validateTrack(req.Target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		share.Target, share.Name = track, trackTitle(track)
+	case "folder":
+		info, err := library.Stat(req.Target)
+		if err != nil || !info.IsDir() {
+			http.Error(w, "folder not found: "+req.Target, http.StatusBadRequest)
+			return
+		}
+		share.Target = filepath.Clean(filepath.FromSlash(req.Target))
+		share.Name = filepath.Base(share.Target)
+		if share.Target == "." {
+			share.Name = info.Name()
+		}
+	case "playlist":
+		p, err := stateFor(r).playlists.Get(req.Target)
+		if err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		share.Target, share.Name = p.ID, p.Name
+	default:
+		http.Error(w, "type must be track, folder or playlist", http.StatusBadRequest)
+		return
+	}
+
+	share, err := shares.Create(share)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := shares.Token(share)
+	writeJSON(w, http.StatusCreated, struct {
+		Share
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}{share, token, externalURL(r, "/s/"+token)})
+}
+
+func listShares(w http.ResponseWriter, r *http.Request) {
+	ownerID := ""
+	if u := currentUser(r); u != nil {
+		ownerID = u.ID
+	}
+	writeJSON(w, http.StatusOK, shares.List(ownerID, hasRole(r, roleAdmin)))
+}
+
+func deleteShare(w http.ResponseWriter, r *http.Request) {
+	ownerID := ""
+	if u := currentUser(r); u != nil {
+		ownerID = u.ID
+	}
+	err := shares.Delete(r.PathValue("id"), ownerID, hasRole(r, roleAdmin))
+	if errors.Is(err, errShareNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeShareError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errShareNotFound), errors.Is(err, errPlaylistNotFound):
+		http.Error(w, errShareNotFound.Error(), http.StatusNotFound)
+	case errors.Is(err, errShareExpired), errors.Is(err, errShareUsedUp):
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} · beatgraze</title>
+{{with .Preview}}<meta property="og:site_name" content="beatgraze">
+<meta property="og:type" content="{{if eq $.Type "track"}}music.song{{else}}music.playlist{{end}}">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:url" content="{{.URL}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+<meta name="twitter:description" content="{{.Description}}">
+{{end}}{{if .Image}}<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:image" content="{{.Image}}">
+{{end}}{{if .Audio}}<meta property="og:audio" content="{{.Audio}}">
+<meta property="og:audio:type" content="{{.AudioType}}">
+{{end}}<meta name="twitter:card" content="player">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:player" content="{{.Player}}">
+<meta name="twitter:player:width" content="{{.PlayerWidth}}">
+<meta name="twitter:player:height" content="{{.PlayerHeight}}">
+<link rel="alternate" type="application/json+oembed" href="{{$.OEmbed}}" title="{{.Title}}">
+{{end}}<style>
+body { font-family: system-ui, sans-serif; background: #111; color: #eee; max-width: 720px; margin: 2rem auto; padding: 0 1rem; }
+li { list-style: none; margin: 1rem 0; }
+audio { width: 100%; }
+a { color: #8cf; }
+small { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<small>Shared from beatgraze · link expires {{.Expires.Format "2 Jan 2006 15:04 MST"}}</small>
+<ul>
+{{range .Tracks}}<li>
+<div>{{.Title}}{{if $.Download}} · <a href="{{.URL}}?download=1">download</a>{{end}}</div>
+<audio controls preload="none" src="{{.URL}}"{{if not $.Download}} controlslist="nodownload"{{end}}></audio>
+</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+type shareTrack struct {
+	Title string `json:"title"`
+	Path  string `json:"path"`
+	URL   string `json:"url"`
+}
+
+// openShare shows the shared tracks as a small player page, or JSON for clients that ask for it.
+// The page carries Open Graph and oEmbed details, so links unfurl in chat apps, whose fetches
+// don't count as uses, and other sites may embed it.
+func openShare(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	share, err := shares.Resolve(token, !linkPreviewBot(r))
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	tracks, err := shareTracks(share)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+
+	list := make([]shareTrack, len(tracks))
+	for i, track := range tracks {
+		u := url.URL{Path: prefixed("/s/") + token + "/" + filepath.ToSlash(track)}
+		list[i] = shareTrack{Title: trackTitle(track), Path: filepath.ToSlash(track), URL: u.String()}
+	}
+	view := struct {
+		Name     string       `json:"name"`
+		Type     string       `json:"type"`
+		Download bool         `json:"download"`
+		Expires  time.Time    `json:"expires"`
+		Tracks   []shareTrack `json:"tracks"`
+		Preview  sharePreview `json:"-"`
+		OEmbed   string       `json:"-"`
+	}{Name: share.Name, Type: share.Type, Download: share.Download, Expires: share.Expires, Tracks: list}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, view)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	allowFraming(w)
+	view.Preview = previewShare(r, token, share, tracks)
+	view.OEmbed = externalURL(r, "/oembed") + "?" + url.Values{"url": {view.Preview.URL}}.Encode()
+	sharePage.Execute(w, view)
+}
+
+// streamShare serves one track of a share, refusing anything the share doesn't cover
+func streamShare(w http.ResponseWriter, r *http.Request) {
+	share, err := shares.Resolve(r.PathValue("token"), false)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	track, err := shareCovers(share, r.PathValue("path"))
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	if track == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("download") != "" {
+		if !share.Download {
+			http.Error(w, "Downloads aren't allowed for this link", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Disposition", attachmentFilename(filepath.Base(track)))
+	}
+	serveLibraryFile(w, r, track)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ShuffleWeights tune weighted mode. Each term adds to a base weight of 1, so
+// setting one to 0 ignores that signal and setting all to 0 is uniform shuffle.
+type ShuffleWeights struct {
+	Rating   float64 `json:"rating"`   // Favors higher rated tracks; unrated counts as middling
+	Recency  float64 `json:"recency"`  // Favors tracks not played for a while
+	Plays    float64 `json:"plays"`    // Favors tracks with fewer plays overall
+	HalfLife float64 `json:"halfLife"` // Days after a play for the recency boost to recover halfway
+}
+
+var defaultShuffleWeights = ShuffleWeights{Rating: 2, Recency: 2, Plays: 1, HalfLife: 14}
+
+type RandomPick struct {
+	AudioFile
+	Weight float64 `json:"weight,omitempty"`
+}
+
+func shuffleWeight(s TrackStats, weights ShuffleWeights, now time.Time) float64 {
+	rating := 0.5
+	if s.Rating > 0 {
+		rating = float64(s.Rating) / 5
+	}
+	recency := 1.0
+	if !s.LastPlayed.IsZero() && weights.HalfLife > 0 {
+		days := now.Sub(s.LastPlayed).Hours() / 24
+		recency = 1 - math.Pow(2, -days/weights.HalfLife)
+	}
+	plays := 1 / float64(1+s.PlayCount)
+	return 1 + weights.Rating*rating + weights.Recency*recency + weights.Plays*plays
+}
+
+// pickWeighted samples count files without replacement, with probability proportional to weight
+// (Efraimidis-Spirakis: take the largest u^(1/w) keys).
+func pickWeighted(files []AudioFile, allStats map[string]TrackStats, weights ShuffleWeights, count int) []RandomPick {
+	now := time.Now()
+	type keyed struct {
+		pick RandomPick
+		key  float64
+	}
+	candidates := make([]keyed, len(files))
+	for i, f := range files {
+		w := shuffleWeight(allStats[f.Path], weights, now)
+		candidates[i] = keyed{RandomPick{f, w}, math.Pow(rand.Float64(), 1/w)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].key > candidates[j].key })
+
+	picks := make([]RandomPick, 0, min(count, len(candidates)))
+	for _, c := range candidates[:cap(picks)] {
+		picks = append(picks, c.pick)
+	}
+	return picks
+}
+
+func pickUniform(files []AudioFile, count int) []RandomPick {
+	picks := make([]RandomPick, 0, min(count, len(files)))
+	for _, i := range rand.Perm(len(files))[:cap(picks)] {
+		picks = append(picks, RandomPick{AudioFile: files[i]})
+	}
+	return picks
+}
+
+func floatParam(r *http.Request, name string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) {
+		return fallback
+	}
+	return v
+}
+
+func getRandomFiles(w http.ResponseWriter, r *http.Request) {
+	count := 1
+	if c, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && c > 0 && c <= 1000 {
+		count = c
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "uniform"
+	}
+	if mode != "uniform" && mode != "weighted" {
+		http.Error(w, "mode must be uniform or weighted", http.StatusBadRequest)
+		return
+	}
+
+	files, err := scanLibrary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var candidates []AudioFile
+	var scores map[string]float64
+	if searchQuery := strings.TrimSpace(r.URL.Query().Get("search")); searchQuery != "" {
+		if scores, err = searchFiles(files, stateFor(r).stats.Snapshot(), searchQuery, r.URL.Query().Get("fuzzy") == "true"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, f := range files {
+		if _, ok := scores[f.Path]; scores == nil || ok {
+			candidates = append(candidates, f.AudioFile)
+		}
+	}
+
+	response := map[string]any{"mode": mode}
+	if mode == "weighted" {
+		weights := ShuffleWeights{
+			Rating:   floatParam(r, "ratingWeight", defaultShuffleWeights.Rating),
+			Recency:  floatParam(r, "recencyWeight", defaultShuffleWeights.Recency),
+			Plays:    floatParam(r, "playsWeight", defaultShuffleWeights.Plays),
+			HalfLife: floatParam(r, "halfLife", defaultShuffleWeights.HalfLife),
+		}
+		response["weights"] = weights
+		response["files"] = pickWeighted(candidates, stateFor(r).stats.Snapshot(), weights, count)
+	} else {
+		response["files"] = pickUniform(candidates, count)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
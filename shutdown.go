@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var shutdownTimeout = 30 * time.Second
+
+// shutdowner is an HTTP server of any version
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// shuttingDown is cancelled as shutdown starts, so streams that never end on their own, like
+// /api/events, close instead of holding it up
+var shuttingDown, beginShutdown = context.WithCancel(context.Background())
+
+// waitForShutdown blocks until a server fails or SIGINT/SIGTERM arrives. On a signal it stops
+// accepting connections, lets in-flight requests and streams run for up to shutdownTimeout,
+// then writes out any state still held in memory.
+func waitForShutdown(errs <-chan error, servers ...shutdowner) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		slog.Info("Shutting down, letting in-flight requests finish", "signal", sig.String(), "timeout", shutdownTimeout)
+	}
+	// A second signal kills the process straight away
+	signal.Stop(signals)
+	sdNotify("STOPPING=1")
+	beginShutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Warn("Cutting off requests still running", "err", err)
+				server.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := saveMetaCache(); err != nil {
+		slog.Error("Error saving metadata cache", "err", err)
+	}
+	return users.Flush()
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A site export is a folder of plain files any web host can serve: index.html with a player
+// for every track, files.json listing them with their tags, the tracks themselves under
+// audio/ and, with -clips, short MP3 previews under clips/.
+
+// SiteTrack is a track in an exported site's files.json. Audio and Clip are relative to it.
+type SiteTrack struct {
+	ScannedFile
+	Audio string `json:"audio,omitempty"`
+	Clip  string `json:"clip,omitempty"`
+}
+
+var sitePage = template.Must(template.New("site").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #111; color: #eee; max-width: 720px; margin: 2rem auto; padding: 0 1rem; }
+input { width: 100%; box-sizing: border-box; padding: .5rem; background: #222; color: #eee; border: 1px solid #444; }
+li { list-style: none; margin: 1rem 0; }
+audio { width: 100%; }
+small { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<small>{{len .Tracks}} tracks{{if .Clips}} · {{.Clips}} second previews{{end}} · exported {{.Exported.Format "2 Jan 2006"}}</small>
+<p><input type="search" id="filter" placeholder="Filter"></p>
+<ul id="tracks">
+{{range .Tracks}}<li data-search="{{.Search}}">
+<div>{{.Title}}{{with .Detail}} <small>{{.}}</small>{{end}}</div>
+<audio controls preload="none" src="{{.Src}}"></audio>
+</li>
+{{end}}</ul>
+<script>
+// Play on down the list, and filter it as you type
+const players = [...document.querySelectorAll("audio")];
+players.forEach((a, i) => {
+  a.addEventListener("play", () => players.forEach(b => b !== a && b.pause()));
+  a.addEventListener("ended", () => {
+    const next = players.slice(i + 1).find(b => b.closest("li").style.display !== "none");
+    if (next) next.play();
+  });
+});
+document.getElementById("filter").addEventListener("input", e => {
+  const q = e.target.value.toLowerCase();
+  document.querySelectorAll("#tracks li").forEach(li => {
+    li.style.display = li.dataset.search.includes(q) ? "" : "none";
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// siteTrackView is a track as index.html shows it
+type siteTrackView struct {
+	Title  string
+	Detail string
+	Search string
+	Src    string
+}
+
+// runExportSite implements "beatgraze export-site"
+func runExportSite(args []string) error {
+	fs := flag.NewFlagSet("export-site", flag.ExitOnError)
+	libraryFlags(fs)
+	out := fs.String("o", "dist", "Folder to write the site to")
+	title := fs.String("title", "", "The page's title (default: the library folder's name)")
+	clips := fs.Int("clips", 0, "Make previews this many seconds long from a third of the way into each track, with ffmpeg")
+	full := fs.Bool("full", true, "Copy the tracks themselves; turn off with -full=false to publish only the previews")
+	commandUsage(fs, "[options] [directory]", "Writes the library out as a static site: a player page, files.json and the audio, for\n"+
+		"publishing a small collection from any web host. Point it at a folder of what to share.")
+	fs.Parse(args)
+	if !*full && *clips <= 0 {
+		return errors.New("-full=false needs -clips, or there's nothing to play")
+	}
+	if *clips > 0 {
+		if _, err := exec.LookPath(ffmpegPath); err != nil {
+			return fmt.Errorf("-clips needs ffmpeg: %w", err)
+		}
+	}
+	if err := openCommandLibrary(fs); err != nil {
+		return err
+	}
+	// Copies inside the library would show up in it
+	if root, ok := localLibraryPath(""); ok {
+		if abs, err := filepath.Abs(*out); err == nil {
+			if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("-o %s is inside the library", *out)
+			}
+		}
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	if *title == "" {
+		*title = siteTitle()
+	}
+
+	tracks := make([]SiteTrack, 0, len(files))
+	views := make([]siteTrackView, 0, len(files))
+	for i, f := range files {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(files), f.Path)
+		t := SiteTrack{ScannedFile: ScannedFile{Path: filepath.ToSlash(f.Path), Size: f.Size, Modified: f.ModTime.UTC(), TrackMeta: trackMeta(f)}}
+		if *full {
+			t.Audio = "audio/" + t.Path
+			if err := copySiteAudio(f.Path, filepath.Join(*out, filepath.FromSlash(t.Audio))); err != nil {
+				return fmt.Errorf("%s: %w", f.Path, err)
+			}
+		}
+		if *clips > 0 {
+			t.Clip = "clips/" + strings.TrimSuffix(t.Path, path.Ext(t.Path)) + ".mp3"
+			if err := makeSiteClip(f.Path, t.Duration, *clips, filepath.Join(*out, filepath.FromSlash(t.Clip))); err != nil {
+				return fmt.Errorf("%s: %w", f.Path, err)
+			}
+		}
+		tracks = append(tracks, t)
+		views = append(views, siteTrackViewOf(t))
+	}
+
+	data, err := json.MarshalIndent(tracks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(*out, "files.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	page, err := os.Create(filepath.Join(*out, "index.html"))
+	if err != nil {
+		return err
+	}
+	err = sitePage.Execute(page, struct {
+		Title    string
+		Clips    int
+		Exported time.Time
+		Tracks   []siteTrackView
+	}{*title, *clips, time.Now(), views})
+	if closeErr := page.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d tracks to %s\n", len(tracks), *out)
+	return nil
+}
+
+func siteTitle() string {
+	if name := filepath.Base(audioDir); name != "." && name != string(filepath.Separator) {
+		return name
+	}
+	return "beatgraze"
+}
+
+func siteTrackViewOf(t SiteTrack) siteTrackView {
+	v := siteTrackView{Title: t.Title, Src: t.Audio}
+	if v.Title == "" {
+		v.Title = trackTitle(t.Path)
+	}
+	if t.Artist != "" {
+		v.Title = t.Artist + " – " + v.Title
+	}
+	var detail []string
+	for _, s := range []string{t.Album, t.Genre} {
+		if s != "" {
+			detail = append(detail, s)
+		}
+	}
+	if t.Duration > 0 {
+		d := int(t.Duration + 0.5)
+		detail = append(detail, fmt.Sprintf("%d:%02d", d/60, d%60))
+	}
+	v.Detail = strings.Join(detail, " · ")
+	v.Search = strings.ToLower(v.Title + " " + v.Detail + " " + t.Path)
+	if v.Src == "" {
+		v.Src = t.Clip
+	}
+	// Names can have spaces and the like; the page links to them as URLs
+	v.Src = (&url.URL{Path: v.Src}).EscapedPath()
+	return v
+}
+
+// copySiteAudio copies a track out of the library, skipping it if it's there already from
+// an earlier export
+func copySiteAudio(relPath, dst string) error {
+	info, err := library.Stat(relPath)
+	if err != nil {
+		return err
+	}
+	if have, err := os.Stat(dst); err == nil && have.Size() == info.Size() && !have.ModTime().Before(info.ModTime()) {
+		return nil
+	}
+	src, err := library.Open(relPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// makeSiteClip encodes seconds of a track as an MP3, from a third of the way in when the
+// track is long enough, fading in and out
+func makeSiteClip(relPath string, duration float64, seconds int, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	start := 0.0
+	if duration > float64(seconds) {
+		start = min(duration/3, duration-float64(seconds))
+	}
+	fade := min(1, float64(seconds)/4)
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-ss", strconv.FormatFloat(start, 'f', 2, 64), "-t", strconv.Itoa(seconds)}
+	var stdin io.Reader
+	if src, ok := localLibraryPath(relPath); ok && fileExists(src) {
+		args = append(args, "-nostdin", "-i", src)
+	} else {
+		// Zipped or remote, so ffmpeg reads it from a pipe
+		f, err := library.Open(relPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stdin = f
+		args = append(args, "-i", "pipe:0")
+	}
+	args = append(args, "-map", "0:a", "-map_metadata", "-1",
+		"-af", fmt.Sprintf("afade=t=in:d=%g,afade=t=out:st=%g:d=%g", fade, float64(seconds)-fade, fade),
+		"-c:a", "libmp3lame", "-b:a", "128k", dst)
+	cmd := exec.CommandContext(context.Background(), ffmpegPath, args...)
+	cmd.Stdin = stdin
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("ffmpeg: %s", msg)
+		}
+		return fmt.Errorf("ffmpeg: %v", err)
+	}
+	return nil
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// snapcastTarget sends the jukebox to a Snapcast server instead of the server's speakers, so
+// every room's snapclient plays it in sync. It's either the named pipe snapserver reads from
+// (a pipe:// source) or tcp://host:port for a tcp:// source in server mode.
+var snapcastTarget string
+
+// snapcastFormat is snapserver's default sample format, 48000:16:2; a source configured
+// with another sampleformat won't sound right
+var snapcastFormat = []string{"-f", "s16le", "-ar", "48000", "-ac", "2"}
+
+// snapcastCommand decodes a track with ffmpeg into raw PCM for snapserver. -re keeps it to
+// real time, so pausing, seeking and elapsed time work as they do with a local player.
+func snapcastCommand(input string, offset float64, volume int) (*exec.Cmd, error) {
+	args := []string{"-hide_banner", "-nostdin", "-nostats", "-loglevel", "error", "-re",
+		"-ss", strconv.FormatFloat(offset, 'f', 3, 64), "-i", input,
+		"-map", "0:a", "-af", "volume=" + strconv.FormatFloat(float64(volume)/100, 'f', 2, 64)}
+	args = append(args, snapcastFormat...)
+	target := snapcastTarget
+	if !strings.HasPrefix(target, "tcp://") {
+		// Writing to a plain file would fill the disk rather than play anything
+		if info, err := os.Stat(target); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+			return nil, fmt.Errorf("%s isn't snapserver's pipe; is snapserver running?", target)
+		}
+		// ffmpeg would otherwise refuse to write to a pipe that already exists
+		args = append(args, "-y")
+	}
+	return exec.Command(ffmpegPath, append(args, target)...), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// loadJSON reads a state file into v, leaving v untouched if the file doesn't exist yet.
+// Files in the data directory come from the -store.
+func loadJSON(path string, v any) error {
+	var data []byte
+	var err error
+	if key, ok := stateKey(path); ok {
+		data, err = stateStore.Load(key)
+	} else {
+		data, err = fileStore{}.Load(path)
+	}
+	if err != nil || data == nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON writes v so that a crash never leaves half of it
+func saveJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if key, ok := stateKey(path); ok {
+		return stateStore.Save(key, data)
+	}
+	return fileStore{}.Save(path, data)
+}
+
+// defaultDataDir is where state lives without -data, alongside other user config
+func defaultDataDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "beatgraze"), nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(r *http.Request, v any) error {
+	defer r.Body.Close()
+	return json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,971 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stations turn a folder or a crate into a radio channel: an endless shuffle of its tracks
+// streamed at /radio/{id}. Everyone tuned in hears the same thing, from a single run that
+// starts with the first listener and stops with the last.
+type Station struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Folder  string    `json:"folder,omitempty"`  // The whole library when neither this nor CrateID is set
+	CrateID string    `json:"crateId,omitempty"` // Instead of a folder
+	Created time.Time `json:"created"`
+}
+
+// StationTrack is what a station is playing
+type StationTrack struct {
+	AudioFile
+	Title   string    `json:"title"` // "Artist - Title" when it's tagged, as players show it
+	Started time.Time `json:"started"`
+}
+
+// StationView is a station with what's on it now
+type StationView struct {
+	Station
+	Playing   *StationTrack `json:"playing,omitempty"`
+	Listeners int           `json:"listeners"`
+}
+
+type StationStore struct {
+	mu       sync.Mutex
+	path     string
+	stations map[string]*Station
+}
+
+var stations *StationStore
+
+var (
+	errStationNotFound = errors.New("station not found")
+	errStationEmpty    = errors.New("the station has no tracks")
+	errStationFFmpeg   = errors.New("stations need ffmpeg, which isn't installed")
+)
+
+const (
+	// stationBurst is how much of the stream new listeners get straight away, a few seconds
+	// of it, so players can start without waiting to fill their buffers
+	stationBurst = 64 << 10
+	// stationBacklog is how many chunks a listener can fall behind by before it's dropped
+	stationBacklog = 64
+)
+
+func loadStationStore(path string) (*StationStore, error) {
+	s := &StationStore{path: path, stations: map[string]*Station{}}
+	var list []*Station
+	if err := loadJSON(path, &list); err != nil {
+		return nil, err
+	}
+	for _, st := range list {
+		s.stations[st.ID] = st
+	}
+	return s, nil
+}
+
+// save must be called with mu held
+func (s *StationStore) save() error {
+	list := make([]*Station, 0, len(s.stations))
+	for _, st := range s.stations {
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return saveJSON(s.path, list)
+}
+
+func (s *StationStore) List() []Station {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Station, 0, len(s.stations))
+	for _, st := range s.stations {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+func (s *StationStore) Get(id string) (Station, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.stations[id]
+	if !ok {
+		return Station{}, errStationNotFound
+	}
+	return *st, nil
+}
+
+func (s *StationStore) Create(st Station) (Station, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.ID = newID()
+	st.Created = time.Now().UTC()
+	s.stations[st.ID] = &st
+	if err := s.save(); err != nil {
+		delete(s.stations, st.ID)
+		return Station{}, err
+	}
+	return st, nil
+}
+
+func (s *StationStore) Update(id string, fn func(st *Station) error) (Station, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.stations[id]
+	if !ok {
+		return Station{}, errStationNotFound
+	}
+	st := *old
+	if err := fn(&st); err != nil {
+		return Station{}, err
+	}
+	s.stations[id] = &st
+	if err := s.save(); err != nil {
+		s.stations[id] = old
+		return Station{}, err
+	}
+	return st, nil
+}
+
+func (s *StationStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.stations[id]
+	if !ok {
+		return errStationNotFound
+	}
+	delete(s.stations, id)
+	if err := s.save(); err != nil {
+		s.stations[id] = old
+		return err
+	}
+	return nil
+}
+
+// MovePaths follows station folders that have moved
+func (s *StationStore) MovePaths(move func(path string) (string, bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, st := range s.stations {
+		if st.Folder == "" {
+			continue
+		}
+		if moved, ok := move(st.Folder); ok {
+			st.Folder, changed = moved, true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// tracks are the station's tracks as they are now, so new files join it as they arrive
+func (st Station) tracks() ([]string, error) {
+	if st.CrateID != "" {
+		c, err := crates.Get(st.CrateID)
+		return c.Tracks, err
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, err
+	}
+	var tracks []string
+	for _, f := range files {
+		if st.Folder == "" || strings.HasPrefix(f.Path, st.Folder+"/") {
+			tracks = append(tracks, f.Path)
+		}
+	}
+	return tracks, nil
+}
+
+// stationFormat is a way of streaming a station
+type stationFormat struct {
+	contentType string
+	bitrate     string
+	ogg         bool     // Sent page by page, so listeners can start at a link's headers
+	rate        int      // What it's paced by: bytes a second of MP3, or Ogg granule positions
+	args        []string // ffmpeg's options for it
+}
+
+// stationFormats are what /radio/{id} streams, by the URL's extension: MP3 with none. Opus
+// goes out as chained Ogg, a link to each track with its tags in the link's headers, and
+// sounds as good as the MP3 in less bandwidth.
+var stationFormats = map[string]stationFormat{
+	"mp3":  {contentType: "audio/mpeg", bitrate: "128k", rate: 128000 / 8, args: []string{"-c:a", "libmp3lame", "-ar", "44100", "-f", "mp3", "-id3v2_version", "0", "-write_xing", "0"}},
+	"opus": {contentType: "audio/ogg", bitrate: "96k", ogg: true, rate: 48000, args: []string{"-c:a", "libopus", "-ar", "48000", "-f", "ogg"}},
+}
+
+// stationExts map the extensions a station's URL can have to its formats
+var stationExts = map[string]string{"": "mp3", ".mp3": "mp3", ".opus": "opus", ".ogg": "opus"}
+
+// stationPlayer broadcasts a station to its listeners
+type stationPlayer struct {
+	id string
+
+	mu        sync.Mutex
+	listeners map[chan stationChunk]string // The format each one's listening in
+	burst     map[string][]stationChunk    // The latest stationBurst bytes of each format
+	headers   map[string][]stationChunk    // The header pages of the Ogg link each format's on
+	playing   *StationTrack
+	track     *stationTrackRun
+	running   chan struct{} // Closed to stop the current run; nil when stopped
+}
+
+// stationChunk is a piece of a station's stream, with the track it's from
+type stationChunk struct {
+	data  []byte
+	track *StationTrack
+}
+
+// stationTrackRun is a track on air, played by an encoder for each format someone's
+// listening in. Those start with the track, or part way into it when the first listener in
+// a format tunes in, and keep to the same clock, so the next track starts for everyone
+// when they've all finished.
+type stationTrackRun struct {
+	path    string
+	meta    TrackMeta
+	started time.Time
+	ctx     context.Context
+	stop    chan struct{}
+	formats map[string]bool
+	pending int
+	done    chan struct{} // Closed when the last encoder finishes
+	over    bool          // Set as done is closed
+	played  bool          // Whether any encoder got to the end
+	err     error
+}
+
+var (
+	stationPlayersMu sync.Mutex
+	stationPlayers   = map[string]*stationPlayer{}
+)
+
+func stationPlayerFor(id string) *stationPlayer {
+	stationPlayersMu.Lock()
+	defer stationPlayersMu.Unlock()
+	p, ok := stationPlayers[id]
+	if !ok {
+		p = &stationPlayer{id: id, listeners: map[chan stationChunk]string{}}
+		stationPlayers[id] = p
+	}
+	return p
+}
+
+// Listen tunes in, starting the station if it's off. The channel is closed if the listener
+// falls too far behind or the station stops; stop must be called when done either way.
+func (p *stationPlayer) Listen(format string) (<-chan stationChunk, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Ogg players can't start mid-link, so they get its headers first
+	catchUp := append(append([]stationChunk(nil), p.headers[format]...), p.burst[format]...)
+	ch := make(chan stationChunk, stationBacklog+len(catchUp))
+	for _, chunk := range catchUp {
+		ch <- chunk
+	}
+	p.listeners[ch] = format
+	if p.running == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.running = make(chan struct{})
+		go func(stop chan struct{}) {
+			select {
+			case <-stop:
+			case <-shuttingDown.Done():
+			}
+			cancel()
+		}(p.running)
+		go p.run(ctx, p.running)
+	} else if p.track != nil {
+		p.encode(p.track, format)
+	}
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.listeners[ch]; !ok {
+			return
+		}
+		delete(p.listeners, ch)
+		close(ch)
+		if len(p.listeners) == 0 && p.running != nil {
+			p.stop()
+		}
+	}
+}
+
+// stop ends the current run; p.mu must be held
+func (p *stationPlayer) stop() {
+	close(p.running)
+	p.running = nil
+	p.burst, p.headers, p.track = nil, nil, nil
+	if p.playing != nil {
+		p.playing = nil
+		p.announce()
+	}
+}
+
+// Close cuts everyone off, for when the station's deleted
+func (p *stationPlayer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.listeners {
+		close(ch)
+	}
+	clear(p.listeners)
+	if p.running != nil {
+		p.stop()
+	}
+}
+
+// hasListeners reports whether anyone's listening in format; p.mu must be held
+func (p *stationPlayer) hasListeners(format string) bool {
+	for _, f := range p.listeners {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// stationOutput is where an encoder writes. It holds each piece of audio back until it's
+// due, timed from the start of the track, so encoders run as fast as they can and every
+// format still goes out in step. Ogg is broadcast a page at a time, which is where a
+// listener can join it.
+type stationOutput struct {
+	p       *stationPlayer
+	t       *stationTrackRun
+	stop    chan struct{}
+	format  string
+	offset  time.Duration // How far into the track the encoder started
+	sent    int64         // Bytes of MP3 so far
+	granule uint64        // Where the last Ogg page ended
+	page    []byte        // The part of a page that's come so far
+}
+
+func (o *stationOutput) Write(b []byte) (int, error) {
+	f := stationFormats[o.format]
+	if !f.ogg {
+		// A quarter of a second at a time, so listeners get it steadily
+		for written := 0; written < len(b); {
+			n := min(len(b)-written, f.rate/4)
+			if err := o.wait(time.Duration(o.sent) * time.Second / time.Duration(f.rate)); err != nil {
+				return written, err
+			}
+			if _, err := o.p.broadcast(o.stop, o.format, b[written:written+n]); err != nil {
+				return written, err
+			}
+			written += n
+			o.sent += int64(n)
+		}
+		return len(b), nil
+	}
+	o.page = append(o.page, b...)
+	for {
+		n, err := oggPageSize(o.page)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		// A page's audio starts where the last one's ended; pages no packet ends on have -1
+		if err := o.wait(time.Duration(o.granule) * time.Second / time.Duration(f.rate)); err != nil {
+			return 0, err
+		}
+		if _, err := o.p.broadcast(o.stop, o.format, o.page[:n]); err != nil {
+			return 0, err
+		}
+		if granule := binary.LittleEndian.Uint64(o.page[6:14]); granule != 0 && granule != math.MaxUint64 {
+			o.granule = granule
+		}
+		o.page = o.page[n:]
+	}
+	o.page = append([]byte(nil), o.page...)
+	return len(b), nil
+}
+
+// wait holds the encoder up until the audio at pos in its output is due
+func (o *stationOutput) wait(pos time.Duration) error {
+	d := time.Until(o.t.started.Add(o.offset + pos))
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-o.t.ctx.Done():
+		return o.t.ctx.Err()
+	}
+}
+
+var errNotOgg = errors.New("not an Ogg stream")
+
+// oggPageSize is the length of the Ogg page at the start of b, or 0 if b doesn't have all of
+// it yet. A page is a 27-byte header, then a table of its segments' lengths, then those.
+func oggPageSize(b []byte) (int, error) {
+	if len(b) < 27 {
+		return 0, nil
+	}
+	if string(b[:4]) != "OggS" {
+		return 0, errNotOgg
+	}
+	n := 27 + int(b[26])
+	if len(b) < n {
+		return 0, nil
+	}
+	for _, segment := range b[27:n] {
+		n += int(segment)
+	}
+	if len(b) < n {
+		return 0, nil
+	}
+	return n, nil
+}
+
+// broadcast sends a piece of the stream to every listener in its format, dropping any that
+// can't keep up. A run that's been stopped gets an error, so its ffmpeg doesn't go on into
+// the next run, and so does an encoder nobody's listening to any more.
+func (p *stationPlayer) broadcast(stop chan struct{}, format string, b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running != stop {
+		return 0, io.ErrClosedPipe
+	}
+	chunk := stationChunk{data: append([]byte(nil), b...), track: p.playing}
+	for ch, f := range p.listeners {
+		if f != format {
+			continue
+		}
+		select {
+		case ch <- chunk:
+		default:
+			delete(p.listeners, ch)
+			close(ch)
+		}
+	}
+	if len(p.listeners) == 0 {
+		p.stop()
+		return 0, io.ErrClosedPipe
+	}
+	if !p.hasListeners(format) {
+		return 0, io.ErrClosedPipe
+	}
+	if p.burst == nil {
+		p.burst, p.headers = map[string][]stationChunk{}, map[string][]stationChunk{}
+	}
+	if stationFormats[format].ogg {
+		// A page that begins a stream starts a link; it and the others before any audio,
+		// whose granule positions are 0, are the link's headers
+		if b[5]&0x02 != 0 {
+			p.headers[format], p.burst[format] = nil, nil
+		}
+		if binary.LittleEndian.Uint64(b[6:14]) == 0 {
+			p.headers[format] = append(p.headers[format], chunk)
+			return len(b), nil
+		}
+	}
+	burst := append(p.burst[format], chunk)
+	size := 0
+	for _, c := range burst {
+		size += len(c.data)
+	}
+	for len(burst) > 1 && size-len(burst[0].data) >= stationBurst {
+		size -= len(burst[0].data)
+		burst = burst[1:]
+	}
+	p.burst[format] = burst
+	return len(b), nil
+}
+
+// Playing is the track on air, if the station's running
+func (p *stationPlayer) Playing() (*StationTrack, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.playing, len(p.listeners)
+}
+
+// announce tells event listeners what's on; p.mu must be held
+func (p *stationPlayer) announce() {
+	events.Publish(Event{Type: "station.playing", Data: map[string]any{
+		"station": p.id, "playing": p.playing, "listeners": len(p.listeners),
+	}})
+}
+
+// run plays shuffled passes through the station's tracks until stop is closed, or until a
+// whole pass fails to play, when it cuts the listeners off rather than spin
+func (p *stationPlayer) run(ctx context.Context, stop chan struct{}) {
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.running != stop {
+			return
+		}
+		for ch := range p.listeners {
+			close(ch)
+		}
+		clear(p.listeners)
+		p.stop()
+	}()
+	var queue []string
+	last := ""
+	failures := 0
+	for ctx.Err() == nil {
+		if len(queue) == 0 {
+			st, err := stations.Get(p.id)
+			if err != nil {
+				return
+			}
+			if queue, err = st.tracks(); err != nil || len(queue) == 0 {
+				slog.Warn("Station has nothing to play", "station", st.Name, "err", err)
+				return
+			}
+			shuffleStation(queue, last)
+			if failures >= len(queue) {
+				slog.Warn("Station stopped after every track failed", "station", st.Name)
+				return
+			}
+		}
+		track := queue[0]
+		queue = queue[1:]
+
+		p.mu.Lock()
+		if p.running != stop {
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		playing := &StationTrack{AudioFile: audioFileFromPath(track), Title: trackTitle(track), Started: time.Now().UTC()}
+		t := &stationTrackRun{path: track, ctx: ctx, stop: stop, formats: map[string]bool{}, done: make(chan struct{})}
+		if info, err := library.Stat(track); err == nil {
+			file := libraryFile{AudioFile: playing.AudioFile, Size: info.Size(), ModTime: info.ModTime()}
+			t.meta = trackMeta(file)
+			playing.Title = icyTitle(file)
+		}
+		p.mu.Lock()
+		if p.running != stop {
+			p.mu.Unlock()
+			return
+		}
+		p.playing, p.track = playing, t
+		t.started = time.Now()
+		for _, format := range p.listeners {
+			p.encode(t, format)
+		}
+		if t.pending == 0 {
+			t.over = true
+			close(t.done)
+		}
+		p.announce()
+		p.mu.Unlock()
+		<-t.done
+		if ctx.Err() != nil {
+			return
+		}
+		p.mu.Lock()
+		played, err := t.played, t.err
+		p.mu.Unlock()
+		if !played {
+			if err != nil {
+				slog.Warn("Station can't play a track", "station", p.id, "path", track, "err", err)
+				failures++
+			}
+			continue
+		}
+		failures = 0
+		last = track
+	}
+}
+
+// encode starts playing the track on air in a format, from however far into it the station
+// is, unless that's already under way or the track's over; p.mu must be held
+func (p *stationPlayer) encode(t *stationTrackRun, format string) {
+	if t.formats[format] || t.over {
+		return
+	}
+	t.formats[format] = true
+	t.pending++
+	offset := time.Since(t.started).Round(10 * time.Millisecond)
+	go func() {
+		err := p.play(t, format, offset, &stationOutput{p: p, t: t, stop: t.stop, format: format, offset: offset})
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err == nil {
+			t.played = true
+		} else if p.hasListeners(format) {
+			t.err = err
+		}
+		if t.pending--; t.pending == 0 {
+			t.over = true
+			close(t.done)
+		}
+	}()
+}
+
+// shuffleStation shuffles a pass, keeping the track that just played from coming straight
+// round again
+func shuffleStation(tracks []string, last string) {
+	rand.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+	if len(tracks) > 1 && tracks[0] == last {
+		k := 1 + rand.IntN(len(tracks)-1)
+		tracks[0], tracks[k] = tracks[k], tracks[0]
+	}
+}
+
+// play encodes a track into the stream, reading local files directly and anything else
+// through a pipe, as casting does. There's no -re: out keeps it to time, and seeking into a
+// piped track that way would take as long as playing up to the offset.
+func (p *stationPlayer) play(t *stationTrackRun, format string, offset time.Duration, out io.Writer) error {
+	f := stationFormats[format]
+	args := []string{"-hide_banner", "-nostats", "-loglevel", "error"}
+	if offset > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(offset.Seconds(), 'f', 2, 64))
+	}
+	var stdin io.Reader
+	if path, ok := localLibraryPath(t.path); ok {
+		args = append(args, "-nostdin", "-i", path)
+	} else {
+		src, err := library.Open(t.path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		stdin = src
+		args = append(args, "-i", "pipe:0")
+	}
+	// Each track's encoded separately. The MP3 leaves out the tags and header frames that
+	// would otherwise turn up between them; Ogg puts these tags in each link's headers.
+	args = append(args, "-map", "0:a:0", "-vn", "-map_metadata", "-1")
+	title := t.meta.Title
+	if title == "" {
+		title = trackTitle(t.path)
+	}
+	for _, tag := range [][2]string{{"title", title}, {"artist", t.meta.Artist}, {"album", t.meta.Album}} {
+		if tag[1] != "" {
+			args = append(args, "-metadata", tag[0]+"="+tag[1])
+		}
+	}
+	args = append(args, "-b:a", f.bitrate, "-ac", "2")
+	args = append(args, f.args...)
+	cmd := exec.CommandContext(t.ctx, ffmpegPath, append(args, "pipe:1")...)
+	var stderr strings.Builder
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, out, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func stationView(st Station) StationView {
+	v := StationView{Station: st}
+	stationPlayersMu.Lock()
+	p, ok := stationPlayers[st.ID]
+	stationPlayersMu.Unlock()
+	if ok {
+		v.Playing, v.Listeners = p.Playing()
+	}
+	return v
+}
+
+func registerStationRoutes() {
+	handleFunc("GET /api/stations", listStations)
+	handleFunc("POST /api/stations", requireRole(roleAdmin, createStation))
+	handleFunc("GET /api/stations/export", exportStations)
+	handleFunc("GET /api/stations/{id}", getStation)
+	handleFunc("PATCH /api/stations/{id}", requireRole(roleAdmin, updateStation))
+	handleFunc("DELETE /api/stations/{id}", requireRole(roleAdmin, deleteStation))
+	handleFunc("GET /radio/{id}", streamStation)
+}
+
+func writeStationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errStationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errStationEmpty):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errStationFFmpeg):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// checkStationSource makes sure a station's folder or crate exists and has tracks in it
+func checkStationSource(st *Station) error {
+	if st.Folder != "" && st.CrateID != "" {
+		return errors.New("give a folder or a crate, not both")
+	}
+	if st.Folder != "" {
+		clean, err := cleanLibraryPath(st.Folder)
+		if err != nil {
+			return err
+		}
+		if st.Folder = filepath.ToSlash(clean); st.Folder == "." {
+			st.Folder = ""
+		}
+	}
+	if st.CrateID != "" {
+		if _, err := crates.Get(st.CrateID); err != nil {
+			return err
+		}
+	}
+	tracks, err := st.tracks()
+	if err != nil {
+		return err
+	}
+	if len(tracks) == 0 {
+		return errStationEmpty
+	}
+	return nil
+}
+
+func listStations(w http.ResponseWriter, r *http.Request) {
+	list := stations.List()
+	views := make([]StationView, 0, len(list))
+	for _, st := range list {
+		views = append(views, stationView(st))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+type opmlDocument struct {
+	XMLName  xml.Name      `xml:"opml"`
+	Version  string        `xml:"version,attr"`
+	Title    string        `xml:"head>title"`
+	Created  string        `xml:"head>dateCreated"`
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:"text,attr"`
+	URL  string `xml:"URL,attr"`
+}
+
+// exportStations lists the stations' stream URLs as an M3U8 playlist or OPML, for internet
+// radio apps and car head units to import. Those can't sign in, so a ?token= the list was
+// fetched with goes on each URL too. ?codec=opus lists the Opus streams instead of the MP3s.
+func exportStations(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "m3u8"
+	}
+	ext := ""
+	switch codec := r.URL.Query().Get("codec"); codec {
+	case "", "mp3":
+	case "opus":
+		ext = ".opus"
+	default:
+		http.Error(w, "codec must be mp3 or opus", http.StatusBadRequest)
+		return
+	}
+	streamURL := func(st Station) string {
+		u := externalURL(r, "/radio/"+st.ID+ext)
+		if token := r.URL.Query().Get("token"); token != "" {
+			u += "?token=" + url.QueryEscape(token)
+		}
+		return u
+	}
+	list := stations.List()
+	switch format {
+	case "m3u8":
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n")
+		for _, st := range list {
+			fmt.Fprintf(&b, "#EXTINF:-1,%s\n", st.Name)
+			b.WriteString(streamURL(st) + "\n")
+		}
+		w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+		w.Header().Set("Content-Disposition", attachmentFilename("stations.m3u8"))
+		w.Write([]byte(b.String()))
+
+	case "opml":
+		doc := opmlDocument{Version: "2.0", Title: libraryName() + " stations", Created: time.Now().UTC().Format(time.RFC1123)}
+		for _, st := range list {
+			doc.Outlines = append(doc.Outlines, opmlOutline{Type: "audio", Text: st.Name, URL: streamURL(st)})
+		}
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.Header().Set("Content-Disposition", attachmentFilename("stations.opml"))
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		enc.Encode(doc)
+
+	default:
+		http.Error(w, "format must be m3u8 or opml", http.StatusBadRequest)
+	}
+}
+
+func getStation(w http.ResponseWriter, r *http.Request) {
+	st, err := stations.Get(r.PathValue("id"))
+	if err != nil {
+		writeStationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stationView(st))
+}
+
+func createStation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    string `json:"name"`
+		Folder  string `json:"folder"`
+		CrateID string `json:"crateId"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	st := Station{Name: strings.TrimSpace(req.Name), Folder: req.Folder, CrateID: req.CrateID}
+	if st.Name == "" {
+		http.Error(w, "Station name is required", http.StatusBadRequest)
+		return
+	}
+	if err := checkStationSource(&st); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	st, err := stations.Create(st)
+	if err != nil {
+		writeStationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, stationView(st))
+}
+
+// updateStation renames a station or points it at another folder or crate, which takes effect
+// from its next pass
+func updateStation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    *string `json:"name"`
+		Folder  *string `json:"folder"`
+		CrateID *string `json:"crateId"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	st, err := stations.Update(r.PathValue("id"), func(st *Station) error {
+		if req.Name != nil {
+			if st.Name = strings.TrimSpace(*req.Name); st.Name == "" {
+				return errors.New("station name cannot be empty")
+			}
+		}
+		if req.Folder != nil {
+			st.Folder, st.CrateID = *req.Folder, ""
+		}
+		if req.CrateID != nil {
+			st.CrateID = *req.CrateID
+			if req.Folder == nil {
+				st.Folder = ""
+			}
+		}
+		return checkStationSource(st)
+	})
+	if errors.Is(err, errStationNotFound) {
+		writeStationError(w, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, stationView(st))
+}
+
+func deleteStation(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := stations.Delete(id); err != nil {
+		writeStationError(w, err)
+		return
+	}
+	stationPlayersMu.Lock()
+	p, ok := stationPlayers[id]
+	delete(stationPlayers, id)
+	stationPlayersMu.Unlock()
+	if ok {
+		p.Close()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// streamStation tunes in to a station, sending its stream until the client goes away
+func streamStation(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ext := path.Ext(id)
+	format, ok := stationExts[strings.ToLower(ext)]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	st, err := stations.Get(strings.TrimSuffix(id, ext))
+	if err != nil {
+		writeStationError(w, err)
+		return
+	}
+	if _, err := exec.LookPath(ffmpegPath); err != nil {
+		writeStationError(w, errStationFFmpeg)
+		return
+	}
+	f := stationFormats[format]
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	var out io.Writer = w
+	var icy *icyWriter
+	// Ogg has the tags in its own headers
+	if wantsICY(r) && !f.ogg {
+		setICYHeaders(w, st.Name)
+		w.Header().Set("icy-br", strings.TrimSuffix(f.bitrate, "k"))
+		icy = newICYWriter(w, "")
+		out = icy
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	chunks, stop := stationPlayerFor(st.ID).Listen(format)
+	defer stop()
+
+	rc := http.NewResponseController(w)
+	// The stream never ends, so don't hold up reloads
+	releaseSettings(r)
+	w.WriteHeader(http.StatusOK)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case chunk, ok := <-chunks:
+			if !ok {
+				return
+			}
+			if icy != nil && chunk.track != nil {
+				icy.SetTitle(chunk.track.Title)
+			}
+			if _, err := out.Write(chunk.data); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxHistory = 10000
+
+type TrackStats struct {
+	Rating     int       `json:"rating,omitempty"`
+	PlayCount  int       `json:"playCount,omitempty"`
+	LastPlayed time.Time `json:"lastPlayed,omitzero"`
+	Favorite   bool      `json:"favorite,omitempty"`
+	Position   float64   `json:"position,omitempty"` // Seconds into the track to resume from
+}
+
+type PlayEvent struct {
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+// NowPlaying is a play as it's recorded, for clients following along
+type NowPlaying struct {
+	User string    `json:"user"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+var nowPlaying = newFeed[NowPlaying]()
+
+type StatsStore struct {
+	mu      sync.Mutex
+	path    string
+	Tracks  map[string]*TrackStats `json:"tracks"`
+	History []PlayEvent            `json:"history"`
+}
+
+func loadStatsStore(path string) (*StatsStore, error) {
+	s := &StatsStore{path: path, Tracks: map[string]*TrackStats{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Tracks == nil {
+		s.Tracks = map[string]*TrackStats{}
+	}
+	return s, nil
+}
+
+func (s *StatsStore) Get(path string) TrackStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.Tracks[path]; ok {
+		return *t
+	}
+	return TrackStats{}
+}
+
+// Snapshot copies all stats so bulk readers like the rule engine don't hold the lock
+func (s *StatsStore) Snapshot() map[string]TrackStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[string]TrackStats, len(s.Tracks))
+	for path, t := range s.Tracks {
+		snapshot[path] = *t
+	}
+	return snapshot
+}
+
+func (s *StatsStore) RecentPlays(limit int) []PlayEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := min(limit, len(s.History))
+	plays := make([]PlayEvent, 0, n)
+	for i := len(s.History) - 1; i >= 0 && len(plays) < n; i-- {
+		plays = append(plays, s.History[i])
+	}
+	return plays
+}
+
+func (s *StatsStore) RecordPlay(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	t := s.track(path)
+	t.PlayCount++
+	t.LastPlayed = now
+	s.History = append(s.History, PlayEvent{Path: path, Time: now})
+	if len(s.History) > maxHistory {
+		s.History = append([]PlayEvent{}, s.History[len(s.History)-maxHistory:]...)
+	}
+	return saveJSON(s.path, s)
+}
+
+func (s *StatsStore) SetRating(path string, rating int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.track(path).Rating = rating
+	return saveJSON(s.path, s)
+}
+
+func (s *StatsStore) SetFavorite(path string, favorite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.track(path).Favorite = favorite
+	return saveJSON(s.path, s)
+}
+
+func (s *StatsStore) SetPosition(path string, position float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.track(path).Position = position
+	return saveJSON(s.path, s)
+}
+
+// Favorites lists favorite tracks in path order
+func (s *StatsStore) Favorites() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for path, t := range s.Tracks {
+		if t.Favorite {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// MovePaths carries ratings, play counts and history over to tracks' new paths
+func (s *StatsStore) MovePaths(move func(path string) (string, bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	renamed := map[string]*TrackStats{}
+	for path, t := range s.Tracks {
+		if moved, ok := move(path); ok {
+			delete(s.Tracks, path)
+			renamed[moved] = t
+		}
+	}
+	for path, t := range renamed {
+		s.Tracks[path], changed = t, true
+	}
+	for i, e := range s.History {
+		if moved, ok := move(e.Path); ok {
+			s.History[i].Path, changed = moved, true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return saveJSON(s.path, s)
+}
+
+// Merge folds in stats from another instance: the higher play count and the later play
+// win, along with that play's resume position, and their ratings and favorites are kept
+// over ours where they've set one
+func (s *StatsStore) Merge(tracks map[string]TrackStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, theirs := range tracks {
+		t, ok := s.Tracks[path]
+		if !ok {
+			s.Tracks[path] = &theirs
+			continue
+		}
+		if theirs.LastPlayed.After(t.LastPlayed) {
+			t.LastPlayed, t.Position = theirs.LastPlayed, theirs.Position
+		}
+		if theirs.Rating != 0 {
+			t.Rating = theirs.Rating
+		}
+		t.Favorite = t.Favorite || theirs.Favorite
+		t.PlayCount = max(t.PlayCount, theirs.PlayCount)
+	}
+	return saveJSON(s.path, s)
+}
+
+// track must be called with mu held
+func (s *StatsStore) track(path string) *TrackStats {
+	t, ok := s.Tracks[path]
+	if !ok {
+		t = &TrackStats{}
+		s.Tracks[path] = t
+	}
+	return t
+}
+
+func registerStatsRoutes() {
+	handleFunc("GET /api/stats", getTrackStats)
+	handleFunc("POST /api/plays", requireRole(roleListener, recordPlay))
+	handleFunc("PUT /api/ratings", requireRole(roleListener, setRating))
+	handleFunc("GET /api/favorites", listFavorites)
+	handleFunc("PUT /api/favorites", requireRole(roleListener, setFavorite))
+	handleFunc("PUT /api/positions", requireRole(roleListener, setPosition))
+}
+
+func getTrackStats(w http.ResponseWriter, r *http.Request) {
+	track, err := validateTrack(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateFor(r).stats.Get(track))
+}
+
+func recordPlay(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	track, err := validateTrack(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := stateFor(r).stats.RecordPlay(track); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats := stateFor(r).stats.Get(track)
+	nowPlaying.Publish(NowPlaying{User: requestUserName(r), Path: track, Time: stats.LastPlayed})
+	if userID, client, ok := scrobbleClient(r); ok {
+		scrobbleStarted(userID, client, track)
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func setRating(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path   string `json:"path"`
+		Rating int    `json:"rating"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Rating < 0 || req.Rating > 5 {
+		http.Error(w, "Rating must be between 0 and 5", http.StatusBadRequest)
+		return
+	}
+	track, err := validateTrack(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := stateFor(r).stats.SetRating(track, req.Rating); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateFor(r).stats.Get(track))
+}
+
+func listFavorites(w http.ResponseWriter, r *http.Request) {
+	files := []AudioFile{}
+	for _, path := range stateFor(r).stats.Favorites() {
+		files = append(files, audioFileFromPath(path))
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+func setFavorite(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path     string `json:"path"`
+		Favorite bool   `json:"favorite"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	track, err := validateTrack(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := stateFor(r).stats.SetFavorite(track, req.Favorite); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateFor(r).stats.Get(track))
+}
+
+// setPosition saves where playback stopped so it can resume there; 0 clears it
+func setPosition(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path     string  `json:"path"`
+		Position float64 `json:"position"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Position < 0 || math.IsNaN(req.Position) || math.IsInf(req.Position, 0) {
+		http.Error(w, "Position must be a number of seconds", http.StatusBadRequest)
+		return
+	}
+	track, err := validateTrack(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := stateFor(r).stats.SetPosition(track, req.Position); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateFor(r).stats.Get(track))
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Storage is where the library's files live. Paths are slash-separated and relative to the
+// library root; implementations refuse anything that would escape it with errInvalidPath.
+// Handlers only go through this, so other backends (S3, WebDAV, zip archives) can stand in
+// for a local directory.
+type Storage interface {
+	Open(path string) (File, error)
+	Stat(path string) (fs.FileInfo, error)
+	// Walk calls fn for every file, not directory, below the root
+	Walk(fn func(path string, info fs.FileInfo) error) error
+	// Watch calls onChange after files are added, removed or modified, until ctx is done
+	Watch(ctx context.Context, onChange func()) error
+}
+
+// File is an open library file; seeking lets tag readers and range requests jump around
+type File interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// writableStorage is implemented by backends that can change the library. None of its
+// methods replace anything already at the destination.
+type writableStorage interface {
+	// Create adds a file from r
+	Create(path string, r io.Reader) error
+	// Rename moves a file or folder within the library
+	Rename(from, to string) error
+	// MoveOut moves a file or folder out of the library to dest on the local disk
+	MoveOut(path, dest string) error
+	// MoveIn moves src on the local disk into the library
+	MoveIn(src, path string) error
+}
+
+var errInvalidPath = errors.New("invalid path")
+
+// library is the Storage for -dir. A reload can swap it while scans, jobs and watchers are
+// using it, so it passes each call on to whichever is current.
+var library = &currentStorage{}
+
+// currentStorage is a Storage that can be replaced while it's in use
+type currentStorage struct {
+	storage atomic.Pointer[Storage]
+}
+
+func (c *currentStorage) Load() Storage {
+	if s := c.storage.Load(); s != nil {
+		return *s
+	}
+	return nil
+}
+
+func (c *currentStorage) Store(s Storage) {
+	c.storage.Store(&s)
+}
+
+func (c *currentStorage) Open(path string) (File, error) { return c.Load().Open(path) }
+
+func (c *currentStorage) Stat(path string) (fs.FileInfo, error) { return c.Load().Stat(path) }
+
+func (c *currentStorage) Walk(fn func(path string, info fs.FileInfo) error) error {
+	return c.Load().Walk(fn)
+}
+
+func (c *currentStorage) Watch(ctx context.Context, onChange func()) error {
+	return c.Load().Watch(ctx, onChange)
+}
+
+// cleanLibraryPath checks a slash-separated library path and returns it in OS form. It's
+// checked with backslashes as separators too, as they are on Windows, and names that
+// unescape to ".." are refused in case a path gets decoded again further on.
+func cleanLibraryPath(relPath string) (string, error) {
+	if strings.ContainsRune(relPath, 0) {
+		return "", errInvalidPath
+	}
+	slashed := strings.ReplaceAll(relPath, "\\", "/")
+	if cleaned := path.Clean(slashed); path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errInvalidPath
+	}
+	for _, name := range strings.Split(slashed, "/") {
+		if unescaped, err := url.PathUnescape(name); err == nil && unescaped != name &&
+			(unescaped == "." || unescaped == ".." || strings.ContainsAny(unescaped, "/\\")) {
+			return "", errInvalidPath
+		}
+	}
+	clean := filepath.Clean(filepath.FromSlash(relPath))
+	if filepath.IsAbs(clean) || !filepath.IsLocal(clean) && clean != "." {
+		return "", errInvalidPath
+	}
+	return clean, nil
+}
+
+// dirStorage serves the library from a local directory
+type dirStorage struct {
+	root string
+}
+
+func newDirStorage(root string) *dirStorage {
+	return &dirStorage{root: root}
+}
+
+// resolve maps a library path onto the filesystem. The result must stay inside the root after
+// cleaning and following symlinks, so ".." tricks, sibling directories sharing a name prefix
+// and links pointing out are all refused.
+func (d *dirStorage) resolve(relPath string) (string, error) {
+	clean, err := cleanLibraryPath(relPath)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, clean))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || !filepath.IsLocal(rel) && rel != "." {
+		return "", errInvalidPath
+	}
+	return resolved, nil
+}
+
+// resolveNew is resolve for a path that may not exist yet: its nearest existing ancestor must
+// resolve inside the root, and the folders below that will be created fresh
+func (d *dirStorage) resolveNew(relPath string) (string, error) {
+	clean, err := cleanLibraryPath(relPath)
+	if err != nil {
+		return "", err
+	}
+	if clean == "." {
+		return "", errInvalidPath
+	}
+	missing := ""
+	for dir := clean; ; dir = filepath.Dir(dir) {
+		resolved, err := d.resolve(filepath.ToSlash(dir))
+		if err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) || dir == "." {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(dir), missing)
+	}
+}
+
+func (d *dirStorage) Create(relPath string, r io.Reader) error {
+	path, err := d.resolveNew(relPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fs.ErrExist
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write beside the target and rename, so scans never see half a file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// resolveEntry is resolve for a file or folder that's about to be moved: only its parent's
+// symlinks are followed, so moving a link moves the link
+func (d *dirStorage) resolveEntry(relPath string) (string, error) {
+	clean, err := cleanLibraryPath(relPath)
+	if err != nil {
+		return "", err
+	}
+	if clean == "." {
+		return "", errInvalidPath
+	}
+	parent, err := d.resolve(filepath.ToSlash(filepath.Dir(clean)))
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(parent, filepath.Base(clean))
+	if _, err := os.Lstat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (d *dirStorage) Rename(from, to string) error {
+	src, err := d.resolveEntry(from)
+	if err != nil {
+		return err
+	}
+	return d.MoveIn(src, to)
+}
+
+func (d *dirStorage) MoveOut(relPath, dest string) error {
+	src, err := d.resolveEntry(relPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	return moveAcrossDevices(src, dest)
+}
+
+func (d *dirStorage) MoveIn(src, relPath string) error {
+	dest, err := d.resolveNew(relPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return fs.ErrExist
+	}
+	if rel, err := filepath.Rel(src, dest); err == nil && filepath.IsLocal(rel) {
+		return fmt.Errorf("%w: can't move a folder inside itself", errInvalidPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return moveAcrossDevices(src, dest)
+}
+
+// moveAcrossDevices renames, falling back to copying and deleting when src and dest are on
+// different filesystems
+func moveAcrossDevices(src, dest string) error {
+	err := os.Rename(src, dest)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := os.Lstat(dest); statErr == nil {
+		return err
+	}
+	if err := copyTree(src, dest); err != nil {
+		os.RemoveAll(dest)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func copyTree(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+func (d *dirStorage) Open(relPath string) (File, error) {
+	path, err := d.resolve(relPath)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (d *dirStorage) Stat(relPath string) (fs.FileInfo, error) {
+	path, err := d.resolve(relPath)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+func (d *dirStorage) Walk(fn func(path string, info fs.FileInfo) error) error {
+	return filepath.Walk(d.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return nil
+		}
+		return fn(filepath.ToSlash(relPath), info)
+	})
+}
+
+// Watch polls, since the standard library has no filesystem notifications
+func (d *dirStorage) Watch(ctx context.Context, onChange func()) error {
+	return pollForChanges(ctx, d, 30*time.Second, onChange)
+}
+
+// pollForChanges walks the storage every interval, comparing each file's size and
+// modification time with the previous walk
+func pollForChanges(ctx context.Context, storage Storage, interval time.Duration, onChange func()) error {
+	snapshot := func() map[string]string {
+		files := map[string]string{}
+		storage.Walk(func(path string, info fs.FileInfo) error {
+			files[path] = info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10)
+			return nil
+		})
+		return files
+	}
+	last := snapshot()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		current := snapshot()
+		if !sameFiles(last, current) {
+			onChange()
+		}
+		last = current
+	}
+}
+
+func readLibraryFile(path string) ([]byte, error) {
+	f, err := library.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func sameFiles(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, sig := range a {
+		if b[path] != sig {
+			return false
+		}
+	}
+	return true
+}
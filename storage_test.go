@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanLibraryPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string // Slash-separated; empty when the path is refused
+	}{
+		{"", "."},
+		{".", "."},
+		{"a/b.mp3", "a/b.mp3"},
+		{"a/./b/../c.mp3", "a/c.mp3"},
+		{"100%25 pure.mp3", "100%25 pure.mp3"},
+		{"..", ""},
+		{"../secret", ""},
+		{"a/../../secret", ""},
+		{"a/b/../../..", ""},
+		{"%2e%2e/secret", ""},
+		{"%2E%2e/secret", ""},
+		{"a/%2e%2e%2f%2e%2e/secret", ""},
+		{"a/..%2fsecret", ""},
+		{"/etc/passwd", ""},
+		{"//server/share", ""},
+		{"..\\secret", ""},
+		{"a\\..\\..\\secret", ""},
+		{"\\etc\\passwd", ""},
+		{"a\\%2e%2e\\secret", ""},
+		{"a\x00.mp3", ""},
+	}
+	for _, tt := range tests {
+		got, err := cleanLibraryPath(tt.path)
+		if tt.want == "" {
+			if !errors.Is(err, errInvalidPath) {
+				t.Errorf("cleanLibraryPath(%q) = %q, %v; want errInvalidPath", tt.path, got, err)
+			}
+			continue
+		}
+		if err != nil || filepath.ToSlash(got) != tt.want {
+			t.Errorf("cleanLibraryPath(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestDirStorageResolve(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "music")
+	outside := filepath.Join(base, "private")
+	for _, dir := range []string{filepath.Join(root, "a"), outside, root + "-other"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{filepath.Join(root, "a", "b.mp3"), filepath.Join(outside, "secret.mp3"), filepath.Join(root+"-other", "c.mp3")} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"out":         outside,
+		"out.mp3":     filepath.Join(outside, "secret.mp3"),
+		"a/up":        "../..",
+		"a/relative":  "../../private/secret.mp3",
+		"a/sibling":   "../../music-other",
+		"inside":      "a",
+		"inside.mp3":  filepath.Join("a", "b.mp3"),
+		"a/alsohere":  "../a/b.mp3",
+		"a/elsewhere": "/",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Skipf("can't make symlinks here: %v", err)
+		}
+	}
+
+	d := newDirStorage(root)
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"a/b.mp3", true},
+		{"inside/b.mp3", true},
+		{"inside.mp3", true},
+		{"a/alsohere", true},
+		{"../private/secret.mp3", false},
+		{"a/../../private/secret.mp3", false},
+		{"%2e%2e/private/secret.mp3", false},
+		{"a/%2e%2e%2f%2e%2e/private/secret.mp3", false},
+		{filepath.Join(outside, "secret.mp3"), false},
+		{"..\\private\\secret.mp3", false},
+		{"a\\..\\..\\private\\secret.mp3", false},
+		{"out/secret.mp3", false},
+		{"out.mp3", false},
+		{"a/up/private/secret.mp3", false},
+		{"a/relative", false},
+		{"a/sibling/c.mp3", false},
+		{"a/elsewhere", false},
+	}
+	for _, tt := range tests {
+		got, err := d.resolve(tt.path)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("resolve(%q) = %v; want it inside the root", tt.path, err)
+			}
+			continue
+		}
+		if !errors.Is(err, errInvalidPath) {
+			t.Errorf("resolve(%q) = %q, %v; want errInvalidPath", tt.path, got, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errStreamStopped = errors.New("stream stopped by an admin")
+
+// Stream is an audio response in progress
+type Stream struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	IP      string    `json:"ip"`
+	URL     string    `json:"url"`
+	Started time.Time `json:"started"`
+	Sent    int64     `json:"sent"` // Bytes so far
+
+	// Set once the file is known
+	File     string  `json:"file,omitempty"`
+	Bitrate  int     `json:"bitrate,omitempty"`  // Average kbps
+	Duration float64 `json:"duration,omitempty"` // Seconds
+	Position float64 `json:"position,omitempty"` // Seconds into the track the bytes sent reach
+}
+
+type activeStream struct {
+	Stream
+	offset  int64 // Where in the file the response started
+	size    int64
+	sent    atomic.Int64
+	stopped atomic.Bool
+	cancel  context.CancelFunc
+	control *http.ResponseController
+}
+
+// EventSession is a connection held open for events
+type EventSession struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	IP      string    `json:"ip"`
+	Started time.Time `json:"started"`
+	Types   []string  `json:"types,omitempty"` // The events asked for, all when empty
+}
+
+type activeEventSession struct {
+	EventSession
+	cancel context.CancelFunc
+}
+
+// streamRegistry keeps track of the audio being sent, so admins can see and stop it
+type streamRegistry struct {
+	mu       sync.Mutex
+	streams  map[string]*activeStream
+	sessions map[string]*activeEventSession
+}
+
+var streams = &streamRegistry{streams: map[string]*activeStream{}, sessions: map[string]*activeEventSession{}}
+
+func (s *streamRegistry) add(st *activeStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[st.ID] = st
+}
+
+func (s *streamRegistry) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// List is the streams in progress, oldest first
+func (s *streamRegistry) List() []Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Stream{}
+	for _, st := range s.streams {
+		view := st.Stream
+		view.Sent = st.sent.Load()
+		if st.size > 0 && view.Duration > 0 {
+			// Players buffer ahead, so this is as far as they could have got
+			view.Position = min(view.Duration, float64(st.offset+view.Sent)/float64(st.size)*view.Duration)
+		}
+		list = append(list, view)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+// Sessions is the event connections open, oldest first
+func (s *streamRegistry) Sessions() []EventSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []EventSession{}
+	for _, sess := range s.sessions {
+		list = append(list, sess.EventSession)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+// Stop cuts a stream off, failing any write in progress as well as those after, which ends
+// the response, or closes an event session
+func (s *streamRegistry) Stop(id string) bool {
+	s.mu.Lock()
+	st, isStream := s.streams[id]
+	sess, isSession := s.sessions[id]
+	s.mu.Unlock()
+	if isStream {
+		st.stopped.Store(true)
+		st.cancel()
+		st.control.SetWriteDeadline(time.Now())
+	}
+	if isSession {
+		sess.cancel()
+	}
+	return isStream || isSession
+}
+
+// openSession registers an event connection, returning a context that ends when it's closed
+// from here or by the client, and a function to call once the connection's done
+func (s *streamRegistry) openSession(r *http.Request, types []string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	sess := &activeEventSession{
+		EventSession: EventSession{ID: newID(), User: requestUserName(r), IP: clientIP(r).String(), Started: time.Now(), Types: types},
+		cancel:       cancel,
+	}
+	s.mu.Lock()
+	s.sessions[sess.ID] = sess
+	s.mu.Unlock()
+	return ctx, func() {
+		cancel()
+		s.mu.Lock()
+		delete(s.sessions, sess.ID)
+		s.mu.Unlock()
+	}
+}
+
+// noteStreamFile fills in which library file a stream is sending, from the point in it the
+// response starts at
+func noteStreamFile(r *http.Request, f libraryFile) {
+	st, ok := r.Context().Value(streamContextKey).(*activeStream)
+	if !ok {
+		return
+	}
+	var offset int64
+	if start, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+		start, _, _ = strings.Cut(start, "-")
+		offset, _ = strconv.ParseInt(start, 10, 64)
+	}
+	duration := trackMeta(f).Duration
+	bitrate, _ := averageBitrate(f)
+
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+	st.File, st.Duration, st.Bitrate = f.Path, duration, bitrate
+	st.offset, st.size = offset, f.Size
+}
+
+type streamWriter struct {
+	http.ResponseWriter
+	stream *activeStream
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	if w.stream.stopped.Load() {
+		return 0, errStreamStopped
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.stream.sent.Add(int64(n))
+	return n, err
+}
+
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// trackStreams registers audio responses for as long as they're being sent
+func trackStreams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isStreamRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		st := &activeStream{
+			Stream: Stream{
+				ID:      newID(),
+				User:    requestUserName(r),
+				IP:      clientIP(r).String(),
+				URL:     r.URL.Path,
+				Started: time.Now(),
+			},
+			cancel:  cancel,
+			control: http.NewResponseController(w),
+		}
+		streams.add(st)
+		defer streams.remove(st.ID)
+		ctx = context.WithValue(ctx, streamContextKey, st)
+		next.ServeHTTP(&streamWriter{ResponseWriter: w, stream: st}, r.WithContext(ctx))
+	})
+}
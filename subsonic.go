@@ -0,0 +1,889 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// subsonicVersion is the Subsonic REST API version the /rest endpoints speak
+const subsonicVersion = "1.16.1"
+
+// subsonicError is a failure reported the Subsonic way, inside a normal 200 response
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+func (e *subsonicError) Error() string {
+	return e.Message
+}
+
+// Subsonic error codes clients act on, like asking for the password again after a 40
+const (
+	subsonicGeneric       = 0
+	subsonicMissingParam  = 10
+	subsonicWrongPassword = 40
+	subsonicNoTokenAuth   = 41
+	subsonicConflictAuth  = 43
+	subsonicInvalidAPIKey = 44
+	subsonicNotAuthorized = 50
+	subsonicNotFound      = 70
+)
+
+func subsonicErr(code int, format string, args ...any) error {
+	return &subsonicError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type subsonicResponse struct {
+	XMLName       xml.Name `xml:"http://subsonic.org/restapi subsonic-response" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error         *subsonicError          `xml:"error,omitempty" json:"error,omitempty"`
+	License       *subsonicLicense        `xml:"license,omitempty" json:"license,omitempty"`
+	Extensions    []subsonicExtension     `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	MusicFolders  *subsonicMusicFolders   `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *subsonicIndexes        `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Directory     *subsonicDirectory      `xml:"directory,omitempty" json:"directory,omitempty"`
+	Song          *subsonicChild          `xml:"song,omitempty" json:"song,omitempty"`
+	SearchResult3 *subsonicSearchResult   `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists     *subsonicPlaylists      `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist      *subsonicPlaylistDetail `xml:"playlist,omitempty" json:"playlist,omitempty"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicExtension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+type subsonicMusicFolders struct {
+	Folders []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicIndexes struct {
+	LastModified    int64           `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Indexes         []subsonicIndex `xml:"index" json:"index,omitempty"`
+	Children        []subsonicChild `xml:"child" json:"child,omitempty"`
+}
+
+type subsonicIndex struct {
+	Name    string           `xml:"name,attr" json:"name"`
+	Artists []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+// subsonicArtist is a top-level folder; Subsonic's folder browsing calls them artists
+type subsonicArtist struct {
+	ID   string `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicDirectory struct {
+	ID       string          `xml:"id,attr" json:"id"`
+	Parent   string          `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name     string          `xml:"name,attr" json:"name"`
+	Children []subsonicChild `xml:"child" json:"child,omitempty"`
+}
+
+// subsonicChild is a folder or a song, as getMusicDirectory, search3 and playlists list them
+type subsonicChild struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration    int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	BPM         int    `xml:"bpm,attr,omitempty" json:"bpm,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	MediaType   string `xml:"mediaType,attr,omitempty" json:"mediaType,omitempty"`
+	Created     string `xml:"created,attr,omitempty" json:"created,omitempty"`
+	Starred     string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	UserRating  int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+	PlayCount   int    `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Played      string `xml:"played,attr,omitempty" json:"played,omitempty"`
+}
+
+type subsonicSearchResult struct {
+	Songs []subsonicChild `xml:"song" json:"song,omitempty"`
+}
+
+type subsonicPlaylists struct {
+	Playlists []subsonicPlaylist `xml:"playlist" json:"playlist,omitempty"`
+}
+
+type subsonicPlaylist struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Owner     string `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Public    bool   `xml:"public,attr" json:"public"`
+	Readonly  bool   `xml:"readonly,attr,omitempty" json:"readonly,omitempty"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Created   string `xml:"created,attr" json:"created"`
+	Changed   string `xml:"changed,attr" json:"changed"`
+}
+
+type subsonicPlaylistDetail struct {
+	subsonicPlaylist
+	Entries []subsonicChild `xml:"entry" json:"entry,omitempty"`
+}
+
+// subsonicMethod answers one /rest endpoint. Methods that send a file themselves return a nil
+// response.
+type subsonicMethod func(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error)
+
+var subsonicMethods = map[string]subsonicMethod{
+	"ping":                      subsonicPing,
+	"getLicense":                subsonicGetLicense,
+	"getOpenSubsonicExtensions": subsonicGetExtensions,
+	"getMusicFolders":           subsonicGetMusicFolders,
+	"getIndexes":                subsonicGetIndexes,
+	"getMusicDirectory":         subsonicGetMusicDirectory,
+	"getSong":                   subsonicGetSong,
+	"stream":                    subsonicStream,
+	"download":                  subsonicStream,
+	"getCoverArt":               subsonicGetCoverArt,
+	"search3":                   subsonicSearch3,
+	"getPlaylists":              subsonicGetPlaylists,
+	"getPlaylist":               subsonicGetPlaylist,
+	"createPlaylist":            subsonicCreatePlaylist,
+	"updatePlaylist":            subsonicUpdatePlaylist,
+	"deletePlaylist":            subsonicDeletePlaylist,
+	"scrobble":                  subsonicScrobble,
+}
+
+// subsonicWrites change saved state, so they need a listener and are refused under -read-only.
+// Subsonic clients send them as GETs, so blockWrites can't catch them.
+var subsonicWrites = map[string]bool{
+	"createPlaylist": true,
+	"updatePlaylist": true,
+	"deletePlaylist": true,
+	"scrobble":       true,
+}
+
+func registerSubsonicRoutes() {
+	for name, method := range subsonicMethods {
+		h := serveSubsonic(name, method)
+		for _, p := range []string{"/rest/" + name, "/rest/" + name + ".view"} {
+			handleFunc(p, h)
+			if !subsonicWrites[name] {
+				queryRoutes["POST "+p] = true
+			}
+		}
+	}
+}
+
+// subsonicCredentials reports whether a /rest request signs in the Subsonic way, with ?u= or
+// ?apiKey=, rather than with anything requireAuth understands. Form posts can have them in the
+// body, which is left for the handler to read.
+func subsonicCredentials(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, "/rest/") {
+		return false
+	}
+	q := r.URL.Query()
+	return q.Get("u") != "" || q.Get("apiKey") != "" || r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+}
+
+func serveSubsonic(name string, method subsonicMethod) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := func() (*subsonicResponse, error) {
+			if err := r.ParseForm(); err != nil {
+				return nil, subsonicErr(subsonicGeneric, "%v", err)
+			}
+			r, err := subsonicAuthenticate(r, subsonicWrites[name])
+			if err != nil {
+				return nil, err
+			}
+			if subsonicWrites[name] {
+				if readOnly {
+					return nil, subsonicErr(subsonicNotAuthorized, "beatgraze is running in read-only mode")
+				}
+				if !hasRole(r, roleListener) {
+					return nil, subsonicErr(subsonicNotAuthorized, "This needs %s access", roleListener)
+				}
+			}
+			return method(w, r)
+		}()
+		if err != nil {
+			var se *subsonicError
+			if !errors.As(err, &se) {
+				se = &subsonicError{Code: subsonicGeneric, Message: err.Error()}
+			}
+			resp = subsonicOK()
+			resp.Status = "failed"
+			resp.Error = se
+		}
+		if resp != nil {
+			writeSubsonic(w, r, resp)
+		}
+	}
+}
+
+func subsonicOK() *subsonicResponse {
+	return &subsonicResponse{
+		Status:        "ok",
+		Version:       subsonicVersion,
+		Type:          "beatgraze",
+		ServerVersion: versionInfo().Version,
+		OpenSubsonic:  true,
+	}
+}
+
+// writeSubsonic sends XML unless the client asked for ?f=json
+func writeSubsonic(w http.ResponseWriter, r *http.Request, resp *subsonicResponse) {
+	if r.FormValue("f") == "json" {
+		writeJSON(w, http.StatusOK, map[string]*subsonicResponse{"subsonic-response": resp})
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(resp)
+}
+
+// subsonicLogins remembers passwords that checked out, since Subsonic clients send one with
+// every request and bcrypt is deliberately slow
+var subsonicLogins = struct {
+	sync.Mutex
+	checked map[[32]byte]subsonicLogin
+}{checked: map[[32]byte]subsonicLogin{}}
+
+type subsonicLogin struct {
+	userID       string
+	passwordHash string // What it was checked against, so changing the password ends it
+	expires      time.Time
+}
+
+const subsonicLoginTTL = 10 * time.Minute
+
+// subsonicAuthenticate signs in a request with ?u= and ?p= (plain or "enc:" hex), or
+// OpenSubsonic's ?apiKey=. Salted tokens would need the plain password stored, so they're refused.
+func subsonicAuthenticate(r *http.Request, write bool) (*http.Request, error) {
+	name, pass, key := r.Form.Get("u"), r.Form.Get("p"), r.Form.Get("apiKey")
+	noAuth := authCredentials == "" && authToken == "" && users.Count() == 0 && !oidcEnabled()
+	if name == "" && key == "" {
+		switch {
+		case signedOut(r):
+			// requireAuth left a form post to us, and it had no credentials in it either
+			return nil, subsonicErr(subsonicMissingParam, "Required parameter is missing: u")
+		case write && !noAuth:
+			// Writes come as GETs, which any page can send along with the session cookie
+			return nil, subsonicErr(subsonicNotAuthorized, "Send u and p, or apiKey, to change anything")
+		}
+		// requireAuth already let this one in
+		return r, nil
+	}
+	if noAuth {
+		return r, nil
+	}
+	if wait := loginGuard.Locked(clientIP(r), name); wait > 0 {
+		return nil, subsonicErr(subsonicGeneric, "Too many failed sign-ins, try again in %d seconds", int(wait.Seconds())+1)
+	}
+
+	if key != "" {
+		if name != "" {
+			return nil, subsonicErr(subsonicConflictAuth, "Send either apiKey or u, not both")
+		}
+		k, u, ok := users.LookupAPIKey(key)
+		if !ok {
+			authFailed(r, "subsonic-api-key", "")
+			return nil, subsonicErr(subsonicInvalidAPIKey, "Invalid API key")
+		}
+		if write && k.Scope != scopeReadWrite {
+			return nil, subsonicErr(subsonicNotAuthorized, "This API key is read-only")
+		}
+		if u != nil {
+			return withUser(r, *u), nil
+		}
+		return withOperator(r), nil
+	}
+
+	if pass == "" {
+		if r.Form.Has("t") {
+			return nil, subsonicErr(subsonicNoTokenAuth, "Token authentication isn't supported, send the password or an API key")
+		}
+		return nil, subsonicErr(subsonicMissingParam, "Required parameter is missing: p")
+	}
+	if encoded, ok := strings.CutPrefix(pass, "enc:"); ok {
+		b, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, subsonicErr(subsonicWrongPassword, "Wrong username or password")
+		}
+		pass = string(b)
+	}
+	if authCredentials != "" && secureCompare(name+":"+pass, authCredentials) {
+		return withOperator(r), nil
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(name) + "\x00" + pass))
+	subsonicLogins.Lock()
+	login, ok := subsonicLogins.checked[sum]
+	subsonicLogins.Unlock()
+	if ok && time.Now().Before(login.expires) {
+		if u, found := users.Get(login.userID); found && u.PasswordHash == login.passwordHash {
+			return withUser(r, u), nil
+		}
+	}
+	u, ok := users.Authenticate(name, pass)
+	if !ok {
+		authFailed(r, "subsonic", name)
+		return nil, subsonicErr(subsonicWrongPassword, "Wrong username or password")
+	}
+	loginGuard.Succeed(name)
+	now := time.Now()
+	subsonicLogins.Lock()
+	for k, l := range subsonicLogins.checked {
+		if now.After(l.expires) {
+			delete(subsonicLogins.checked, k)
+		}
+	}
+	subsonicLogins.checked[sum] = subsonicLogin{userID: u.ID, passwordHash: u.PasswordHash, expires: now.Add(subsonicLoginTTL)}
+	subsonicLogins.Unlock()
+	return withUser(r, u), nil
+}
+
+// Subsonic IDs are opaque strings; folders and songs carry their library path so they
+// survive restarts without a database
+func subsonicFolderID(dir string) string {
+	return "d" + base64.RawURLEncoding.EncodeToString([]byte(dir))
+}
+
+func subsonicSongID(relPath string) string {
+	return "t" + base64.RawURLEncoding.EncodeToString([]byte(relPath))
+}
+
+// subsonicPath decodes an ID made by subsonicFolderID or subsonicSongID
+func subsonicPath(id string) (kind byte, relPath string, err error) {
+	if id == "" {
+		return 0, "", subsonicErr(subsonicMissingParam, "Required parameter is missing: id")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(id[1:])
+	if err != nil || id[0] != 'd' && id[0] != 't' {
+		return 0, "", subsonicErr(subsonicNotFound, "Not found: %s", id)
+	}
+	return id[0], string(b), nil
+}
+
+// subsonicFile looks up a song by its ID
+func subsonicFile(id string) (libraryFile, error) {
+	kind, relPath, err := subsonicPath(id)
+	if err != nil {
+		return libraryFile{}, err
+	}
+	if kind != 't' {
+		return libraryFile{}, subsonicErr(subsonicNotFound, "Not a song: %s", id)
+	}
+	return subsonicTrack(relPath)
+}
+
+func subsonicTrack(relPath string) (libraryFile, error) {
+	f, err := libraryFileAt(relPath)
+	if err != nil {
+		return libraryFile{}, subsonicErr(subsonicNotFound, "Song not found")
+	}
+	return f, nil
+}
+
+var subsonicContentTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"wav":  "audio/wav",
+	"flac": "audio/flac",
+	"m4a":  "audio/mp4",
+	"aac":  "audio/aac",
+	"ogg":  "audio/ogg",
+}
+
+func subsonicSong(f libraryFile, stats TrackStats) subsonicChild {
+	meta := trackMeta(f)
+	suffix := strings.TrimPrefix(strings.ToLower(path.Ext(f.Path)), ".")
+	song := subsonicChild{
+		ID:          subsonicSongID(f.Path),
+		Parent:      subsonicFolderID(f.Dir),
+		Title:       firstNonEmpty(meta.Title, strings.TrimSuffix(f.Name, path.Ext(f.Name))),
+		Album:       meta.Album,
+		Artist:      meta.Artist,
+		Track:       meta.Track,
+		Year:        meta.Year,
+		Genre:       meta.Genre,
+		CoverArt:    subsonicFolderID(f.Dir),
+		Size:        f.Size,
+		ContentType: subsonicContentTypes[suffix],
+		Suffix:      suffix,
+		Duration:    int(meta.Duration + 0.5),
+		BPM:         int(meta.BPM + 0.5),
+		Path:        f.Path,
+		Type:        "music",
+		MediaType:   "song",
+		Created:     f.ModTime.UTC().Format(time.RFC3339),
+		UserRating:  stats.Rating,
+		PlayCount:   stats.PlayCount,
+	}
+	if !stats.LastPlayed.IsZero() {
+		song.Played = stats.LastPlayed.UTC().Format(time.RFC3339)
+	}
+	// Favorites don't record when they were made, only that they were
+	if stats.Favorite {
+		song.Starred = song.Created
+	}
+	return song
+}
+
+func subsonicFolder(n *FolderNode, parent string) subsonicChild {
+	return subsonicChild{
+		ID:       subsonicFolderID(n.Path),
+		Parent:   subsonicFolderID(parent),
+		IsDir:    true,
+		Title:    n.Name,
+		CoverArt: subsonicFolderID(n.Path),
+	}
+}
+
+// subsonicInt reads an optional number parameter
+func subsonicInt(r *http.Request, name string, fallback int) (int, error) {
+	v := r.Form.Get(name)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, subsonicErr(subsonicGeneric, "%s must be a number", name)
+	}
+	return n, nil
+}
+
+func subsonicPing(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	return subsonicOK(), nil
+}
+
+func subsonicGetLicense(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	resp := subsonicOK()
+	resp.License = &subsonicLicense{Valid: true}
+	return resp, nil
+}
+
+func subsonicGetExtensions(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	resp := subsonicOK()
+	resp.Extensions = []subsonicExtension{
+		{Name: "apiKeyAuthentication", Versions: []int{1}},
+		{Name: "formPost", Versions: []int{1}},
+	}
+	return resp, nil
+}
+
+// The whole library is one music folder
+func subsonicGetMusicFolders(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	resp := subsonicOK()
+	resp.MusicFolders = &subsonicMusicFolders{Folders: []subsonicMusicFolder{{ID: 1, Name: libraryName()}}}
+	return resp, nil
+}
+
+// subsonicGetIndexes lists the top-level folders by their first letter, and the songs
+// sitting in the library root
+func subsonicGetIndexes(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, err
+	}
+	var lastModified time.Time
+	for _, f := range files {
+		if f.ModTime.After(lastModified) {
+			lastModified = f.ModTime
+		}
+	}
+	indexes := &subsonicIndexes{LastModified: lastModified.UnixMilli()}
+	if since, err := subsonicInt(r, "ifModifiedSince", 0); err == nil && since > 0 && int64(since) >= indexes.LastModified {
+		resp := subsonicOK()
+		resp.Indexes = indexes
+		return resp, nil
+	}
+
+	for _, n := range buildFolderTree(files).Folders {
+		letter := "#"
+		if first := []rune(strings.ToUpper(n.Name)); len(first) > 0 && unicode.IsLetter(first[0]) {
+			letter = string(first[0])
+		}
+		if len(indexes.Indexes) == 0 || indexes.Indexes[len(indexes.Indexes)-1].Name != letter {
+			indexes.Indexes = append(indexes.Indexes, subsonicIndex{Name: letter})
+		}
+		last := &indexes.Indexes[len(indexes.Indexes)-1]
+		last.Artists = append(last.Artists, subsonicArtist{ID: subsonicFolderID(n.Path), Name: n.Name})
+	}
+	stats := stateFor(r).stats.Snapshot()
+	for _, f := range files {
+		if f.Dir == "" {
+			indexes.Children = append(indexes.Children, subsonicSong(f, stats[f.Path]))
+		}
+	}
+	resp := subsonicOK()
+	resp.Indexes = indexes
+	return resp, nil
+}
+
+func subsonicGetMusicDirectory(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	kind, dir, err := subsonicPath(r.Form.Get("id"))
+	if err != nil {
+		return nil, err
+	}
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, err
+	}
+	node := buildFolderTree(files).find(dir)
+	if kind != 'd' || node == nil {
+		return nil, subsonicErr(subsonicNotFound, "Directory not found")
+	}
+	d := &subsonicDirectory{ID: subsonicFolderID(node.Path), Name: node.Name}
+	if node.Path != "" {
+		d.Parent = subsonicFolderID(libraryDir(node.Path))
+	}
+	for _, sub := range node.Folders {
+		d.Children = append(d.Children, subsonicFolder(sub, node.Path))
+	}
+	stats := stateFor(r).stats.Snapshot()
+	for _, f := range files {
+		if f.Dir == node.Path {
+			d.Children = append(d.Children, subsonicSong(f, stats[f.Path]))
+		}
+	}
+	resp := subsonicOK()
+	resp.Directory = d
+	return resp, nil
+}
+
+func subsonicGetSong(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	f, err := subsonicFile(r.Form.Get("id"))
+	if err != nil {
+		return nil, err
+	}
+	song := subsonicSong(f, stateFor(r).stats.Get(f.Path))
+	resp := subsonicOK()
+	resp.Song = &song
+	return resp, nil
+}
+
+// subsonicStream sends the original file; beatgraze doesn't transcode on the fly, so maxBitRate
+// and format are ignored
+func subsonicStream(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	f, err := subsonicFile(r.Form.Get("id"))
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.Path == "/rest/download" || r.URL.Path == "/rest/download.view" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name}))
+	}
+	serveLibraryFile(w, r, f.Path)
+	return nil, nil
+}
+
+// subsonicGetCoverArt serves a folder's cover image, taking a song's from its folder
+func subsonicGetCoverArt(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	kind, dir, err := subsonicPath(r.Form.Get("id"))
+	if err != nil {
+		return nil, err
+	}
+	if kind == 't' {
+		dir = libraryDir(dir)
+	}
+	cover, ok := folderCover(dir)
+	if !ok {
+		return nil, subsonicErr(subsonicNotFound, "Cover art not found")
+	}
+	serveLibraryFile(w, r, cover)
+	return nil, nil
+}
+
+// subsonicSearch3 finds songs the way the search box does. Clients syncing the whole library
+// page through an empty query.
+func subsonicSearch3(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	count, err := subsonicInt(r, "songCount", 20)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := subsonicInt(r, "songOffset", 0)
+	if err != nil {
+		return nil, err
+	}
+	query := strings.Trim(strings.TrimSpace(r.Form.Get("query")), `"*`)
+	files, err := scanLibrary()
+	if err != nil {
+		return nil, err
+	}
+	stats := stateFor(r).stats.Snapshot()
+	found, err := trackQuery{Search: query}.run(files, stats)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(found) {
+		offset = len(found)
+	}
+	found = found[offset:min(offset+count, len(found))]
+	result := &subsonicSearchResult{}
+	for _, t := range found {
+		result.Songs = append(result.Songs, subsonicSong(t.file, t.stats))
+	}
+	resp := subsonicOK()
+	resp.SearchResult3 = result
+	return resp, nil
+}
+
+// subsonicPlaylistPrefix keeps playlist IDs apart from folder and song IDs
+const subsonicPlaylistPrefix = "p"
+
+func subsonicPlaylistID(id string) string {
+	return subsonicPlaylistPrefix + id
+}
+
+// subsonicEntries lists a playlist's songs that are still in the library, with where each sits
+// in p.Tracks so updatePlaylist's indexes can be mapped back
+func subsonicEntries(p Playlist, stats map[string]TrackStats) ([]subsonicChild, []int) {
+	var entries []subsonicChild
+	var positions []int
+	for i, t := range p.Tracks {
+		f, err := subsonicTrack(t)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, subsonicSong(f, stats[f.Path]))
+		positions = append(positions, i)
+	}
+	return entries, positions
+}
+
+func subsonicPlaylistInfo(r *http.Request, p Playlist, entries []subsonicChild) subsonicPlaylist {
+	info := subsonicPlaylist{
+		ID:        subsonicPlaylistID(p.ID),
+		Name:      p.Name,
+		Owner:     requestUserName(r),
+		Readonly:  p.trackEditError() != nil,
+		SongCount: len(entries),
+		Created:   p.Created.UTC().Format(time.RFC3339),
+		Changed:   p.Updated.UTC().Format(time.RFC3339),
+	}
+	for _, e := range entries {
+		info.Duration += e.Duration
+	}
+	return info
+}
+
+func subsonicGetPlaylists(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	list := stateFor(r).playlists.List()
+	if err := materializePlaylists(list, stateFor(r).stats); err != nil {
+		return nil, err
+	}
+	stats := stateFor(r).stats.Snapshot()
+	result := &subsonicPlaylists{}
+	for _, p := range list {
+		entries, _ := subsonicEntries(p, stats)
+		result.Playlists = append(result.Playlists, subsonicPlaylistInfo(r, p, entries))
+	}
+	resp := subsonicOK()
+	resp.Playlists = result
+	return resp, nil
+}
+
+// subsonicLoadPlaylist fetches the playlist an ID param names, with any smart tracks filled in
+func subsonicLoadPlaylist(r *http.Request, param string) (Playlist, error) {
+	id, ok := strings.CutPrefix(r.Form.Get(param), subsonicPlaylistPrefix)
+	if !ok {
+		return Playlist{}, subsonicErr(subsonicNotFound, "Playlist not found")
+	}
+	p, err := stateFor(r).playlists.Get(id)
+	if errors.Is(err, errPlaylistNotFound) {
+		return p, subsonicErr(subsonicNotFound, "Playlist not found")
+	}
+	if err != nil {
+		return p, err
+	}
+	return p, materializePlaylist(&p, stateFor(r).stats)
+}
+
+func subsonicPlaylistResponse(r *http.Request, p Playlist) *subsonicResponse {
+	entries, _ := subsonicEntries(p, stateFor(r).stats.Snapshot())
+	resp := subsonicOK()
+	resp.Playlist = &subsonicPlaylistDetail{subsonicPlaylistInfo(r, p, entries), entries}
+	return resp
+}
+
+func subsonicGetPlaylist(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	p, err := subsonicLoadPlaylist(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	return subsonicPlaylistResponse(r, p), nil
+}
+
+// subsonicSongPaths turns repeated song ID params into library paths
+func subsonicSongPaths(r *http.Request, param string) ([]string, error) {
+	var paths []string
+	for _, id := range r.Form[param] {
+		f, err := subsonicFile(id)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, f.Path)
+	}
+	return paths, nil
+}
+
+// subsonicCreatePlaylist makes a playlist, or with playlistId replaces an existing one's songs
+func subsonicCreatePlaylist(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	tracks, err := subsonicSongPaths(r, "songId")
+	if err != nil {
+		return nil, err
+	}
+	store := stateFor(r).playlists
+	var p Playlist
+	if r.Form.Get("playlistId") != "" {
+		if p, err = subsonicLoadPlaylist(r, "playlistId"); err != nil {
+			return nil, err
+		}
+		p, err = store.Update(p.ID, func(p *Playlist) error {
+			if err := p.trackEditError(); err != nil {
+				return err
+			}
+			if name := strings.TrimSpace(r.Form.Get("name")); name != "" {
+				p.Name = name
+			}
+			p.Tracks = append([]string{}, tracks...)
+			return nil
+		})
+	} else {
+		name := strings.TrimSpace(r.Form.Get("name"))
+		if name == "" {
+			return nil, subsonicErr(subsonicMissingParam, "Required parameter is missing: name")
+		}
+		p, err = store.Create(name, append([]string{}, tracks...))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return subsonicPlaylistResponse(r, p), nil
+}
+
+// subsonicUpdatePlaylist renames a playlist and adds or removes songs. Removal indexes count the
+// songs getPlaylist showed, which leaves out tracks no longer in the library.
+func subsonicUpdatePlaylist(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	p, err := subsonicLoadPlaylist(r, "playlistId")
+	if err != nil {
+		return nil, err
+	}
+	add, err := subsonicSongPaths(r, "songIdToAdd")
+	if err != nil {
+		return nil, err
+	}
+	_, positions := subsonicEntries(p, nil)
+	remove := map[int]bool{}
+	for _, v := range r.Form["songIndexToRemove"] {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(positions) {
+			return nil, subsonicErr(subsonicGeneric, "songIndexToRemove out of range: %s", v)
+		}
+		remove[positions[i]] = true
+	}
+	name := strings.TrimSpace(r.Form.Get("name"))
+	_, err = stateFor(r).playlists.Update(p.ID, func(p *Playlist) error {
+		if len(add) > 0 || len(remove) > 0 {
+			if err := p.trackEditError(); err != nil {
+				return err
+			}
+			kept := []string{}
+			for i, t := range p.Tracks {
+				if !remove[i] {
+					kept = append(kept, t)
+				}
+			}
+			p.Tracks = append(kept, add...)
+		}
+		if name != "" {
+			p.Name = name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subsonicOK(), nil
+}
+
+func subsonicDeletePlaylist(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	p, err := subsonicLoadPlaylist(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	if err := stateFor(r).playlists.Delete(p.ID); err != nil {
+		return nil, err
+	}
+	return subsonicOK(), nil
+}
+
+// subsonicScrobble records plays, or with submission=false only announces what's playing
+func subsonicScrobble(w http.ResponseWriter, r *http.Request) (*subsonicResponse, error) {
+	ids := r.Form["id"]
+	if len(ids) == 0 {
+		return nil, subsonicErr(subsonicMissingParam, "Required parameter is missing: id")
+	}
+	submission := r.Form.Get("submission") != "false"
+	times := r.Form["time"] // When each was played, in milliseconds
+	stats := stateFor(r).stats
+	userID, client, scrobble := scrobbleClient(r)
+	for i, id := range ids {
+		f, err := subsonicFile(id)
+		if err != nil {
+			return nil, err
+		}
+		if submission {
+			if err := stats.RecordPlay(f.Path); err != nil {
+				return nil, err
+			}
+		}
+		nowPlaying.Publish(NowPlaying{User: requestUserName(r), Path: f.Path, Time: time.Now()})
+		switch {
+		case !scrobble:
+		case submission:
+			at := time.Now()
+			if i < len(times) {
+				if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil {
+					at = time.UnixMilli(ms)
+				}
+			}
+			scrobbleFinished(userID, client, f.Path, at)
+		default:
+			scrobbleNowPlaying(userID, f.Path)
+		}
+	}
+	return subsonicOK(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd passes activated sockets starting at this descriptor
+const listenFDsStart = 3
+
+// systemdActivated reports whether systemd handed us listening sockets
+func systemdActivated() bool {
+	return os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) && os.Getenv("LISTEN_FDS") != ""
+}
+
+// systemdListener takes over the first socket from a .socket unit
+func systemdListener() (net.Listener, error) {
+	if !systemdActivated() {
+		return nil, errors.New("-listen systemd needs to be started by a systemd socket unit")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("systemd passed no sockets")
+	}
+	if n > 1 {
+		slog.Warn("systemd passed several sockets, using only the first", "count", n)
+	}
+	// Keep the variables from leaking into anything we start
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(listenFDsStart, "systemd")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// sdNotify sends a status update to systemd for Type=notify units, and does nothing otherwise
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// A leading @ is Linux's abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Couldn't notify systemd", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Couldn't notify systemd", "err", err)
+	}
+}
+
+// sdWatchdog pings systemd at half the WatchdogSec= interval so it can restart a hung process
+func sdWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+		sdNotify("WATCHDOG=1")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig holds the HTTPS flags. Either a certificate and key are given, or
+// -acme-domain fetches and renews certificates from Let's Encrypt.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ACMEDomains  string
+	ACMEEmail    string
+	ACMECache    string
+	RedirectHTTP string // Address for a plain HTTP listener that redirects to HTTPS
+	HSTS         bool
+}
+
+var tlsConfig = TLSConfig{HSTS: true}
+
+var disableHTTP2 bool
+
+// enableHTTP3 is -http3
+var enableHTTP3 bool
+
+// certificate is the loaded -tls-cert pair, swapped out when a reload picks up renewed files
+var certificate atomic.Pointer[tls.Certificate]
+
+func loadCertificate() error {
+	cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return err
+	}
+	certificate.Store(&cert)
+	return nil
+}
+
+func tlsEnabled() bool {
+	return tlsConfig.CertFile != "" || tlsConfig.ACMEDomains != ""
+}
+
+func validateTLSConfig() error {
+	if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		return errors.New("-tls-cert and -tls-key must be used together")
+	}
+	if tlsConfig.CertFile != "" && tlsConfig.ACMEDomains != "" {
+		return errors.New("use either -tls-cert/-tls-key or -acme-domain, not both")
+	}
+	return nil
+}
+
+// withHSTS tells browsers to stick to HTTPS once they've reached us over it
+func withHSTS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tlsConfig.HSTS && r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectToHTTPS sends plain HTTP visitors to the same URL on the HTTPS port
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// Long enough for slow phones, short enough that idle or trickling clients can't pile up.
+// There's no overall read or write timeout since streams and uploads can rightly take ages.
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+	maxHeaderBytes    = 64 << 10
+)
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// serve listens on addr, over HTTPS when configured, plus the optional HTTP redirect listener,
+// until a server fails or the process is asked to stop
+func serve(addr string, handler http.Handler) error {
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	// Requests queue on the listener from here, so systemd can start sending them
+	sdNotify("READY=1")
+	go sdWatchdog()
+	if mdnsEnabled {
+		go advertiseMDNS(ln.Addr())
+	}
+	if dlnaEnabled {
+		go advertiseDLNA(ln.Addr())
+	}
+	errs := make(chan error, 3)
+	if !tlsEnabled() {
+		server := newServer("", handler)
+		// gRPC clients speak HTTP/2 without TLS by prior knowledge
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(!disableHTTP2)
+		go func() { errs <- server.Serve(ln) }()
+		return waitForShutdown(errs, server)
+	}
+
+	server := newServer("", withHSTS(handler))
+	// Browsers negotiate HTTP/2 through ALPN, which multiplexes the many small API
+	// requests and stream ranges over one connection; HTTP/1.1 stays for older clients
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(!disableHTTP2)
+	// Behind a Unix socket the public port is the proxy's business, so assume the standard one
+	port := "443"
+	if _, p, err := net.SplitHostPort(addr); err == nil {
+		port = p
+	}
+	redirect := redirectToHTTPS(port)
+
+	if tlsConfig.ACMEDomains != "" {
+		cache := tlsConfig.ACMECache
+		if cache == "" {
+			cache = filepath.Join(dataDir, "acme")
+		}
+		var domains []string
+		for _, d := range strings.Split(tlsConfig.ACMEDomains, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				domains = append(domains, d)
+			}
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cache),
+			Email:      tlsConfig.ACMEEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		// HTTP-01 challenges arrive on port 80, everything else there gets redirected
+		redirect = manager.HTTPHandler(redirect)
+		if tlsConfig.RedirectHTTP == "" {
+			tlsConfig.RedirectHTTP = ":80"
+		}
+	} else {
+		if err := loadCertificate(); err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return certificate.Load(), nil
+			},
+		}
+	}
+
+	servers := []shutdowner{server}
+	if h3 := serveHTTP3(ln.Addr(), server, errs); h3 != nil {
+		servers = append(servers, h3)
+	}
+	go func() { errs <- server.ServeTLS(ln, "", "") }()
+	if tlsConfig.RedirectHTTP == "" {
+		return waitForShutdown(errs, servers...)
+	}
+	redirectServer := newServer(tlsConfig.RedirectHTTP, redirect)
+	go func() { errs <- redirectServer.ListenAndServe() }()
+	slog.Info("Redirecting HTTP to HTTPS", "addr", tlsConfig.RedirectHTTP)
+	return waitForShutdown(errs, append(servers, redirectServer)...)
+}
+
+// serveHTTP3 answers over QUIC on the UDP port with the HTTPS listener's number, and has the
+// HTTPS server advertise it with Alt-Svc, which is how browsers find it. It's only on with
+// -http3, and not behind a Unix socket, where the proxy in front decides, or if the UDP port
+// can't be had.
+func serveHTTP3(addr net.Addr, server *http.Server, errs chan<- error) *http3.Server {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !enableHTTP3 || !ok {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", tcp.String())
+	if err != nil {
+		slog.Warn("Not serving HTTP/3", "err", err)
+		return nil
+	}
+	h3 := &http3.Server{
+		Port:           tcp.Port,
+		Handler:        server.Handler,
+		TLSConfig:      http3.ConfigureTLSConfig(server.TLSConfig),
+		MaxHeaderBytes: maxHeaderBytes,
+		IdleTimeout:    idleTimeout,
+	}
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+	go func() { errs <- h3.Serve(conn) }()
+	return h3
+}
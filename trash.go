@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trashDays is how long deleted files are kept before they're gone for good; 0 keeps them
+var trashDays = 30
+
+// TrashEntry is a file or folder deleted from the library, kept so it can be restored
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // Where it was in the library
+	Folder    bool      `json:"folder"`
+	Size      int64     `json:"size"`
+	DeletedBy string    `json:"deletedBy"`
+	Deleted   time.Time `json:"deleted"`
+}
+
+// TrashStore keeps deleted files under its dir, each in a folder named by the entry's ID
+type TrashStore struct {
+	mu      sync.Mutex
+	path    string
+	dir     string
+	Entries map[string]*TrashEntry `json:"entries"`
+}
+
+var trash *TrashStore
+
+var errTrashNotFound = errors.New("not in the trash")
+
+func loadTrashStore(path, dir string) (*TrashStore, error) {
+	s := &TrashStore{path: path, dir: dir, Entries: map[string]*TrashEntry{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Entries == nil {
+		s.Entries = map[string]*TrashEntry{}
+	}
+	return s, os.MkdirAll(dir, 0700)
+}
+
+func (s *TrashStore) itemPath(e *TrashEntry) string {
+	return filepath.Join(s.dir, e.ID, path.Base(e.Path))
+}
+
+// Add moves a library file or folder into the trash
+func (s *TrashStore) Add(storage writableStorage, relPath, deletedBy string) (TrashEntry, error) {
+	info, err := library.Stat(relPath)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	clean, _ := cleanLibraryPath(relPath)
+	e := &TrashEntry{ID: newID(), Path: filepath.ToSlash(clean), Folder: info.IsDir(), DeletedBy: deletedBy, Deleted: time.Now().UTC()}
+	if err := storage.MoveOut(e.Path, s.itemPath(e)); err != nil {
+		os.RemoveAll(filepath.Join(s.dir, e.ID))
+		return TrashEntry{}, err
+	}
+	filepath.WalkDir(s.itemPath(e), func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				e.Size += info.Size()
+			}
+		}
+		return nil
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Entries[e.ID] = e
+	return *e, saveJSON(s.path, s)
+}
+
+func (s *TrashStore) List() []TrashEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]TrashEntry, 0, len(s.Entries))
+	for _, e := range s.Entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Deleted.After(list[j].Deleted) })
+	return list
+}
+
+// take removes an entry from the index while its files are dealt with, so two requests
+// can't both restore or purge it
+func (s *TrashStore) take(id string) (*TrashEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.Entries[id]
+	if !ok {
+		return nil, errTrashNotFound
+	}
+	delete(s.Entries, id)
+	return e, nil
+}
+
+func (s *TrashStore) putBack(e *TrashEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Entries[e.ID] = e
+}
+
+// Restore moves an entry back into the library, at its old path unless to is given
+func (s *TrashStore) Restore(storage writableStorage, id, to string) (TrashEntry, error) {
+	e, err := s.take(id)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	if to == "" {
+		to = e.Path
+	}
+	if err := storage.MoveIn(s.itemPath(e), to); err != nil {
+		s.putBack(e)
+		return TrashEntry{}, err
+	}
+	os.RemoveAll(filepath.Join(s.dir, e.ID))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *e, saveJSON(s.path, s)
+}
+
+// Purge deletes an entry for good
+func (s *TrashStore) Purge(id string) error {
+	e, err := s.take(id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, e.ID)); err != nil {
+		s.putBack(e)
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return saveJSON(s.path, s)
+}
+
+// purgeExpired empties the trash of anything older than trashDays
+func (s *TrashStore) purgeExpired() {
+	if trashDays <= 0 {
+		return
+	}
+	for _, e := range s.List() {
+		if time.Since(e.Deleted) > time.Duration(trashDays)*24*time.Hour {
+			if err := s.Purge(e.ID); err != nil && !errors.Is(err, errTrashNotFound) {
+				slog.Error("Error emptying trash", "path", e.Path, "err", err)
+			}
+		}
+	}
+}
+
+func (s *TrashStore) purgePeriodically() {
+	s.purgeExpired()
+	for range time.Tick(time.Hour) {
+		s.purgeExpired()
+	}
+}
+
+// movedPath maps p to its new path when from, or the folder it's in, has moved to to
+func movedPath(from, to, p string) (string, bool) {
+	if p == from {
+		return to, true
+	}
+	if rest, ok := strings.CutPrefix(p, from+"/"); ok {
+		return to + "/" + rest, true
+	}
+	return "", false
+}
+
+// updateMovedPaths carries playlists, crates, stats and share links over to a moved file or
+// folder
+func updateMovedPaths(from, to string) {
+	updateLibraryPaths(func(p string) (string, bool) { return movedPath(from, to, p) })
+}
+
+// updateLibraryPaths rewrites every stored reference to a library path that move maps to a
+// new one. A failure only leaves some references pointing at the old paths.
+func updateLibraryPaths(move func(path string) (string, bool)) {
+	type mover interface {
+		MovePaths(move func(path string) (string, bool)) error
+	}
+	stores := []mover{crates, stations, shares}
+	for _, st := range users.States() {
+		stores = append(stores, st.playlists, st.stats)
+	}
+	for _, store := range stores {
+		if err := store.MovePaths(move); err != nil {
+			slog.Error("Error updating references to moved files", "err", err)
+		}
+	}
+
+	// Tags don't change with the path, so don't read them all again
+	metaCache.Lock()
+	defer metaCache.Unlock()
+	renamed := map[string]metaEntry{}
+	for p, entry := range metaCache.entries {
+		if moved, ok := move(p); ok {
+			delete(metaCache.entries, p)
+			renamed[moved] = entry
+		}
+	}
+	for p, entry := range renamed {
+		metaCache.entries[p] = entry
+		metaCache.dirty = true
+	}
+}
+
+func registerFileRoutes() {
+	handleFunc("POST /api/files/move", requireRole(roleAdmin, moveFile))
+	handleFunc("POST /api/files/trash", requireRole(roleAdmin, trashFiles))
+	handleFunc("GET /api/trash", requireRole(roleListener, listTrash))
+	handleFunc("POST /api/trash/{id}/restore", requireRole(roleAdmin, restoreTrash))
+	handleFunc("DELETE /api/trash/{id}", requireRole(roleAdmin, purgeTrash))
+}
+
+func writeFileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidPath):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, errTrashNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrExist):
+		http.Error(w, "Something is already there", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writableLibraryOrError fails the request when the library can't be changed
+func writableLibraryOrError(w http.ResponseWriter) (writableStorage, bool) {
+	storage, ok := writableLibrary()
+	if !ok {
+		http.Error(w, "Only a library on a local disk can be changed", http.StatusNotImplemented)
+	}
+	return storage, ok
+}
+
+// moveFile renames or moves {"from": ..., "to": ...}, a file or a whole folder
+func moveFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := readJSON(r, &req); err != nil || req.From == "" || req.To == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	storage, ok := writableLibraryOrError(w)
+	if !ok {
+		return
+	}
+	from, errFrom := cleanLibraryPath(req.From)
+	to, errTo := cleanLibraryPath(req.To)
+	if errFrom != nil || errTo != nil {
+		writeFileError(w, errInvalidPath)
+		return
+	}
+	from, to = filepath.ToSlash(from), filepath.ToSlash(to)
+	if info, err := library.Stat(from); err == nil && !info.IsDir() && !audioExts[strings.ToLower(path.Ext(to))] && audioExts[strings.ToLower(path.Ext(from))] {
+		http.Error(w, "Keep an audio file's extension when renaming it", http.StatusBadRequest)
+		return
+	}
+	if err := storage.Rename(from, to); err != nil {
+		writeFileError(w, err)
+		return
+	}
+	updateMovedPaths(from, to)
+	writeJSON(w, http.StatusOK, map[string]string{"from": from, "to": to})
+}
+
+// trashFiles moves {"paths": [...]} to the trash, stopping at the first failure
+func trashFiles(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Paths []string `json:"paths"`
+	}
+	if err := readJSON(r, &req); err != nil || len(req.Paths) == 0 {
+		http.Error(w, "paths are required", http.StatusBadRequest)
+		return
+	}
+	storage, ok := writableLibraryOrError(w)
+	if !ok {
+		return
+	}
+	entries := []TrashEntry{}
+	for _, p := range req.Paths {
+		e, err := trash.Add(storage, p, requestUserName(r))
+		if err != nil {
+			writeFileError(w, err)
+			return
+		}
+		entries = append(entries, e)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func listTrash(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"entries":       trash.List(),
+		"retentionDays": trashDays,
+	})
+}
+
+// restoreTrash puts an entry back where it was, or at {"path": ...} if that's taken
+func restoreTrash(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	storage, ok := writableLibraryOrError(w)
+	if !ok {
+		return
+	}
+	e, err := trash.Restore(storage, r.PathValue("id"), req.Path)
+	if err != nil {
+		writeFileError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func purgeTrash(w http.ResponseWriter, r *http.Request) {
+	if err := trash.Purge(r.PathValue("id")); err != nil {
+		writeFileError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
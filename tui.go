@@ -0,0 +1,440 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/term"
+)
+
+// The TUI is a bubbletea interface to a server's library and jukebox, for headless
+// machines and SSH sessions. It talks to the server only through the API, the same as
+// "beatgraze ctl", so it works the same against a local or remote server. Requests run as
+// commands, off the UI loop, and come back as messages.
+
+// tuiRefresh is how often the jukebox's status is fetched again
+const tuiRefresh = 2 * time.Second
+
+const tuiHelp = "enter open/queue  a queue all  / search  tab queue  space pause  n/p next/prev  +/- volume  q quit"
+
+// tuiItem is a row of the library list: a folder to open or a track to queue
+type tuiItem struct {
+	label  string
+	path   string
+	folder bool
+}
+
+// Messages from requests
+type (
+	tuiListMsg struct {
+		items []tuiItem
+		total int
+		focus string // Folder to put the cursor on, when coming back out of it
+		err   error
+	}
+	tuiStatusMsg struct {
+		state JukeboxState
+		queue []JukeboxItem
+		err   error
+	}
+	tuiDoneMsg struct {
+		message string
+		err     error
+	}
+	tuiTickMsg struct{}
+)
+
+type tuiModel struct {
+	c *ctlClient
+
+	width, height int
+	showQueue     bool
+	dir           string // Folder being browsed
+	search        string // Query the list holds the results of, if any
+	typing        bool   // Editing the search query
+	query         string
+	items         []tuiItem
+	cursor        int
+	scroll        int
+
+	state   JukeboxState
+	queue   []JukeboxItem
+	message string
+}
+
+// runTUI implements "beatgraze tui"
+func runTUI(args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	connect := ctlFlags(fs)
+	commandUsage(fs, "[options]", "Browses and searches a server's library in the terminal, queueing tracks on its jukebox.\n"+tuiHelp)
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("tui needs a terminal")
+	}
+	// Find out before taking over the screen whether the server is there at all
+	status := fetchTUIStatus(c)().(tuiStatusMsg)
+	if status.err != nil {
+		return status.err
+	}
+	m := tuiModel{c: c, width: 80, height: 24, state: status.state, queue: status.queue}
+	_, err = tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return tea.Batch(m.load(""), tuiTick())
+}
+
+func tuiTick() tea.Cmd {
+	return tea.Tick(tuiRefresh, func(time.Time) tea.Msg { return tuiTickMsg{} })
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tuiTickMsg:
+		return m, tea.Batch(fetchTUIStatus(m.c), tuiTick())
+	case tuiStatusMsg:
+		if msg.err != nil {
+			m.message = msg.err.Error()
+			break
+		}
+		m.state, m.queue = msg.state, msg.queue
+		if m.showQueue {
+			m.move(0)
+		}
+	case tuiListMsg:
+		if msg.err != nil {
+			m.message = msg.err.Error()
+			break
+		}
+		m.items, m.cursor, m.scroll = msg.items, 0, 0
+		for i, item := range m.items {
+			if item.folder && item.path == msg.focus {
+				m.move(i)
+			}
+		}
+		if msg.total > len(msg.items) {
+			m.message = fmt.Sprintf("Showing %d of %d", len(msg.items), msg.total)
+		}
+	case tuiDoneMsg:
+		m.message = msg.message
+		if msg.err != nil {
+			m.message = msg.err.Error()
+		}
+		return m, fetchTUIStatus(m.c)
+	case tea.KeyMsg:
+		m.message = ""
+		if m.typing {
+			return m.updateSearch(msg)
+		}
+		return m.updateKey(msg)
+	}
+	return m, nil
+}
+
+// updateSearch edits the query being typed
+func (m tuiModel) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.typing, m.search = false, strings.TrimSpace(m.query)
+		return m, m.load("")
+	case tea.KeyEsc:
+		m.typing = false
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyBackspace:
+		if _, size := utf8.DecodeLastRuneInString(m.query); size > 0 {
+			m.query = m.query[:len(m.query)-size]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.query += string(msg.Runes)
+	}
+	return m, nil
+}
+
+func (m tuiModel) updateKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		m.move(-1)
+	case "down", "j":
+		m.move(1)
+	case "pgup":
+		m.move(-m.listHeight())
+	case "pgdown":
+		m.move(m.listHeight())
+	case "home", "g":
+		m.move(-m.cursor)
+	case "end", "G":
+		m.move(m.rows())
+	case "tab":
+		m.showQueue = !m.showQueue
+		m.cursor, m.scroll = 0, 0
+	case "/":
+		m.showQueue, m.typing, m.query = false, true, m.search
+	case "enter", "right", "l":
+		return m.open()
+	case "left", "h", "backspace", "esc":
+		return m.back()
+	case "a":
+		return m, m.queueSelected()
+	case " ":
+		return m, m.control("toggle")
+	case "n":
+		return m, m.control("next")
+	case "p":
+		return m, m.control("previous")
+	case "s":
+		return m, m.control("stop")
+	case "+", "=":
+		return m, m.control("volume?level=%2B5")
+	case "-":
+		return m, m.control("volume?level=-5")
+	case "r":
+		return m, tea.Batch(m.load(""), fetchTUIStatus(m.c))
+	}
+	return m, nil
+}
+
+func (m *tuiModel) rows() int {
+	if m.showQueue {
+		return len(m.queue)
+	}
+	return len(m.items)
+}
+
+// move moves the cursor, scrolling to keep it in view
+func (m *tuiModel) move(by int) {
+	m.cursor = max(0, min(m.cursor+by, m.rows()-1))
+	if m.cursor < m.scroll {
+		m.scroll = m.cursor
+	}
+	if height := m.listHeight(); m.cursor >= m.scroll+height {
+		m.scroll = m.cursor - height + 1
+	}
+}
+
+// open goes into a folder, or queues a track
+func (m tuiModel) open() (tea.Model, tea.Cmd) {
+	if m.showQueue || m.cursor >= len(m.items) {
+		return m, nil
+	}
+	item := m.items[m.cursor]
+	if !item.folder {
+		return m, m.queueSelected()
+	}
+	m.dir, m.search = item.path, ""
+	return m, m.load("")
+}
+
+// back leaves search results, or goes up a folder, landing on the folder just left so
+// going back and forth keeps your place
+func (m tuiModel) back() (tea.Model, tea.Cmd) {
+	if m.showQueue {
+		return m, nil
+	}
+	from := m.dir
+	switch {
+	case m.search != "":
+		m.search = ""
+	case m.dir != "":
+		if m.dir = path.Dir(m.dir); m.dir == "." {
+			m.dir = ""
+		}
+	default:
+		return m, nil
+	}
+	return m, m.load(from)
+}
+
+// load lists the folder being browsed, or the search results
+func (m tuiModel) load(focus string) tea.Cmd {
+	c, dir, search := m.c, m.dir, m.search
+	return func() tea.Msg {
+		q := url.Values{"perPage": {"1000"}}
+		if search != "" {
+			q.Set("search", search)
+		} else {
+			q.Set("dir", dir)
+			q.Set("recursive", "false")
+		}
+		var resp PaginatedResponse
+		if err := c.do("GET", "api/files?"+q.Encode(), nil, &resp); err != nil {
+			return tuiListMsg{err: err}
+		}
+		var items []tuiItem
+		for _, f := range resp.Folders {
+			items = append(items, tuiItem{label: f.Name + "/", path: f.Path, folder: true})
+		}
+		for _, f := range resp.Files {
+			label := f.Name
+			if search != "" {
+				label = f.Path
+			}
+			items = append(items, tuiItem{label: label, path: f.Path})
+		}
+		return tuiListMsg{items: items, total: len(resp.Folders) + resp.Total, focus: focus}
+	}
+}
+
+// queueSelected queues the track under the cursor, or everything in the folder
+func (m tuiModel) queueSelected() tea.Cmd {
+	if m.showQueue || m.cursor >= len(m.items) {
+		return nil
+	}
+	c, item := m.c, m.items[m.cursor]
+	return func() tea.Msg {
+		paths := []string{item.path}
+		if item.folder {
+			var resp PaginatedResponse
+			if err := c.do("GET", "api/files?"+url.Values{"dir": {item.path}, "perPage": {"1000"}}.Encode(), nil, &resp); err != nil {
+				return tuiDoneMsg{err: err}
+			}
+			paths = paths[:0]
+			for _, f := range resp.Files {
+				paths = append(paths, f.Path)
+			}
+			sort.Strings(paths)
+			if len(paths) == 0 {
+				return tuiDoneMsg{message: "Nothing to queue in " + item.label}
+			}
+		}
+		var added struct {
+			IDs []int `json:"ids"`
+		}
+		if err := c.do("POST", "api/jukebox/queue", map[string][]string{"paths": paths}, &added); err != nil {
+			return tuiDoneMsg{err: err}
+		}
+		return tuiDoneMsg{message: fmt.Sprintf("Queued %d tracks", len(added.IDs))}
+	}
+}
+
+func (m tuiModel) control(cmd string) tea.Cmd {
+	c := m.c
+	return func() tea.Msg {
+		var state JukeboxState
+		return tuiDoneMsg{err: c.do("POST", "api/jukebox/"+cmd, nil, &state)}
+	}
+}
+
+// fetchTUIStatus fetches the jukebox's status and queue
+func fetchTUIStatus(c *ctlClient) tea.Cmd {
+	return func() tea.Msg {
+		var status struct {
+			JukeboxState
+			Queue []JukeboxItem `json:"queue"`
+		}
+		err := c.do("GET", "api/jukebox", nil, &status)
+		return tuiStatusMsg{state: status.JukeboxState, queue: status.Queue, err: err}
+	}
+}
+
+// listHeight leaves a line for the title, and two for the status and help
+func (m *tuiModel) listHeight() int {
+	return max(m.height-3, 1)
+}
+
+func (m tuiModel) View() string {
+	var b strings.Builder
+	line := func(s string, highlight bool) {
+		s = tuiFit(s, m.width)
+		if highlight {
+			// Fill the line
+			s = "\x1b[7m" + s + strings.Repeat(" ", max(m.width-utf8.RuneCountInString(s), 0)) + "\x1b[0m"
+		}
+		b.WriteString(s + "\n")
+	}
+
+	var title string
+	var rows []string
+	current := -1
+	switch {
+	case m.typing:
+		title = "Search: " + m.query + "█"
+	case m.showQueue:
+		title = fmt.Sprintf("Queue (%d)", len(m.queue))
+	case m.search != "":
+		title = fmt.Sprintf("Search: %s (%d)", m.search, len(m.items))
+	default:
+		title = "Library: /" + m.dir
+	}
+	if m.showQueue {
+		for i, item := range m.queue {
+			mark := "  "
+			if i == m.state.Current {
+				mark, current = "▶ ", i
+			}
+			rows = append(rows, mark+item.Path)
+		}
+	} else {
+		for _, item := range m.items {
+			rows = append(rows, "  "+item.label)
+		}
+	}
+	line("\x1b[1mbeatgraze\x1b[0m  "+title, false)
+
+	height := m.listHeight()
+	scroll := min(m.scroll, m.cursor)
+	if m.cursor >= scroll+height {
+		scroll = m.cursor - height + 1
+	}
+	for i := scroll; i < scroll+height; i++ {
+		switch {
+		case i >= len(rows):
+			line("", false)
+		case i == current && i != m.cursor:
+			line("\x1b[1m"+rows[i]+"\x1b[0m", false)
+		default:
+			line(rows[i], i == m.cursor)
+		}
+	}
+
+	status := fmt.Sprintf("%s, %d queued, volume %d%%", m.state.State, m.state.Length, m.state.Volume)
+	if m.state.File != nil {
+		status = fmt.Sprintf("%s %s  %s  volume %d%%", m.state.State, m.state.File.Path, podcastDuration(m.state.Elapsed), m.state.Volume)
+	}
+	line(status, true)
+	b.WriteString(tuiFit(cmp.Or(m.message, tuiHelp), m.width))
+	return b.String()
+}
+
+// tuiFit cuts s to width characters, not counting escape sequences
+func tuiFit(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			end := strings.IndexByte(s[i:], 'm')
+			if end < 0 {
+				break
+			}
+			b.WriteString(s[i : i+end+1])
+			i += end + 1
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if n < width {
+			b.WriteRune(r)
+		}
+		n++
+		i += size
+	}
+	return b.String()
+}
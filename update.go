@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const githubAPI = "https://api.github.com"
+
+// updatePublicKey is a base64 ed25519 key, set at release build time with
+// -ldflags "-X main.updatePublicKey=...". When set, checksums.txt must carry a
+// valid checksums.txt.sig before anything gets installed.
+var updatePublicKey = ""
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r githubRelease) assetURL(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+// releaseAssetName is the binary for this platform, e.g. beatgraze_linux_amd64
+func releaseAssetName() string {
+	name := "beatgraze_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// runUpdate implements "beatgraze update"
+func runUpdate(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	checkOnly := fs.Bool("check-only", false, "Only report whether a newer release exists")
+	force := fs.Bool("force", false, "Reinstall the latest release even if this build is the same or newer")
+	repo := fs.String("repo", "jackharrhy/beatgraze", "GitHub repository to take releases from")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s update [options]\n\nReplaces this binary with the latest GitHub release.\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	release, err := latestRelease(client, *repo)
+	if err != nil {
+		return err
+	}
+	current := versionInfo().Version
+	newer := compareVersions(release.TagName, current) > 0
+	fmt.Printf("Current: %s\nLatest:  %s (%s)\n", current, release.TagName, release.HTMLURL)
+	if *checkOnly {
+		if newer {
+			fmt.Println("An update is available")
+			os.Exit(1)
+		}
+		fmt.Println("Up to date")
+		return nil
+	}
+	if !newer && !*force {
+		fmt.Println("Up to date")
+		return nil
+	}
+
+	asset := releaseAssetName()
+	binaryURL, sumsURL := release.assetURL(asset), release.assetURL("checksums.txt")
+	if binaryURL == "" {
+		return fmt.Errorf("release %s has no %s", release.TagName, asset)
+	}
+	if sumsURL == "" {
+		return fmt.Errorf("release %s has no checksums.txt, refusing to install unverified", release.TagName)
+	}
+	sums, err := download(client, sumsURL)
+	if err != nil {
+		return err
+	}
+	if updatePublicKey != "" {
+		if err := verifySignature(client, release, sums); err != nil {
+			return err
+		}
+	}
+	want, err := checksumFor(sums, asset)
+	if err != nil {
+		return err
+	}
+	binary, err := download(client, binaryURL)
+	if err != nil {
+		return err
+	}
+	got := sha256.Sum256(binary)
+	if hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("checksum mismatch for %s", asset)
+	}
+	if err := replaceExecutable(binary); err != nil {
+		return err
+	}
+	fmt.Printf("Updated to %s; restart beatgraze to use it\n", release.TagName)
+	return nil
+}
+
+func latestRelease(client *http.Client, repo string) (githubRelease, error) {
+	var release githubRelease
+	data, err := download(client, githubAPI+"/repos/"+repo+"/releases/latest")
+	if err != nil {
+		return release, err
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return release, fmt.Errorf("reading release info: %v", err)
+	}
+	return release, nil
+}
+
+func download(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func verifySignature(client *http.Client, release githubRelease, sums []byte) error {
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("this build has an invalid update key")
+	}
+	sigURL := release.assetURL("checksums.txt.sig")
+	if sigURL == "" {
+		return fmt.Errorf("release %s isn't signed, refusing to install", release.TagName)
+	}
+	encoded, err := download(client, sigURL)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), sums, sig) {
+		return errors.New("checksums.txt signature doesn't verify, refusing to install")
+	}
+	return nil
+}
+
+// checksumFor finds name in sha256sum output
+func checksumFor(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt doesn't list %s", name)
+}
+
+// replaceExecutable swaps the new binary in with a rename, so the old one keeps running
+// untouched until the process restarts
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".beatgraze-update-*")
+	if err != nil {
+		return fmt.Errorf("can't write next to %s: %v", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return err
+	}
+	// Windows won't replace a running executable, but will let it be renamed
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+// compareVersions compares dotted versions like v1.10.2, ignoring any -suffix.
+// Anything that isn't a release version, like "dev", sorts before all releases.
+func compareVersions(a, b string) int {
+	parse := func(v string) []int {
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil
+			}
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	pa, pb := parse(a), parse(b)
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
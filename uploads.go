@@ -0,0 +1,684 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tusVersion = "1.0.0"
+	// abandonedUploadAge is how long an unfinished upload is kept for the client to resume
+	abandonedUploadAge = 24 * time.Hour
+)
+
+// UploadConfig holds the upload limits. The per-user quota doesn't apply to admins unless
+// they're given one of their own; the total counts everyone.
+type UploadConfig struct {
+	MaxMB   int
+	QuotaMB int // Per user, counting everything they've uploaded; 0 for none
+	TotalMB int // Across all users; 0 for none
+}
+
+var uploadConfig = UploadConfig{MaxMB: 2048}
+
+// Upload is a file sent to the library, either through tus, which can be resumed after a
+// dropped connection, or as a plain multipart form
+type Upload struct {
+	ID       string    `json:"id"`
+	OwnerID  string    `json:"ownerId,omitempty"`
+	Path     string    `json:"path"` // Where the file lands in the library
+	Size     int64     `json:"size"`
+	Offset   int64     `json:"offset"` // Bytes received so far
+	Complete bool      `json:"complete"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+
+	// Set by instances syncing to this one
+	Replace  bool      `json:"replace,omitempty"` // Trash whatever's at Path first
+	Modified time.Time `json:"modified,omitzero"` // Given to the file once it's in place
+}
+
+type UploadStore struct {
+	mu      sync.Mutex
+	path    string
+	dir     string             // Unfinished uploads' data, named by ID
+	writing map[string]bool    // Uploads with a PATCH in progress
+	Uploads map[string]*Upload `json:"uploads"`
+}
+
+var uploads *UploadStore
+
+var (
+	errUploadNotFound = errors.New("upload not found")
+	errUploadBusy     = errors.New("upload is already being written to")
+	errQuotaExceeded  = errors.New("upload quota exceeded")
+	errUploadsFull    = errors.New("the server's upload space is full")
+	errOffsetMismatch = errors.New("Upload-Offset doesn't match the bytes received")
+
+	errLibraryNotWritable = errors.New("only a library on a local disk can be changed")
+)
+
+func loadUploadStore(path, dir string) (*UploadStore, error) {
+	s := &UploadStore{path: path, dir: dir, writing: map[string]bool{}, Uploads: map[string]*Upload{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Uploads == nil {
+		s.Uploads = map[string]*Upload{}
+	}
+	return s, os.MkdirAll(dir, 0700)
+}
+
+func (s *UploadStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// used is how many bytes ownerID has uploaded or reserved; s.mu must be held
+func (s *UploadStore) used(ownerID string) int64 {
+	var total int64
+	for _, u := range s.Uploads {
+		if u.OwnerID == ownerID {
+			total += u.Size
+		}
+	}
+	return total
+}
+
+// usedTotal is how many bytes everyone has uploaded or reserved; s.mu must be held
+func (s *UploadStore) usedTotal() int64 {
+	var total int64
+	for _, u := range s.Uploads {
+		total += u.Size
+	}
+	return total
+}
+
+// Create reserves space for an upload, enforcing the user's quota unless quota is 0, and
+// the total for the server
+func (s *UploadStore) Create(u Upload, quota int64) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	// Drop uploads nobody came back to while we're writing anyway
+	s.dropAbandoned(now)
+	if quota > 0 && s.used(u.OwnerID)+u.Size > quota {
+		return Upload{}, errQuotaExceeded
+	}
+	if total := int64(uploadConfig.TotalMB) << 20; total > 0 && s.usedTotal()+u.Size > total {
+		return Upload{}, errUploadsFull
+	}
+	for _, old := range s.Uploads {
+		if !old.Complete && old.Path == u.Path {
+			return Upload{}, fmt.Errorf("%s is already being uploaded", u.Path)
+		}
+	}
+	u.ID = newID()
+	u.Created, u.Updated = now, now
+	if !u.Complete {
+		f, err := os.OpenFile(s.dataPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return Upload{}, err
+		}
+		f.Close()
+	}
+	s.Uploads[u.ID] = &u
+	if err := saveJSON(s.path, s); err != nil {
+		delete(s.Uploads, u.ID)
+		os.Remove(s.dataPath(u.ID))
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+func (s *UploadStore) Get(id, ownerID string) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Uploads[id]
+	if !ok || u.OwnerID != ownerID {
+		return Upload{}, errUploadNotFound
+	}
+	return *u, nil
+}
+
+// Append writes the next chunk of an unfinished upload, which must start at offset. It
+// keeps whatever arrived even if the body is cut off, so the client can resume from there.
+func (s *UploadStore) Append(id, ownerID string, offset int64, body io.Reader) (Upload, error) {
+	s.mu.Lock()
+	u, ok := s.Uploads[id]
+	if !ok || u.OwnerID != ownerID {
+		s.mu.Unlock()
+		return Upload{}, errUploadNotFound
+	}
+	if s.writing[id] {
+		s.mu.Unlock()
+		return Upload{}, errUploadBusy
+	}
+	if u.Complete || offset != u.Offset || u.Offset == u.Size {
+		s.mu.Unlock()
+		return *u, errOffsetMismatch
+	}
+	s.writing[id] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.writing, id)
+		s.mu.Unlock()
+	}()
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return Upload{}, err
+	}
+	// A crash after writing but before saving the offset leaves extra bytes to drop
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return Upload{}, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return Upload{}, err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(body, u.Size-offset))
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.Offset += n
+	u.Updated = time.Now().UTC()
+	if err := saveJSON(s.path, s); err != nil {
+		return *u, err
+	}
+	return *u, copyErr
+}
+
+// Finish moves a fully received upload into the library
+func (s *UploadStore) Finish(id string) (Upload, error) {
+	s.mu.Lock()
+	u, ok := s.Uploads[id]
+	if !ok {
+		s.mu.Unlock()
+		return Upload{}, errUploadNotFound
+	}
+	upload := *u
+	s.mu.Unlock()
+
+	storage, ok := writableLibrary()
+	if !ok {
+		return upload, errLibraryNotWritable
+	}
+	if upload.Replace {
+		if _, err := library.Stat(upload.Path); err == nil {
+			if _, err := trash.Add(storage, upload.Path, "sync"); err != nil {
+				return upload, err
+			}
+		}
+	}
+	f, err := os.Open(s.dataPath(id))
+	if err != nil {
+		return upload, err
+	}
+	err = storage.Create(upload.Path, f)
+	f.Close()
+	if err != nil {
+		// Nothing the client can resume, so let the space go
+		s.Delete(id, upload.OwnerID)
+		return upload, err
+	}
+	os.Remove(s.dataPath(id))
+	if !upload.Modified.IsZero() {
+		if p, ok := localLibraryPath(upload.Path); ok {
+			os.Chtimes(p, upload.Modified, upload.Modified)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.Complete = true
+	u.Updated = time.Now().UTC()
+	return *u, saveJSON(s.path, s)
+}
+
+// Delete abandons an unfinished upload; finished ones stay in the library
+func (s *UploadStore) Delete(id, ownerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Uploads[id]
+	if !ok || u.OwnerID != ownerID || u.Complete {
+		return errUploadNotFound
+	}
+	if s.writing[id] {
+		return errUploadBusy
+	}
+	os.Remove(s.dataPath(id))
+	delete(s.Uploads, id)
+	return saveJSON(s.path, s)
+}
+
+func (s *UploadStore) List(ownerID string) []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Upload{}
+	for _, u := range s.Uploads {
+		if u.OwnerID == ownerID {
+			list = append(list, *u)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list
+}
+
+func (s *UploadStore) Used(ownerID string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used(ownerID)
+}
+
+func (s *UploadStore) UsedTotal() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usedTotal()
+}
+
+func registerUploadRoutes() {
+	handleFunc("GET /api/uploads", requireRole(roleListener, listUploads))
+	handleFunc("POST /api/uploads", requireRole(roleListener, uploadFiles))
+	handleFunc("OPTIONS /api/uploads/tus", tusOptions)
+	handleFunc("POST /api/uploads/tus", requireRole(roleListener, requireTus(createTusUpload)))
+	handleFunc("HEAD /api/uploads/tus/{id}", requireRole(roleListener, requireTus(getTusOffset)))
+	handleFunc("PATCH /api/uploads/tus/{id}", requireRole(roleListener, requireTus(patchTusUpload)))
+	handleFunc("DELETE /api/uploads/tus/{id}", requireRole(roleListener, requireTus(deleteTusUpload)))
+}
+
+func uploadOwner(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.ID
+	}
+	return ""
+}
+
+// uploadQuota is the caller's quota in bytes, 0 for none
+func uploadQuota(r *http.Request) int64 {
+	if u := currentUser(r); u != nil && u.UploadQuotaMB != nil {
+		return int64(*u.UploadQuotaMB) << 20
+	}
+	if hasRole(r, roleAdmin) {
+		return 0
+	}
+	return int64(uploadConfig.QuotaMB) << 20
+}
+
+// uploadSpace is how many more bytes the caller can upload, or -1 without any limit
+func uploadSpace(r *http.Request) int64 {
+	space := int64(-1)
+	if quota := uploadQuota(r); quota > 0 {
+		space = max(quota-uploads.Used(uploadOwner(r)), 0)
+	}
+	if total := int64(uploadConfig.TotalMB) << 20; total > 0 {
+		left := max(total-uploads.UsedTotal(), 0)
+		if space < 0 || left < space {
+			space = left
+		}
+	}
+	return space
+}
+
+// uploadTarget checks an upload's destination: an audio file that doesn't exist yet, unless
+// it's to be replaced, in a folder that isn't inside an archive
+func uploadTarget(folder, filename string, replace bool) (string, error) {
+	if filename == "" || filename != path.Base(filename) || strings.ContainsAny(filename, `/\`) {
+		return "", errors.New("filename must be a plain file name")
+	}
+	if !audioExts[strings.ToLower(path.Ext(filename))] {
+		return "", fmt.Errorf("%s isn't a supported audio file", filename)
+	}
+	target := path.Join(strings.Trim(folder, "/"), filename)
+	clean, err := cleanLibraryPath(target)
+	if err != nil {
+		return "", errors.New("invalid folder")
+	}
+	target = filepath.ToSlash(clean)
+	if insideZip(target) {
+		return "", errors.New("can't upload into a zip archive")
+	}
+	if isMounted(target) {
+		return "", errors.New("can't upload to a mounted server")
+	}
+	if _, err := library.Stat(target); err == nil && !replace {
+		return "", &fs.PathError{Op: "upload", Path: target, Err: fs.ErrExist}
+	}
+	return target, nil
+}
+
+// insideZip reports whether a library path is a file within a zip archive
+func insideZip(relPath string) bool {
+	for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+		if isZipPath(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUploadNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errQuotaExceeded), errors.Is(err, errUploadsFull):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errUploadBusy), errors.Is(err, errOffsetMismatch), errors.Is(err, fs.ErrExist):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errLibraryNotWritable):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// indexUpload reads a new file's tags straight away, so the first listing after an upload
+// doesn't pay for them
+func indexUpload(u Upload) {
+	info, err := library.Stat(u.Path)
+	if err != nil {
+		return
+	}
+	trackMeta(libraryFile{AudioFile: audioFileFromPath(u.Path), Size: info.Size(), ModTime: info.ModTime()})
+	slog.Info("Uploaded file", "path", u.Path, "size", u.Size)
+	events.Publish(Event{Type: "upload.received", Data: u, visible: ownerOnly(u.OwnerID)})
+}
+
+func listUploads(w http.ResponseWriter, r *http.Request) {
+	owner := uploadOwner(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"uploads":    uploads.List(owner),
+		"used":       uploads.Used(owner),
+		"quota":      uploadQuota(r),
+		"remaining":  uploadSpace(r),
+		"totalUsed":  uploads.UsedTotal(),
+		"totalQuota": int64(uploadConfig.TotalMB) << 20,
+		"maxSize":    int64(uploadConfig.MaxMB) << 20,
+		"extensions": sortedAudioExts(),
+	})
+}
+
+func sortedAudioExts() []string {
+	exts := make([]string, 0, len(audioExts))
+	for ext := range audioExts {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// uploadFiles takes a multipart form of "file" parts into ?folder=, for small files that
+// don't need resuming. Each file is checked and stored before the next is read.
+func uploadFiles(w http.ResponseWriter, r *http.Request) {
+	storage, ok := writableLibrary()
+	if !ok {
+		writeUploadError(w, errLibraryNotWritable)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+	folder := r.URL.Query().Get("folder")
+	maxSize := int64(uploadConfig.MaxMB) << 20
+	stored := []Upload{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		target, err := uploadTarget(folder, part.FileName(), false)
+		if errors.Is(err, fs.ErrExist) {
+			writeUploadError(w, err)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Spool to disk first, since the size isn't known until the part ends
+		tmp, err := os.CreateTemp(uploads.dir, "form-*")
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		size, err := io.Copy(tmp, io.LimitReader(part, maxSize+1))
+		if err == nil && size > maxSize {
+			err = fmt.Errorf("%s is larger than %d MB", part.FileName(), uploadConfig.MaxMB)
+		}
+		if err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		var u Upload
+		if err == nil {
+			u, err = uploads.Create(Upload{OwnerID: uploadOwner(r), Path: target, Size: size, Offset: size, Complete: true}, uploadQuota(r))
+			if err == nil {
+				if err = storage.Create(target, tmp); err != nil {
+					uploads.forget(u.ID)
+				}
+			}
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+		if err != nil {
+			if errors.Is(err, errQuotaExceeded) || errors.Is(err, errUploadsFull) || errors.Is(err, fs.ErrExist) {
+				writeUploadError(w, err)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		indexUpload(u)
+		stored = append(stored, u)
+	}
+	if len(stored) == 0 {
+		http.Error(w, "no file parts in the form", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, stored)
+}
+
+// dropAbandoned removes unfinished uploads nobody has come back to, returning how many; s.mu
+// must be held
+func (s *UploadStore) dropAbandoned(now time.Time) int {
+	n := 0
+	for id, old := range s.Uploads {
+		if !old.Complete && now.Sub(old.Updated) > abandonedUploadAge && !s.writing[id] {
+			os.Remove(s.dataPath(id))
+			delete(s.Uploads, id)
+			n++
+		}
+	}
+	return n
+}
+
+// PruneAbandoned removes abandoned uploads without waiting for the next one to start
+func (s *UploadStore) PruneAbandoned() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.dropAbandoned(time.Now().UTC())
+	if n == 0 {
+		return 0, nil
+	}
+	return n, saveJSON(s.path, s)
+}
+
+// forget drops the record of an upload that never made it into the library
+func (s *UploadStore) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Uploads, id)
+	saveJSON(s.path, s)
+}
+
+// The tus resumable upload protocol, https://tus.io/protocols/resumable-upload, with the
+// creation and termination extensions
+
+// requireTus checks the client speaks our version of tus, and labels every response with it
+func requireTus(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func tusOptions(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Tus-Resumable", tusVersion)
+	h.Set("Tus-Version", tusVersion)
+	h.Set("Tus-Extension", "creation,termination")
+	h.Set("Tus-Max-Size", strconv.FormatInt(int64(uploadConfig.MaxMB)<<20, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusMetadata reads Upload-Metadata, "key base64value" pairs separated by commas
+func parseTusMetadata(header string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err == nil {
+			meta[key] = string(decoded)
+		}
+	}
+	return meta
+}
+
+// createTusUpload expects Upload-Length and Upload-Metadata with filename and optionally
+// folder. Admins can also send replace, to overwrite an existing file, and modified, the
+// file's modification time as RFC 3339, which sync uses to tell when files have changed.
+func createTusUpload(w http.ResponseWriter, r *http.Request) {
+	if _, ok := writableLibrary(); !ok {
+		writeUploadError(w, errLibraryNotWritable)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, "Upload-Length is required", http.StatusBadRequest)
+		return
+	}
+	if size > int64(uploadConfig.MaxMB)<<20 {
+		http.Error(w, fmt.Sprintf("Uploads are limited to %d MB", uploadConfig.MaxMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	replace := meta["replace"] == "true" && hasRole(r, roleAdmin)
+	var modified time.Time
+	if m := meta["modified"]; m != "" && hasRole(r, roleAdmin) {
+		if modified, err = time.Parse(time.RFC3339, m); err != nil {
+			http.Error(w, "modified must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	target, err := uploadTarget(meta["folder"], meta["filename"], replace)
+	if errors.Is(err, fs.ErrExist) {
+		writeUploadError(w, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := uploads.Create(Upload{OwnerID: uploadOwner(r), Path: target, Size: size, Replace: replace, Modified: modified}, uploadQuota(r))
+	if errors.Is(err, errQuotaExceeded) || errors.Is(err, errUploadsFull) {
+		writeUploadError(w, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if size == 0 {
+		if u, err = uploads.Finish(u.ID); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		indexUpload(u)
+	}
+	w.Header().Set("Location", prefixed("/api/uploads/tus/"+u.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func getTusOffset(w http.ResponseWriter, r *http.Request) {
+	u, err := uploads.Get(r.PathValue("id"), uploadOwner(r))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func patchTusUpload(w http.ResponseWriter, r *http.Request) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset is required", http.StatusBadRequest)
+		return
+	}
+	// Let the chunk take as long as it needs, past the settings lock other requests share
+	releaseSettings(r)
+	u, err := uploads.Append(r.PathValue("id"), uploadOwner(r), offset, r.Body)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	if u.Offset == u.Size {
+		if u, err = uploads.Finish(u.ID); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		indexUpload(u)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteTusUpload(w http.ResponseWriter, r *http.Request) {
+	if err := uploads.Delete(r.PathValue("id"), uploadOwner(r)); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
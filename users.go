@@ -0,0 +1,805 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie   = "beatgraze_session"
+	sessionLifetime = 30 * 24 * time.Hour
+)
+
+type User struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"passwordHash"`
+	Role         Role      `json:"role"`
+	OIDCSubject  string    `json:"oidcSubject,omitempty"` // Issuer and subject for accounts that sign in through OIDC
+	Admin        bool      `json:"admin,omitempty"`       // Before roles; only read to migrate old accounts
+	Created      time.Time `json:"created"`
+
+	UploadQuotaMB *int `json:"uploadQuotaMb,omitempty"` // Overrides -upload-quota-mb, 0 for no limit
+}
+
+// UserView is what the API shows of a user; the password hash never leaves the server
+type UserView struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Role          Role      `json:"role"`
+	Created       time.Time `json:"created"`
+	UploadQuotaMB *int      `json:"uploadQuotaMb,omitempty"`
+}
+
+type Session struct {
+	UserID     string    `json:"userId"`
+	Expires    time.Time `json:"expires"`
+	NoScrobble bool      `json:"noScrobble,omitempty"` // Scrobbling turned off for this browser
+}
+
+// userState is everything that belongs to one listener. Without any accounts
+// the whole server shares defaultState, which lives at the top of the data directory.
+type userState struct {
+	playlists *PlaylistStore
+	stats     *StatsStore
+}
+
+type UserStore struct {
+	mu       sync.Mutex
+	path     string
+	Users    map[string]*User    `json:"users"`
+	Sessions map[string]*Session `json:"sessions"` // Keyed by a hash of the cookie value
+	APIKeys  map[string]*APIKey  `json:"apiKeys"`  // Keyed by a hash of the key
+	states   map[string]*userState
+}
+
+var users *UserStore
+
+var defaultState *userState
+
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("beatgraze"), bcrypt.DefaultCost)
+	return hash
+})
+
+var errUserNotFound = errors.New("user not found")
+var errUserExists = errors.New("a user with that name already exists")
+var errLastAdmin = errors.New("can't remove the last admin")
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	csrfContextKey
+	settingsContextKey
+	logInfoContextKey
+	requestIDContextKey
+	grpcRequestContextKey
+	streamContextKey
+	signedOutContextKey
+)
+
+func loadUserState(dir string) (*userState, error) {
+	ps, err := loadPlaylistStore(filepath.Join(dir, "playlists.json"))
+	if err != nil {
+		return nil, err
+	}
+	ss, err := loadStatsStore(filepath.Join(dir, "stats.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &userState{playlists: ps, stats: ss}, nil
+}
+
+func loadUserStore(path string) (*UserStore, error) {
+	s := &UserStore{
+		path:     path,
+		Users:    map[string]*User{},
+		Sessions: map[string]*Session{},
+		APIKeys:  map[string]*APIKey{},
+		states:   map[string]*userState{},
+	}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	if s.Users == nil {
+		s.Users = map[string]*User{}
+	}
+	if s.Sessions == nil {
+		s.Sessions = map[string]*Session{}
+	}
+	if s.APIKeys == nil {
+		s.APIKeys = map[string]*APIKey{}
+	}
+	for id, u := range s.Users {
+		if u.Role == "" {
+			u.Role = roleListener
+			if u.Admin {
+				u.Role = roleAdmin
+			}
+			u.Admin = false
+		}
+		st, err := loadUserState(s.stateDir(id))
+		if err != nil {
+			return nil, err
+		}
+		s.states[id] = st
+	}
+	return s, nil
+}
+
+func (s *UserStore) stateDir(id string) string {
+	return filepath.Join(filepath.Dir(s.path), "users", id)
+}
+
+func (u *User) view() UserView {
+	return UserView{ID: u.ID, Name: u.Name, Role: u.Role, Created: u.Created, UploadQuotaMB: u.UploadQuotaMB}
+}
+
+func (s *UserStore) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Users)
+}
+
+func (s *UserStore) List() []UserView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]UserView, 0, len(s.Users))
+	for _, u := range s.Users {
+		list = append(list, u.view())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *UserStore) Get(id string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[id]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// State returns the user's playlists and stats, falling back to the shared state for unknown IDs
+func (s *UserStore) State(id string) *userState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.states[id]; ok {
+		return st
+	}
+	return defaultState
+}
+
+// States lists every user's state plus the shared one, for background jobs like daily mixes
+func (s *UserStore) States() []*userState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*userState{defaultState}
+	for _, st := range s.states {
+		list = append(list, st)
+	}
+	return list
+}
+
+// Create adds a user. The very first account is always an admin and inherits the
+// playlists and history built up before accounts existed.
+func (s *UserStore) Create(name, password string, role Role) (User, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || password == "" {
+		return User{}, errors.New("name and password are required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nameTaken(name) {
+		return User{}, errUserExists
+	}
+	if len(s.Users) == 0 {
+		role = roleAdmin
+	}
+	return s.add(&User{ID: newID(), Name: name, PasswordHash: string(hash), Role: role, Created: time.Now().UTC()})
+}
+
+// Lookup finds a user by name, ignoring case as names are unique that way
+func (s *UserStore) Lookup(name string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.Users {
+		if strings.EqualFold(u.Name, name) {
+			return *u, true
+		}
+	}
+	return User{}, false
+}
+
+// nameTaken must be called with mu held
+func (s *UserStore) nameTaken(name string) bool {
+	for _, u := range s.Users {
+		if strings.EqualFold(u.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// add sets up storage for a new user and saves it; must be called with mu held
+func (s *UserStore) add(u *User) (User, error) {
+	dir := s.stateDir(u.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return User{}, err
+	}
+	if len(s.Users) == 0 {
+		// Through the data store, which has them rather than files unless it's -store json
+		for _, file := range []string{"playlists.json", "stats.json"} {
+			var doc json.RawMessage
+			if err := loadJSON(filepath.Join(filepath.Dir(s.path), file), &doc); err != nil {
+				return User{}, err
+			}
+			if doc != nil {
+				if err := saveJSON(filepath.Join(dir, file), doc); err != nil {
+					return User{}, err
+				}
+			}
+		}
+	}
+	st, err := loadUserState(dir)
+	if err != nil {
+		return User{}, err
+	}
+
+	s.Users[u.ID] = u
+	if err := saveJSON(s.path, s); err != nil {
+		delete(s.Users, u.ID)
+		return User{}, err
+	}
+	s.states[u.ID] = st
+	return *u, nil
+}
+
+func (s *UserStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[id]
+	if !ok {
+		return errUserNotFound
+	}
+	if u.Role == roleAdmin && s.adminCount() == 1 {
+		return errLastAdmin
+	}
+	delete(s.Users, id)
+	for key, session := range s.Sessions {
+		if session.UserID == id {
+			delete(s.Sessions, key)
+		}
+	}
+	for hash, k := range s.APIKeys {
+		if k.UserID == id {
+			delete(s.APIKeys, hash)
+		}
+	}
+	if err := saveJSON(s.path, s); err != nil {
+		s.Users[id] = u
+		return err
+	}
+	delete(s.states, id)
+	return os.RemoveAll(s.stateDir(id))
+}
+
+func (s *UserStore) SetRole(id string, role Role) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	if u.Role == roleAdmin && role != roleAdmin && s.adminCount() == 1 {
+		return User{}, errLastAdmin
+	}
+	old := u.Role
+	u.Role = role
+	if err := saveJSON(s.path, s); err != nil {
+		u.Role = old
+		return User{}, err
+	}
+	return *u, nil
+}
+
+// SetUploadQuota gives a user their own upload quota in MB, or with nil puts them back on the default
+func (s *UserStore) SetUploadQuota(id string, quotaMB *int) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	old := u.UploadQuotaMB
+	u.UploadQuotaMB = quotaMB
+	if err := saveJSON(s.path, s); err != nil {
+		u.UploadQuotaMB = old
+		return User{}, err
+	}
+	return *u, nil
+}
+
+// adminCount must be called with mu held
+func (s *UserStore) adminCount() int {
+	n := 0
+	for _, u := range s.Users {
+		if u.Role == roleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *UserStore) SetPassword(id, password string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[id]
+	if !ok {
+		return errUserNotFound
+	}
+	u.PasswordHash = string(hash)
+	// A new password signs out every existing session
+	for key, session := range s.Sessions {
+		if session.UserID == id {
+			delete(s.Sessions, key)
+		}
+	}
+	return saveJSON(s.path, s)
+}
+
+// Authenticate checks a name and password, returning the matching user
+func (s *UserStore) Authenticate(name, password string) (User, bool) {
+	s.mu.Lock()
+	var found *User
+	for _, u := range s.Users {
+		if strings.EqualFold(u.Name, name) {
+			c := *u
+			found = &c
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		// Burn the same time as a real check so unknown names aren't obvious
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return User{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(found.PasswordHash), []byte(password)) != nil {
+		return User{}, false
+	}
+	return *found, true
+}
+
+func hashSecret(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *UserStore) NewSession(userID string) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	expires := time.Now().Add(sessionLifetime).UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, session := range s.Sessions {
+		if now.After(session.Expires) {
+			delete(s.Sessions, key)
+		}
+	}
+	s.Sessions[hashSecret(token)] = &Session{UserID: userID, Expires: expires}
+	return token, expires, saveJSON(s.path, s)
+}
+
+// SessionUser looks up the user behind a session cookie value
+func (s *UserStore) SessionUser(token string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.Sessions[hashSecret(token)]
+	if !ok || time.Now().After(session.Expires) {
+		return User{}, false
+	}
+	u, ok := s.Users[session.UserID]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// SessionScrobbles reports whether plays from a browser's session are scrobbled
+func (s *UserStore) SessionScrobbles(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.Sessions[hashSecret(token)]
+	return !ok || !session.NoScrobble
+}
+
+func (s *UserStore) SetSessionScrobbling(token string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.Sessions[hashSecret(token)]
+	if !ok {
+		return errors.New("session not found")
+	}
+	session.NoScrobble = !enabled
+	return saveJSON(s.path, s)
+}
+
+func (s *UserStore) EndSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Sessions, hashSecret(token))
+	return saveJSON(s.path, s)
+}
+
+func withUser(r *http.Request, u User) *http.Request {
+	if info, ok := r.Context().Value(logInfoContextKey).(*requestLogInfo); ok {
+		info.user = u.Name
+	}
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, &u))
+}
+
+// withoutSignIn marks a request requireAuth let through without credentials, on a route
+// that checks its own (a share token, say) or needs none, so it doesn't count as the operator
+func withoutSignIn(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), signedOutContextKey, true))
+}
+
+// withOperator marks a request a route signed in with the operator credentials itself
+func withOperator(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), signedOutContextKey, false))
+}
+
+func signedOut(r *http.Request) bool {
+	out, _ := r.Context().Value(signedOutContextKey).(bool)
+	return out
+}
+
+// currentUser is the signed-in account, or nil for operator credentials and servers without accounts
+func currentUser(r *http.Request) *User {
+	u, _ := r.Context().Value(userContextKey).(*User)
+	return u
+}
+
+// stateFor picks the playlists and stats a request should read and write
+func stateFor(r *http.Request) *userState {
+	if u := currentUser(r); u != nil {
+		return users.State(u.ID)
+	}
+	return defaultState
+}
+
+func startSession(w http.ResponseWriter, r *http.Request, u User) error {
+	token, expires, err := users.NewSession(u.ID)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     prefixed("/"),
+		Expires:  expires,
+		HttpOnly: true,
+		// Lax keeps other sites' writes cookie-less while still surviving the redirect back from an OIDC provider
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil,
+	})
+	return nil
+}
+
+func registerUserRoutes() {
+	handleFunc("POST /api/login", login)
+	handleFunc("POST /api/logout", logout)
+	handleFunc("GET /api/me", getMe)
+	handleFunc("GET /api/users", requireRole(roleAdmin, listUsers))
+	handleFunc("POST /api/users", requireRole(roleAdmin, createUser))
+	handleFunc("PATCH /api/users/{id}", requireRole(roleAdmin, updateUser))
+	handleFunc("DELETE /api/users/{id}", requireRole(roleAdmin, deleteUser))
+	handleFunc("PUT /api/users/{id}/password", setUserPassword)
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errUserExists), errors.Is(err, errLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if loginThrottled(w, r, req.Name) {
+		return
+	}
+	u, ok := users.Authenticate(req.Name, req.Password)
+	if !ok {
+		authFailed(r, "password", req.Name)
+		http.Error(w, "Invalid name or password", http.StatusUnauthorized)
+		return
+	}
+	loginGuard.Succeed(u.Name)
+	if err := startSession(w, r, u); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, u.view())
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := users.EndSession(cookie.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: prefixed("/"), MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getMe(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		http.Error(w, "Not signed in", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, u.view())
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, users.List())
+}
+
+func createUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = string(roleListener)
+	}
+	role, err := parseRole(req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := users.Create(req.Name, req.Password, role)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, u.view())
+}
+
+// updateUser changes a user's role and/or upload quota; an uploadQuotaMb of -1 goes back to the default
+func updateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role          string `json:"role"`
+		UploadQuotaMB *int   `json:"uploadQuotaMb"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" && req.UploadQuotaMB == nil {
+		http.Error(w, "Nothing to change", http.StatusBadRequest)
+		return
+	}
+	if req.UploadQuotaMB != nil && *req.UploadQuotaMB < -1 {
+		http.Error(w, "uploadQuotaMb can't be negative", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	u, ok := users.Get(id)
+	if !ok {
+		writeUserError(w, errUserNotFound)
+		return
+	}
+	var err error
+	if req.Role != "" {
+		role, err := parseRole(req.Role)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if u, err = users.SetRole(id, role); err != nil {
+			writeUserError(w, err)
+			return
+		}
+	}
+	if req.UploadQuotaMB != nil {
+		quota := req.UploadQuotaMB
+		if *quota == -1 {
+			quota = nil
+		}
+		if u, err = users.SetUploadQuota(id, quota); err != nil {
+			writeUserError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, u.view())
+}
+
+func deleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := users.Delete(r.PathValue("id")); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setUserPassword lets people change their own password, given the old one as oldPassword;
+// admins can reset anyone else's without it
+func setUserPassword(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	u := currentUser(r)
+	if !hasRole(r, roleAdmin) && (u == nil || u.ID != id) {
+		http.Error(w, "This needs admin access", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		OldPassword string `json:"oldPassword"`
+		Password    string `json:"password"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	// Otherwise anyone at a signed in browser could lock its owner out
+	if u != nil && u.ID == id {
+		if loginThrottled(w, r, u.Name) {
+			return
+		}
+		if _, ok := users.Authenticate(u.Name, req.OldPassword); !ok {
+			authFailed(r, "password", u.Name)
+			http.Error(w, "The old password is wrong", http.StatusForbidden)
+			return
+		}
+		loginGuard.Succeed(u.Name)
+	}
+	if err := users.SetPassword(id, req.Password); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addAccount creates an account from name:password, or just a name to be asked for the
+// password on the terminal
+func addAccount(spec, roleName string) (User, error) {
+	role, err := parseRole(roleName)
+	if err != nil {
+		return User{}, err
+	}
+	name, password := accountPassword(spec)
+	return users.Create(name, password, role)
+}
+
+// accountPassword splits name:password, asking for the password when there's no colon so
+// it stays out of shell history and the process list
+func accountPassword(spec string) (name, password string) {
+	name, password, ok := strings.Cut(spec, ":")
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		password = strings.TrimRight(line, "\r\n")
+	}
+	return name, password
+}
+
+// runUsers implements "beatgraze users", for managing accounts while the server is stopped.
+// It works on the data store directly, so it can make the first admin before anyone can
+// sign in, or let an admin back in who has forgotten their password.
+func runUsers(args []string) error {
+	action := ""
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	stateFlags(fs)
+	role := fs.String("role", string(roleListener), "Role for add: admin, listener or guest")
+	commandUsage(fs, "list|add|remove|passwd [options] [name[:password]]",
+		"Lists, adds and removes accounts, or sets an account's password, signing it out everywhere.\n"+
+			"The first account is always an admin. add and passwd ask for the password when it isn't\n"+
+			"given.")
+	fs.Parse(args)
+	switch action {
+	case "list":
+	case "add", "remove", "passwd":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := openCommandState(); err != nil {
+		return err
+	}
+	defer stateStore.Close()
+
+	switch action {
+	case "add":
+		u, err := addAccount(fs.Arg(0), *role)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s (%s)\n", u.Name, u.Role)
+	case "remove":
+		u, ok := users.Lookup(fs.Arg(0))
+		if !ok {
+			return fmt.Errorf("no account named %q", fs.Arg(0))
+		}
+		if err := users.Delete(u.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", u.Name)
+	case "passwd":
+		name, password := accountPassword(fs.Arg(0))
+		u, ok := users.Lookup(name)
+		if !ok {
+			return fmt.Errorf("no account named %q", name)
+		}
+		if err := users.SetPassword(u.ID, password); err != nil {
+			return err
+		}
+		fmt.Printf("Changed the password for %s\n", u.Name)
+	default:
+		for _, u := range users.List() {
+			fmt.Printf("%-24s %-9s %s\n", u.Name, u.Role, u.Created.Local().Format("2006-01-02"))
+		}
+	}
+	return nil
+}
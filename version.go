@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Plain go builds fall back to the VCS details the toolchain embeds.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+func versionInfo() VersionInfo {
+	info := VersionInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	vcs := map[string]string{}
+	for _, s := range build.Settings {
+		vcs[s.Key] = s.Value
+	}
+	if info.Commit == "" && vcs["vcs.revision"] != "" {
+		info.Commit = vcs["vcs.revision"]
+		if vcs["vcs.modified"] == "true" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = vcs["vcs.time"]
+	}
+	return info
+}
+
+func (v VersionInfo) String() string {
+	s := "beatgraze " + v.Version
+	if v.Commit != "" {
+		s += " (" + v.Commit
+		if v.BuildDate != "" {
+			s += ", built " + v.BuildDate
+		}
+		s += ")"
+	}
+	return s + " " + v.GoVersion
+}
+
+func registerVersionRoutes() {
+	handleFunc("GET /api/version", getVersion)
+}
+
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, versionInfo())
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxWebhooks          = 50
+	maxWebhookDeliveries = 20 // Recent deliveries kept per webhook
+	webhookTimeout       = 15 * time.Second
+)
+
+// webhookRetries are the waits between attempts at a delivery the receiver didn't take
+var webhookRetries = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+// Webhook is a URL sent a signed JSON payload whenever one of its events happens
+type Webhook struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events,omitempty"` // Like /api/events' ?types=, all events when empty
+	Secret  string    `json:"secret,omitempty"` // Only shown when the webhook is made
+	Created time.Time `json:"created"`
+}
+
+func (h *Webhook) wants(eventType string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if eventType == t || strings.HasPrefix(eventType, t+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the outcome of sending one event to one webhook
+type WebhookDelivery struct {
+	ID       string    `json:"id"`
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"` // The receiver's last response
+	Error    string    `json:"error,omitempty"`
+	Done     bool      `json:"done"` // Delivered, or given up on
+}
+
+type WebhookStore struct {
+	mu    sync.Mutex
+	path  string
+	Hooks []*Webhook `json:"hooks"`
+
+	deliveries map[string][]*WebhookDelivery // Newest last, by webhook ID
+}
+
+var webhooks *WebhookStore
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+func loadWebhookStore(path string) (*WebhookStore, error) {
+	s := &WebhookStore{path: path, deliveries: map[string][]*WebhookDelivery{}}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns the webhooks without their secrets
+func (s *WebhookStore) List() []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Webhook, 0, len(s.Hooks))
+	for _, h := range s.Hooks {
+		c := *h
+		c.Secret = ""
+		list = append(list, c)
+	}
+	return list
+}
+
+func (s *WebhookStore) Add(h Webhook) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Hooks) >= maxWebhooks {
+		return Webhook{}, fmt.Errorf("at most %d webhooks", maxWebhooks)
+	}
+	h.ID = newID()
+	h.Created = time.Now().UTC()
+	s.Hooks = append(s.Hooks, &h)
+	if err := saveJSON(s.path, s); err != nil {
+		s.Hooks = s.Hooks[:len(s.Hooks)-1]
+		return Webhook{}, err
+	}
+	return h, nil
+}
+
+func (s *WebhookStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, h := range s.Hooks {
+		if h.ID == id {
+			old := s.Hooks
+			s.Hooks = append(s.Hooks[:i:i], s.Hooks[i+1:]...)
+			if err := saveJSON(s.path, s); err != nil {
+				s.Hooks = old
+				return err
+			}
+			delete(s.deliveries, id)
+			return nil
+		}
+	}
+	return errWebhookNotFound
+}
+
+func (s *WebhookStore) get(id string) (Webhook, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.Hooks {
+		if h.ID == id {
+			return *h, true
+		}
+	}
+	return Webhook{}, false
+}
+
+// Deliveries returns a webhook's recent deliveries, newest first
+func (s *WebhookStore) Deliveries(id string) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, h := range s.Hooks {
+		found = found || h.ID == id
+	}
+	if !found {
+		return nil, errWebhookNotFound
+	}
+	list := make([]WebhookDelivery, 0, len(s.deliveries[id]))
+	for _, d := range s.deliveries[id] {
+		list = append(list, *d)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+	return list, nil
+}
+
+// notify starts delivering an event to every webhook that wants it
+func (s *WebhookStore) notify(eventType string, data any) {
+	s.mu.Lock()
+	var hooks []Webhook
+	for _, h := range s.Hooks {
+		if h.wants(eventType) {
+			hooks = append(hooks, *h)
+		}
+	}
+	s.mu.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := webhookPayload(eventType, data)
+	if err != nil {
+		slog.Warn("Error encoding webhook payload", "event", eventType, "err", err)
+		return
+	}
+	for _, h := range hooks {
+		go s.deliver(h, eventType, body)
+	}
+}
+
+// deliver sends one payload, trying again with longer waits while the receiver is down or
+// failing. The record of it is kept until newer deliveries push it out.
+func (s *WebhookStore) deliver(h Webhook, eventType string, body []byte) {
+	d := &WebhookDelivery{ID: newID(), Event: eventType, Time: time.Now().UTC()}
+	s.mu.Lock()
+	list := append(s.deliveries[h.ID], d)
+	if len(list) > maxWebhookDeliveries {
+		list = list[len(list)-maxWebhookDeliveries:]
+	}
+	s.deliveries[h.ID] = list
+	s.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		status, err := postWebhook(h, d.ID, eventType, body)
+		retry := err != nil || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+		s.mu.Lock()
+		d.Attempts = attempt + 1
+		d.Status = status
+		d.Error = ""
+		if err != nil {
+			d.Error = err.Error()
+		} else if status >= 300 {
+			d.Error = http.StatusText(status)
+		}
+		d.Done = !retry || attempt == len(webhookRetries)
+		s.mu.Unlock()
+		if d.Done {
+			if d.Error != "" {
+				slog.Warn("Webhook delivery failed", "url", h.URL, "event", eventType, "attempts", d.Attempts, "err", d.Error)
+			}
+			return
+		}
+		select {
+		case <-shuttingDown.Done():
+			return
+		case <-time.After(webhookRetries[attempt]):
+		}
+		// Stop if the webhook was deleted while waiting
+		if _, ok := s.get(h.ID); !ok {
+			return
+		}
+	}
+}
+
+// postWebhook sends a payload signed with the webhook's secret, so the receiver can check it
+// came from here: X-Beatgraze-Signature is sha256= and the hex HMAC-SHA256 of the body
+func postWebhook(h Webhook, deliveryID, eventType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(shuttingDown, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "beatgraze/"+versionInfo().Version)
+	req.Header.Set("X-Beatgraze-Event", eventType)
+	req.Header.Set("X-Beatgraze-Delivery", deliveryID)
+	if h.Secret != "" {
+		req.Header.Set("X-Beatgraze-Signature", "sha256="+signWebhook(h.Secret, body))
+	}
+	resp, err := (&http.Client{Timeout: webhookTimeout}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func webhookPayload(eventType string, data any) ([]byte, error) {
+	return json.Marshal(struct {
+		Event string    `json:"event"`
+		Time  time.Time `json:"time"`
+		Data  any       `json:"data"`
+	}{eventType, time.Now().UTC(), data})
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// dispatchWebhooks passes library events and plays on to the webhooks until shutdown
+func dispatchWebhooks() {
+	all, stopEvents := events.Watch()
+	defer stopEvents()
+	plays, stopPlays := nowPlaying.Watch()
+	defer stopPlays()
+	for {
+		select {
+		case <-shuttingDown.Done():
+			return
+		case e := <-all:
+			webhooks.notify(e.Type, e.Data)
+		case p := <-plays:
+			webhooks.notify("track.played", struct {
+				NowPlaying
+				File AudioFile `json:"file"`
+			}{p, audioFileFromPath(p.Path)})
+		}
+	}
+}
+
+func registerWebhookRoutes() {
+	handleFunc("GET /api/webhooks", requireRole(roleAdmin, listWebhooks))
+	handleFunc("POST /api/webhooks", requireRole(roleAdmin, createWebhook))
+	handleFunc("DELETE /api/webhooks/{id}", requireRole(roleAdmin, deleteWebhook))
+	handleFunc("GET /api/webhooks/{id}/deliveries", requireRole(roleAdmin, listWebhookDeliveries))
+	handleFunc("POST /api/webhooks/{id}/test", requireRole(roleAdmin, testWebhook))
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, webhooks.List())
+}
+
+// createWebhook makes up a secret when none is given, returning it once
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+	var types []string
+	for _, t := range req.Events {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	if req.Secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	h, err := webhooks.Add(Webhook{URL: u.String(), Events: types, Secret: req.Secret})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusCreated, h)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	err := webhooks.Delete(r.PathValue("id"))
+	if errors.Is(err, errWebhookNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	list, err := webhooks.Deliveries(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// testWebhook sends a ping straight away, once, and reports how the receiver answered
+func testWebhook(w http.ResponseWriter, r *http.Request) {
+	h, ok := webhooks.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, errWebhookNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	body, _ := webhookPayload("ping", map[string]string{"webhook": h.ID})
+	status, err := postWebhook(h, newID(), "ping", body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"status": status})
+}
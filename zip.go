@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxInflatedZipEntry caps how much of a compressed entry is unpacked into memory to make it
+// seekable. Stored entries are read straight from the archive, whatever their size.
+const maxInflatedZipEntry = 64 << 20
+
+// zipStorage shows .zip files in another Storage as read-only folders, so a sample pack's
+// contents are listed and streamed like any other files, as "pack.zip/Kicks/kick.wav"
+type zipStorage struct {
+	Storage
+
+	mu      sync.Mutex
+	indexes map[string]*zipIndex // By archive path
+}
+
+// zipIndex is an archive's table of contents, kept until the archive changes
+type zipIndex struct {
+	modTime time.Time
+	size    int64
+	files   map[string]fs.FileInfo // By path inside the archive
+	dirs    map[string]bool
+	names   []string // files' keys in archive order
+}
+
+func newZipStorage(storage Storage) *zipStorage {
+	return &zipStorage{Storage: storage, indexes: map[string]*zipIndex{}}
+}
+
+func isZipPath(p string) bool {
+	return strings.EqualFold(path.Ext(p), ".zip")
+}
+
+// split finds the archive in a library path, returning its path and the path inside it.
+// ok is false when the path doesn't go through an archive.
+func (z *zipStorage) split(relPath string) (archive, inner string, info fs.FileInfo, ok bool, err error) {
+	clean, err := cleanLibraryPath(relPath)
+	if err != nil {
+		return "", "", nil, false, err
+	}
+	parts := strings.Split(filepath.ToSlash(clean), "/")
+	for i, part := range parts {
+		if !isZipPath(part) {
+			continue
+		}
+		archive = strings.Join(parts[:i+1], "/")
+		if info, err := z.Storage.Stat(archive); err == nil && !info.IsDir() {
+			return archive, strings.Join(parts[i+1:], "/"), info, true, nil
+		}
+	}
+	return "", "", nil, false, nil
+}
+
+// index returns the archive's table of contents, reading it if the archive is new or changed
+func (z *zipStorage) index(archive string, info fs.FileInfo) (*zipIndex, error) {
+	z.mu.Lock()
+	idx := z.indexes[archive]
+	z.mu.Unlock()
+	if idx != nil && idx.modTime.Equal(info.ModTime()) && idx.size == info.Size() {
+		return idx, nil
+	}
+
+	r, f, err := z.openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx = &zipIndex{modTime: info.ModTime(), size: info.Size(), files: map[string]fs.FileInfo{}, dirs: map[string]bool{"": true}}
+	for _, entry := range r.File {
+		name := strings.TrimSuffix(entry.Name, "/")
+		// Skip anything that would climb out of the archive, along with folder entries
+		if !fs.ValidPath(name) || name == "." || entry.FileInfo().IsDir() {
+			continue
+		}
+		idx.files[name] = entry.FileInfo()
+		idx.names = append(idx.names, name)
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			idx.dirs[dir] = true
+		}
+	}
+	z.mu.Lock()
+	z.indexes[archive] = idx
+	z.mu.Unlock()
+	return idx, nil
+}
+
+func (z *zipStorage) openArchive(archive string) (*zip.Reader, File, error) {
+	f, err := z.Storage.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	r, err := zip.NewReader(&fileReaderAt{f: f}, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("reading %s: %v", archive, err)
+	}
+	return r, f, nil
+}
+
+func (z *zipStorage) Stat(relPath string) (fs.FileInfo, error) {
+	archive, inner, info, ok, err := z.split(relPath)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return z.Storage.Stat(relPath)
+	}
+	idx, err := z.index(archive, info)
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := idx.files[inner]; ok {
+		return entry, nil
+	}
+	if idx.dirs[inner] {
+		return remoteFileInfo{name: path.Base(path.Join(archive, inner)), modTime: info.ModTime(), dir: true}, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (z *zipStorage) Open(relPath string) (File, error) {
+	archive, inner, info, ok, err := z.split(relPath)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || inner == "" {
+		return z.Storage.Open(relPath)
+	}
+	idx, err := z.index(archive, info)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := idx.files[inner]; !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	r, f, err := z.openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	var entry *zip.File
+	for _, e := range r.File {
+		if strings.TrimSuffix(e.Name, "/") == inner {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	if entry.Method == zip.Store {
+		offset, err := entry.DataOffset()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		section := io.NewSectionReader(&fileReaderAt{f: f}, offset, int64(entry.UncompressedSize64))
+		return &zipEntryFile{ReadSeeker: section, info: entry.FileInfo(), closer: f}, nil
+	}
+
+	// Compressed entries can't seek, so unpack them
+	defer f.Close()
+	if entry.UncompressedSize64 > maxInflatedZipEntry {
+		return nil, errors.New("compressed zip entry is too large to stream")
+	}
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxInflatedZipEntry))
+	if err != nil {
+		return nil, err
+	}
+	return &zipEntryFile{ReadSeeker: bytes.NewReader(data), info: entry.FileInfo()}, nil
+}
+
+// Walk lists archives' contents in place of the archives themselves
+func (z *zipStorage) Walk(fn func(path string, info fs.FileInfo) error) error {
+	seen := map[string]bool{}
+	err := z.Storage.Walk(func(p string, info fs.FileInfo) error {
+		if !isZipPath(p) {
+			return fn(p, info)
+		}
+		idx, err := z.index(p, info)
+		if err != nil {
+			return nil // A broken archive shouldn't hide the rest of the library
+		}
+		seen[p] = true
+		for _, name := range idx.names {
+			if err := fn(p+"/"+name, idx.files[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// Forget archives that have gone
+	z.mu.Lock()
+	for archive := range z.indexes {
+		if !seen[archive] {
+			delete(z.indexes, archive)
+		}
+	}
+	z.mu.Unlock()
+	return err
+}
+
+// zipEntryFile is an open file inside an archive
+type zipEntryFile struct {
+	io.ReadSeeker
+	info   fs.FileInfo
+	closer io.Closer // The archive, for entries read straight from it
+}
+
+func (f *zipEntryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *zipEntryFile) Close() error {
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+// fileReaderAt lets archive/zip read a library file, which only offers Seek and Read
+type fileReaderAt struct {
+	mu sync.Mutex
+	f  File
+}
+
+func (r *fileReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}